@@ -3,27 +3,45 @@ package api
 import (
 	"errors"
 	"fmt"
-	"os"
+	"log"
+	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/0x0Glitch/hotel-reservation/notify"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // AuthHandler handles HTTP requests related to authentication
-// It processes login requests and generates JWT tokens
+// It processes login requests and issues short-lived JWT access tokens together
+// with refresh tokens that can be exchanged for new ones
 type AuthHandler struct{
-	userStore db.UserStore // Database interface for user operations
+	userStore  db.UserStore      // Database interface for user operations
+	tokenStore db.TokenStore     // Refresh tokens and revoked access tokens
+	keySet     *keys.KeySet      // Keys the access tokens are signed with
+	mailer     notify.Mailer     // Sends the password reset and verification emails
+	limiter    *LoginLimiter     // Throttles failed logins
+	authCfg    config.AuthConfig // Who the tokens are issued for and how long they live
 }
 
-// NewAuthHandler creates a new AuthHandler with the provided UserStore
+// NewAuthHandler creates a new AuthHandler with the provided stores and token settings
 // Factory function to create handlers with dependency injection
-func NewAuthHandler(userStore db.UserStore) *AuthHandler{
+func NewAuthHandler(userStore db.UserStore, tokenStore db.TokenStore, keySet *keys.KeySet, mailer notify.Mailer, limiter *LoginLimiter, authCfg config.AuthConfig) *AuthHandler{
 	return &AuthHandler{
-		userStore: userStore,
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		keySet:     keySet,
+		mailer:     mailer,
+		limiter:    limiter,
+		authCfg:    authCfg,
 	}
 }
 
@@ -35,18 +53,31 @@ type AuthParams struct{
 }
 
 // AuthResponse defines the data returned after successful authentication
-// Contains both the user data and the JWT token
+// Contains the user data, the JWT access token and the refresh token
 type AuthResponse struct{
-	User  *types.User `json:"User"`  // User information
-	Token string      `json:"token"` // JWT token for authentication
+	User         *types.User `json:"User"`         // User information
+	Token        string      `json:"token"`        // JWT access token for authentication
+	RefreshToken string      `json:"refreshToken"` // Exchanged for a new access token at /api/auth/refresh
+}
+
+// RefreshParams defines the data needed to refresh or revoke a refresh token
+type RefreshParams struct{
+	RefreshToken string `json:"refreshToken"` // The refresh token returned by the last login or refresh
 }
 
 // HandleAuthentication processes login requests
 // POST /api/auth/login
+// Failed logins are counted; too many of them get 429 Too Many Requests before
+// the password is even checked
 func (h *AuthHandler) HandleAuthentication(c *fiber.Ctx) error{
 	// Parse login parameters from request body
 	var params AuthParams
 	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+
+	// Reject the attempt if there were too many failures for the email or the client
+	if err := h.limiter.Check(c, params.Email); err != nil{
 		return err
 	}
 	
@@ -55,56 +86,494 @@ func (h *AuthHandler) HandleAuthentication(c *fiber.Ctx) error{
 	if err != nil{
 		// If user not found, return invalid credentials
 		// This is a security best practice - don't reveal if the email exists
+		// Unknown emails count as failures too, so the lockout gives nothing away either
 		if errors.Is(err, mongo.ErrNoDocuments){
-			return fmt.Errorf("invalid credentials")
+			if err := h.limiter.Failure(c, params.Email, nil); err != nil{
+				return err
+			}
+			return ErrInvalidCredentials()
 		}
 		return err
 	}
 	
 	// Verify password matches
 	if !types.IsValidPassword(user.EncryptedPassword, params.Password){
-		return fmt.Errorf("Invalid credentials")
+		if err := h.limiter.Failure(c, params.Email, user); err != nil{
+			return err
+		}
+		return ErrInvalidCredentials()
+	}
+	if err := h.limiter.Success(c, params.Email); err != nil{
+		return err
 	}
 	
-	// Generate JWT token for the user
-	token := createTokenFromUser(user)
-	
-	// Create and return the response
-	resp := AuthResponse{
-		User:  user,
-		Token: token,
+	// Generate the tokens for the user, starting a new token family
+	resp, err := h.issueTokens(c, user, primitive.NewObjectID())
+	if err != nil{
+		return err
 	}
 	return c.JSON(resp)
 }
 
-// createTokenFromUser generates a JWT token for the authenticated user
-// The token contains user ID, email, and expiration time
-func createTokenFromUser(user *types.User) string{
+// HandleRegister processes self-service sign-ups from new guests
+// POST /api/register
+// The account is created with the guest role and a token is returned right away,
+// so the client is logged in without a separate call to /api/auth. A link to
+// verify the email address is sent to the new user
+func (h *AuthHandler) HandleRegister(c *fiber.Ctx) error{
+	// Parse registration data from request body
+	var params types.CreateUserParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+
+	// Validate the user input
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	// Create new user from params (includes password hashing)
+	user, err := types.NewUserFromParams(params)
+	if err != nil{
+		return err
+	}
+
+	// Save the user, refusing emails that are already registered (409 Conflict)
+	insertedUser, err := h.userStore.InsertUser(c.Context(), user)
+	if err != nil{
+		return err
+	}
+
+	// The account exists now, so a failed email is only logged; the user can ask for another one
+	if err := h.sendVerification(c, insertedUser); err != nil{
+		log.Printf("sending verification email to user %s: %v", insertedUser.ID.Hex(), err)
+	}
+
+	// Log the new user in
+	resp, err := h.issueTokens(c, insertedUser, primitive.NewObjectID())
+	if err != nil{
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(resp)
+}
+
+// HandleRefresh exchanges a refresh token for a new access token and refresh token
+// POST /api/auth/refresh
+// Every refresh token can be used once. Presenting it a second time means it was
+// copied, so the whole token family is revoked and both the thief and the user
+// have to log in again
+func (h *AuthHandler) HandleRefresh(c *fiber.Ctx) error{
+	var params RefreshParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if params.RefreshToken == ""{
+		return ErrBadRequest("refreshToken is required")
+	}
+
+	token, err := h.tokenStore.GetRefreshToken(c.Context(), types.HashToken(params.RefreshToken))
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return errInvalidRefreshToken()
+		}
+		return err
+	}
+	if token.Revoked || token.IsExpired(time.Now()){
+		return errInvalidRefreshToken()
+	}
+
+	// Use the token up before issuing new ones, so it cannot be used twice
+	if err := h.tokenStore.UseRefreshToken(c.Context(), token.ID); err != nil{
+		if errors.Is(err, db.ErrRefreshTokenReused){
+			if err := h.tokenStore.RevokeTokenFamily(c.Context(), token.FamilyID); err != nil{
+				return err
+			}
+			return NewError(http.StatusUnauthorized, "refresh_token_reused", "refresh token was already used, log in again")
+		}
+		return err
+	}
+
+	// The user may have been deleted since logging in
+	user, err := h.userStore.GetUserById(c.Context(), token.UserID.Hex())
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return errInvalidRefreshToken()
+		}
+		return err
+	}
+
+	resp, err := h.issueTokens(c, user, token.FamilyID)
+	if err != nil{
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// HandleLogout revokes the access token of the request
+// POST /api/auth/logout
+// If the body contains the refresh token it is revoked as well, together with
+// every other token of its family
+func (h *AuthHandler) HandleLogout(c *fiber.Ctx) error{
+	user, ok := getAuthUser(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+	claims, ok := getAuthClaims(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+
+	if err := h.tokenStore.RevokeAccessToken(c.Context(), claims.ID, claims.ExpiresAt.Time); err != nil{
+		return err
+	}
+
+	var params RefreshParams
+	if len(c.Body()) > 0{
+		if err := c.BodyParser(&params); err != nil{
+			return ErrBadRequest("invalid request body")
+		}
+	}
+	if params.RefreshToken != ""{
+		token, err := h.tokenStore.GetRefreshToken(c.Context(), types.HashToken(params.RefreshToken))
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments){
+			return err
+		}
+		// Unknown tokens and tokens of other users are ignored, the logout itself succeeded
+		if err == nil && token.UserID == user.ID{
+			if err := h.tokenStore.RevokeTokenFamily(c.Context(), token.FamilyID); err != nil{
+				return err
+			}
+		}
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// HandleForgotPassword emails a password reset link to the user
+// POST /api/auth/forgot
+// The response is the same whether or not the email belongs to an account, so
+// the endpoint cannot be used to find out who is registered
+func (h *AuthHandler) HandleForgotPassword(c *fiber.Ctx) error{
+	var params types.ForgotPasswordParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if params.Email == ""{
+		return ErrBadRequest("email is required")
+	}
+
+	user, err := h.userStore.GetUserByEmail(c.Context(), params.Email)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return c.SendStatus(http.StatusAccepted)
+		}
+		return err
+	}
+
+	secret, err := h.newUserToken(c, user, types.TokenPurposePasswordReset, h.authCfg.ResetTTL)
+	if err != nil{
+		return err
+	}
+
+	// A failed delivery is not reported to the client either, it would tell that the account exists
+	link := tokenLink(h.authCfg.ResetURL, secret)
+	if err := h.mailer.Send(c.Context(), resetMessage(user, link, h.authCfg.ResetTTL)); err != nil{
+		log.Printf("sending password reset email to user %s: %v", user.ID.Hex(), err)
+	}
+	return c.SendStatus(http.StatusAccepted)
+}
+
+// HandleResetPassword sets a new password with the token from a reset email
+// POST /api/auth/reset
+// The token is used up, and every session of the user is revoked, so whoever
+// may have known the old password is logged out. Following the emailed link
+// also proves that the user owns the address, so it counts as verified
+func (h *AuthHandler) HandleResetPassword(c *fiber.Ctx) error{
+	var params types.ResetPasswordParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	token, err := h.tokenStore.UseUserToken(c.Context(), types.TokenPurposePasswordReset, types.HashToken(params.Token))
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return NewError(http.StatusBadRequest, "invalid_reset_token", "invalid or expired reset token")
+		}
+		return err
+	}
+
+	encpw, err := types.EncryptPassword(params.Password)
+	if err != nil{
+		return err
+	}
+	if err := h.userStore.UpdateUser(c.Context(), bson.M{"_id": token.UserID}, bson.M{"EncryptedPassword": encpw, "emailVerified": true}); err != nil{
+		return err
+	}
+
+	// Links sent before this one must not work anymore either
+	if err := h.tokenStore.InvalidateUserTokens(c.Context(), token.UserID, types.TokenPurposePasswordReset); err != nil{
+		return err
+	}
+	if err := h.tokenStore.RevokeUserSessions(c.Context(), token.UserID); err != nil{
+		return err
+	}
+	return c.SendStatus(http.StatusNoContent)
+}
+
+// HandleChangePassword changes the password of the current user
+// PUT /api/v1/user/me/password
+// The current password has to be given too, so a stolen access token is not
+// enough to take over the account. Wrong current passwords are throttled like
+// failed logins of the account, so the token cannot be used to guess it either.
+// Every session is revoked afterwards and the response carries new tokens for
+// the client that made the change
+func (h *AuthHandler) HandleChangePassword(c *fiber.Ctx) error{
+	user, ok := getAuthUser(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+	var params types.ChangePasswordParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	if err := h.limiter.Check(c, user.Email); err != nil{
+		return err
+	}
+	if !types.IsValidPassword(user.EncryptedPassword, params.CurrentPassword){
+		if err := h.limiter.Failure(c, user.Email, user); err != nil{
+			return err
+		}
+		return NewError(http.StatusForbidden, "invalid_current_password", "current password is incorrect")
+	}
+	if err := h.limiter.Success(c, user.Email); err != nil{
+		return err
+	}
+
+	encpw, err := types.EncryptPassword(params.NewPassword)
+	if err != nil{
+		return err
+	}
+	if err := h.userStore.UpdateUser(c.Context(), bson.M{"_id": user.ID}, bson.M{"EncryptedPassword": encpw}); err != nil{
+		return err
+	}
+	user.EncryptedPassword = encpw
+
+	// A reset link sent earlier would otherwise undo the change
+	if err := h.tokenStore.InvalidateUserTokens(c.Context(), user.ID, types.TokenPurposePasswordReset); err != nil{
+		return err
+	}
+	if err := h.tokenStore.RevokeUserSessions(c.Context(), user.ID); err != nil{
+		return err
+	}
+	resp, err := h.issueTokens(c, user, primitive.NewObjectID())
+	if err != nil{
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// HandleVerifyEmail marks the email address of a user as verified
+// GET /api/auth/verify?token=
+// This is the link in the verification email, so it needs no access token
+func (h *AuthHandler) HandleVerifyEmail(c *fiber.Ctx) error{
+	secret := c.Query("token")
+	if secret == ""{
+		return ErrBadRequest("token is required")
+	}
+
+	token, err := h.tokenStore.UseUserToken(c.Context(), types.TokenPurposeEmailVerification, types.HashToken(secret))
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return NewError(http.StatusBadRequest, "invalid_verification_token", "invalid or expired verification token")
+		}
+		return err
+	}
+	if err := h.userStore.UpdateUser(c.Context(), bson.M{"_id": token.UserID}, bson.M{"emailVerified": true}); err != nil{
+		return err
+	}
+	return c.JSON(map[string]string{"verified": token.UserID.Hex()})
+}
+
+// HandleResendVerification sends a new verification email to the current user
+// POST /api/auth/verify/resend
+// Links sent before stop working, only the new one can be used
+func (h *AuthHandler) HandleResendVerification(c *fiber.Ctx) error{
+	user, ok := getAuthUser(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+	if user.EmailVerified{
+		return ErrConflict("email_already_verified", "email address is already verified")
+	}
+	if err := h.sendVerification(c, user); err != nil{
+		return err
+	}
+	return c.SendStatus(http.StatusAccepted)
+}
+
+// sendVerification emails a new verification link to the user
+func (h *AuthHandler) sendVerification(c *fiber.Ctx, user *types.User) error{
+	secret, err := h.newUserToken(c, user, types.TokenPurposeEmailVerification, h.authCfg.VerifyTTL)
+	if err != nil{
+		return err
+	}
+	link := tokenLink(h.authCfg.VerifyURL, secret)
+	return h.mailer.Send(c.Context(), verificationMessage(user, link, h.authCfg.VerifyTTL))
+}
+
+// newUserToken stores a new token of the purpose for the user and returns its secret
+// Only the newest token of a purpose works, older ones are invalidated
+func (h *AuthHandler) newUserToken(c *fiber.Ctx, user *types.User, purpose types.TokenPurpose, ttl time.Duration) (string, error){
+	if err := h.tokenStore.InvalidateUserTokens(c.Context(), user.ID, purpose); err != nil{
+		return "", err
+	}
+	token, secret, err := types.NewUserToken(user.ID, purpose, ttl)
+	if err != nil{
+		return "", err
+	}
+	if _, err := h.tokenStore.InsertUserToken(c.Context(), token); err != nil{
+		return "", err
+	}
+	return secret, nil
+}
+
+// tokenLink returns the link to base carrying the token in its query
+func tokenLink(base, secret string) string{
+	link, err := url.Parse(base)
+	if err != nil{
+		// The configuration has been validated, fall back to appending the token
+		return base + "?token=" + url.QueryEscape(secret)
+	}
+	query := link.Query()
+	query.Set("token", secret)
+	link.RawQuery = query.Encode()
+	return link.String()
+}
+
+// resetMessage builds the password reset email
+func resetMessage(user *types.User, link string, ttl time.Duration) notify.Message{
+	return notify.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(`Hello %s,
+
+someone asked to reset the password of your Hotel Reservation account.
+To choose a new password, open this link within %s:
+
+%s
+
+If it was not you, you can ignore this email. Your password stays the same.
+`, user.FirstName, ttl, link),
+	}
+}
+
+// verificationMessage builds the email verification email
+func verificationMessage(user *types.User, link string, ttl time.Duration) notify.Message{
+	return notify.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(`Hello %s,
+
+welcome to Hotel Reservation! Please confirm your email address by opening
+this link within %s:
+
+%s
+
+If you did not create an account, you can ignore this email.
+`, user.FirstName, ttl, link),
+	}
+}
+
+// issueTokens creates an access token and a refresh token of the given family for the user
+func (h *AuthHandler) issueTokens(c *fiber.Ctx, user *types.User, familyID primitive.ObjectID) (*AuthResponse, error){
+	accessToken, claims, err := createTokenFromUser(user, h.keySet, h.authCfg)
+	if err != nil{
+		return nil, err
+	}
+
+	refreshToken, secret, err := types.NewRefreshToken(user.ID, familyID, h.authCfg.RefreshTTL)
+	if err != nil{
+		return nil, err
+	}
+	// Remember the access token, so it can be revoked with the family
+	refreshToken.AccessTokenID = claims.ID
+	refreshToken.AccessExpiresAt = claims.ExpiresAt.Time
+	if _, err := h.tokenStore.InsertRefreshToken(c.Context(), refreshToken); err != nil{
+		return nil, err
+	}
+
+	return &AuthResponse{
+		User:         user,
+		Token:        accessToken,
+		RefreshToken: secret,
+	}, nil
+}
+
+// errInvalidRefreshToken is returned for refresh tokens that are unknown, expired or revoked
+func errInvalidRefreshToken() Error{
+	return NewError(http.StatusUnauthorized, "invalid_refresh_token", "invalid refresh token")
+}
+
+// Claims is the payload of the JWT tokens issued by the API
+// The user ID is the subject (sub) and expiry, issuer and audience use the registered
+// claims, so they are checked by the jwt library when the token is parsed.
+// Email and role are a snapshot taken when the token was issued and only a hint
+// for clients. The API never authorizes with them: JWTAuthentication loads the
+// user from the store on every request, so a changed role or hotel assignment
+// applies right away, and the hotels of a staff member are not in the token at all
+type Claims struct{
+	Email string     `json:"email"` // Email for reference
+	Role  types.Role `json:"role"`  // Role when the token was issued, so clients know what the user may do
+	jwt.RegisteredClaims
+}
+
+// createTokenFromUser generates a JWT access token for the authenticated user
+// The token is issued for the configured audience, expires after the configured
+// lifetime and is signed with the current key of the key set. Its unique ID (jti)
+// is what logging out revokes
+func createTokenFromUser(user *types.User, keySet *keys.KeySet, authCfg config.AuthConfig) (string, *Claims, error){
+	tokenID, err := types.NewTokenID()
+	if err != nil{
+		return "", nil, err
+	}
 	now := time.Now()
-	// Token expires in 4 hours
-	expires := now.Add(time.Hour*4).Unix()
-	
-	// Create JWT claims (payload data)
-	claims := jwt.MapClaims{
-		"id":      user.ID,       // User ID for identification
-		"email":   user.Email,    // Email for reference
-		"expires": expires,       // Expiration timestamp
+	claims := &Claims{
+		Email: user.Email,
+		Role:  user.Role,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Subject:   user.ID.Hex(),
+			Issuer:    authCfg.Issuer,
+			Audience:  jwt.ClaimStrings{authCfg.Audience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(authCfg.TokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+		},
 	}
 	
-	// Create a new token with the claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	
-	// Get the signing key from environment variables
-	secret := os.Getenv("JWT_SECRET")
-	
-	// Sign the token with the secret key
-	tokenStr, err := token.SignedString([]byte(secret))
-	fmt.Println(secret)
-	
-	// Handle signing errors
+	// Sign the token with the current signing key
+	tokenStr, err := keySet.Sign(claims)
 	if err != nil{
-		fmt.Println("Failed to sign token with secret", err)
+		return "", nil, fmt.Errorf("signing token: %w", err)
 	}
 	
-	return tokenStr
-}
\ No newline at end of file
+	return tokenStr, claims, nil
+}
+
+// getAuthUser returns the user that JWTAuthentication stored on the request
+// The second return value is false if the request is not authenticated
+func getAuthUser(c *fiber.Ctx) (*types.User, bool){
+	user, ok := c.Context().UserValue("user").(*types.User)
+	return user, ok
+}
+
+// getAuthClaims returns the claims of the access token JWTAuthentication accepted
+func getAuthClaims(c *fiber.Ctx) (*Claims, bool){
+	claims, ok := c.Context().UserValue("claims").(*Claims)
+	return claims, ok
+}
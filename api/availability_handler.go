@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AvailabilityHandler handles searches for rooms that are free over a date range
+type AvailabilityHandler struct{
+	store *db.Store // Central store providing access to all database collections
+}
+
+// NewAvailabilityHandler creates a new AvailabilityHandler with the provided store
+func NewAvailabilityHandler(store *db.Store) *AvailabilityHandler{
+	return &AvailabilityHandler{
+		store: store,
+	}
+}
+
+// AvailabilityQueryParams defines the query accepted by the availability search
+// Dates are given either as 2006-01-02 or as RFC 3339 timestamps
+type AvailabilityQueryParams struct{
+	From     string `query:"from"`     // Check-in date
+	Till     string `query:"till"`     // Check-out date
+	Guests   int    `query:"guests"`   // Number of guests that must fit into a room, defaults to 1
+	Location string `query:"location"` // Case-insensitive substring of the hotel location
+}
+
+// HotelAvailability is a hotel together with its rooms that are free for the whole range
+type HotelAvailability struct{
+	Hotel *types.Hotel   `json:"hotel"`
+	Rooms []*types.Room  `json:"rooms"`
+}
+
+// dateRange parses and validates the requested stay
+// The same rules as for booking a room apply, so every room returned can actually be booked
+func (p AvailabilityQueryParams) dateRange() (time.Time, time.Time, error){
+	from, err := parseQueryDate(p.From)
+	if err != nil{
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid from date: %w", err)
+	}
+	till, err := parseQueryDate(p.Till)
+	if err != nil{
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid till date: %w", err)
+	}
+	params := BookRoomParams{FromDate: from, TillDate: till, NumPersons: p.Guests}
+	if err := params.validate(); err != nil{
+		return time.Time{}, time.Time{}, err
+	}
+	return from, till, nil
+}
+
+// parseQueryDate parses a date passed in a query string
+func parseQueryDate(s string) (time.Time, error){
+	if s == ""{
+		return time.Time{}, fmt.Errorf("date is required")
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil{
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// HandleGetAvailability processes requests for the hotels and rooms free over a date range
+// GET /api/v1/availability?from=2030-01-10&till=2030-01-12&guests=2&location=rome
+// Rooms are checked with one aggregate query rather than one query per room
+func (h *AvailabilityHandler) HandleGetAvailability(c *fiber.Ctx) error{
+	var params AvailabilityQueryParams
+	if err := c.QueryParser(&params); err != nil{
+		return ErrBadRequest("invalid query parameters")
+	}
+	if params.Guests == 0{
+		params.Guests = 1
+	}
+	from, till, err := params.dateRange()
+	if err != nil{
+		return ErrBadRequest(err.Error())
+	}
+
+	// Narrow the search down to the hotels in the requested location first
+	roomFilter := bson.M{}
+	hotelFilter := bson.M{}
+	if params.Location != ""{
+		hotelFilter["location"] = containsIgnoreCase(params.Location)
+	}
+	hotels, err := h.store.Hotel.GetHotels(c.Context(), hotelFilter)
+	if err != nil{
+		return err
+	}
+	if params.Location != ""{
+		hotelIDs := make([]primitive.ObjectID, len(hotels))
+		for i, hotel := range hotels{
+			hotelIDs[i] = hotel.ID
+		}
+		roomFilter["hotelID"] = bson.M{"$in": hotelIDs}
+	}
+
+	// Rooms being deleted cannot be booked anymore
+	rooms, err := h.store.Room.GetAvailableRooms(c.Context(), db.ListedRoomsFilter(roomFilter, time.Now()), overlappingBookingsFilter(from, till))
+	if err != nil{
+		return err
+	}
+
+	// Group the free rooms that fit everyone by hotel, leaving out hotels without any
+	roomsByHotel := map[primitive.ObjectID][]*types.Room{}
+	for _, room := range rooms{
+		if room.Capacity() < params.Guests{
+			continue
+		}
+		roomsByHotel[room.HotelID] = append(roomsByHotel[room.HotelID], room)
+	}
+	resp := []HotelAvailability{}
+	for _, hotel := range hotels{
+		if free := roomsByHotel[hotel.ID]; len(free) > 0{
+			resp = append(resp, HotelAvailability{Hotel: hotel, Rooms: free})
+		}
+	}
+	return c.JSON(resp)
+}
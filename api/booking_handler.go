@@ -0,0 +1,460 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BookingHandler handles HTTP requests related to existing bookings
+// It lets guests read back and manage the bookings they made through RoomHandler
+type BookingHandler struct {
+	store    *db.Store        // Central store providing access to all database collections
+	payments *bookingPayments // Pays for holds and refunds cancelled bookings
+}
+
+// NewBookingHandler creates a new BookingHandler with the provided store and payment provider
+// Factory function to create handlers with dependency injection
+func NewBookingHandler(store *db.Store, provider payments.Provider, paymentsCfg config.PaymentsConfig) *BookingHandler {
+	return &BookingHandler{
+		store:    store,
+		payments: newBookingPayments(store.Payment, provider, paymentsCfg),
+	}
+}
+
+// BookingQueryParams defines the filters accepted when listing bookings
+type BookingQueryParams struct {
+	When   string `query:"when"`   // "upcoming" for stays that have not ended yet, "past" for finished ones
+	Status string `query:"status"` // Only return bookings with this status
+}
+
+// ModifyBookingParams defines the changes accepted for an existing booking
+// Fields left out keep their current value
+type ModifyBookingParams struct {
+	FromDate   time.Time          `json:"fromDate"`   // New check-in date
+	TillDate   time.Time          `json:"tillDate"`   // New check-out date
+	NumPersons    int                `json:"numPersons"`    // New number of guests
+	RoomID        primitive.ObjectID `json:"roomID"`        // Another room of the same hotel
+	PaymentMethod string             `json:"paymentMethod"` // Token of the guest's payment method, needed when the total of a paid booking changes
+}
+
+// apply returns a copy of the booking with the changes applied
+func (p ModifyBookingParams) apply(booking *types.Booking) *types.Booking {
+	modified := *booking
+	if !p.FromDate.IsZero() {
+		modified.FromDate = p.FromDate
+	}
+	if !p.TillDate.IsZero() {
+		modified.TillDate = p.TillDate
+	}
+	if p.NumPersons != 0 {
+		modified.NumPerson = p.NumPersons
+	}
+	if !p.RoomID.IsZero() {
+		modified.RoomID = p.RoomID
+	}
+	return &modified
+}
+
+// ConfirmBookingParams defines the data needed to confirm a hold
+type ConfirmBookingParams struct {
+	PaymentMethod string `json:"paymentMethod"` // Token of the guest's payment method
+}
+
+// BookingDetail is a booking together with the room and hotel it belongs to
+type BookingDetail struct {
+	*types.Booking
+	Room                   *types.Room              `json:"room"`                   // Null once the room has been deleted
+	Hotel                  *types.Hotel             `json:"hotel"`                  // Null once the room or the hotel has been deleted
+	Payments               []*types.Payment         `json:"payments"`               // Every payment attempt, oldest first
+	CancellationPolicy     types.CancellationPolicy `json:"cancellationPolicy"`     // The policy the booking was made under
+	CancellationPolicyText string                   `json:"cancellationPolicyText"` // The policy as shown to the guest
+}
+
+// HandleGetBookings processes requests to list the authenticated user's bookings
+// GET /api/v1/booking?when=upcoming|past&status=confirmed
+func (h *BookingHandler) HandleGetBookings(c *fiber.Ctx) error {
+	var params BookingQueryParams
+	if err := c.QueryParser(&params); err != nil {
+		return ErrBadRequest("invalid query parameters")
+	}
+
+	user, ok := getAuthUser(c)
+	if !ok {
+		return ErrUnauthorized()
+	}
+
+	// Build the filter, always scoped to the caller's own bookings
+	filter := bson.M{"userID": user.ID}
+	switch params.When {
+	case "":
+	case "upcoming":
+		filter["tillDate"] = bson.M{"$gt": time.Now()}
+	case "past":
+		filter["tillDate"] = bson.M{"$lte": time.Now()}
+	default:
+		return ErrBadRequest("when must be upcoming or past")
+	}
+	if params.Status != "" {
+		filter["status"] = types.BookingStatus(params.Status)
+	}
+
+	bookings, err := h.store.Booking.GetBookings(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+	return c.JSON(bookings)
+}
+
+// HandleGetBooking processes requests to get a single booking with its room and hotel
+// GET /api/v1/booking/:id
+// Responds with 403 if the booking belongs to someone else
+func (h *BookingHandler) HandleGetBooking(c *fiber.Ctx) error {
+	booking, err := h.getAccessibleBooking(c)
+	if err != nil {
+		return err
+	}
+
+	// Embed the room and the hotel it belongs to, as far as they still exist
+	room, err := h.store.Room.GetRoomByID(c.Context(), booking.RoomID)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	var hotel *types.Hotel
+	if room != nil {
+		hotel, err = h.store.Hotel.GetHotelByID(c.Context(), room.HotelID)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+	}
+	paid, err := h.store.Payment.GetPayments(c.Context(), bson.M{"bookingID": booking.ID})
+	if err != nil {
+		return err
+	}
+
+	policy := booking.ApplicableCancellationPolicy()
+	return c.JSON(BookingDetail{
+		Booking:                booking,
+		Room:                   room,
+		Hotel:                  hotel,
+		Payments:               paid,
+		CancellationPolicy:     policy,
+		CancellationPolicyText: policy.Description(),
+	})
+}
+
+// HandleCancelBooking processes requests to cancel a booking
+// PUT /api/v1/booking/:id/cancel
+// Only the guest who made the booking, staff of its hotel or an admin may cancel it.
+// The cancellation policy the booking was made under decides the penalty, which
+// is charged to the payments of the booking; the rest of them is refunded. The
+// penalty and refund are returned in the cancellation of the booking.
+// The booking is cancelled first and marked settlementPending until the gateway
+// has settled its payments. If the gateway fails, the booking stays cancelled and
+// cancelling it again finishes the settlement
+func (h *BookingHandler) HandleCancelBooking(c *fiber.Ctx) error {
+	booking, err := h.getAccessibleBooking(c)
+	if err != nil {
+		return err
+	}
+
+	if !booking.IsSettlementPending() {
+		booking, err = h.store.Booking.CancelBooking(c.Context(), booking.ID, types.NewCancellation(booking, time.Now()))
+		if err != nil {
+			return err
+		}
+		if !booking.IsSettlementPending() {
+			return c.JSON(booking)
+		}
+	}
+
+	refund, err := h.payments.settleCancellation(c.Context(), booking)
+	if err != nil {
+		return err
+	}
+	settled, err := h.store.Booking.SettleCancellation(c.Context(), booking.ID, refund)
+	if err != nil {
+		return err
+	}
+	return c.JSON(settled)
+}
+
+// HandleConfirmBooking processes requests to pay for a hold and confirm it
+// PUT /api/v1/booking/:id/confirm
+// Responds with 409 and the reason hold_expired if the hold ran out first. A
+// declined payment returns 402 and leaves the hold as it is, so the guest can
+// try another payment method. If the gateway needs more time the hold is
+// answered with 202 and confirmed once the gateway reports the outcome.
+// Only the guest who made the booking or an admin may confirm it
+func (h *BookingHandler) HandleConfirmBooking(c *fiber.Ctx) error {
+	var params ConfirmBookingParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest("invalid request body")
+	}
+	if params.PaymentMethod == "" {
+		return ErrBadRequest("paymentMethod is required")
+	}
+	booking, err := h.getChangeableBooking(c)
+	if err != nil {
+		return err
+	}
+	// Do not charge for a hold that cannot be confirmed anymore
+	switch {
+	case booking.Status == types.BookingStatusExpired || booking.IsHoldExpired(time.Now()):
+		return db.ErrHoldExpired
+	case booking.Status != types.BookingStatusHeld:
+		return db.ErrBookingNotHeld
+	}
+
+	payment, err := h.payments.authorize(c.Context(), booking, params.PaymentMethod)
+	if err != nil {
+		return err
+	}
+	switch payment.Status {
+	case types.PaymentStatusPending:
+		return c.Status(http.StatusAccepted).JSON(booking)
+	case types.PaymentStatusFailed:
+		return errPaymentDeclined(payment)
+	}
+
+	confirmed, err := h.store.Booking.ConfirmHold(c.Context(), booking.ID)
+	if err != nil {
+		// The hold ran out while the payment was authorized
+		if refundErr := h.payments.refundPayment(c.Context(), payment); refundErr != nil {
+			return refundErr
+		}
+		return err
+	}
+	return c.JSON(confirmed)
+}
+
+// HandleModifyBooking processes requests to change the dates, guests or room of a booking
+// PATCH /api/v1/booking/:id
+// The booking keeps the nights it already holds while it is moved, so a guest
+// extending a stay never risks losing the room. The price is recomputed at the
+// current rate of the room and the previous terms are added to the history.
+// If a confirmed booking ends up with a new total, the new total is authorized
+// with the given payment method before the change is made and the payments made
+// so far are given back afterwards. Bookings with a payment the gateway has not
+// decided yet cannot be changed, whatever their status: a hold whose confirmation
+// was answered with 202 would otherwise be confirmed for terms it was not paid for.
+// Only the guest who made the booking or an admin may change it
+func (h *BookingHandler) HandleModifyBooking(c *fiber.Ctx) error {
+	var params ModifyBookingParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest("invalid request body")
+	}
+	booking, err := h.getChangeableBooking(c)
+	if err != nil {
+		return err
+	}
+	user, ok := getAuthUser(c)
+	if !ok {
+		return ErrUnauthorized()
+	}
+	if !booking.IsCancellable() {
+		return ErrConflict("booking_not_modifiable", "booking cannot be modified")
+	}
+	pending, err := h.payments.hasPendingPayment(c.Context(), booking.ID)
+	if err != nil {
+		return err
+	}
+	if pending || booking.Status == types.BookingStatusPending {
+		return ErrConflict("booking_not_modifiable", "the payment of the booking is still pending")
+	}
+	if booking.IsHoldExpired(time.Now()) {
+		return db.ErrHoldExpired
+	}
+
+	modified := params.apply(booking)
+	if modified.RoomID == booking.RoomID && modified.FromDate.Equal(booking.FromDate) &&
+		modified.TillDate.Equal(booking.TillDate) && modified.NumPerson == booking.NumPerson {
+		return ErrBadRequest("nothing to change")
+	}
+	terms := BookRoomParams{FromDate: modified.FromDate, TillDate: modified.TillDate, NumPersons: modified.NumPerson}
+	if err := terms.validate(); err != nil {
+		return ErrBadRequest(err.Error())
+	}
+
+	room, err := h.getNewRoom(c, booking, modified.RoomID)
+	if err != nil {
+		return err
+	}
+	if err := checkRoomCapacity(room, modified.NumPerson); err != nil {
+		return err
+	}
+
+	// Fast path like in HandleBookRoom, the store decides atomically in the end
+	filter := overlappingBookingsFilter(modified.FromDate, modified.TillDate)
+	filter["roomID"] = room.ID
+	filter["_id"] = bson.M{"$ne": booking.ID}
+	conflicts, err := h.store.Booking.GetBookings(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+	if len(conflicts) > 0 {
+		return ErrConflict("room_already_booked", "room already booked")
+	}
+
+	modified.Price = types.NewPriceBreakdown(room.Price, modified.FromDate, modified.TillDate)
+	previous, payment, err := h.reauthorize(c.Context(), booking, modified, params.PaymentMethod)
+	if err != nil {
+		return err
+	}
+	updated, err := h.store.Booking.ModifyBooking(c.Context(), modified, types.NewBookingChange(booking, user.ID))
+	if err != nil {
+		// The booking keeps its terms and the payments made for them
+		if payment != nil {
+			if refundErr := h.payments.refundPayment(c.Context(), payment); refundErr != nil {
+				return refundErr
+			}
+		}
+		return err
+	}
+	if err := h.payments.releasePayments(c.Context(), previous); err != nil {
+		return err
+	}
+	return c.JSON(updated)
+}
+
+// reauthorize authorizes the new total of a modified booking that has been paid for
+// It returns the payments the new one replaces together with the new payment,
+// or nothing if the booking is not paid for or its total stays the same
+func (h *BookingHandler) reauthorize(ctx context.Context, booking, modified *types.Booking, paymentMethod string) ([]*types.Payment, *types.Payment, error) {
+	if booking.Status != types.BookingStatusConfirmed {
+		return nil, nil, nil
+	}
+	previous, err := h.payments.activePayments(ctx, booking.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Bookings made before payments existed have nothing to replace
+	if len(previous) == 0 || paidAmount(previous) == modified.Price.Total {
+		return nil, nil, nil
+	}
+	if paymentMethod == "" {
+		return nil, nil, ErrBadRequest("paymentMethod is required to change the total of a paid booking")
+	}
+
+	payment, err := h.payments.authorize(ctx, modified, paymentMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch payment.Status {
+	case types.PaymentStatusFailed:
+		return nil, nil, errPaymentDeclined(payment)
+	case types.PaymentStatusPending:
+		// The change cannot wait for the gateway, the payment is given back
+		// once the webhook reports it authorized
+		return nil, nil, NewError(http.StatusPaymentRequired, "payment_not_authorized", "the new total could not be authorized right away, try another payment method")
+	}
+	return previous, payment, nil
+}
+
+// getNewRoom loads the room a booking is moved to
+// A booking can only move between rooms of the same hotel
+func (h *BookingHandler) getNewRoom(c *fiber.Ctx, booking *types.Booking, roomID primitive.ObjectID) (*types.Room, error) {
+	current, err := h.store.Room.GetRoomByID(c.Context(), booking.RoomID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound("room")
+		}
+		return nil, err
+	}
+	if roomID == current.ID {
+		return current, nil
+	}
+	room, err := h.store.Room.GetRoomByID(c.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound("room")
+		}
+		return nil, err
+	}
+	if room.HotelID != current.HotelID {
+		return nil, NewError(http.StatusBadRequest, "different_hotel", "a booking can only move to a room of the same hotel")
+	}
+	return room, nil
+}
+
+// getAccessibleBooking loads the booking named by the :id parameter
+// Returns a 404 error if it does not exist and a 403 error if the
+// authenticated user is not allowed to access it
+func (h *BookingHandler) getAccessibleBooking(c *fiber.Ctx) (*types.Booking, error) {
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, ErrInvalidID()
+	}
+
+	user, ok := getAuthUser(c)
+	if !ok {
+		return nil, ErrUnauthorized()
+	}
+
+	booking, err := h.store.Booking.GetBookingByID(c.Context(), oid)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound("booking")
+		}
+		return nil, err
+	}
+
+	allowed, err := h.canAccessBooking(c.Context(), user, booking)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, ErrForbidden()
+	}
+	return booking, nil
+}
+
+// getChangeableBooking loads the booking named by the :id parameter for a change
+// that pays with the guest's payment method, like confirming or modifying it
+// Staff of the hotel may see and cancel the booking but not change it, so
+// everyone but the guest who made it and admins gets a 403 error
+func (h *BookingHandler) getChangeableBooking(c *fiber.Ctx) (*types.Booking, error) {
+	booking, err := h.getAccessibleBooking(c)
+	if err != nil {
+		return nil, err
+	}
+	user, ok := getAuthUser(c)
+	if !ok {
+		return nil, ErrUnauthorized()
+	}
+	if booking.UserID != user.ID && !user.IsAdmin() {
+		return nil, ErrForbidden()
+	}
+	return booking, nil
+}
+
+// canAccessBooking reports whether the user may read or cancel the booking
+// Guests can only access their own bookings, staff the bookings of the hotels
+// they work for and admins all of them
+func (h *BookingHandler) canAccessBooking(ctx context.Context, user *types.User, booking *types.Booking) (bool, error) {
+	if booking.UserID == user.ID || user.IsAdmin() {
+		return true, nil
+	}
+	if !user.HasRole(types.RoleStaff) {
+		return false, nil
+	}
+	// Once the room is deleted no hotel claims the booking any more
+	room, err := h.store.Room.GetRoomByID(ctx, booking.RoomID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return user.CanManageHotel(room.HotelID), nil
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Error is an error that knows how it should be reported to API clients
+// Handlers return it like any other error and ErrorHandler turns it into
+// a JSON response with the right HTTP status
+type Error struct {
+	Code   int    `json:"code"`   // HTTP status code of the response
+	Reason string `json:"reason"` // Machine-readable reason, e.g. "room_already_booked"
+	Err    string `json:"error"`  // Human-readable description
+}
+
+// Error implements the error interface
+func (e Error) Error() string {
+	return e.Err
+}
+
+// NewError creates a new Error with the given HTTP status, reason and message
+func NewError(code int, reason, err string) Error {
+	return Error{
+		Code:   code,
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+// ErrBadRequest is returned when the request is malformed or fails validation
+func ErrBadRequest(err string) Error {
+	return NewError(http.StatusBadRequest, "bad_request", err)
+}
+
+// ErrInvalidID is returned when a path parameter is not a valid ObjectID
+func ErrInvalidID() Error {
+	return NewError(http.StatusBadRequest, "invalid_id", "invalid id given")
+}
+
+// ErrUnauthorized is returned when the request is not authenticated
+func ErrUnauthorized() Error {
+	return NewError(http.StatusUnauthorized, "unauthorized", "unauthorized request")
+}
+
+// ErrInvalidCredentials is returned when logging in with a wrong email or password
+func ErrInvalidCredentials() Error {
+	return NewError(http.StatusUnauthorized, "invalid_credentials", "invalid credentials")
+}
+
+// ErrTooManyAttempts is returned when logins have to wait after failed attempts
+func ErrTooManyAttempts() Error {
+	return NewError(http.StatusTooManyRequests, "too_many_attempts", "too many failed logins, try again later")
+}
+
+// ErrForbidden is returned when the user is authenticated but not allowed to do something
+func ErrForbidden() Error {
+	return NewError(http.StatusForbidden, "forbidden", "forbidden")
+}
+
+// ErrNotFound is returned when the requested resource does not exist
+func ErrNotFound(resource string) Error {
+	return NewError(http.StatusNotFound, "not_found", fmt.Sprintf("%s not found", resource))
+}
+
+// ErrConflict is returned when the request conflicts with the current state of a resource
+func ErrConflict(reason, err string) Error {
+	return NewError(http.StatusConflict, reason, err)
+}
+
+// ErrorHandler is the fiber error handler used by the application
+// It responds with the status and reason of an api.Error, maps well-known
+// store errors to their matching status and hides everything else behind a 500
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	apiErr := toAPIError(err)
+	if apiErr.Code == http.StatusInternalServerError {
+		log.Printf("internal error on %s %s: %v", c.Method(), c.Path(), err)
+	}
+	return c.Status(apiErr.Code).JSON(apiErr)
+}
+
+// toAPIError converts any error returned by a handler into an Error
+func toAPIError(err error) Error {
+	var apiErr Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	// Errors from fiber itself, e.g. unknown routes or unsupported content types
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return NewError(fiberErr.Code, "http_error", fiberErr.Message)
+	}
+
+	var hexErr hex.InvalidByteError
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return ErrNotFound("resource")
+	case errors.Is(err, primitive.ErrInvalidHex), errors.As(err, &hexErr):
+		return ErrInvalidID()
+	case errors.Is(err, db.ErrRoomDeleted):
+		return ErrNotFound("room")
+	case errors.Is(err, db.ErrHotelHasRooms):
+		return ErrConflict("hotel_has_rooms", "delete the rooms of the hotel first")
+	case errors.Is(err, db.ErrRoomAlreadyBooked):
+		return ErrConflict("room_already_booked", err.Error())
+	case errors.Is(err, db.ErrBookingNotCancellable):
+		return ErrConflict("booking_not_cancellable", err.Error())
+	case errors.Is(err, db.ErrBookingNotModifiable):
+		return ErrConflict("booking_not_modifiable", err.Error())
+	case errors.Is(err, db.ErrHoldExpired):
+		return ErrConflict("hold_expired", err.Error())
+	case errors.Is(err, db.ErrBookingNotHeld):
+		return ErrConflict("booking_not_held", err.Error())
+	case errors.Is(err, db.ErrBookingNotPending):
+		return ErrConflict("booking_not_pending", err.Error())
+	case errors.Is(err, db.ErrPaymentStatusChanged):
+		return ErrConflict("payment_status_changed", err.Error())
+	case errors.Is(err, db.ErrDuplicateEmail):
+		return ErrConflict("duplicate_email", err.Error())
+	}
+	return NewError(http.StatusInternalServerError, "internal_error", "internal server error")
+}
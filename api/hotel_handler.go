@@ -1,10 +1,19 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // HotelHandler handles HTTP requests related to hotel and room operations
@@ -21,13 +30,110 @@ func NewHotelHandler(store *db.Store)*HotelHandler{
 	}
 }
 
-// type HotelQueryParams struct{
-// 	Rooms bool
-// 	Rating int
-// }
+// Limits for paginated hotel listings
+const (
+	defaultHotelPageSize = 20  // Page size used when the client does not ask for one
+	maxHotelPageSize     = 100 // Largest page a client can request
+)
+
+// hotelSortFields maps the sort values accepted from clients to document fields
+var hotelSortFields = map[string]string{
+	"name":     "name",
+	"rating":   "rating",
+	"location": "location",
+}
+
+// HotelQueryParams defines the filters, sorting and paging accepted when listing hotels
+type HotelQueryParams struct{
+	MinRating int    `query:"minRating"` // Only hotels rated at least this
+	MaxRating int    `query:"maxRating"` // Only hotels rated at most this
+	Location  string `query:"location"`  // Case-insensitive substring of the location
+	Name      string `query:"name"`      // Case-insensitive substring of the name
+	Sort      string `query:"sort"`      // name, rating or location; prefix with "-" for descending order
+	Page      int64  `query:"page"`      // 1-based page number
+	Limit     int64  `query:"limit"`     // Hotels per page
+}
+
+// ResourceResponse is one page of a listing together with paging information
+type ResourceResponse struct{
+	Data    interface{} `json:"data"`    // The results on this page
+	Results int         `json:"results"` // Number of results on this page
+	Total   int64       `json:"total"`   // Number of results across all pages
+	Page    int64       `json:"page"`    // Current page number
+	Limit   int64       `json:"limit"`   // Page size
+}
+
+// filter builds the database filter described by the query parameters
+func (p HotelQueryParams) filter() bson.M{
+	filter := bson.M{}
+	rating := bson.M{}
+	if p.MinRating > 0{
+		rating["$gte"] = p.MinRating
+	}
+	if p.MaxRating > 0{
+		rating["$lte"] = p.MaxRating
+	}
+	if len(rating) > 0{
+		filter["rating"] = rating
+	}
+	if p.Location != ""{
+		filter["location"] = containsIgnoreCase(p.Location)
+	}
+	if p.Name != ""{
+		filter["name"] = containsIgnoreCase(p.Name)
+	}
+	return filter
+}
+
+// findOptions validates the sorting and paging parameters and converts them for the store
+// Missing values fall back to the first page of defaultHotelPageSize hotels sorted by name
+func (p *HotelQueryParams) findOptions() (*db.FindOptions, error){
+	if p.MinRating < 0 || p.MaxRating < 0 || (p.MaxRating > 0 && p.MinRating > p.MaxRating){
+		return nil, ErrBadRequest("invalid rating range")
+	}
+	if p.Page < 0 || p.Limit < 0{
+		return nil, ErrBadRequest("page and limit must be positive")
+	}
+	if p.Page == 0{
+		p.Page = 1
+	}
+	if p.Limit == 0{
+		p.Limit = defaultHotelPageSize
+	}
+	if p.Limit > maxHotelPageSize{
+		p.Limit = maxHotelPageSize
+	}
+
+	sort := p.Sort
+	direction := 1
+	if strings.HasPrefix(sort, "-"){
+		sort = sort[1:]
+		direction = -1
+	}
+	if sort == ""{
+		sort = "name"
+	}
+	field, ok := hotelSortFields[sort]
+	if !ok{
+		return nil, ErrBadRequest("cannot sort by " + sort)
+	}
+
+	return &db.FindOptions{
+		// Sorting by _id as well keeps pages stable when several hotels share a value
+		Sort:  bson.D{{Key: field, Value: direction}, {Key: "_id", Value: 1}},
+		Page:  p.Page,
+		Limit: p.Limit,
+	}, nil
+}
+
+// containsIgnoreCase returns a filter matching strings that contain s, ignoring case
+func containsIgnoreCase(s string) primitive.Regex{
+	return primitive.Regex{Pattern: regexp.QuoteMeta(s), Options: "i"}
+}
 
 // HandleGetRooms processes requests to get all rooms for a specific hotel
 // GET /api/hotel/:id/rooms
+// Rooms that are being deleted are left out
 func (h *HotelHandler) HandleGetRooms(c *fiber.Ctx) error{
 	// Extract hotel ID from URL parameters
 	id := c.Params("id")
@@ -35,11 +141,11 @@ func (h *HotelHandler) HandleGetRooms(c *fiber.Ctx) error{
 	// Convert string ID to MongoDB ObjectID
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil{
-		return err
+		return ErrInvalidID()
 	}
 	
-	// Create filter to find rooms for this specific hotel
-	filter := bson.M{"hotelID": oid}
+	// Create filter to find rooms for this specific hotel, except those being deleted
+	filter := db.ListedRoomsFilter(bson.M{"hotelID": oid}, time.Now())
 	
 	// Fetch rooms from the database
 	rooms, err := h.store.Room.GetRooms(c.Context(), filter)
@@ -51,18 +157,38 @@ func (h *HotelHandler) HandleGetRooms(c *fiber.Ctx) error{
 	return c.JSON(rooms)
 }
 
-// HandleGetHotels processes requests to get all hotels
-// GET /api/hotel
-// Can be extended to support query parameters for filtering
+// HandleGetHotels processes requests to search hotels
+// GET /api/hotel?minRating=3&location=paris&name=grand&sort=-rating&page=1&limit=20
+// Responds with one page of hotels and the total number of matches
 func (h *HotelHandler) HandleGetHotels(c *fiber.Ctx) error{
-	// The nil filter means "get all hotels" (no conditions)
-	hotels, err := h.store.Hotel.GetHotels(c.Context(), nil)
+	// Parse filters, sorting and paging from the query string
+	var params HotelQueryParams
+	if err := c.QueryParser(&params); err != nil{
+		return ErrBadRequest("invalid query parameters")
+	}
+	opts, err := params.findOptions()
 	if err != nil{
 		return err
 	}
-	
-	// Return hotels as JSON array
-	return c.JSON(hotels)
+	filter := params.filter()
+
+	// Fetch the requested page and the total number of matches
+	hotels, err := h.store.Hotel.SearchHotels(c.Context(), filter, opts)
+	if err != nil{
+		return err
+	}
+	total, err := h.store.Hotel.CountHotels(c.Context(), filter)
+	if err != nil{
+		return err
+	}
+
+	return c.JSON(ResourceResponse{
+		Data:    hotels,
+		Results: len(hotels),
+		Total:   total,
+		Page:    params.Page,
+		Limit:   params.Limit,
+	})
 }
 
 // HandleGetHotel processes requests to get a specific hotel by ID
@@ -74,15 +200,209 @@ func (h *HotelHandler) HandleGetHotel(c *fiber.Ctx) error{
 	// Convert string ID to MongoDB ObjectID
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil{
-		return err
+		return ErrInvalidID()
 	}
 	
 	// Fetch the hotel from the database
 	hotel, err := h.store.Hotel.GetHotelByID(c.Context(), oid)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound("hotel")
+		}
 		return err
 	}
 	
 	// Return the hotel as JSON
 	return c.JSON(hotel)
 }
+
+// HandlePostHotel processes requests to create a hotel
+// POST /api/v1/hotel
+// The hotel starts without rooms, they are added through HandlePostRoom
+func (h *HotelHandler) HandlePostHotel(c *fiber.Ctx) error{
+	var params types.CreateHotelParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	hotel, err := h.store.Hotel.Insert(c.Context(), types.NewHotelFromParams(params))
+	if err != nil{
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(hotel)
+}
+
+// HandlePutHotel processes requests to update a hotel
+// PUT /api/v1/hotel/:id
+func (h *HotelHandler) HandlePutHotel(c *fiber.Ctx) error{
+	hotel, err := h.getHotel(c)
+	if err != nil{
+		return err
+	}
+	var params types.UpdateHotelParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	if err := h.store.Hotel.Update(c.Context(), bson.M{"_id": hotel.ID}, bson.M{"$set": params.ToBSON()}); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("hotel")
+		}
+		return err
+	}
+	return c.JSON(map[string]string{"updated": hotel.ID.Hex()})
+}
+
+// HandleDeleteHotel processes requests to delete a hotel
+// DELETE /api/v1/hotel/:id
+// Only hotels without rooms can be deleted, so bookings never end up pointing
+// at a hotel that no longer exists. The store checks this as part of the delete
+func (h *HotelHandler) HandleDeleteHotel(c *fiber.Ctx) error{
+	hotel, err := h.getHotel(c)
+	if err != nil{
+		return err
+	}
+	if err := h.store.Hotel.DeleteHotel(c.Context(), hotel.ID); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("hotel")
+		}
+		return err
+	}
+	return c.JSON(map[string]string{"deleted": hotel.ID.Hex()})
+}
+
+// HandlePostRoom processes requests to add a room to a hotel
+// POST /api/v1/hotel/:id/rooms
+func (h *HotelHandler) HandlePostRoom(c *fiber.Ctx) error{
+	hotel, err := h.getHotel(c)
+	if err != nil{
+		return err
+	}
+	var params types.CreateRoomParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	room, err := h.store.Room.InsertRoom(c.Context(), types.NewRoomFromParams(hotel.ID, params))
+	if err != nil{
+		// The hotel was deleted in the meantime
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("hotel")
+		}
+		return err
+	}
+	return c.Status(http.StatusCreated).JSON(room)
+}
+
+// HandlePutRoom processes requests to update a room of a hotel
+// PUT /api/v1/hotel/:id/rooms/:roomID
+// Bookings keep the price they were made at, a new price only applies to new bookings
+func (h *HotelHandler) HandlePutRoom(c *fiber.Ctx) error{
+	room, err := h.getHotelRoom(c)
+	if err != nil{
+		return err
+	}
+	var params types.UpdateRoomParams
+	if err := c.BodyParser(&params); err != nil{
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0{
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+
+	if err := h.store.Room.UpdateRoom(c.Context(), room.ID, params.ToBSON()); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("room")
+		}
+		return err
+	}
+	return c.JSON(map[string]string{"updated": room.ID.Hex()})
+}
+
+// HandleDeleteRoom processes requests to delete a room of a hotel
+// DELETE /api/v1/hotel/:id/rooms/:roomID
+// Rooms that guests are still going to stay in cannot be deleted; their
+// bookings have to be cancelled first. The room is marked as being deleted
+// before looking for upcoming stays, so a booking made at the same time either
+// shows up in the check or is refused by the booking store
+func (h *HotelHandler) HandleDeleteRoom(c *fiber.Ctx) error{
+	room, err := h.getHotelRoom(c)
+	if err != nil{
+		return err
+	}
+
+	if err := h.store.Room.SetRoomDeleting(c.Context(), room.ID, true); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("room")
+		}
+		return err
+	}
+	upcoming, err := h.store.Booking.HasUpcomingStays(c.Context(), room.ID, time.Now())
+	if err != nil || upcoming{
+		// The room stays and can be booked again, even if the request was cancelled
+		if restoreErr := h.store.Room.SetRoomDeleting(context.Background(), room.ID, false); restoreErr != nil{
+			return restoreErr
+		}
+		if err != nil{
+			return err
+		}
+		return ErrConflict("room_has_bookings", "the room has upcoming bookings")
+	}
+
+	if err := h.store.Room.DeleteRoom(c.Context(), room.ID); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("room")
+		}
+		return err
+	}
+	return c.JSON(map[string]string{"deleted": room.ID.Hex()})
+}
+
+// getHotel fetches the hotel named by the id URL parameter
+func (h *HotelHandler) getHotel(c *fiber.Ctx) (*types.Hotel, error){
+	oid, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil{
+		return nil, ErrInvalidID()
+	}
+	hotel, err := h.store.Hotel.GetHotelByID(c.Context(), oid)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return nil, ErrNotFound("hotel")
+		}
+		return nil, err
+	}
+	return hotel, nil
+}
+
+// getHotelRoom fetches the room named by the roomID URL parameter
+// Rooms of other hotels than the one in the URL are treated as unknown
+func (h *HotelHandler) getHotelRoom(c *fiber.Ctx) (*types.Room, error){
+	hotelID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil{
+		return nil, ErrInvalidID()
+	}
+	roomID, err := primitive.ObjectIDFromHex(c.Params("roomID"))
+	if err != nil{
+		return nil, ErrInvalidID()
+	}
+	room, err := h.store.Room.GetRoomByID(c.Context(), roomID)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return nil, ErrNotFound("room")
+		}
+		return nil, err
+	}
+	if room.HotelID != hotelID{
+		return nil, ErrNotFound("room")
+	}
+	return room, nil
+}
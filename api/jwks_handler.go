@@ -0,0 +1,30 @@
+package api
+
+import (
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/gofiber/fiber/v2"
+)
+
+// jwksMaxAge is how long clients may cache the key set, in seconds
+// Keys are published before they start signing, so a short cache is enough
+const jwksMaxAge = "300"
+
+// JWKSHandler publishes the public keys tokens are signed with
+// Partner services use them to verify our tokens without holding any secret
+type JWKSHandler struct{
+	keySet *keys.KeySet // Keys whose public parts are published
+}
+
+// NewJWKSHandler creates a new JWKSHandler publishing the keys of the key set
+func NewJWKSHandler(keySet *keys.KeySet) *JWKSHandler{
+	return &JWKSHandler{
+		keySet: keySet,
+	}
+}
+
+// HandleGetJWKS returns the public signing keys as a JSON Web Key Set
+// GET /.well-known/jwks.json
+func (h *JWKSHandler) HandleGetJWKS(c *fiber.Ctx) error{
+	c.Set(fiber.HeaderCacheControl, "public, max-age="+jwksMaxAge)
+	return c.JSON(h.keySet.JWKS())
+}
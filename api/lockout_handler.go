@@ -0,0 +1,66 @@
+package api
+
+import (
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// LockoutHandler lets admins see login lockouts and unlock accounts
+type LockoutHandler struct{
+	userStore  db.UserStore  // Accounts to unlock
+	loginStore db.LoginStore // Lockout events
+	limiter    *LoginLimiter // Lifts the lockouts
+}
+
+// NewLockoutHandler creates a new LockoutHandler with the provided stores
+func NewLockoutHandler(userStore db.UserStore, loginStore db.LoginStore, limiter *LoginLimiter) *LockoutHandler{
+	return &LockoutHandler{
+		userStore:  userStore,
+		loginStore: loginStore,
+		limiter:    limiter,
+	}
+}
+
+// HandleGetLockouts lists the lockout events, newest first
+// GET /api/v1/lockout?email=&active=true
+// email limits the list to one address, active to lockouts that have neither
+// ended nor been lifted by an admin
+func (h *LockoutHandler) HandleGetLockouts(c *fiber.Ctx) error{
+	filter := bson.M{}
+	if email := c.Query("email"); email != ""{
+		filter["email"] = types.NormalizeEmail(email)
+	}
+	if c.QueryBool("active"){
+		filter["lockedUntil"] = bson.M{"$gt": time.Now().UTC()}
+		filter["unlockedAt"] = bson.M{"$exists": false}
+	}
+
+	events, err := h.loginStore.GetLockoutEvents(c.Context(), filter)
+	if err != nil{
+		return err
+	}
+	return c.JSON(events)
+}
+
+// HandleUnlockUser lifts the login lockout of an account
+// POST /api/v1/user/:id/unlock
+// The failed logins of the account are forgotten, so the user can log in right away
+func (h *LockoutHandler) HandleUnlockUser(c *fiber.Ctx) error{
+	admin, ok := getAuthUser(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+	userID := c.Params("id")
+	user, err := h.userStore.GetUserById(c.Context(), userID)
+	if err != nil{
+		return err
+	}
+	if err := h.limiter.Unlock(c.Context(), user, admin); err != nil{
+		return err
+	}
+	return c.JSON(map[string]string{"unlocked": userID})
+}
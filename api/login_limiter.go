@@ -0,0 +1,205 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// LoginLimiter slows down and locks logins after failed attempts
+// Failures are counted per email and per client IP, so neither guessing the
+// password of one account nor trying one password on many accounts gets far.
+// Blocked attempts are rejected before the password is compared, which keeps
+// the expensive bcrypt check from being used to load the server. An attempt
+// that gets through is counted as failed right away, so parallel attempts
+// cannot all pass the check before the first failure is recorded. For the
+// email only one attempt at a time gets through; a client IP may be shared by
+// many users, so its attempts are counted without getting in each other's way
+type LoginLimiter struct{
+	store db.LoginStore      // Failed login counters and lockout events
+	cfg   config.LoginConfig // Limits, backoff and lockout duration
+}
+
+// NewLoginLimiter creates a new LoginLimiter with the given store and limits
+func NewLoginLimiter(store db.LoginStore, cfg config.LoginConfig) *LoginLimiter{
+	return &LoginLimiter{
+		store: store,
+		cfg:   cfg,
+	}
+}
+
+// loginReservation is the login attempt Check counted, kept as the "loginAttempt"
+// user value of the request for Failure and Success
+type loginReservation struct{
+	emailAttempts *types.LoginAttempts // Record of the email including the attempt
+	ipAttempts    *types.LoginAttempts // Record of the client IP including the attempt
+}
+
+// Check returns a 429 Too Many Requests error if logins with the email or from
+// the client IP have to wait, and sets the Retry-After header accordingly
+// Otherwise the attempt is reserved by counting it as failed for the email and
+// the IP before the password is compared. The email counter is only incremented
+// if no parallel attempt changed it since it was checked, so a burst of attempts
+// for one email gets one through and 429 for the rest. The IP counter is always
+// incremented, so logins of different users behind one IP never fail each other,
+// and the backoff of the IP only starts once a login actually fails. Every
+// attempt Check lets through must end in Failure or Success
+func (l *LoginLimiter) Check(c *fiber.Ctx, email string) error{
+	now := time.Now()
+	emailKey := types.EmailLoginKey(email)
+	ipKey := types.IPLoginKey(c.IP())
+	emailAttempts, err := l.attempts(c.Context(), emailKey)
+	if err != nil{
+		return err
+	}
+	ipAttempts, err := l.attempts(c.Context(), ipKey)
+	if err != nil{
+		return err
+	}
+	emailWait, emailLocked := l.blockedFor(emailAttempts, l.cfg.MaxFailures, now)
+	ipWait, _ := l.blockedFor(ipAttempts, l.cfg.MaxIPFailures, now)
+
+	wait := max(emailWait, ipWait)
+	if wait > 0{
+		c.Set(fiber.HeaderRetryAfter, retryAfter(wait))
+		if emailLocked && emailWait == wait{
+			return NewError(http.StatusTooManyRequests, "account_locked", "too many failed logins, the account is locked for now")
+		}
+		return ErrTooManyAttempts()
+	}
+
+	var reservation loginReservation
+	reservation.emailAttempts, err = l.store.ReserveLoginAttempt(c.Context(), emailKey, emailAttempts.Failures, l.cfg.Lockout)
+	if err != nil{
+		return l.reserveError(c, err)
+	}
+	reservation.ipAttempts, err = l.store.CountLoginAttempt(c.Context(), ipKey, l.cfg.Lockout)
+	if err != nil{
+		// The attempt does not happen, so it must not count against the email either
+		if err := l.store.ReleaseLoginAttempt(c.Context(), emailKey); err != nil{
+			return err
+		}
+		return err
+	}
+	c.Context().SetUserValue("loginAttempt", &reservation)
+	return nil
+}
+
+// Failure records that the login with the email Check let through has failed
+// user is the account of the email, nil if there is none. The failure was already
+// counted by Check; the backoff of the IP starts now. If the failure reached the
+// limit, the email or the IP is locked now and a lockout event is recorded
+func (l *LoginLimiter) Failure(c *fiber.Ctx, email string, user *types.User) error{
+	reservation, ok := c.Context().UserValue("loginAttempt").(*loginReservation)
+	if !ok{
+		return fmt.Errorf("login failure of %s without a reserved attempt", email)
+	}
+	ip := c.IP()
+	attempts := reservation.emailAttempts
+	// Only the failure reaching the limit records an event, later ones are rejected by Check
+	if attempts.Failures == l.cfg.MaxFailures{
+		event := &types.LockoutEvent{
+			Email:       types.NormalizeEmail(email),
+			IP:          ip,
+			Failures:    attempts.Failures,
+			LockedUntil: attempts.ExpiresAt,
+			CreatedAt:   time.Now().UTC(),
+		}
+		if user != nil{
+			event.UserID = &user.ID
+		}
+		if _, err := l.store.InsertLockoutEvent(c.Context(), event); err != nil{
+			return err
+		}
+	}
+
+	recorded, err := l.store.RecordLoginFailure(c.Context(), types.IPLoginKey(ip), l.cfg.Lockout)
+	if err != nil{
+		return err
+	}
+	if reservation.ipAttempts.Failures == l.cfg.MaxIPFailures{
+		event := &types.LockoutEvent{
+			IP:          ip,
+			Failures:    reservation.ipAttempts.Failures,
+			LockedUntil: recorded.ExpiresAt,
+			CreatedAt:   time.Now().UTC(),
+		}
+		if _, err := l.store.InsertLockoutEvent(c.Context(), event); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+// Success forgets the failed logins with the email after a successful login
+// The earlier failures of the IP are kept, or an attacker could reset them with
+// an account of their own; only the attempt Check counted is taken back
+func (l *LoginLimiter) Success(c *fiber.Ctx, email string) error{
+	if err := l.store.ClearLoginAttempts(c.Context(), types.EmailLoginKey(email)); err != nil{
+		return err
+	}
+	return l.store.ReleaseLoginAttempt(c.Context(), types.IPLoginKey(c.IP()))
+}
+
+// Unlock lifts the lockout of the user's email and marks its events as unlocked by the admin
+func (l *LoginLimiter) Unlock(ctx context.Context, user *types.User, admin *types.User) error{
+	if err := l.store.ClearLoginAttempts(ctx, types.EmailLoginKey(user.Email)); err != nil{
+		return err
+	}
+	return l.store.UnlockLockoutEvents(ctx, types.NormalizeEmail(user.Email), admin.ID)
+}
+
+// attempts returns the failed logins of the key, a record without failures if there is none
+func (l *LoginLimiter) attempts(ctx context.Context, key string) (*types.LoginAttempts, error){
+	attempts, err := l.store.GetLoginAttempts(ctx, key)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return &types.LoginAttempts{ID: key}, nil
+		}
+		return nil, fmt.Errorf("checking login attempts: %w", err)
+	}
+	return attempts, nil
+}
+
+// reserveError turns a failed reservation into a 429 if a parallel attempt reserved first
+func (l *LoginLimiter) reserveError(c *fiber.Ctx, err error) error{
+	if errors.Is(err, db.ErrLoginAttemptTaken){
+		c.Set(fiber.HeaderRetryAfter, retryAfter(l.cfg.Backoff))
+		return ErrTooManyAttempts()
+	}
+	return err
+}
+
+// retryAfter formats the wait as whole seconds for the Retry-After header, at least 1
+func retryAfter(wait time.Duration) string{
+	return strconv.Itoa(max(1, int(math.Ceil(wait.Seconds()))))
+}
+
+// blockedFor returns how long logins with the failed attempts have to wait at now,
+// and whether the key is locked rather than just backing off
+// After the n-th failure the next attempt waits Backoff * 2^(n-1), capped at
+// MaxBackoff; after maxFailures it waits until the lockout ends
+func (l *LoginLimiter) blockedFor(attempts *types.LoginAttempts, maxFailures int, now time.Time) (time.Duration, bool){
+	if attempts.Failures == 0{
+		return 0, false
+	}
+	if attempts.Failures >= maxFailures{
+		return attempts.ExpiresAt.Sub(now), true
+	}
+	backoff := l.cfg.Backoff
+	for i := 1; i < attempts.Failures && backoff < l.cfg.MaxBackoff; i++{
+		backoff *= 2
+	}
+	backoff = min(backoff, l.cfg.MaxBackoff)
+	return attempts.LastFailure.Add(backoff).Sub(now), false
+}
@@ -0,0 +1,294 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// PaymentSignatureHeader carries the signature of a webhook sent by the payment gateway
+const PaymentSignatureHeader = "X-Payment-Signature"
+
+// bookingPayments authorizes the payments of bookings and records them
+type bookingPayments struct{
+	store    db.PaymentStore   // Payment records
+	provider payments.Provider // Payment gateway
+	currency string            // Currency the guests are charged in
+}
+
+// newBookingPayments creates a bookingPayments charging through the provider
+func newBookingPayments(store db.PaymentStore, provider payments.Provider, cfg config.PaymentsConfig) *bookingPayments{
+	return &bookingPayments{
+		store:    store,
+		provider: provider,
+		currency: cfg.Currency,
+	}
+}
+
+// authorize asks the gateway to authorize the total of the booking with the
+// guest's payment method and records the outcome, which may also be a decline
+// If the outcome cannot be recorded the authorization is released again, since
+// without a record nothing would ever give it back
+func (p *bookingPayments) authorize(ctx context.Context, booking *types.Booking, paymentMethod string) (*types.Payment, error){
+	result, err := p.provider.Authorize(ctx, payments.AuthorizeRequest{
+		Reference:     booking.ID.Hex(),
+		Amount:        booking.Price.Total,
+		Currency:      p.currency,
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil{
+		return nil, err
+	}
+	now := time.Now().UTC()
+	payment, err := p.store.InsertPayment(ctx, &types.Payment{
+		BookingID:     booking.ID,
+		UserID:        booking.UserID,
+		Provider:      p.provider.Name(),
+		ProviderRef:   result.PaymentRef,
+		Amount:        booking.Price.Total,
+		Currency:      p.currency,
+		Status:        result.Status,
+		FailureReason: result.FailureReason,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+	if err != nil{
+		if result.Status != types.PaymentStatusFailed{
+			if _, refundErr := p.provider.Refund(ctx, result.PaymentRef, booking.Price.Total); refundErr != nil{
+				log.Printf("releasing unrecorded payment %s: %v", result.PaymentRef, refundErr)
+			}
+		}
+		return nil, err
+	}
+	return payment, nil
+}
+
+// activePayments returns the authorized or captured payments of the booking, oldest first
+func (p *bookingPayments) activePayments(ctx context.Context, bookingID primitive.ObjectID) ([]*types.Payment, error){
+	return p.store.GetPayments(ctx, bson.M{
+		"bookingID": bookingID,
+		"status":    bson.M{"$in": []types.PaymentStatus{types.PaymentStatusAuthorized, types.PaymentStatusCaptured}},
+	})
+}
+
+// hasPendingPayment reports whether the gateway has yet to decide a payment of the booking
+func (p *bookingPayments) hasPendingPayment(ctx context.Context, bookingID primitive.ObjectID) (bool, error){
+	pending, err := p.store.GetPayments(ctx, bson.M{"bookingID": bookingID, "status": types.PaymentStatusPending})
+	if err != nil{
+		return false, err
+	}
+	return len(pending) > 0, nil
+}
+
+// paidAmount returns how much of the payments is authorized or charged
+func paidAmount(paid []*types.Payment) float64{
+	total := 0.0
+	for _, payment := range paid{
+		total += chargeableAmount(payment)
+	}
+	return math.Round(total*100) / 100
+}
+
+// releasePayments gives back each of the payments in full
+func (p *bookingPayments) releasePayments(ctx context.Context, paid []*types.Payment) error{
+	for _, payment := range paid{
+		if err := p.refundPayment(ctx, payment); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+// settleCancellation charges the penalty of a cancelled booking to its authorized
+// or captured payments, oldest first, and gives back the rest of them
+// It returns how much is given back: what the guest paid minus the penalty
+// charged, 0 if nothing was paid. Payments are only captured when a cancellation
+// is settled, so what was paid is the amount of the payments still active plus
+// that of those refunded since the cancellation, which an earlier attempt to
+// settle it may have given back already
+func (p *bookingPayments) settleCancellation(ctx context.Context, booking *types.Booking) (float64, error){
+	cancellation := booking.Cancellation
+	paid, err := p.activePayments(ctx, booking.ID)
+	if err != nil{
+		return 0, err
+	}
+	refunded, err := p.store.GetPayments(ctx, bson.M{
+		"bookingID": booking.ID,
+		"status":    types.PaymentStatusRefunded,
+		"updatedAt": bson.M{"$gte": cancellation.CancelledAt},
+	})
+	if err != nil{
+		return 0, err
+	}
+	total := 0.0
+	for _, payment := range append(paid, refunded...){
+		total += payment.Amount
+	}
+	refund := math.Round((total-math.Min(cancellation.Penalty, total))*100) / 100
+
+	penalty := cancellation.Penalty
+	for _, payment := range paid{
+		charge := math.Min(penalty, chargeableAmount(payment))
+		penalty = math.Round((penalty-charge)*100) / 100
+		if err := p.chargePayment(ctx, payment, charge); err != nil{
+			return 0, err
+		}
+	}
+	return refund, nil
+}
+
+// chargeableAmount returns how much of the payment can still be charged
+func chargeableAmount(payment *types.Payment) float64{
+	if payment.Status == types.PaymentStatusCaptured{
+		return payment.Captured
+	}
+	return payment.Amount
+}
+
+// chargePayment charges amount of the payment and gives back the rest
+func (p *bookingPayments) chargePayment(ctx context.Context, payment *types.Payment, amount float64) error{
+	if amount <= 0{
+		return p.refundPayment(ctx, payment)
+	}
+	switch payment.Status{
+	case types.PaymentStatusAuthorized:
+		// Capturing part of an authorization releases the rest of it
+		if _, err := p.provider.Capture(ctx, payment.ProviderRef, amount); err != nil{
+			return err
+		}
+	case types.PaymentStatusCaptured:
+		if payment.Captured > amount{
+			if _, err := p.provider.Refund(ctx, payment.ProviderRef, math.Round((payment.Captured-amount)*100)/100); err != nil{
+				return err
+			}
+		}
+	}
+	_, err := p.store.CapturePayment(ctx, payment.ID, payment.Status, amount)
+	return err
+}
+
+// refundPayment gives back the payment in full
+func (p *bookingPayments) refundPayment(ctx context.Context, payment *types.Payment) error{
+	if _, err := p.provider.Refund(ctx, payment.ProviderRef, chargeableAmount(payment)); err != nil{
+		return err
+	}
+	_, err := p.store.UpdatePaymentStatus(ctx, payment.ID, payment.Status, types.PaymentStatusRefunded, "")
+	return err
+}
+
+// errPaymentDeclined returns the error for a payment the gateway declined
+func errPaymentDeclined(payment *types.Payment) Error{
+	return NewError(http.StatusPaymentRequired, "payment_declined", "payment declined: "+payment.FailureReason)
+}
+
+// PaymentHandler handles the webhooks of the payment gateway
+type PaymentHandler struct{
+	store    *db.Store         // Central store providing access to all database collections
+	provider payments.Provider // Payment gateway sending the webhooks
+	payments *bookingPayments  // Refunds payments that arrive for bookings that are gone
+}
+
+// NewPaymentHandler creates a new PaymentHandler for webhooks sent by the provider
+func NewPaymentHandler(store *db.Store, provider payments.Provider, paymentsCfg config.PaymentsConfig) *PaymentHandler{
+	return &PaymentHandler{
+		store:    store,
+		provider: provider,
+		payments: newBookingPayments(store.Payment, provider, paymentsCfg),
+	}
+}
+
+// HandleWebhook processes the webhooks the gateway sends when a pending payment is decided
+// POST /api/payments/webhook
+// The request is authenticated by its signature in the X-Payment-Signature header
+// instead of a token. An authorized payment confirms its booking, a declined one
+// fails a pending booking and frees its nights; a held booking stays held, so the
+// guest can try another payment method before the hold expires. If the booking
+// is gone by the time its payment is authorized, the payment is refunded.
+// Events are delivered at least once, so repeated events are answered with the
+// payment as it is without changing anything
+func (h *PaymentHandler) HandleWebhook(c *fiber.Ctx) error{
+	event, err := h.provider.ParseWebhook(c.Body(), c.Get(PaymentSignatureHeader))
+	if err != nil{
+		if errors.Is(err, payments.ErrInvalidSignature){
+			return NewError(http.StatusUnauthorized, "invalid_signature", err.Error())
+		}
+		if errors.Is(err, payments.ErrNoWebhookSecret){
+			return err
+		}
+		return ErrBadRequest(err.Error())
+	}
+
+	payment, err := h.store.Payment.GetPaymentByProviderRef(c.Context(), h.provider.Name(), event.PaymentRef)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return ErrNotFound("payment")
+		}
+		return err
+	}
+	if payment.IsFinal(){
+		return c.JSON(payment)
+	}
+
+	status, reason := types.PaymentStatusAuthorized, ""
+	if event.Type == payments.EventPaymentFailed{
+		status, reason = types.PaymentStatusFailed, event.FailureReason
+	}
+	updated, err := h.store.Payment.UpdatePaymentStatus(c.Context(), payment.ID, types.PaymentStatusPending, status, reason)
+	if err != nil{
+		// A concurrent delivery of the same event got there first
+		if errors.Is(err, db.ErrPaymentStatusChanged){
+			return c.JSON(payment)
+		}
+		return err
+	}
+
+	if err := h.settleBooking(c.Context(), updated); err != nil{
+		return err
+	}
+	return c.JSON(updated)
+}
+
+// settleBooking moves the booking of a payment the gateway has decided on
+func (h *PaymentHandler) settleBooking(ctx context.Context, payment *types.Payment) error{
+	booking, err := h.store.Booking.GetBookingByID(ctx, payment.BookingID)
+	if err != nil{
+		return err
+	}
+
+	if payment.Status == types.PaymentStatusFailed{
+		if booking.Status != types.BookingStatusPending{
+			return nil
+		}
+		_, err := h.store.Booking.FailPendingBooking(ctx, booking.ID)
+		if errors.Is(err, db.ErrBookingNotPending){
+			return nil
+		}
+		return err
+	}
+
+	switch booking.Status{
+	case types.BookingStatusPending:
+		_, err = h.store.Booking.ConfirmPendingBooking(ctx, booking.ID)
+	case types.BookingStatusHeld:
+		_, err = h.store.Booking.ConfirmHold(ctx, booking.ID)
+	default:
+		err = db.ErrBookingNotPending
+	}
+	// The booking was cancelled or expired in the meantime, nothing is left to pay for
+	if errors.Is(err, db.ErrBookingNotPending) || errors.Is(err, db.ErrHoldExpired) || errors.Is(err, db.ErrBookingNotHeld){
+		return h.payments.refundPayment(ctx, payment)
+	}
+	return err
+}
@@ -2,75 +2,233 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type BookRoomParams struct {
-	FromDate   time.Time `json:"fromDate"`
-	TillDate   time.Time `json:"tillDate"`
-	NumPersons int       `json:"numPersons"`
+	FromDate      time.Time `json:"fromDate"`
+	TillDate      time.Time `json:"tillDate"`
+	NumPersons    int       `json:"numPersons"`
+	PaymentMethod string    `json:"paymentMethod,omitempty"` // Token of the guest's payment method, required to book but not to hold or quote
 }
 
 type RoomHandler struct {
-	store *db.Store
+	store      *db.Store
+	bookingCfg config.BookingConfig // How long rooms are held during checkout
+	payments   *bookingPayments     // Authorizes the payment of new bookings
 }
 
-func NewRoomHandler(store *db.Store) *RoomHandler {
+func NewRoomHandler(store *db.Store, bookingCfg config.BookingConfig, provider payments.Provider, paymentsCfg config.PaymentsConfig) *RoomHandler {
 	return &RoomHandler{
-		store: store,
+		store:      store,
+		bookingCfg: bookingCfg,
+		payments:   newBookingPayments(store.Payment, provider, paymentsCfg),
 	}
 }
 
 
+// BookingQuote is what a stay in a room would cost, returned without booking anything
+type BookingQuote struct {
+	RoomID                 primitive.ObjectID       `json:"roomID"`
+	FromDate               time.Time                `json:"fromDate"`
+	TillDate               time.Time                `json:"tillDate"`
+	NumPersons             int                      `json:"numPersons"`
+	Price                  *types.PriceBreakdown    `json:"price"`
+	CancellationPolicy     types.CancellationPolicy `json:"cancellationPolicy"`     // The policy the booking would be made under
+	CancellationPolicyText string                   `json:"cancellationPolicyText"` // The policy as shown to the guest
+}
+
+// HandleBookRoom processes requests to book a room
+// POST /api/v1/room/:id/book
+// The nights are reserved first and the total is then authorized with the
+// paymentMethod of the body. The booking is confirmed once the payment is
+// authorized; a declined payment frees the nights again and returns 402. If the
+// gateway needs more time the booking stays pending, which is answered with 202,
+// until the gateway reports the outcome through the webhook
 func (h *RoomHandler) HandleBookRoom(c *fiber.Ctx) error {
+	return h.reserveRoom(c, false)
+}
+
+// HandleHoldRoom processes requests to hold a room while the guest pays
+// POST /api/v1/room/:id/hold
+// Takes the same body as booking the room. The booking is created with the status
+// held and reserves the room until holdExpiresAt; confirm it before then at
+// PUT /api/v1/booking/:id/confirm, otherwise the nights are released again
+func (h *RoomHandler) HandleHoldRoom(c *fiber.Ctx) error {
+	return h.reserveRoom(c, true)
+}
+
+// reserveRoom books the room named by the :id parameter, as a hold if hold is set
+func (h *RoomHandler) reserveRoom(c *fiber.Ctx, hold bool) error {
 	var params BookRoomParams
 	if err := c.BodyParser(&params); err != nil {
-		return err
+		return ErrBadRequest("invalid request body")
 	}
-	// Validate that the booking dates are in the future and that FromDate is before TillDate.
-	if err := params.validate(); err != nil {
-		return err
+	if !hold && params.PaymentMethod == "" {
+		return ErrBadRequest("paymentMethod is required")
 	}
-	
-	roomID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	room, err := h.getBookableRoom(c, params)
 	if err != nil {
 		return err
 	}
 	
-	user, ok := c.Context().Value("user").(*types.User)
+	user, ok := getAuthUser(c)
 	if !ok {
-		return c.Status(http.StatusInternalServerError).JSON("error")
+		return ErrUnauthorized()
 	}
 	
-	available, err := h.isRoomAvailableForBooking(c.Context(), roomID, params)
+	available, err := h.isRoomAvailableForBooking(c.Context(), room.ID, params)
 	if err != nil {
 		return err
 	}
 	if !available {
-		return fmt.Errorf("room already booked")
+		return ErrConflict("room_already_booked", "room already booked")
+	}
+	policy, err := h.cancellationPolicy(c.Context(), room)
+	if err != nil {
+		return err
 	}
 	
 	booking := types.Booking{
-		RoomID:    roomID,
-		UserID:    user.ID,
-		FromDate:  params.FromDate,
-		TillDate:  params.TillDate,
-		NumPerson: params.NumPersons,
+		RoomID:             room.ID,
+		UserID:             user.ID,
+		FromDate:           params.FromDate,
+		TillDate:           params.TillDate,
+		NumPerson:          params.NumPersons,
+		Status:             types.BookingStatusPending,
+		// The price and the cancellation policy are fixed now, later changes to the
+		// room rate or the hotel's policy do not affect them
+		Price:              types.NewPriceBreakdown(room.Price, params.FromDate, params.TillDate),
+		CancellationPolicy: &policy,
+	}
+	if hold {
+		expiresAt := time.Now().Add(h.bookingCfg.HoldTTL).UTC()
+		booking.Status = types.BookingStatusHeld
+		booking.HoldExpiresAt = &expiresAt
+	}
+	// The availability check above is only a fast path; two concurrent requests can both
+	// pass it, so the store decides atomically which one actually gets the room.
+	inserted, err := h.store.Booking.InsertBookingIfAvailable(c.Context(), &booking)
+	if err != nil {
+		return err
 	}
-	inserted, err := h.store.Booking.InsertBooking(c.Context(), &booking)
+	if hold {
+		return c.JSON(inserted)
+	}
+	return h.payForBooking(c, inserted, params.PaymentMethod)
+}
+
+// payForBooking authorizes the payment of the pending booking and confirms or fails it accordingly
+func (h *RoomHandler) payForBooking(c *fiber.Ctx, booking *types.Booking, paymentMethod string) error {
+	payment, err := h.payments.authorize(c.Context(), booking, paymentMethod)
 	if err != nil {
+		// Without an answer from the gateway the nights must not stay blocked
+		if _, failErr := h.store.Booking.FailPendingBooking(c.Context(), booking.ID); failErr != nil {
+			return failErr
+		}
+		return err
+	}
+
+	switch payment.Status {
+	case types.PaymentStatusAuthorized:
+		confirmed, err := h.store.Booking.ConfirmPendingBooking(c.Context(), booking.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(confirmed)
+	case types.PaymentStatusPending:
+		return c.Status(http.StatusAccepted).JSON(booking)
+	}
+	if _, err := h.store.Booking.FailPendingBooking(c.Context(), booking.ID); err != nil {
 		return err
 	}
+	return errPaymentDeclined(payment)
+}
+
+// HandleGetQuote processes requests for the price of a stay without booking it
+// POST /api/v1/room/:id/quote
+// Takes the same body as booking the room and returns the price breakdown and the
+// cancellation policy the booking would get
+func (h *RoomHandler) HandleGetQuote(c *fiber.Ctx) error {
+	var params BookRoomParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest("invalid request body")
+	}
+	room, err := h.getBookableRoom(c, params)
+	if err != nil {
+		return err
+	}
+	policy, err := h.cancellationPolicy(c.Context(), room)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(BookingQuote{
+		RoomID:                 room.ID,
+		FromDate:               params.FromDate,
+		TillDate:               params.TillDate,
+		NumPersons:             params.NumPersons,
+		Price:                  types.NewPriceBreakdown(room.Price, params.FromDate, params.TillDate),
+		CancellationPolicy:     policy,
+		CancellationPolicyText: policy.Description(),
+	})
+}
+
+// cancellationPolicy returns the cancellation policy of the hotel the room belongs to
+func (h *RoomHandler) cancellationPolicy(ctx context.Context, room *types.Room) (types.CancellationPolicy, error) {
+	hotel, err := h.store.Hotel.GetHotelByID(ctx, room.HotelID)
+	if err != nil {
+		return types.CancellationPolicy{}, err
+	}
+	return hotel.ApplicableCancellationPolicy(), nil
+}
+
+// getBookableRoom validates the booking parameters and loads the room named by the :id parameter
+// Returns a 400 error if the parameters are invalid or the guests do not fit into the room
+// and a 404 error if the room does not exist
+func (h *RoomHandler) getBookableRoom(c *fiber.Ctx, params BookRoomParams) (*types.Room, error) {
+	// Validate that the booking dates are in the future and that FromDate is before TillDate.
+	if err := params.validate(); err != nil {
+		return nil, ErrBadRequest(err.Error())
+	}
 	
-	return c.JSON(inserted)
+	roomID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, ErrInvalidID()
+	}
+
+	room, err := h.store.Room.GetRoomByID(c.Context(), roomID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound("room")
+		}
+		return nil, err
+	}
+	// Make sure everyone fits into the room.
+	if err := checkRoomCapacity(room, params.NumPersons); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// checkRoomCapacity returns a 400 error if the guests do not fit into the room
+func checkRoomCapacity(room *types.Room, numPersons int) error {
+	if numPersons > room.Capacity() {
+		return NewError(http.StatusBadRequest, "capacity_exceeded", fmt.Sprintf("room sleeps at most %d guests", room.Capacity()))
+	}
+	return nil
 }
 
 func (p BookRoomParams) validate() error {
@@ -83,11 +241,24 @@ func (p BookRoomParams) validate() error {
 	if p.FromDate.After(p.TillDate) || p.FromDate.Equal(p.TillDate) {
 		return fmt.Errorf("fromDate must be before tillDate")
 	}
+	// Every booking is for at least one guest.
+	if p.NumPersons <= 0 {
+		return fmt.Errorf("numPersons must be at least 1")
+	}
+	// Rooms are booked per night, so check-out must be on a later day than check-in.
+	nights := types.NumNights(p.FromDate, p.TillDate)
+	if nights == 0 {
+		return fmt.Errorf("a booking must cover at least one night")
+	}
+	// Longer stays have to be booked in several parts.
+	if nights > types.MaxBookingNights {
+		return fmt.Errorf("a booking may cover at most %d nights", types.MaxBookingNights)
+	}
 	return nil
 }
 
 func (h *RoomHandler) HandleGetRooms(c *fiber.Ctx) error{
-	rooms, err := h.store.Room.GetRooms(c.Context(),bson.M{})
+	rooms, err := h.store.Room.GetRooms(c.Context(),db.ListedRoomsFilter(bson.M{}, time.Now()))
 	if err != nil{
 		return err
 	}
@@ -95,16 +266,9 @@ func (h *RoomHandler) HandleGetRooms(c *fiber.Ctx) error{
 }
 
 func (h *RoomHandler) isRoomAvailableForBooking(ctx context.Context, roomID primitive.ObjectID, params BookRoomParams) (bool, error) {
-	// Find any booking that overlaps with the requested time range.
-	filter := bson.M{
-		"roomID": roomID,
-		"fromDate": bson.M{
-			"$lt": params.TillDate, // existing booking starts before new booking ends
-		},
-		"tillDate": bson.M{
-			"$gt": params.FromDate, // existing booking ends after new booking starts
-		},
-	}
+	// Find any booking of this room that overlaps with the requested time range.
+	filter := overlappingBookingsFilter(params.FromDate, params.TillDate)
+	filter["roomID"] = roomID
 
 	bookings, err := h.store.Booking.GetBookings(ctx, filter)
 	if err != nil {
@@ -114,3 +278,28 @@ func (h *RoomHandler) isRoomAvailableForBooking(ctx context.Context, roomID prim
 	// If there are any overlapping bookings, the room is not available.
 	return len(bookings) == 0, nil
 }
+
+// overlappingBookingsFilter returns a filter matching the bookings that hold a room
+// at some point between fromDate and tillDate. Cancelled, failed and expired bookings
+// no longer hold the room, and neither do holds the sweeper has not released yet.
+// Like the nights ledger, stays are compared by their nights rather than their
+// timestamps: a booking overlaps if it checks in before the check-out day and
+// checks out after the first night, i.e. on the day after check-in or later.
+func overlappingBookingsFilter(fromDate, tillDate time.Time) bson.M {
+	checkIn, checkOut := types.StayDays(fromDate, tillDate)
+	return bson.M{
+		"status": bson.M{
+			"$nin": types.ReleasedBookingStatuses(),
+		},
+		"$or": []bson.M{
+			{"status": bson.M{"$ne": types.BookingStatusHeld}},
+			{"holdExpiresAt": bson.M{"$gt": time.Now()}},
+		},
+		"fromDate": bson.M{
+			"$lt": checkOut, // existing booking starts before the new check-out day
+		},
+		"tillDate": bson.M{
+			"$gte": checkIn.AddDate(0, 0, 1), // existing booking ends after the first new night
+		},
+	}
+}
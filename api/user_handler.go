@@ -2,6 +2,7 @@ package api
 
 import (
 	"errors"
+	"net/http"
 
 	"github.com/0x0Glitch/hotel-reservation/db"
 	"github.com/0x0Glitch/hotel-reservation/types"
@@ -28,16 +29,29 @@ func NewUserHandler(userStore db.UserStore) *UserHandler{
 
 // HandleGetUser processes requests to get a single user by ID
 // GET /api/users/:id
+// Users can only get their own account, admins can get anyone's
 func (h *UserHandler) HandleGetUser(c *fiber.Ctx) error{
 	// Extract user ID from URL parameters
 	var id = c.Params("id")
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil{
+		return ErrInvalidID()
+	}
+
+	authUser, ok := getAuthUser(c)
+	if !ok{
+		return ErrUnauthorized()
+	}
+	if authUser.ID != oid && !authUser.IsAdmin(){
+		return ErrForbidden()
+	}
 	
 	// Fetch the user from the database
 	user, err:= h.userStore.GetUserById(c.Context(), id)
 	if err != nil{
 		// If user is not found, return a friendly error message
 		if errors.Is(err, mongo.ErrNoDocuments){
-			return c.JSON(map[string]string{"error":"not found"})
+			return ErrNotFound("user")
 		}
 		// For any other error, return it directly
 		return err
@@ -66,13 +80,13 @@ func (h *UserHandler) HandlePostUser(c *fiber.Ctx) error {
 	
 	// Parse request body into CreateUserParams struct
 	if err := c.BodyParser(&params); err != nil{
-		return err
+		return ErrBadRequest("invalid request body")
 	}
 	
 	// Validate the user input
 	if errors := params.Validate(); len(errors) > 0{
 		// If validation fails, return errors to the client
-		return c.JSON(errors)
+		return c.Status(http.StatusBadRequest).JSON(errors)
 	}
 	
 	// Create new user from params (includes password hashing)
@@ -108,33 +122,50 @@ func (h *UserHandler) HandleDeleteUser(c *fiber.Ctx) error{
 
 // HandlePutUser processes requests to update a user
 // PUT /api/users/:id
+// Users may only update themselves, admins may update everyone and are the only
+// ones allowed to change roles and hotel assignments
 func (h *UserHandler) HandlePutUser(c *fiber.Ctx) error {
-	// Create a map to hold the update fields
-	var update bson.M
-	
-	// Parse the JSON body into the update map
-	if err := c.BodyParser(&update); err != nil {
-		return err
-	}
-
 	// Extract user ID from URL parameters
 	userID := c.Params("id")
 	
 	// Convert string ID to MongoDB ObjectID
 	oid, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
+		return ErrInvalidID()
+	}
+
+	authUser, ok := getAuthUser(c)
+	if !ok {
+		return ErrUnauthorized()
+	}
+	if authUser.ID != oid && !authUser.IsAdmin() {
+		return ErrForbidden()
+	}
+
+	// Only the whitelisted fields of UpdateUserParams can be changed
+	var params types.UpdateUserParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest("invalid request body")
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	if params.IsAdminOnly() && !authUser.IsAdmin() {
+		return ErrForbidden()
+	}
+
+	if _, err := h.userStore.GetUserById(c.Context(), userID); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrNotFound("user")
+		}
 		return err
 	}
 
-	// Create filter to find the user by ID
-	filter := bson.M{"_id": oid}
-	
 	// Update the user in the database
-	if err := h.userStore.UpdateUser(c.Context(), filter, update); err != nil {
+	if err := h.userStore.UpdateUser(c.Context(), bson.M{"_id": oid}, params.ToBSON()); err != nil {
 		return err
 	}
 
 	// Return success message with updated ID
 	return c.JSON(map[string]string{"updated": userID})
 }
-
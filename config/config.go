@@ -0,0 +1,537 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Default values used when no other source sets them
+const (
+	DefaultListenAddr           = ":5001"
+	DefaultDBURI                = "mongodb://localhost:27017/"
+	DefaultDBName               = "hotel-reservation"
+	DefaultTokenTTL             = 15 * time.Minute
+	DefaultRefreshTTL           = 30 * 24 * time.Hour
+	DefaultIssuer               = "hotel-reservation"
+	DefaultAudience             = "hotel-reservation-api"
+	DefaultResetTTL             = time.Hour
+	DefaultResetURL             = "http://localhost:5001/reset-password"
+	DefaultVerifyTTL            = 48 * time.Hour
+	DefaultVerifyURL            = "http://localhost:5001/api/auth/verify"
+	DefaultMailFrom             = "Hotel Reservation <no-reply@localhost>"
+	DefaultSMTPPort             = 587
+	DefaultMailDir              = "mail"
+	DefaultLoginMaxFailures     = 5
+	DefaultLoginMaxIPFailures   = 20
+	DefaultLoginBackoff         = time.Second
+	DefaultLoginMaxBackoff      = 30 * time.Second
+	DefaultLoginLockout         = 15 * time.Minute
+	DefaultHoldTTL              = 10 * time.Minute
+	DefaultHoldSweepInterval    = time.Minute
+	DefaultIdempotencyRetention = 24 * time.Hour
+	DefaultIdempotencyLease     = 2 * time.Minute
+	DefaultPaymentProvider      = PaymentProviderMock
+	DefaultPaymentCurrency      = "EUR"
+)
+
+// Environment variables read by Load
+const (
+	EnvConfigFile           = "CONFIG_FILE"            // Path of the YAML configuration file
+	EnvListenAddr           = "LISTEN_ADDR"            // Listen address of the API server
+	EnvProxyHeader          = "PROXY_HEADER"           // Header a reverse proxy puts the client IP in, e.g. "X-Forwarded-For"
+	EnvDBURI                = "MONGO_DB_URI"           // MongoDB connection string
+	EnvDBName               = "MONGO_DB_NAME"          // Name of the MongoDB database
+	EnvJWTSecret            = "JWT_SECRET"             // Secret used to sign and verify JWT tokens
+	EnvTokenTTL             = "JWT_TOKEN_TTL"          // Lifetime of a JWT access token, e.g. "15m"
+	EnvRefreshTTL           = "JWT_REFRESH_TOKEN_TTL"  // Lifetime of a refresh token, e.g. "720h"
+	EnvIssuer               = "JWT_ISSUER"             // Issuer (iss) of the JWT tokens
+	EnvAudience             = "JWT_AUDIENCE"           // Audience (aud) the JWT tokens are meant for
+	EnvResetTTL             = "PASSWORD_RESET_TTL"     // Lifetime of a password reset token, e.g. "1h"
+	EnvResetURL             = "PASSWORD_RESET_URL"     // Page the password reset emails link to
+	EnvVerifyTTL            = "EMAIL_VERIFY_TTL"       // Lifetime of an email verification token, e.g. "48h"
+	EnvVerifyURL            = "EMAIL_VERIFY_URL"       // Address the email verification emails link to
+	EnvRequireVerified      = "REQUIRE_VERIFIED_EMAIL" // Whether only users with a verified email may book, "true" or "false"
+	EnvMailFrom             = "MAIL_FROM"              // Sender of the emails
+	EnvMailDir              = "MAIL_DIR"               // Directory emails are written to when no SMTP host is set
+	EnvSMTPHost             = "SMTP_HOST"              // SMTP server emails are sent through
+	EnvSMTPPort             = "SMTP_PORT"              // Port of the SMTP server
+	EnvSMTPUser             = "SMTP_USERNAME"          // User name to log in to the SMTP server
+	EnvSMTPPass             = "SMTP_PASSWORD"          // Password to log in to the SMTP server
+	EnvLoginMaxFailures     = "LOGIN_MAX_FAILURES"     // Failed logins for an email before it is locked
+	EnvLoginMaxIPFailures   = "LOGIN_MAX_IP_FAILURES"  // Failed logins from an IP before it is locked
+	EnvLoginBackoff         = "LOGIN_BACKOFF"          // Wait after the first failed login, doubled with every further one, e.g. "1s"
+	EnvLoginMaxBackoff      = "LOGIN_MAX_BACKOFF"      // Longest wait between failed logins, e.g. "30s"
+	EnvLoginLockout         = "LOGIN_LOCKOUT"          // How long a lockout lasts, e.g. "15m"
+	EnvHoldTTL              = "BOOKING_HOLD_TTL"       // How long a room hold lasts before it expires, e.g. "10m"
+	EnvHoldSweepInterval    = "BOOKING_HOLD_SWEEP"     // How often expired holds are released, e.g. "1m"
+	EnvIdempotencyRetention = "IDEMPOTENCY_RETENTION"  // How long responses are kept for replaying retries, e.g. "24h"
+	EnvIdempotencyLease     = "IDEMPOTENCY_LEASE"      // How long a request keeps its key without renewing it, e.g. "2m"
+	EnvPaymentProvider      = "PAYMENT_PROVIDER"       // Payment gateway bookings are paid through, e.g. "mock"
+	EnvPaymentCurrency      = "PAYMENT_CURRENCY"       // ISO 4217 currency the guests are charged in, e.g. "EUR"
+	EnvPaymentWebhookSecret = "PAYMENT_WEBHOOK_SECRET" // Secret the payment gateway signs its webhooks with
+)
+
+// Config holds everything the application needs to start
+// It is loaded once at startup by Load and passed on to the parts that need it
+type Config struct{
+	ListenAddr  string            `yaml:"listenAddr"`  // Listen address of the API server
+	ProxyHeader string            `yaml:"proxyHeader"` // Header with the client IP, only set behind a proxy that always overwrites it
+	DB          DBConfig          `yaml:"db"`          // Database connection
+	Auth        AuthConfig        `yaml:"auth"`        // Token signing and verification
+	Mail        MailConfig        `yaml:"mail"`        // How emails to users are sent
+	Login       LoginConfig       `yaml:"login"`       // Protection against guessed passwords
+	Booking     BookingConfig     `yaml:"booking"`     // Room holds during checkout
+	Idempotency IdempotencyConfig `yaml:"idempotency"` // Replaying retried requests
+	Payments    PaymentsConfig    `yaml:"payments"`    // How guests pay for their bookings
+}
+
+// DBConfig describes which MongoDB database to use
+type DBConfig struct{
+	URI  string `yaml:"uri"`  // MongoDB connection string
+	Name string `yaml:"name"` // Name of the database holding all collections
+}
+
+// AuthConfig describes how JWT tokens are issued and verified
+// Tokens are signed with the asymmetric SigningKeys if there are any, otherwise with
+// the shared JWTSecret. A JWTSecret set next to signing keys is only used to accept
+// the HS256 tokens issued before switching to the keys
+type AuthConfig struct{
+	JWTSecret            string             `yaml:"jwtSecret"`            // Secret used to sign and verify HS256 tokens
+	SigningKeys          []SigningKeyConfig `yaml:"signingKeys"`          // Asymmetric keys, selected by their kid
+	TokenTTL             time.Duration      `yaml:"tokenTTL"`             // How long an issued access token stays valid
+	RefreshTTL           time.Duration      `yaml:"refreshTTL"`           // How long a refresh token can be exchanged for a new access token
+	Issuer               string             `yaml:"issuer"`               // Put into the iss claim and required when verifying
+	Audience             string             `yaml:"audience"`             // Put into the aud claim and required when verifying
+	ResetTTL             time.Duration      `yaml:"resetTTL"`             // How long a password reset link stays valid
+	ResetURL             string             `yaml:"resetURL"`             // Page the reset emails link to, the token is appended as ?token=
+	VerifyTTL            time.Duration      `yaml:"verifyTTL"`            // How long an email verification link stays valid
+	VerifyURL            string             `yaml:"verifyURL"`            // GET /api/auth/verify as reached by users, the token is appended as ?token=
+	RequireVerifiedEmail bool               `yaml:"requireVerifiedEmail"` // Only let users with a verified email book rooms
+}
+
+// MailConfig describes how emails to users are sent
+// With an SMTP host they are sent through that server, without one they are
+// written to files in Dir
+type MailConfig struct{
+	From string     `yaml:"from"` // Sender, e.g. "Hotel Reservation <no-reply@example.com>"
+	Dir  string     `yaml:"dir"`  // Directory emails are written to when no SMTP host is set
+	SMTP SMTPConfig `yaml:"smtp"` // SMTP server
+}
+
+// SMTPConfig describes the SMTP server emails are sent through
+type SMTPConfig struct{
+	Host     string `yaml:"host"`     // Host name, empty to write emails to files instead
+	Port     int    `yaml:"port"`     // Port, usually 587 for submission with STARTTLS
+	Username string `yaml:"username"` // User name, empty if the server needs no login
+	Password string `yaml:"password"` // Password of the user
+}
+
+// LoginConfig describes how failed logins are throttled
+// Failures are counted per email and per client IP. After each failure the next
+// attempt has to wait, starting at Backoff and doubling up to MaxBackoff; after
+// MaxFailures for an email or MaxIPFailures for an IP, logins are locked for Lockout
+type LoginConfig struct{
+	MaxFailures   int           `yaml:"maxFailures"`   // Failed logins for an email before it is locked
+	MaxIPFailures int           `yaml:"maxIPFailures"` // Failed logins from an IP before it is locked
+	Backoff       time.Duration `yaml:"backoff"`       // Wait after the first failure
+	MaxBackoff    time.Duration `yaml:"maxBackoff"`    // Longest wait between two failures
+	Lockout       time.Duration `yaml:"lockout"`       // How long a lockout lasts, failures are also forgotten after this long
+}
+
+// BookingConfig describes how rooms are held during checkout
+// A hold reserves a room for HoldTTL until it is confirmed; expired holds are
+// ignored right away and released for good every HoldSweepInterval
+type BookingConfig struct{
+	HoldTTL           time.Duration `yaml:"holdTTL"`           // How long a hold lasts
+	HoldSweepInterval time.Duration `yaml:"holdSweepInterval"` // How often expired holds are released
+}
+
+// IdempotencyConfig describes how requests sent with an Idempotency-Key header are replayed
+// A retry with the same key within Retention gets the original response instead
+// of being processed again. A running request renews its key every third of
+// the Lease; a request whose server died stops renewing and no longer blocks
+// its key once the Lease has run out
+type IdempotencyConfig struct{
+	Retention time.Duration `yaml:"retention"` // How long a key and its response are kept
+	Lease     time.Duration `yaml:"lease"`     // How long a request keeps its key without renewing it
+}
+
+// PaymentsConfig describes the payment gateway bookings are paid through
+// A booking is only confirmed once the gateway has authorized its total; the
+// gateway reports later changes to a payment through a signed webhook
+type PaymentsConfig struct{
+	Provider      string `yaml:"provider"`      // Payment gateway, currently only mock
+	Currency      string `yaml:"currency"`      // ISO 4217 currency the guests are charged in
+	WebhookSecret string `yaml:"webhookSecret"` // Secret the webhooks are signed with
+}
+
+// Supported payment providers
+const (
+	PaymentProviderMock = "mock" // Local gateway for development and tests, see payments.MockProvider
+)
+
+// Algorithms supported for signing keys
+const (
+	AlgorithmRS256 = "RS256" // RSA with SHA-256, keys of at least 2048 bits
+	AlgorithmEdDSA = "EdDSA" // Ed25519
+)
+
+// SigningKeyConfig describes an asymmetric key used to sign tokens
+// Keys are rotated by adding the next key with an activeFrom date in the future:
+// it is published right away, so verifiers can fetch it in time, and takes over
+// signing once the date has passed. The old key is kept until every token it
+// signed has expired and can then be retired
+type SigningKeyConfig struct{
+	ID             string    `yaml:"id"`             // Key ID, put into the kid header of the tokens
+	Algorithm      string    `yaml:"algorithm"`      // RS256 or EdDSA
+	PrivateKeyFile string    `yaml:"privateKeyFile"` // PEM file holding the private key
+	ActiveFrom     time.Time `yaml:"activeFrom"`     // When the key starts signing, zero means right away
+	RetireAt       time.Time `yaml:"retireAt"`       // When tokens signed with the key stop being accepted, zero means never
+}
+
+// Default returns the configuration used when nothing else is set
+// It has no JWT secret, so it does not pass Validate on its own
+func Default() *Config{
+	return &Config{
+		ListenAddr: DefaultListenAddr,
+		DB: DBConfig{
+			URI:  DefaultDBURI,
+			Name: DefaultDBName,
+		},
+		Auth: AuthConfig{
+			TokenTTL:   DefaultTokenTTL,
+			RefreshTTL: DefaultRefreshTTL,
+			Issuer:     DefaultIssuer,
+			Audience:   DefaultAudience,
+			ResetTTL:   DefaultResetTTL,
+			ResetURL:   DefaultResetURL,
+			VerifyTTL:  DefaultVerifyTTL,
+			VerifyURL:  DefaultVerifyURL,
+		},
+		Mail: MailConfig{
+			From: DefaultMailFrom,
+			Dir:  DefaultMailDir,
+			SMTP: SMTPConfig{
+				Port: DefaultSMTPPort,
+			},
+		},
+		Login: LoginConfig{
+			MaxFailures:   DefaultLoginMaxFailures,
+			MaxIPFailures: DefaultLoginMaxIPFailures,
+			Backoff:       DefaultLoginBackoff,
+			MaxBackoff:    DefaultLoginMaxBackoff,
+			Lockout:       DefaultLoginLockout,
+		},
+		Booking: BookingConfig{
+			HoldTTL:           DefaultHoldTTL,
+			HoldSweepInterval: DefaultHoldSweepInterval,
+		},
+		Idempotency: IdempotencyConfig{
+			Retention: DefaultIdempotencyRetention,
+			Lease:     DefaultIdempotencyLease,
+		},
+		Payments: PaymentsConfig{
+			Provider: DefaultPaymentProvider,
+			Currency: DefaultPaymentCurrency,
+		},
+	}
+}
+
+// Load builds the configuration from the defaults, a YAML file, environment variables
+// and command line flags. Later sources win: a flag beats an environment variable,
+// which beats the file, which beats the default.
+// args are the command line arguments without the program name and lookupEnv is
+// usually os.LookupEnv. The file is named by the -config flag or CONFIG_FILE.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error){
+	cfg := Default()
+
+	// Flags are parsed first to find the config file, but applied last
+	fs := flag.NewFlagSet("hotel-reservation", flag.ContinueOnError)
+	configFile := fs.String("config", "", "Path of the YAML configuration file")
+	listenAddr := fs.String("listenAddr", DefaultListenAddr, "The listen address of the API server")
+	dbURI := fs.String("dbURI", DefaultDBURI, "The MongoDB connection string")
+	dbName := fs.String("dbName", DefaultDBName, "The name of the MongoDB database")
+	if err := fs.Parse(args); err != nil{
+		return nil, err
+	}
+
+	path := *configFile
+	if path == ""{
+		path, _ = lookupEnv(EnvConfigFile)
+	}
+	if path != ""{
+		if err := cfg.loadFile(path); err != nil{
+			return nil, err
+		}
+	}
+
+	if err := cfg.loadEnv(lookupEnv); err != nil{
+		return nil, err
+	}
+
+	// Only flags given explicitly override the other sources
+	fs.Visit(func(f *flag.Flag){
+		switch f.Name{
+		case "listenAddr":
+			cfg.ListenAddr = *listenAddr
+		case "dbURI":
+			cfg.DB.URI = *dbURI
+		case "dbName":
+			cfg.DB.Name = *dbName
+		}
+	})
+	return cfg, nil
+}
+
+// loadFile overrides the configuration with the values set in a YAML file
+// Unknown keys are rejected so that typos do not go unnoticed
+func (c *Config) loadFile(path string) error{
+	f, err := os.Open(path)
+	if err != nil{
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF){
+		return fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides the configuration with the environment variables that are set
+func (c *Config) loadEnv(lookupEnv func(string) (string, bool)) error{
+	if v, ok := lookupEnv(EnvListenAddr); ok{
+		c.ListenAddr = v
+	}
+	if v, ok := lookupEnv(EnvProxyHeader); ok{
+		c.ProxyHeader = v
+	}
+	if v, ok := lookupEnv(EnvDBURI); ok{
+		c.DB.URI = v
+	}
+	if v, ok := lookupEnv(EnvDBName); ok{
+		c.DB.Name = v
+	}
+	if v, ok := lookupEnv(EnvJWTSecret); ok{
+		c.Auth.JWTSecret = v
+	}
+	if v, ok := lookupEnv(EnvTokenTTL); ok{
+		ttl, err := time.ParseDuration(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvTokenTTL, err)
+		}
+		c.Auth.TokenTTL = ttl
+	}
+	if v, ok := lookupEnv(EnvRefreshTTL); ok{
+		ttl, err := time.ParseDuration(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvRefreshTTL, err)
+		}
+		c.Auth.RefreshTTL = ttl
+	}
+	if v, ok := lookupEnv(EnvIssuer); ok{
+		c.Auth.Issuer = v
+	}
+	if v, ok := lookupEnv(EnvAudience); ok{
+		c.Auth.Audience = v
+	}
+	if v, ok := lookupEnv(EnvResetTTL); ok{
+		ttl, err := time.ParseDuration(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvResetTTL, err)
+		}
+		c.Auth.ResetTTL = ttl
+	}
+	if v, ok := lookupEnv(EnvResetURL); ok{
+		c.Auth.ResetURL = v
+	}
+	if v, ok := lookupEnv(EnvVerifyTTL); ok{
+		ttl, err := time.ParseDuration(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvVerifyTTL, err)
+		}
+		c.Auth.VerifyTTL = ttl
+	}
+	if v, ok := lookupEnv(EnvVerifyURL); ok{
+		c.Auth.VerifyURL = v
+	}
+	if v, ok := lookupEnv(EnvRequireVerified); ok{
+		required, err := strconv.ParseBool(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvRequireVerified, err)
+		}
+		c.Auth.RequireVerifiedEmail = required
+	}
+	if v, ok := lookupEnv(EnvMailFrom); ok{
+		c.Mail.From = v
+	}
+	if v, ok := lookupEnv(EnvMailDir); ok{
+		c.Mail.Dir = v
+	}
+	if v, ok := lookupEnv(EnvSMTPHost); ok{
+		c.Mail.SMTP.Host = v
+	}
+	if v, ok := lookupEnv(EnvSMTPPort); ok{
+		port, err := strconv.Atoi(v)
+		if err != nil{
+			return fmt.Errorf("invalid %s: %w", EnvSMTPPort, err)
+		}
+		c.Mail.SMTP.Port = port
+	}
+	if v, ok := lookupEnv(EnvSMTPUser); ok{
+		c.Mail.SMTP.Username = v
+	}
+	if v, ok := lookupEnv(EnvSMTPPass); ok{
+		c.Mail.SMTP.Password = v
+	}
+	if v, ok := lookupEnv(EnvPaymentProvider); ok{
+		c.Payments.Provider = v
+	}
+	if v, ok := lookupEnv(EnvPaymentCurrency); ok{
+		c.Payments.Currency = v
+	}
+	if v, ok := lookupEnv(EnvPaymentWebhookSecret); ok{
+		c.Payments.WebhookSecret = v
+	}
+	for env, dst := range map[string]*int{
+		EnvLoginMaxFailures:   &c.Login.MaxFailures,
+		EnvLoginMaxIPFailures: &c.Login.MaxIPFailures,
+	}{
+		if v, ok := lookupEnv(env); ok{
+			n, err := strconv.Atoi(v)
+			if err != nil{
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*dst = n
+		}
+	}
+	for env, dst := range map[string]*time.Duration{
+		EnvLoginBackoff:         &c.Login.Backoff,
+		EnvLoginMaxBackoff:      &c.Login.MaxBackoff,
+		EnvLoginLockout:         &c.Login.Lockout,
+		EnvHoldTTL:              &c.Booking.HoldTTL,
+		EnvHoldSweepInterval:    &c.Booking.HoldSweepInterval,
+		EnvIdempotencyRetention: &c.Idempotency.Retention,
+		EnvIdempotencyLease:     &c.Idempotency.Lease,
+	}{
+		if v, ok := lookupEnv(env); ok{
+			d, err := time.ParseDuration(v)
+			if err != nil{
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			*dst = d
+		}
+	}
+	return nil
+}
+
+// Validate checks that every required value is set
+// The application refuses to start with an invalid configuration, most importantly
+// without a JWT secret, which would let anyone sign their own tokens
+func (c *Config) Validate() error{
+	var problems []string
+	if c.ListenAddr == ""{
+		problems = append(problems, "listen address is required")
+	}
+	if c.DB.URI == ""{
+		problems = append(problems, "database URI is required")
+	}
+	if c.DB.Name == ""{
+		problems = append(problems, "database name is required")
+	}
+	if strings.TrimSpace(c.Auth.JWTSecret) == "" && len(c.Auth.SigningKeys) == 0{
+		problems = append(problems, fmt.Sprintf("JWT secret or signing keys are required (set %s)", EnvJWTSecret))
+	}
+	ids := map[string]bool{}
+	for i, key := range c.Auth.SigningKeys{
+		switch{
+		case key.ID == "":
+			problems = append(problems, fmt.Sprintf("signing key %d has no id", i+1))
+		case ids[key.ID]:
+			problems = append(problems, fmt.Sprintf("signing key id %s is used twice", key.ID))
+		}
+		ids[key.ID] = true
+		if key.Algorithm != AlgorithmRS256 && key.Algorithm != AlgorithmEdDSA{
+			problems = append(problems, fmt.Sprintf("signing key %s has unsupported algorithm %q", key.ID, key.Algorithm))
+		}
+		if key.PrivateKeyFile == ""{
+			problems = append(problems, fmt.Sprintf("signing key %s has no private key file", key.ID))
+		}
+		if !key.RetireAt.IsZero() && !key.RetireAt.After(key.ActiveFrom){
+			problems = append(problems, fmt.Sprintf("signing key %s is retired before it becomes active", key.ID))
+		}
+	}
+	if c.Auth.TokenTTL <= 0{
+		problems = append(problems, "token TTL must be positive")
+	}
+	if c.Auth.RefreshTTL < c.Auth.TokenTTL{
+		problems = append(problems, "refresh token TTL must not be shorter than the token TTL")
+	}
+	if c.Auth.Issuer == "" || c.Auth.Audience == ""{
+		problems = append(problems, "token issuer and audience are required")
+	}
+	if c.Auth.ResetTTL <= 0{
+		problems = append(problems, "password reset TTL must be positive")
+	}
+	if u, err := url.Parse(c.Auth.ResetURL); err != nil || !u.IsAbs(){
+		problems = append(problems, "password reset URL must be an absolute URL")
+	}
+	if c.Auth.VerifyTTL <= 0{
+		problems = append(problems, "email verification TTL must be positive")
+	}
+	if u, err := url.Parse(c.Auth.VerifyURL); err != nil || !u.IsAbs(){
+		problems = append(problems, "email verification URL must be an absolute URL")
+	}
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil{
+		problems = append(problems, "mail sender must be a valid address")
+	}
+	if c.Mail.SMTP.Host == "" && c.Mail.Dir == ""{
+		problems = append(problems, "either an SMTP host or a mail directory is required")
+	}
+	if c.Mail.SMTP.Host != "" && (c.Mail.SMTP.Port <= 0 || c.Mail.SMTP.Port > 65535){
+		problems = append(problems, "SMTP port must be between 1 and 65535")
+	}
+	if c.Login.MaxFailures <= 0 || c.Login.MaxIPFailures <= 0{
+		problems = append(problems, "login failure limits must be positive")
+	}
+	if c.Login.Backoff <= 0 || c.Login.MaxBackoff < c.Login.Backoff{
+		problems = append(problems, "login backoff must be positive and not exceed the maximum backoff")
+	}
+	if c.Login.Lockout <= 0{
+		problems = append(problems, "login lockout must be positive")
+	}
+	if c.Booking.HoldTTL <= 0 || c.Booking.HoldSweepInterval <= 0{
+		problems = append(problems, "booking hold TTL and sweep interval must be positive")
+	}
+	if c.Idempotency.Retention <= 0{
+		problems = append(problems, "idempotency retention must be positive")
+	}
+	if c.Idempotency.Lease < time.Second || c.Idempotency.Lease > c.Idempotency.Retention{
+		problems = append(problems, "idempotency lease must be at least 1s and not exceed the retention")
+	}
+	if c.Payments.Provider != PaymentProviderMock{
+		problems = append(problems, fmt.Sprintf("unsupported payment provider %q", c.Payments.Provider))
+	}
+	if len(c.Payments.Currency) != 3 || strings.ToUpper(c.Payments.Currency) != c.Payments.Currency{
+		problems = append(problems, "payment currency must be a three-letter ISO 4217 code")
+	}
+	if strings.TrimSpace(c.Payments.WebhookSecret) == ""{
+		problems = append(problems, fmt.Sprintf("payment webhook secret is required (set %s)", EnvPaymentWebhookSecret))
+	}
+	if len(problems) > 0{
+		return fmt.Errorf("invalid configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -2,28 +2,90 @@ package db
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrRoomAlreadyBooked is returned when a booking overlaps a night that is
+// already reserved for the same room
+var ErrRoomAlreadyBooked = errors.New("room already booked")
+
+// ErrBookingNotCancellable is returned when cancelling a booking that is
+// already cancelled or whose stay has started
+var ErrBookingNotCancellable = errors.New("booking cannot be cancelled")
+
+// ErrBookingNotModifiable is returned when modifying a booking that is cancelled,
+// whose stay has started or that was changed by someone else in the meantime
+var ErrBookingNotModifiable = errors.New("booking cannot be modified")
+
+// ErrHoldExpired is returned when confirming a hold that has run out
+var ErrHoldExpired = errors.New("hold has expired")
+
+// ErrBookingNotHeld is returned when confirming a booking that is not a hold
+var ErrBookingNotHeld = errors.New("booking is not a hold")
+
+// ErrBookingNotPending is returned when settling the payment of a booking that
+// is not waiting for one
+var ErrBookingNotPending = errors.New("booking is not waiting for a payment")
+
+// ErrRoomDeleted is returned when booking nights of a room that no longer
+// exists or is being deleted
+var ErrRoomDeleted = errors.New("room has been deleted")
+
+// Name of the MongoDB collection for bookings
+const bookingColl = "Bookings"
+
+// Name of the MongoDB collection holding the reservation ledger
+const roomNightColl = "roomNights"
+
 type BookingStore interface{
 	InsertBooking(context.Context,*types.Booking)(*types.Booking,error)
+	InsertBookingIfAvailable(context.Context,*types.Booking)(*types.Booking,error) // Insert only if no night of the stay is taken
 	GetBookings(context.Context,bson.M)([]*types.Booking,error)
+	GetBookingByID(context.Context,primitive.ObjectID)(*types.Booking,error)
+	CancelBooking(context.Context,primitive.ObjectID,*types.Cancellation)(*types.Booking,error) // Cancel, record what it cost and free the booked nights
+	SettleCancellation(context.Context,primitive.ObjectID,float64)(*types.Booking,error) // Record that the payments of a cancelled booking have been settled and what was refunded
+	ModifyBooking(context.Context,*types.Booking,types.BookingChange)(*types.Booking,error) // Move a booking to new terms, keeping its own nights
+	ConfirmHold(context.Context,primitive.ObjectID)(*types.Booking,error)          // Turn a hold that has not expired into a confirmed booking
+	ExpireHolds(context.Context,time.Time)(int,error)                              // Release every hold that has run out
+	ConfirmPendingBooking(context.Context,primitive.ObjectID)(*types.Booking,error) // Confirm a booking once its payment is authorized
+	FailPendingBooking(context.Context,primitive.ObjectID)(*types.Booking,error)    // Fail a booking whose payment was declined and free its nights
+	HasUpcomingStays(context.Context,primitive.ObjectID,time.Time)(bool,error)     // Whether any booking still holds a night of the room from now on
+	ReleaseOrphanedNights(context.Context,time.Time)(int,error)                    // Free the nights claimed before the given time for bookings that do not hold them
+}
+
+// roomNight is an entry in the reservation ledger
+// Every booked night of a room has exactly one entry, enforced by a unique
+// index on (roomID, night), which is what makes booking creation race-free
+type roomNight struct{
+	RoomID    primitive.ObjectID `bson:"roomID"`
+	Night     time.Time          `bson:"night"`
+	BookingID primitive.ObjectID `bson:"bookingID"`
+	ClaimedAt time.Time          `bson:"claimedAt"` // When the night was claimed, entries made before it was recorded have none
 }
 
 type MongoBookingStore struct{
 	client *mongo.Client
 	coll   *mongo.Collection
+	nights *mongo.Collection // Reservation ledger, one document per booked room night
+	rooms  *mongo.Collection // The rooms collection, checked for rooms being deleted
+	nightsIndex indexOnce
 	BookingStore
 }
 
-func NewMongoBookingStore(client *mongo.Client) *MongoBookingStore{
+// NewMongoBookingStore creates a new MongoBookingStore using the collections in database dbname
+func NewMongoBookingStore(client *mongo.Client, dbname string) *MongoBookingStore{
 	return &MongoBookingStore{
 		client: client,
-		coll: client.Database(DBNAME).Collection("Bookings"),
+		coll: client.Database(dbname).Collection(bookingColl),
+		nights: client.Database(dbname).Collection(roomNightColl),
+		rooms: client.Database(dbname).Collection(roomColl),
 	}
 }
 
@@ -35,6 +97,171 @@ func (s *MongoBookingStore) InsertBooking(ctx context.Context, booking *types.Bo
 	booking.ID = resp.InsertedID.(primitive.ObjectID)
 	return booking,nil
 }
+
+// InsertBookingIfAvailable reserves every night of the booking and inserts it
+// The nights are claimed in date order in the ledger; if any of them is already
+// taken the claims made so far are released and ErrRoomAlreadyBooked is returned.
+// ErrRoomDeleted is returned if the room is gone or being deleted. Claiming and
+// inserting are separate writes: if the process dies in between, the claimed
+// nights are left to ReleaseOrphanedNights
+func (s *MongoBookingStore) InsertBookingIfAvailable(ctx context.Context, booking *types.Booking)(*types.Booking,error){
+	if err := s.nightsIndex.ensure(ctx, s.nights, mongo.IndexModel{
+		Keys:    bson.D{{Key: "roomID", Value: 1}, {Key: "night", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil{
+		return nil,err
+	}
+
+	// Holds that ran out must not keep the room until the sweeper gets to them
+	if _, err := s.expireHolds(ctx, bson.M{"roomID": booking.RoomID}, time.Now()); err != nil{
+		return nil,err
+	}
+
+	if booking.ID.IsZero(){
+		booking.ID = primitive.NewObjectID()
+	}
+	now := time.Now()
+	var docs []interface{}
+	for _, night := range types.BookingNights(booking.FromDate, booking.TillDate){
+		docs = append(docs, roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID, ClaimedAt: now})
+	}
+	if len(docs) == 0{
+		return nil,errors.New("booking does not cover any night")
+	}
+
+	if _, err := s.nights.InsertMany(ctx, docs); err != nil{
+		s.releaseNights(booking.ID)
+		if mongo.IsDuplicateKeyError(err){
+			return nil,ErrRoomAlreadyBooked
+		}
+		return nil,err
+	}
+	if err := s.checkRoomBookable(ctx, booking.RoomID); err != nil{
+		s.releaseNights(booking.ID)
+		return nil,err
+	}
+	if _, err := s.coll.InsertOne(ctx, booking); err != nil{
+		s.releaseNights(booking.ID)
+		return nil,err
+	}
+	return booking,nil
+}
+
+// checkRoomBookable returns ErrRoomDeleted if the room is missing or being deleted
+// It runs after the nights are claimed: a deletion that marked the room before
+// the claim is seen here, one that marks it afterwards finds the claimed nights
+func (s *MongoBookingStore) checkRoomBookable(ctx context.Context, roomID primitive.ObjectID) error{
+	n, err := s.rooms.CountDocuments(ctx, bookableRoomFilter(roomID))
+	if err != nil{
+		return err
+	}
+	if n == 0{
+		return ErrRoomDeleted
+	}
+	return nil
+}
+
+// bookableRoomFilter matches the room with the given ID unless it is being deleted
+func bookableRoomFilter(roomID primitive.ObjectID) bson.M{
+	filter := notDeletingFilter(time.Now())
+	filter["_id"] = roomID
+	return filter
+}
+
+// HasUpcomingStays reports whether any booking still holds a night of the room from now on
+// Besides the ledger it looks at the bookings themselves, which also covers
+// bookings that were inserted without claiming their nights
+func (s *MongoBookingStore) HasUpcomingStays(ctx context.Context, roomID primitive.ObjectID, now time.Time)(bool,error){
+	if _, err := s.expireHolds(ctx, bson.M{"roomID": roomID}, now); err != nil{
+		return false,err
+	}
+	today, _ := types.StayDays(now, now)
+	nights, err := s.nights.CountDocuments(ctx, bson.M{"roomID": roomID, "night": bson.M{"$gte": today}})
+	if err != nil{
+		return false,err
+	}
+	if nights > 0{
+		return true,nil
+	}
+	bookings, err := s.coll.CountDocuments(ctx, upcomingBookingsFilter(roomID, now))
+	if err != nil{
+		return false,err
+	}
+	return bookings > 0,nil
+}
+
+// upcomingBookingsFilter matches the bookings of the room that have not ended
+// by the given time and still hold the room
+func upcomingBookingsFilter(roomID primitive.ObjectID, now time.Time) bson.M{
+	return bson.M{
+		"roomID":   roomID,
+		"tillDate": bson.M{"$gt": now},
+		"status": bson.M{"$nin": append(types.ReleasedBookingStatuses(), types.BookingStatusCheckedOut)},
+		"$or": []bson.M{
+			{"status": bson.M{"$ne": types.BookingStatusHeld}},
+			{"holdExpiresAt": bson.M{"$gt": now}},
+		},
+	}
+}
+
+// ReleaseOrphanedNights removes the ledger entries claimed before the given time
+// whose booking is missing or has been released, and returns how many it removed
+// Such entries are left behind when a request fails between claiming the nights
+// and writing the booking. Newer claims may belong to a request still running
+// and are kept
+func (s *MongoBookingStore) ReleaseOrphanedNights(ctx context.Context, before time.Time)(int,error){
+	ids, err := s.nights.Distinct(ctx, "bookingID", claimedBeforeFilter(before))
+	if err != nil{
+		return 0,err
+	}
+	var orphaned []primitive.ObjectID
+	for _, id := range ids{
+		bookingID, ok := id.(primitive.ObjectID)
+		if !ok{
+			continue
+		}
+		n, err := s.coll.CountDocuments(ctx, holdingBookingFilter(bookingID))
+		if err != nil{
+			return 0,err
+		}
+		if n == 0{
+			orphaned = append(orphaned, bookingID)
+		}
+	}
+	if len(orphaned) == 0{
+		return 0,nil
+	}
+
+	filter := claimedBeforeFilter(before)
+	filter["bookingID"] = bson.M{"$in": orphaned}
+	res, err := s.nights.DeleteMany(ctx, filter)
+	if err != nil{
+		return 0,err
+	}
+	return int(res.DeletedCount),nil
+}
+
+// claimedBeforeFilter matches the ledger entries claimed before the given time
+// Entries made before claims were timestamped count as old
+func claimedBeforeFilter(before time.Time) bson.M{
+	return bson.M{"$or": []bson.M{
+		{"claimedAt": bson.M{"$lt": before}},
+		{"claimedAt": bson.M{"$exists": false}},
+	}}
+}
+
+// holdingBookingFilter matches the booking with the given ID unless it has been released
+func holdingBookingFilter(id primitive.ObjectID) bson.M{
+	return bson.M{"_id": id, "status": bson.M{"$nin": types.ReleasedBookingStatuses()}}
+}
+
+// releaseNights removes every ledger entry held by the given booking
+// It runs on its own context so a cancelled request still cleans up after itself
+func (s *MongoBookingStore) releaseNights(bookingID primitive.ObjectID) error{
+	_, err := s.nights.DeleteMany(context.Background(), bson.M{"bookingID": bookingID})
+	return err
+}
+
 func (s *MongoBookingStore) GetBookings(ctx context.Context, filter bson.M)([]*types.Booking,error){
 	curr,err := s.coll.Find(ctx,filter)
 	if err != nil{
@@ -46,3 +273,339 @@ func (s *MongoBookingStore) GetBookings(ctx context.Context, filter bson.M)([]*t
 	}
 	return bookings,nil
 }
+
+func (s *MongoBookingStore) GetBookingByID(ctx context.Context, id primitive.ObjectID)(*types.Booking,error){
+	var booking types.Booking
+	if err := s.coll.FindOne(ctx,bson.M{"_id": id}).Decode(&booking); err != nil{
+		return nil,err
+	}
+	return &booking,nil
+}
+
+// CancelBooking marks the booking as cancelled, records what the cancellation
+// cost and releases its nights in the ledger
+// Returns ErrBookingNotCancellable if the booking is not in a cancellable state
+func (s *MongoBookingStore) CancelBooking(ctx context.Context, id primitive.ObjectID, cancellation *types.Cancellation)(*types.Booking,error){
+	filter := cancellableBookingFilter(id)
+	update := cancelBookingUpdate(cancellation)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var booking types.Booking
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&booking); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			// Tell a missing booking apart from one in the wrong state
+			if _, err := s.GetBookingByID(ctx, id); err != nil{
+				return nil,err
+			}
+			return nil,ErrBookingNotCancellable
+		}
+		return nil,err
+	}
+	if err := s.releaseNights(booking.ID); err != nil{
+		return nil,err
+	}
+	return &booking,nil
+}
+
+// SettleCancellation records that the penalty of the cancelled booking has been
+// charged and the rest of its payments, refund in total, given back
+// Returns mongo.ErrNoDocuments if there is no such cancelled booking
+func (s *MongoBookingStore) SettleCancellation(ctx context.Context, id primitive.ObjectID, refund float64)(*types.Booking,error){
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var booking types.Booking
+	if err := s.coll.FindOneAndUpdate(ctx, cancelledBookingFilter(id), settleCancellationUpdate(refund), opts).Decode(&booking); err != nil{
+		return nil,err
+	}
+	return &booking,nil
+}
+
+// cancelledBookingFilter matches the booking with the given ID if it has been cancelled
+func cancelledBookingFilter(id primitive.ObjectID) bson.M{
+	return bson.M{"_id": id, "status": types.BookingStatusCancelled}
+}
+
+// settleCancellationUpdate records the refund of a cancellation and clears its pending settlement
+func settleCancellationUpdate(refund float64) bson.M{
+	return bson.M{
+		"$set":   bson.M{"cancellation.refund": refund},
+		"$unset": bson.M{"cancellation.settlementPending": ""},
+	}
+}
+
+// cancellableBookingFilter matches the booking with the given ID if it can still be cancelled
+func cancellableBookingFilter(id primitive.ObjectID) bson.M{
+	return bson.M{
+		"_id": id,
+		"status": bson.M{"$nin": append(types.ReleasedBookingStatuses(),
+			types.BookingStatusCheckedIn,
+			types.BookingStatusCheckedOut,
+		)},
+	}
+}
+
+// cancelBookingUpdate marks a booking cancelled and records what cancelling it cost
+func cancelBookingUpdate(cancellation *types.Cancellation) bson.M{
+	set := bson.M{"status": types.BookingStatusCancelled}
+	if cancellation != nil{
+		set["cancellation"] = cancellation
+	}
+	return bson.M{"$set": set}
+}
+
+// ModifyBooking moves the booking to the room, dates, guests and price it now holds
+// change carries the terms before the modification and is added to the history.
+// Nights the booking keeps stay reserved the whole time; new nights are claimed
+// in the ledger first and ErrRoomAlreadyBooked is returned if another booking
+// holds any of them, or ErrRoomDeleted if their room is being deleted. ErrBookingNotModifiable is returned if the booking can no
+// longer be modified or no longer has the terms recorded in change
+func (s *MongoBookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, change types.BookingChange)(*types.Booking,error){
+	added, removed := nightChanges(booking, change)
+	now := time.Now()
+	if _, err := s.expireHolds(ctx, bson.M{"roomID": booking.RoomID}, now); err != nil{
+		return nil,err
+	}
+
+	var docs []interface{}
+	for _, night := range added{
+		docs = append(docs, roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID, ClaimedAt: now})
+	}
+	// Gives up the claimed nights again, on its own context like releaseNights
+	release := func(){
+		if len(added) > 0{
+			s.nights.DeleteMany(context.Background(), bson.M{"bookingID": booking.ID, "roomID": booking.RoomID, "night": bson.M{"$in": added}})
+		}
+	}
+	if len(docs) > 0{
+		if _, err := s.nights.InsertMany(ctx, docs); err != nil{
+			release()
+			if mongo.IsDuplicateKeyError(err){
+				return nil,ErrRoomAlreadyBooked
+			}
+			return nil,err
+		}
+		if err := s.checkRoomBookable(ctx, booking.RoomID); err != nil{
+			release()
+			return nil,err
+		}
+	}
+
+	// Only apply the change to the terms and the status it was computed from, so
+	// a hold confirmed in the meantime is not moved away from what was paid for
+	filter := modifiableBookingFilter(booking.ID)
+	filter["roomID"] = change.RoomID
+	filter["fromDate"] = change.FromDate
+	filter["tillDate"] = change.TillDate
+	filter["$and"] = []bson.M{sameStatusFilter(booking.Status)}
+	update := bson.M{
+		"$set": bson.M{
+			"roomID":     booking.RoomID,
+			"fromDate":   booking.FromDate,
+			"tillDate":   booking.TillDate,
+			"numPersons": booking.NumPerson,
+			"price":      booking.Price,
+		},
+		"$push": bson.M{"history": change},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var modified types.Booking
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&modified); err != nil{
+		release()
+		if errors.Is(err, mongo.ErrNoDocuments){
+			if _, err := s.GetBookingByID(ctx, booking.ID); err != nil{
+				return nil,err
+			}
+			return nil,ErrBookingNotModifiable
+		}
+		return nil,err
+	}
+
+	if len(removed) > 0{
+		if _, err := s.nights.DeleteMany(ctx, bson.M{"bookingID": booking.ID, "roomID": change.RoomID, "night": bson.M{"$in": removed}}); err != nil{
+			return nil,err
+		}
+	}
+	return &modified,nil
+}
+
+// modifiableBookingFilter matches the booking with the given ID if it can still be modified
+// That is the case as long as it could be cancelled, unless it is waiting for a
+// payment, which is for its current total
+func modifiableBookingFilter(id primitive.ObjectID) bson.M{
+	return bson.M{
+		"_id": id,
+		"status": bson.M{"$nin": append(types.ReleasedBookingStatuses(),
+			types.BookingStatusCheckedIn,
+			types.BookingStatusCheckedOut,
+			types.BookingStatusPending,
+		)},
+	}
+}
+
+// sameStatusFilter matches bookings that still have the given status
+// Bookings made before statuses existed have none stored
+func sameStatusFilter(status types.BookingStatus) bson.M{
+	if status == ""{
+		return bson.M{"status": nil}
+	}
+	return bson.M{"status": status}
+}
+
+// nightChanges returns the nights the booking has to claim in its new room and
+// the nights it gives up in its old room when moving from the terms in change
+func nightChanges(booking *types.Booking, change types.BookingChange) (added, removed []time.Time){
+	oldNights := types.BookingNights(change.FromDate, change.TillDate)
+	newNights := types.BookingNights(booking.FromDate, booking.TillDate)
+	if booking.RoomID != change.RoomID{
+		return newNights, oldNights
+	}
+	return nightsMissing(newNights, oldNights), nightsMissing(oldNights, newNights)
+}
+
+// nightsMissing returns the nights of a that are not in b
+func nightsMissing(a, b []time.Time) []time.Time{
+	in := map[time.Time]bool{}
+	for _, night := range b{
+		in[night] = true
+	}
+	var missing []time.Time
+	for _, night := range a{
+		if !in[night]{
+			missing = append(missing, night)
+		}
+	}
+	return missing
+}
+
+// ConfirmHold turns the held booking into a confirmed one
+// Returns ErrHoldExpired if the hold has run out and ErrBookingNotHeld if the
+// booking is not a hold
+func (s *MongoBookingStore) ConfirmHold(ctx context.Context, id primitive.ObjectID)(*types.Booking,error){
+	filter := bson.M{"_id": id, "status": types.BookingStatusHeld, "holdExpiresAt": bson.M{"$gt": time.Now()}}
+	update := bson.M{
+		"$set":   bson.M{"status": types.BookingStatusConfirmed},
+		"$unset": bson.M{"holdExpiresAt": ""},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var booking types.Booking
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&booking); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return nil,s.holdError(ctx, id)
+		}
+		return nil,err
+	}
+	return &booking,nil
+}
+
+// holdError explains why the booking could not be confirmed as a hold
+func (s *MongoBookingStore) holdError(ctx context.Context, id primitive.ObjectID) error{
+	booking, err := s.GetBookingByID(ctx, id)
+	if err != nil{
+		return err
+	}
+	return confirmHoldError(booking)
+}
+
+// ExpireHolds releases the nights of every hold that has run out by now
+// It returns how many holds expired. Running it concurrently, or at the same
+// time as ConfirmHold, is safe: each hold either expires or is confirmed
+func (s *MongoBookingStore) ExpireHolds(ctx context.Context, now time.Time)(int,error){
+	return s.expireHolds(ctx, bson.M{}, now)
+}
+
+// expireHolds expires the holds matching the filter that have run out by now
+func (s *MongoBookingStore) expireHolds(ctx context.Context, filter bson.M, now time.Time)(int,error){
+	cursor, err := s.coll.Find(ctx, expiredHoldsFilter(filter, now))
+	if err != nil{
+		return 0,err
+	}
+	var holds []*types.Booking
+	if err := cursor.All(ctx, &holds); err != nil{
+		return 0,err
+	}
+
+	expired := 0
+	for _, hold := range holds{
+		// Only the request that actually changes the status releases the nights
+		res, err := s.coll.UpdateOne(ctx, expiredHoldsFilter(bson.M{"_id": hold.ID}, now), expireHoldUpdate())
+		if err != nil{
+			return expired,err
+		}
+		if res.ModifiedCount == 0{
+			continue
+		}
+		if err := s.releaseNights(hold.ID); err != nil{
+			return expired,err
+		}
+		expired++
+	}
+	return expired,nil
+}
+
+// expiredHoldsFilter adds the conditions matching holds that have run out by now to the filter
+func expiredHoldsFilter(filter bson.M, now time.Time) bson.M{
+	expired := bson.M{"status": types.BookingStatusHeld, "holdExpiresAt": bson.M{"$lte": now}}
+	for key, value := range filter{
+		expired[key] = value
+	}
+	return expired
+}
+
+// expireHoldUpdate marks a hold as expired
+func expireHoldUpdate() bson.M{
+	return bson.M{"$set": bson.M{"status": types.BookingStatusExpired}}
+}
+
+// confirmHoldError returns the error for a booking ConfirmHold did not match
+func confirmHoldError(booking *types.Booking) error{
+	switch booking.Status{
+	case types.BookingStatusHeld, types.BookingStatusExpired:
+		return ErrHoldExpired
+	}
+	return ErrBookingNotHeld
+}
+
+// pendingBookingFilter matches the booking with the given ID if it is waiting for its payment
+func pendingBookingFilter(id primitive.ObjectID) bson.M{
+	return bson.M{"_id": id, "status": types.BookingStatusPending}
+}
+
+// ConfirmPendingBooking confirms the booking once its payment has been authorized
+// Returns ErrBookingNotPending if the booking is not waiting for a payment
+func (s *MongoBookingStore) ConfirmPendingBooking(ctx context.Context, id primitive.ObjectID)(*types.Booking,error){
+	return s.settlePendingBooking(ctx, id, types.BookingStatusConfirmed)
+}
+
+// FailPendingBooking marks the booking failed after its payment was declined
+// and releases its nights in the ledger
+// Returns ErrBookingNotPending if the booking is not waiting for a payment
+func (s *MongoBookingStore) FailPendingBooking(ctx context.Context, id primitive.ObjectID)(*types.Booking,error){
+	booking, err := s.settlePendingBooking(ctx, id, types.BookingStatusFailed)
+	if err != nil{
+		return nil,err
+	}
+	if err := s.releaseNights(booking.ID); err != nil{
+		return nil,err
+	}
+	return booking,nil
+}
+
+// settlePendingBooking moves the pending booking to the given status
+func (s *MongoBookingStore) settlePendingBooking(ctx context.Context, id primitive.ObjectID, status types.BookingStatus)(*types.Booking,error){
+	update := bson.M{"$set": bson.M{"status": status}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var booking types.Booking
+	if err := s.coll.FindOneAndUpdate(ctx, pendingBookingFilter(id), update, opts).Decode(&booking); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			// Tell a missing booking apart from one in the wrong state
+			if _, err := s.GetBookingByID(ctx, id); err != nil{
+				return nil,err
+			}
+			return nil,ErrBookingNotPending
+		}
+		return nil,err
+	}
+	return &booking,nil
+}
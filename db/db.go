@@ -1,12 +1,68 @@
 package db
-const DBNAME = "hotel-reservation"
-const DBURI = "mongodb://localhost:27017/"
-const TestDBNAME = "hotil"
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
 
 type Store struct{
 	User UserStore
 	Hotel HotelStore
 	Room RoomStore
 	Booking BookingStore
+	Token TokenStore
+	Login LoginStore
+	Idempotency IdempotencyStore
+	Payment PaymentStore
 }
 
+// FindOptions controls the order and the page of results returned by store queries
+type FindOptions struct{
+	Sort  bson.D // Sort order, e.g. bson.D{{Key: "rating", Value: -1}}
+	Page  int64  // 1-based page number
+	Limit int64  // Maximum number of results per page, 0 means no limit
+}
+
+// toMongo converts the options into the driver's find options
+func (o *FindOptions) toMongo() *options.FindOptions{
+	opts := options.Find()
+	if o == nil{
+		return opts
+	}
+	if len(o.Sort) > 0{
+		opts.SetSort(o.Sort)
+	}
+	if o.Limit > 0{
+		opts.SetLimit(o.Limit)
+		if o.Page > 1{
+			opts.SetSkip((o.Page - 1) * o.Limit)
+		}
+	}
+	return opts
+}
+
+// indexOnce makes sure the indexes a store relies on exist before it is used
+// Creating an index that already exists is a no-op in MongoDB, so a failed
+// attempt is simply retried on the next call
+type indexOnce struct{
+	mu   sync.Mutex
+	done bool
+}
+
+// ensure creates the given indexes on the collection the first time it is called
+func (o *indexOnce) ensure(ctx context.Context, coll *mongo.Collection, models ...mongo.IndexModel) error{
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.done{
+		return nil
+	}
+	if _, err := coll.Indexes().CreateMany(ctx, models); err != nil{
+		return err
+	}
+	o.done = true
+	return nil
+}
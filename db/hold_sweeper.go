@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// orphanedNightsGrace is how old a claim in the ledger must be before the sweeper
+// frees it for a booking that does not exist, long enough for any request that
+// is still inserting the booking to have finished or given up
+const orphanedNightsGrace = 10 * time.Minute
+
+// SweepHolds releases expired holds every interval until the context is done
+// Holds stop reserving their room as soon as they run out; sweeping frees their
+// nights in the ledger and marks them expired, so guests see what happened.
+// It also frees the nights left behind by bookings that were never written
+func SweepHolds(ctx context.Context, store BookingStore, interval time.Duration){
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for{
+		select{
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := store.ExpireHolds(ctx, now)
+			if err != nil{
+				log.Printf("releasing expired holds: %v", err)
+				continue
+			}
+			if n > 0{
+				log.Printf("released %d expired holds", n)
+			}
+			n, err = store.ReleaseOrphanedNights(ctx, now.Add(-orphanedNightsGrace))
+			if err != nil{
+				log.Printf("releasing orphaned nights: %v", err)
+				continue
+			}
+			if n > 0{
+				log.Printf("released %d orphaned nights", n)
+			}
+		}
+	}
+}
@@ -2,19 +2,29 @@ package db
 
 import (
 	"context"
+	"errors"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Name of the MongoDB collection for hotels
+const hotelColl = "hotels"
+
+// ErrHotelHasRooms is returned when deleting a hotel that still has rooms
+var ErrHotelHasRooms = errors.New("hotel still has rooms")
+
 // HotelStore defines the interface for hotel data operations
 // Any implementation of HotelStore must provide these methods
 type HotelStore interface{
 	Insert(context.Context,*types.Hotel) (*types.Hotel, error)           // Add a new hotel
 	Update(context.Context,bson.M,bson.M)error                           // Update hotel information
 	GetHotels(context.Context,bson.M) ([]*types.Hotel,error)             // Get hotels with optional filters
+	SearchHotels(context.Context,bson.M,*FindOptions) ([]*types.Hotel,error) // Get one sorted page of matching hotels
+	CountHotels(context.Context,bson.M) (int64,error)                    // Count hotels matching a filter
 	GetHotelByID(context.Context,primitive.ObjectID) (*types.Hotel,error) // Find a hotel by ID
+	DeleteHotel(context.Context,primitive.ObjectID) error                // Remove a hotel without rooms
 }
 
 // MongoHotelStore implements the HotelStore interface with MongoDB
@@ -25,11 +35,11 @@ type MongoHotelStore struct{
 }
 
 // NewMongoHotelStore creates a new MongoHotelStore with the provided MongoDB client
-// This is a factory function that sets up the connection to the hotels collection
-func NewMongoHotelStore(client *mongo.Client) *MongoHotelStore{
+// This is a factory function that sets up the connection to the hotels collection in database dbname
+func NewMongoHotelStore(client *mongo.Client, dbname string) *MongoHotelStore{
 	return &MongoHotelStore{
 		client: client,
-		coll: client.Database(DBNAME).Collection("hotels"),
+		coll: client.Database(dbname).Collection(hotelColl),
 	}
 }
 
@@ -47,11 +57,18 @@ func (s *MongoHotelStore) Insert(ctx context.Context, hotel *types.Hotel)(*types
 }
 
 // Update modifies hotel information
-// Takes a filter to select the hotel and an update document
+// Takes a filter to select the hotel and an update document.
+// Returns mongo.ErrNoDocuments if no hotel matches the filter
 func (s *MongoHotelStore) Update(ctx context.Context,filter ,update bson.M) error{
 	// Update the hotel document
-	_, err := s.coll.UpdateOne(ctx,filter,update)
-	return err
+	res, err := s.coll.UpdateOne(ctx,filter,update)
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
 }
 
 // GetHotels retrieves hotels from the database
@@ -81,4 +98,66 @@ func (s *MongoHotelStore) GetHotelByID(ctx context.Context,id primitive.ObjectID
 		return nil,err
 	}
 	return &hotel,nil
-}
\ No newline at end of file
+}
+
+// SearchHotels retrieves one page of hotels matching the filter
+// The options decide the sort order and which page is returned
+func (s *MongoHotelStore) SearchHotels(ctx context.Context,filter bson.M,opts *FindOptions) ([]*types.Hotel,error){
+	// A nil filter is not a valid query document for Find
+	if filter == nil{
+		filter = bson.M{}
+	}
+
+	// Find hotels matching the filter, sorted and paginated
+	resp, err := s.coll.Find(ctx,filter,opts.toMongo())
+	if err != nil{
+		return nil,err
+	}
+
+	// Decode all results into the hotels slice
+	hotels := []*types.Hotel{}
+	if err := resp.All(ctx,&hotels);err!= nil{
+		return nil,err
+	}
+	return hotels,nil
+}
+
+// CountHotels returns the number of hotels matching the filter
+// Used together with SearchHotels to report the total number of results
+func (s *MongoHotelStore) CountHotels(ctx context.Context,filter bson.M) (int64,error){
+	if filter == nil{
+		filter = bson.M{}
+	}
+	return s.coll.CountDocuments(ctx,filter)
+}
+
+// DeleteHotel removes the hotel with the given ID if it has no rooms
+// Whether it has rooms is checked by the delete itself, so a room added at the
+// same time either lands before and keeps the hotel or fails to find it.
+// Returns ErrHotelHasRooms if it still has rooms and mongo.ErrNoDocuments if
+// there is no such hotel
+func (s *MongoHotelStore) DeleteHotel(ctx context.Context,id primitive.ObjectID) error{
+	res, err := s.coll.DeleteOne(ctx,emptyHotelFilter(id))
+	if err != nil{
+		return err
+	}
+	if res.DeletedCount == 0{
+		// Tell a missing hotel apart from one with rooms
+		if _, err := s.GetHotelByID(ctx,id); err != nil{
+			return err
+		}
+		return ErrHotelHasRooms
+	}
+	return nil
+}
+
+// emptyHotelFilter matches the hotel with the given ID if it has no rooms
+func emptyHotelFilter(id primitive.ObjectID) bson.M{
+	return bson.M{
+		"_id": id,
+		"$or": []bson.M{
+			{"rooms": nil},
+			{"rooms": bson.A{}},
+		},
+	}
+}
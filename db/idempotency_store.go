@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Name of the MongoDB collection for idempotency keys
+const idempotencyColl = "idempotencyKeys"
+
+// ErrIdempotencyClaimLost is returned when a request stores its response after
+// its lease ran out and a retry took over the key
+var ErrIdempotencyClaimLost = errors.New("idempotency key was claimed by another request")
+
+// IdempotencyStore remembers requests sent with an Idempotency-Key header and their responses
+type IdempotencyStore interface{
+	ClaimIdempotencyKey(context.Context,*types.IdempotencyRecord) (*types.IdempotencyRecord,error) // Store the record, or return the one already holding its key
+	RenewIdempotencyKey(ctx context.Context,record *types.IdempotencyRecord,lease time.Duration) error                               // Extend the lease of a running request, ErrIdempotencyClaimLost if a retry took over the key
+	CompleteIdempotencyKey(ctx context.Context,record *types.IdempotencyRecord,statusCode int,contentType string,body []byte) error // Store the response of the request, ErrIdempotencyClaimLost if a retry took over the key
+	ReleaseIdempotencyKey(ctx context.Context,record *types.IdempotencyRecord) error                                                  // Forget the request, so a retry is processed again
+}
+
+// reclaimableFilter matches the record holding the key if the key can be claimed again:
+// its retention has ended, or its request has not completed within the lease
+func reclaimableFilter(id string) bson.M{
+	now := time.Now().UTC()
+	return bson.M{
+		"_id": id,
+		"$or": []bson.M{
+			{"expiresAt": bson.M{"$lte": now}},
+			{"completed": false, "leaseExpiresAt": bson.M{"$lte": now}},
+		},
+	}
+}
+
+// claimFilter matches the record only while it still belongs to the claim that created it
+func claimFilter(record *types.IdempotencyRecord) bson.M{
+	return bson.M{"_id": record.ID, "claimID": record.ClaimID}
+}
+
+// runningClaimFilter matches the record while it belongs to the claim that created it and has no response yet
+func runningClaimFilter(record *types.IdempotencyRecord) bson.M{
+	filter := claimFilter(record)
+	filter["completed"] = false
+	return filter
+}
+
+// renewIdempotencyUpdate moves the end of the lease to lease from now
+func renewIdempotencyUpdate(lease time.Duration) bson.M{
+	return bson.M{"$set": bson.M{"leaseExpiresAt": time.Now().UTC().Add(lease)}}
+}
+
+// completeIdempotencyUpdate stores the response of a request on its record
+func completeIdempotencyUpdate(statusCode int, contentType string, body []byte) bson.M{
+	return bson.M{"$set": bson.M{
+		"completed":   true,
+		"statusCode":  statusCode,
+		"contentType": contentType,
+		"body":        body,
+	}}
+}
+
+type MongoIdempotencyStore struct{
+	client *mongo.Client
+	coll   *mongo.Collection
+	collIndex indexOnce
+}
+
+// NewMongoIdempotencyStore creates a new MongoIdempotencyStore using the collection in database dbname
+func NewMongoIdempotencyStore(client *mongo.Client, dbname string) *MongoIdempotencyStore{
+	return &MongoIdempotencyStore{
+		client: client,
+		coll: client.Database(dbname).Collection(idempotencyColl),
+	}
+}
+
+// ClaimIdempotencyKey stores the record of a request that is about to be processed
+// It returns nil if the key was free. If another record holds the key, that record
+// is returned and nothing is stored; the unique _id makes sure only one of several
+// concurrent requests with the same key claims it. A record whose request did not
+// complete within its lease no longer holds the key, so a request that died while
+// it was processed does not block retries until the key expires.
+// Expired records are removed by MongoDB through a TTL index
+func (s *MongoIdempotencyStore) ClaimIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord) (*types.IdempotencyRecord,error){
+	if err := s.collIndex.ensure(ctx, s.coll, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil{
+		return nil,err
+	}
+
+	// An expired record MongoDB has not removed yet no longer holds the key, nor does an abandoned one
+	if _, err := s.coll.DeleteOne(ctx, reclaimableFilter(record.ID)); err != nil{
+		return nil,err
+	}
+	_, err := s.coll.InsertOne(ctx, record)
+	if err == nil{
+		return nil,nil
+	}
+	if !mongo.IsDuplicateKeyError(err){
+		return nil,err
+	}
+
+	var existing types.IdempotencyRecord
+	if err := s.coll.FindOne(ctx, unexpiredFilter(record.ID)).Decode(&existing); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			return nil,errors.New("idempotency key expired while it was claimed")
+		}
+		return nil,err
+	}
+	return &existing,nil
+}
+
+// RenewIdempotencyKey extends the lease of a request that is still being processed
+// Returns ErrIdempotencyClaimLost if the lease ran out and a retry claimed the key
+func (s *MongoIdempotencyStore) RenewIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord, lease time.Duration) error{
+	res, err := s.coll.UpdateOne(ctx, runningClaimFilter(record), renewIdempotencyUpdate(lease))
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// CompleteIdempotencyKey stores the response the request got
+// Returns ErrIdempotencyClaimLost if the lease ran out and a retry claimed the key
+func (s *MongoIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord, statusCode int, contentType string, body []byte) error{
+	res, err := s.coll.UpdateOne(ctx, claimFilter(record), completeIdempotencyUpdate(statusCode, contentType, body))
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the record, so the key can be used again
+// A record that a retry has claimed since is left alone
+func (s *MongoIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord) error{
+	_, err := s.coll.DeleteOne(ctx, claimFilter(record))
+	return err
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Name of the MongoDB collection for failed login counters
+const loginAttemptColl = "loginAttempts"
+
+// Name of the MongoDB collection for lockout events
+const lockoutEventColl = "lockoutEvents"
+
+// ErrLoginAttemptTaken is returned when reserving a login attempt for a key whose
+// failures changed since they were read, because a parallel attempt reserved first
+var ErrLoginAttemptTaken = errors.New("another login attempt was reserved first")
+
+// lockoutEventOrder lists the newest lockout events first
+var lockoutEventOrder = &FindOptions{Sort: bson.D{{Key: "createdAt", Value: -1}}}
+
+// LoginStore keeps the failed login counters and the lockout events they caused
+type LoginStore interface{
+	ReserveLoginAttempt(ctx context.Context,key string,failures int,ttl time.Duration) (*types.LoginAttempts,error) // Count a login as failed if the key still has failures, the record lives for ttl after it
+	CountLoginAttempt(ctx context.Context,key string,ttl time.Duration) (*types.LoginAttempts,error)              // Count a login as failed without any condition, a new record lives for ttl
+	RecordLoginFailure(ctx context.Context,key string,ttl time.Duration) (*types.LoginAttempts,error)             // Mark a counted login as failed now, the record lives for ttl after it
+	ReleaseLoginAttempt(ctx context.Context,key string) error                                                     // Take back a login counted by ReserveLoginAttempt or CountLoginAttempt
+	GetLoginAttempts(ctx context.Context,key string) (*types.LoginAttempts,error)                       // Get an unexpired record, ErrNoDocuments if there is none
+	ClearLoginAttempts(ctx context.Context,key string) error
+	InsertLockoutEvent(context.Context,*types.LockoutEvent) (*types.LockoutEvent,error)
+	GetLockoutEvents(context.Context,bson.M) ([]*types.LockoutEvent,error)                     // Get matching events, newest first
+	UnlockLockoutEvents(ctx context.Context,email string,adminID primitive.ObjectID) error     // Mark the open events of an email unlocked by the admin
+}
+
+// unexpiredFilter matches the record with the key if it has not expired yet
+// MongoDB removes expired records only about once a minute, so they are skipped explicitly
+func unexpiredFilter(key string) bson.M{
+	return bson.M{
+		"_id":       key,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}
+}
+
+// openLockoutFilter matches the lockout events of the email no admin has lifted yet
+func openLockoutFilter(email string) bson.M{
+	return bson.M{
+		"email":      email,
+		"unlockedAt": bson.M{"$exists": false},
+	}
+}
+
+type MongoLoginStore struct{
+	client   *mongo.Client
+	attempts *mongo.Collection // Failed login counters
+	events   *mongo.Collection // Lockout events
+	attemptIndex indexOnce
+	eventIndex   indexOnce
+}
+
+// NewMongoLoginStore creates a new MongoLoginStore using the collections in database dbname
+func NewMongoLoginStore(client *mongo.Client, dbname string) *MongoLoginStore{
+	return &MongoLoginStore{
+		client: client,
+		attempts: client.Database(dbname).Collection(loginAttemptColl),
+		events: client.Database(dbname).Collection(lockoutEventColl),
+	}
+}
+
+// ReserveLoginAttempt counts a login for the key as failed and returns the updated record
+// The login is only counted if the key still has the given number of failures, which
+// is checked and updated in a single operation: of parallel attempts that read the
+// same record only one is counted, the others get ErrLoginAttemptTaken. A key without
+// a record has 0 failures. lastFailure is set right away, so attempts arriving while
+// the password is compared wait out the backoff; a successful login clears the
+// record anyway. Expired records are removed by MongoDB through a TTL index
+func (s *MongoLoginStore) ReserveLoginAttempt(ctx context.Context, key string, failures int, ttl time.Duration) (*types.LoginAttempts,error){
+	if err := s.attemptIndex.ensure(ctx, s.attempts, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil{
+		return nil,err
+	}
+
+	now := time.Now().UTC()
+	// An expired record MongoDB has not removed yet must not count, start over
+	if _, err := s.attempts.DeleteOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}); err != nil{
+		return nil,err
+	}
+	var attempts types.LoginAttempts
+	// If another attempt changed the record first, nothing matches: the upsert for a
+	// key without failures collides with the existing record, otherwise nothing is found
+	err := s.attempts.FindOneAndUpdate(ctx,
+		bson.M{"_id": key, "failures": failures},
+		bson.M{
+			"$inc": bson.M{"failures": 1},
+			"$set": bson.M{"lastFailure": now, "expiresAt": now.Add(ttl)},
+		},
+		options.FindOneAndUpdate().SetUpsert(failures == 0).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil{
+		if mongo.IsDuplicateKeyError(err) || errors.Is(err, mongo.ErrNoDocuments){
+			return nil,ErrLoginAttemptTaken
+		}
+		return nil,err
+	}
+	return &attempts,nil
+}
+
+// CountLoginAttempt counts a login for the key as failed and returns the updated record
+// Unlike ReserveLoginAttempt it always counts, so parallel logins never get in each
+// other's way, and it leaves lastFailure alone: the login has not failed yet, see
+// RecordLoginFailure. A new record lives for ttl
+func (s *MongoLoginStore) CountLoginAttempt(ctx context.Context, key string, ttl time.Duration) (*types.LoginAttempts,error){
+	if err := s.attemptIndex.ensure(ctx, s.attempts, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil{
+		return nil,err
+	}
+
+	now := time.Now().UTC()
+	// An expired record MongoDB has not removed yet must not count, start over
+	if _, err := s.attempts.DeleteOne(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}); err != nil{
+		return nil,err
+	}
+	var attempts types.LoginAttempts
+	err := s.attempts.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$inc":         bson.M{"failures": 1},
+			"$setOnInsert": bson.M{"expiresAt": now.Add(ttl)},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil{
+		return nil,err
+	}
+	return &attempts,nil
+}
+
+// RecordLoginFailure marks the login of the key counted by CountLoginAttempt as
+// failed now, so the backoff starts, and keeps the record for ttl from now
+// If the record is gone in the meantime, e.g. because it expired, the failure starts a new one
+func (s *MongoLoginStore) RecordLoginFailure(ctx context.Context, key string, ttl time.Duration) (*types.LoginAttempts,error){
+	now := time.Now().UTC()
+	var attempts types.LoginAttempts
+	err := s.attempts.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		bson.M{
+			"$set":         bson.M{"lastFailure": now, "expiresAt": now.Add(ttl)},
+			"$setOnInsert": bson.M{"failures": 1},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempts)
+	if err != nil{
+		return nil,err
+	}
+	return &attempts,nil
+}
+
+// ReleaseLoginAttempt takes back a login of the key counted by ReserveLoginAttempt
+// or CountLoginAttempt that turned out not to have failed
+func (s *MongoLoginStore) ReleaseLoginAttempt(ctx context.Context, key string) error{
+	_, err := s.attempts.UpdateOne(ctx,
+		bson.M{"_id": key, "failures": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"failures": -1}},
+	)
+	return err
+}
+
+// GetLoginAttempts finds the unexpired record of the key
+func (s *MongoLoginStore) GetLoginAttempts(ctx context.Context, key string) (*types.LoginAttempts,error){
+	var attempts types.LoginAttempts
+	if err := s.attempts.FindOne(ctx, unexpiredFilter(key)).Decode(&attempts); err != nil{
+		return nil,err
+	}
+	return &attempts,nil
+}
+
+// ClearLoginAttempts forgets the failed logins of the key, lifting any lockout
+func (s *MongoLoginStore) ClearLoginAttempts(ctx context.Context, key string) error{
+	_, err := s.attempts.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
+
+// InsertLockoutEvent stores a new lockout event
+func (s *MongoLoginStore) InsertLockoutEvent(ctx context.Context, event *types.LockoutEvent) (*types.LockoutEvent,error){
+	if err := s.eventIndex.ensure(ctx, s.events,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "createdAt", Value: -1}},
+		},
+	); err != nil{
+		return nil,err
+	}
+
+	if event.ID.IsZero(){
+		event.ID = primitive.NewObjectID()
+	}
+	if _, err := s.events.InsertOne(ctx, event); err != nil{
+		return nil,err
+	}
+	return event,nil
+}
+
+// GetLockoutEvents retrieves the lockout events matching the filter, newest first
+func (s *MongoLoginStore) GetLockoutEvents(ctx context.Context, filter bson.M) ([]*types.LockoutEvent,error){
+	cur, err := s.events.Find(ctx, filter, lockoutEventOrder.toMongo())
+	if err != nil{
+		return nil,err
+	}
+	events := []*types.LockoutEvent{}
+	if err := cur.All(ctx, &events); err != nil{
+		return nil,err
+	}
+	return events,nil
+}
+
+// UnlockLockoutEvents marks the open lockout events of the email as lifted by the admin
+func (s *MongoLoginStore) UnlockLockoutEvents(ctx context.Context, email string, adminID primitive.ObjectID) error{
+	_, err := s.events.UpdateMany(ctx, openLockoutFilter(email), bson.M{
+		"$set": bson.M{"unlockedAt": time.Now().UTC(), "unlockedBy": adminID},
+	})
+	return err
+}
@@ -0,0 +1,279 @@
+package db
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errDuplicateID is returned when inserting a document whose _id is already taken
+var errDuplicateID = errors.New("duplicate _id")
+
+// Make sure the memory stores stay in sync with the store interfaces
+var (
+	_ UserStore    = (*MemoryUserStore)(nil)
+	_ HotelStore   = (*MemoryHotelStore)(nil)
+	_ RoomStore    = (*MemoryRoomStore)(nil)
+	_ BookingStore = (*MemoryBookingStore)(nil)
+	_ TokenStore   = (*MemoryTokenStore)(nil)
+	_ LoginStore   = (*MemoryLoginStore)(nil)
+	_ IdempotencyStore = (*MemoryIdempotencyStore)(nil)
+	_ PaymentStore     = (*MemoryPaymentStore)(nil)
+)
+
+// MemoryDatabase is an in-memory stand-in for the MongoDB database
+// Stores created from the same MemoryDatabase share their data, just like Mongo
+// stores sharing a client, so e.g. the room store can see the stored bookings
+type MemoryDatabase struct{
+	mu    sync.Mutex
+	colls map[string]*memoryCollection // Collections by name, created on first use
+}
+
+// NewMemoryDatabase creates a new, empty in-memory database
+func NewMemoryDatabase() *MemoryDatabase{
+	return &MemoryDatabase{
+		colls: map[string]*memoryCollection{},
+	}
+}
+
+// collection returns the collection with the given name, creating it if needed
+func (d *MemoryDatabase) collection(name string) *memoryCollection{
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	coll, ok := d.colls[name]
+	if !ok{
+		coll = &memoryCollection{}
+		d.colls[name] = coll
+	}
+	return coll
+}
+
+// memoryCollection holds the documents of one collection in insertion order
+// Documents are kept in their BSON form so that filters, updates and sorting
+// see the same values MongoDB would, e.g. times truncated to milliseconds
+type memoryCollection struct{
+	mu   sync.RWMutex
+	docs []bson.M
+}
+
+// insert adds a document to the collection and returns its _id
+// Like the Mongo driver, a new ObjectID is generated if the document has none
+func (c *memoryCollection) insert(v interface{}) (interface{}, error){
+	doc, err := toDocument(v)
+	if err != nil{
+		return nil, err
+	}
+	if id, ok := doc["_id"]; !ok || id == nil{
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, existing := range c.docs{
+		if equalValues(existing["_id"], doc["_id"]){
+			return nil, errDuplicateID
+		}
+	}
+	c.docs = append(c.docs, doc)
+	return doc["_id"], nil
+}
+
+// find returns the documents matching the filter, sorted and paginated by opts
+func (c *memoryCollection) find(filter bson.M, opts *FindOptions) ([]bson.M, error){
+	filter, err := normalizeFilter(filter)
+	if err != nil{
+		return nil, err
+	}
+
+	c.mu.RLock()
+	var found []bson.M
+	for _, doc := range c.docs{
+		ok, err := matchDocument(doc, filter)
+		if err != nil{
+			c.mu.RUnlock()
+			return nil, err
+		}
+		if ok{
+			found = append(found, doc)
+		}
+	}
+	c.mu.RUnlock()
+
+	if opts == nil{
+		return found, nil
+	}
+	if len(opts.Sort) > 0{
+		sort.SliceStable(found, func(i, j int) bool{
+			return compareDocuments(found[i], found[j], opts.Sort) < 0
+		})
+	}
+	if opts.Limit > 0{
+		skip := int64(0)
+		if opts.Page > 1{
+			skip = (opts.Page - 1) * opts.Limit
+		}
+		if skip >= int64(len(found)){
+			return nil, nil
+		}
+		found = found[skip:]
+		if opts.Limit < int64(len(found)){
+			found = found[:opts.Limit]
+		}
+	}
+	return found, nil
+}
+
+// findOne decodes the first document matching the filter into v
+// Returns mongo.ErrNoDocuments if there is none, just like the Mongo stores
+func (c *memoryCollection) findOne(filter bson.M, v interface{}) error{
+	docs, err := c.find(filter, nil)
+	if err != nil{
+		return err
+	}
+	if len(docs) == 0{
+		return mongo.ErrNoDocuments
+	}
+	return fromDocument(docs[0], v)
+}
+
+// count returns the number of documents matching the filter
+func (c *memoryCollection) count(filter bson.M) (int64, error){
+	docs, err := c.find(filter, nil)
+	if err != nil{
+		return 0, err
+	}
+	return int64(len(docs)), nil
+}
+
+// update applies the update to the first document matching the filter, or to
+// all of them if many is set, and returns the updated documents
+func (c *memoryCollection) update(filter, update bson.M, many bool) ([]bson.M, error){
+	filter, err := normalizeFilter(filter)
+	if err != nil{
+		return nil, err
+	}
+	update, err = normalizeFilter(update)
+	if err != nil{
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var updated []bson.M
+	for i, doc := range c.docs{
+		ok, err := matchDocument(doc, filter)
+		if err != nil{
+			return nil, err
+		}
+		if !ok{
+			continue
+		}
+		// Work on a copy so a failing update leaves the document untouched
+		changed := copyDocument(doc)
+		if err := applyUpdate(changed, update); err != nil{
+			return nil, err
+		}
+		c.docs[i] = changed
+		updated = append(updated, changed)
+		if !many{
+			break
+		}
+	}
+	return updated, nil
+}
+
+// delete removes the documents matching the filter and returns how many were removed
+func (c *memoryCollection) delete(filter bson.M) (int64, error){
+	filter, err := normalizeFilter(filter)
+	if err != nil{
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kept := c.docs[:0]
+	var deleted int64
+	for _, doc := range c.docs{
+		ok, err := matchDocument(doc, filter)
+		if err != nil{
+			return 0, err
+		}
+		if ok{
+			deleted++
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	c.docs = kept
+	return deleted, nil
+}
+
+// drop removes every document from the collection
+func (c *memoryCollection) drop(){
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.docs = nil
+}
+
+// toDocument converts a value into its BSON document form
+func toDocument(v interface{}) (bson.M, error){
+	data, err := bson.Marshal(v)
+	if err != nil{
+		return nil, err
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(data, &doc); err != nil{
+		return nil, err
+	}
+	return doc, nil
+}
+
+// fromDocument decodes a BSON document into v
+func fromDocument(doc bson.M, v interface{}) error{
+	data, err := bson.Marshal(doc)
+	if err != nil{
+		return err
+	}
+	return bson.Unmarshal(data, v)
+}
+
+// decodeDocuments decodes every document into a new value of type T
+func decodeDocuments[T any](docs []bson.M) ([]*T, error){
+	values := []*T{}
+	for _, doc := range docs{
+		v := new(T)
+		if err := fromDocument(doc, v); err != nil{
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// copyDocument returns a deep copy of a document
+func copyDocument(doc bson.M) bson.M{
+	cp := make(bson.M, len(doc))
+	for k, v := range doc{
+		cp[k] = copyValue(v)
+	}
+	return cp
+}
+
+// copyValue returns a deep copy of a value stored in a document
+func copyValue(v interface{}) interface{}{
+	switch v := v.(type){
+	case bson.M:
+		return copyDocument(v)
+	case primitive.A:
+		cp := make(primitive.A, len(v))
+		for i, e := range v{
+			cp[i] = copyValue(e)
+		}
+		return cp
+	}
+	return v
+}
@@ -0,0 +1,398 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryBookingStore implements the BookingStore interface in memory
+// It keeps the same reservation ledger as MongoBookingStore, guarded by a mutex
+// instead of a unique index
+type MemoryBookingStore struct{
+	mu     sync.Mutex        // Serializes changes to the ledger
+	coll   *memoryCollection // The bookings collection
+	nights *memoryCollection // Reservation ledger, one document per booked room night
+	rooms  *memoryCollection // The rooms collection, checked for rooms being deleted
+}
+
+// NewMemoryBookingStore creates a new MemoryBookingStore backed by the given in-memory database
+func NewMemoryBookingStore(database *MemoryDatabase) *MemoryBookingStore{
+	return &MemoryBookingStore{
+		coll:   database.collection(bookingColl),
+		nights: database.collection(roomNightColl),
+		rooms:  database.collection(roomColl),
+	}
+}
+
+// InsertBooking adds a booking without checking the ledger
+func (s *MemoryBookingStore) InsertBooking(ctx context.Context, booking *types.Booking) (*types.Booking, error){
+	id, err := s.coll.insert(booking)
+	if err != nil{
+		return nil, err
+	}
+	booking.ID = id.(primitive.ObjectID)
+	return booking, nil
+}
+
+// InsertBookingIfAvailable reserves every night of the booking and inserts it
+// Returns ErrRoomAlreadyBooked if any of the nights is already taken and
+// ErrRoomDeleted if the room is gone or being deleted
+func (s *MemoryBookingStore) InsertBookingIfAvailable(ctx context.Context, booking *types.Booking) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	nights := types.BookingNights(booking.FromDate, booking.TillDate)
+	if len(nights) == 0{
+		return nil, errors.New("booking does not cover any night")
+	}
+	if _, err := s.expireHolds(bson.M{"roomID": booking.RoomID}, time.Now()); err != nil{
+		return nil, err
+	}
+	for _, night := range nights{
+		taken, err := s.nights.count(bson.M{"roomID": booking.RoomID, "night": night})
+		if err != nil{
+			return nil, err
+		}
+		if taken > 0{
+			return nil, ErrRoomAlreadyBooked
+		}
+	}
+	if err := s.checkRoomBookable(booking.RoomID); err != nil{
+		return nil, err
+	}
+
+	if booking.ID.IsZero(){
+		booking.ID = primitive.NewObjectID()
+	}
+	if _, err := s.InsertBooking(ctx, booking); err != nil{
+		return nil, err
+	}
+	for _, night := range nights{
+		if _, err := s.nights.insert(roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID, ClaimedAt: time.Now()}); err != nil{
+			return nil, err
+		}
+	}
+	return booking, nil
+}
+
+// checkRoomBookable returns ErrRoomDeleted if the room is missing or being deleted
+// The caller must hold s.mu, so a deletion either sees the nights claimed
+// afterwards or has marked the room before this check
+func (s *MemoryBookingStore) checkRoomBookable(roomID primitive.ObjectID) error{
+	n, err := s.rooms.count(bookableRoomFilter(roomID))
+	if err != nil{
+		return err
+	}
+	if n == 0{
+		return ErrRoomDeleted
+	}
+	return nil
+}
+
+// HasUpcomingStays reports whether any booking still holds a night of the room from now on
+func (s *MemoryBookingStore) HasUpcomingStays(ctx context.Context, roomID primitive.ObjectID, now time.Time) (bool, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.expireHolds(bson.M{"roomID": roomID}, now); err != nil{
+		return false, err
+	}
+	today, _ := types.StayDays(now, now)
+	nights, err := s.nights.count(bson.M{"roomID": roomID, "night": bson.M{"$gte": today}})
+	if err != nil{
+		return false, err
+	}
+	if nights > 0{
+		return true, nil
+	}
+	bookings, err := s.coll.count(upcomingBookingsFilter(roomID, now))
+	if err != nil{
+		return false, err
+	}
+	return bookings > 0, nil
+}
+
+// ReleaseOrphanedNights removes the ledger entries claimed before the given time
+// whose booking is missing or has been released, and returns how many it removed
+func (s *MemoryBookingStore) ReleaseOrphanedNights(ctx context.Context, before time.Time) (int, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	docs, err := s.nights.find(claimedBeforeFilter(before), nil)
+	if err != nil{
+		return 0, err
+	}
+	checked := map[primitive.ObjectID]bool{}
+	removed := 0
+	for _, doc := range docs{
+		bookingID, ok := doc["bookingID"].(primitive.ObjectID)
+		if !ok || checked[bookingID]{
+			continue
+		}
+		checked[bookingID] = true
+		n, err := s.coll.count(holdingBookingFilter(bookingID))
+		if err != nil{
+			return removed, err
+		}
+		if n > 0{
+			continue
+		}
+		filter := claimedBeforeFilter(before)
+		filter["bookingID"] = bookingID
+		deleted, err := s.nights.delete(filter)
+		if err != nil{
+			return removed, err
+		}
+		removed += int(deleted)
+	}
+	return removed, nil
+}
+
+// GetBookings retrieves the bookings matching the filter
+func (s *MemoryBookingStore) GetBookings(ctx context.Context, filter bson.M) ([]*types.Booking, error){
+	docs, err := s.coll.find(filter, nil)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.Booking](docs)
+}
+
+// GetBookingByID retrieves a booking by its ID
+func (s *MemoryBookingStore) GetBookingByID(ctx context.Context, id primitive.ObjectID) (*types.Booking, error){
+	var booking types.Booking
+	if err := s.coll.findOne(bson.M{"_id": id}, &booking); err != nil{
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// CancelBooking marks the booking as cancelled, records what the cancellation
+// cost and releases its nights in the ledger
+// Returns ErrBookingNotCancellable if the booking is not in a cancellable state
+func (s *MemoryBookingStore) CancelBooking(ctx context.Context, id primitive.ObjectID, cancellation *types.Cancellation) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.coll.update(cancellableBookingFilter(id), cancelBookingUpdate(cancellation), false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		// Tell a missing booking apart from one in the wrong state
+		if _, err := s.GetBookingByID(ctx, id); err != nil{
+			return nil, err
+		}
+		return nil, ErrBookingNotCancellable
+	}
+	if _, err := s.nights.delete(bson.M{"bookingID": id}); err != nil{
+		return nil, err
+	}
+
+	var booking types.Booking
+	if err := fromDocument(updated[0], &booking); err != nil{
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// SettleCancellation records that the penalty of the cancelled booking has been
+// charged and the rest of its payments, refund in total, given back
+// Returns mongo.ErrNoDocuments if there is no such cancelled booking
+func (s *MemoryBookingStore) SettleCancellation(ctx context.Context, id primitive.ObjectID, refund float64) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.coll.update(cancelledBookingFilter(id), settleCancellationUpdate(refund), false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		return nil, mongo.ErrNoDocuments
+	}
+
+	var booking types.Booking
+	if err := fromDocument(updated[0], &booking); err != nil{
+		return nil, err
+	}
+	return &booking, nil
+}
+
+
+// ModifyBooking moves the booking to the room, dates, guests and price it now holds
+// change carries the terms before the modification and is added to the history.
+// Returns ErrRoomAlreadyBooked if another booking holds one of the new nights,
+// ErrRoomDeleted if their room is being deleted and ErrBookingNotModifiable if the booking can no longer be modified or no longer
+// has the terms recorded in change
+func (s *MemoryBookingStore) ModifyBooking(ctx context.Context, booking *types.Booking, change types.BookingChange) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	added, removed := nightChanges(booking, change)
+	if _, err := s.expireHolds(bson.M{"roomID": booking.RoomID}, time.Now()); err != nil{
+		return nil, err
+	}
+	for _, night := range added{
+		taken, err := s.nights.count(bson.M{"roomID": booking.RoomID, "night": night})
+		if err != nil{
+			return nil, err
+		}
+		if taken > 0{
+			return nil, ErrRoomAlreadyBooked
+		}
+	}
+	if len(added) > 0{
+		if err := s.checkRoomBookable(booking.RoomID); err != nil{
+			return nil, err
+		}
+	}
+
+	filter := modifiableBookingFilter(booking.ID)
+	filter["roomID"] = change.RoomID
+	filter["fromDate"] = change.FromDate
+	filter["tillDate"] = change.TillDate
+	filter["$and"] = []bson.M{sameStatusFilter(booking.Status)}
+	update := bson.M{
+		"$set": bson.M{
+			"roomID":     booking.RoomID,
+			"fromDate":   booking.FromDate,
+			"tillDate":   booking.TillDate,
+			"numPersons": booking.NumPerson,
+			"price":      booking.Price,
+		},
+		"$push": bson.M{"history": change},
+	}
+	updated, err := s.coll.update(filter, update, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		if _, err := s.GetBookingByID(ctx, booking.ID); err != nil{
+			return nil, err
+		}
+		return nil, ErrBookingNotModifiable
+	}
+
+	for _, night := range added{
+		if _, err := s.nights.insert(roomNight{RoomID: booking.RoomID, Night: night, BookingID: booking.ID, ClaimedAt: time.Now()}); err != nil{
+			return nil, err
+		}
+	}
+	if len(removed) > 0{
+		if _, err := s.nights.delete(bson.M{"bookingID": booking.ID, "roomID": change.RoomID, "night": bson.M{"$in": removed}}); err != nil{
+			return nil, err
+		}
+	}
+
+	var modified types.Booking
+	if err := fromDocument(updated[0], &modified); err != nil{
+		return nil, err
+	}
+	return &modified, nil
+}
+
+// ConfirmHold turns the held booking into a confirmed one
+// Returns ErrHoldExpired if the hold has run out and ErrBookingNotHeld if the
+// booking is not a hold
+func (s *MemoryBookingStore) ConfirmHold(ctx context.Context, id primitive.ObjectID) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	filter := bson.M{"_id": id, "status": types.BookingStatusHeld, "holdExpiresAt": bson.M{"$gt": time.Now()}}
+	update := bson.M{
+		"$set":   bson.M{"status": types.BookingStatusConfirmed},
+		"$unset": bson.M{"holdExpiresAt": ""},
+	}
+	updated, err := s.coll.update(filter, update, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		booking, err := s.GetBookingByID(ctx, id)
+		if err != nil{
+			return nil, err
+		}
+		return nil, confirmHoldError(booking)
+	}
+
+	var booking types.Booking
+	if err := fromDocument(updated[0], &booking); err != nil{
+		return nil, err
+	}
+	return &booking, nil
+}
+
+// ExpireHolds releases the nights of every hold that has run out by now
+func (s *MemoryBookingStore) ExpireHolds(ctx context.Context, now time.Time) (int, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expireHolds(bson.M{}, now)
+}
+
+// expireHolds expires the holds matching the filter that have run out by now
+// The caller must hold s.mu
+func (s *MemoryBookingStore) expireHolds(filter bson.M, now time.Time) (int, error){
+	updated, err := s.coll.update(expiredHoldsFilter(filter, now), expireHoldUpdate(), true)
+	if err != nil{
+		return 0, err
+	}
+	for _, doc := range updated{
+		if _, err := s.nights.delete(bson.M{"bookingID": doc["_id"]}); err != nil{
+			return 0, err
+		}
+	}
+	return len(updated), nil
+}
+
+// ConfirmPendingBooking confirms the booking once its payment has been authorized
+// Returns ErrBookingNotPending if the booking is not waiting for a payment
+func (s *MemoryBookingStore) ConfirmPendingBooking(ctx context.Context, id primitive.ObjectID) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.settlePendingBooking(ctx, id, types.BookingStatusConfirmed)
+}
+
+// FailPendingBooking marks the booking failed after its payment was declined
+// and releases its nights in the ledger
+// Returns ErrBookingNotPending if the booking is not waiting for a payment
+func (s *MemoryBookingStore) FailPendingBooking(ctx context.Context, id primitive.ObjectID) (*types.Booking, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	booking, err := s.settlePendingBooking(ctx, id, types.BookingStatusFailed)
+	if err != nil{
+		return nil, err
+	}
+	if _, err := s.nights.delete(bson.M{"bookingID": id}); err != nil{
+		return nil, err
+	}
+	return booking, nil
+}
+
+// settlePendingBooking moves the pending booking to the given status
+// The caller must hold s.mu
+func (s *MemoryBookingStore) settlePendingBooking(ctx context.Context, id primitive.ObjectID, status types.BookingStatus) (*types.Booking, error){
+	updated, err := s.coll.update(pendingBookingFilter(id), bson.M{"$set": bson.M{"status": status}}, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		// Tell a missing booking apart from one in the wrong state
+		if _, err := s.GetBookingByID(ctx, id); err != nil{
+			return nil, err
+		}
+		return nil, ErrBookingNotPending
+	}
+
+	var booking types.Booking
+	if err := fromDocument(updated[0], &booking); err != nil{
+		return nil, err
+	}
+	return &booking, nil
+}
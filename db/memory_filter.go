@@ -0,0 +1,447 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// This file evaluates the subset of the MongoDB query and update language that
+// the handlers and stores actually use, so the memory stores can take the same
+// bson.M filters as the Mongo stores. Anything outside that subset is reported
+// as an error instead of being silently ignored.
+
+// normalizeFilter converts a filter or update into plain BSON values
+// Custom types such as types.BookingStatus become strings and times become
+// primitive.DateTime, so they compare equal to the stored documents
+func normalizeFilter(filter bson.M) (bson.M, error){
+	if filter == nil{
+		return bson.M{}, nil
+	}
+	doc, err := toDocument(bson.M{"filter": filter})
+	if err != nil{
+		return nil, err
+	}
+	return doc["filter"].(bson.M), nil
+}
+
+// matchDocument reports whether the document matches every condition of the filter
+func matchDocument(doc, filter bson.M) (bool, error){
+	for key, cond := range filter{
+		var (
+			ok  bool
+			err error
+		)
+		switch key{
+		case "$and", "$or", "$nor":
+			ok, err = matchLogical(doc, key, cond)
+		default:
+			if strings.HasPrefix(key, "$"){
+				return false, fmt.Errorf("memory store: unsupported query operator %s", key)
+			}
+			value, found := lookupField(doc, key)
+			ok, err = matchCondition(value, found, cond)
+		}
+		if err != nil || !ok{
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchLogical evaluates $and, $or and $nor over a list of sub-filters
+func matchLogical(doc bson.M, op string, cond interface{}) (bool, error){
+	clauses, ok := cond.(primitive.A)
+	if !ok{
+		return false, fmt.Errorf("memory store: %s needs an array", op)
+	}
+	matched := 0
+	for _, clause := range clauses{
+		filter, ok := clause.(bson.M)
+		if !ok{
+			return false, fmt.Errorf("memory store: %s needs an array of documents", op)
+		}
+		ok, err := matchDocument(doc, filter)
+		if err != nil{
+			return false, err
+		}
+		if ok{
+			matched++
+		}
+	}
+	switch op{
+	case "$and":
+		return matched == len(clauses), nil
+	case "$or":
+		return matched > 0, nil
+	default:
+		return matched == 0, nil
+	}
+}
+
+// matchCondition reports whether a field value satisfies a condition
+// The condition is either a document of operators like {"$lt": x}, a regex
+// or a plain value the field must be equal to
+func matchCondition(value interface{}, found bool, cond interface{}) (bool, error){
+	ops, ok := cond.(bson.M)
+	if !ok || !isOperatorDocument(ops){
+		return matchEqual(value, found, cond), nil
+	}
+
+	for op, arg := range ops{
+		var ok bool
+		switch op{
+		case "$eq":
+			ok = matchEqual(value, found, arg)
+		case "$ne":
+			ok = !matchEqual(value, found, arg)
+		case "$gt", "$gte", "$lt", "$lte":
+			ok = matchComparison(value, found, op, arg)
+		case "$in", "$nin":
+			list, isList := arg.(primitive.A)
+			if !isList{
+				return false, fmt.Errorf("memory store: %s needs an array", op)
+			}
+			for _, e := range list{
+				if matchEqual(value, found, e){
+					ok = true
+					break
+				}
+			}
+			if op == "$nin"{
+				ok = !ok
+			}
+		case "$exists":
+			want, isBool := arg.(bool)
+			if !isBool{
+				return false, fmt.Errorf("memory store: $exists needs a boolean")
+			}
+			ok = found == want
+		case "$regex":
+			options, _ := ops["$options"].(string)
+			pattern, isString := arg.(string)
+			if !isString{
+				return false, fmt.Errorf("memory store: $regex needs a string")
+			}
+			ok = matchRegex(value, primitive.Regex{Pattern: pattern, Options: options})
+		case "$options":
+			// Read together with $regex
+			ok = true
+		default:
+			return false, fmt.Errorf("memory store: unsupported query operator %s", op)
+		}
+		if !ok{
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isOperatorDocument reports whether every key of the document is an operator
+func isOperatorDocument(doc bson.M) bool{
+	if len(doc) == 0{
+		return false
+	}
+	for key := range doc{
+		if !strings.HasPrefix(key, "$"){
+			return false
+		}
+	}
+	return true
+}
+
+// matchEqual reports whether the field equals want
+// Like MongoDB, an array field matches if any of its elements does, a regex
+// matches strings and a null value matches missing fields
+func matchEqual(value interface{}, found bool, want interface{}) bool{
+	if !found{
+		return want == nil
+	}
+	if re, ok := want.(primitive.Regex); ok{
+		return matchRegex(value, re)
+	}
+	if equalValues(value, want){
+		return true
+	}
+	if arr, ok := value.(primitive.A); ok{
+		for _, e := range arr{
+			if equalValues(e, want){
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchComparison evaluates $gt, $gte, $lt and $lte
+// Values of different kinds never match, e.g. a date is neither before nor after a string
+func matchComparison(value interface{}, found bool, op string, arg interface{}) bool{
+	if !found{
+		return false
+	}
+	cmp, ok := compareValues(value, arg)
+	if !ok{
+		return false
+	}
+	switch op{
+	case "$gt":
+		return cmp > 0
+	case "$gte":
+		return cmp >= 0
+	case "$lt":
+		return cmp < 0
+	default:
+		return cmp <= 0
+	}
+}
+
+// matchRegex reports whether the value is a string matching the regular expression
+func matchRegex(value interface{}, re primitive.Regex) bool{
+	s, ok := value.(string)
+	if !ok{
+		return false
+	}
+	flags := ""
+	for _, o := range re.Options{
+		if strings.ContainsRune("ims", o){
+			flags += string(o)
+		}
+	}
+	pattern := re.Pattern
+	if flags != ""{
+		pattern = "(?" + flags + ")" + pattern
+	}
+	compiled, err := regexp.Compile(pattern)
+	if err != nil{
+		return false
+	}
+	return compiled.MatchString(s)
+}
+
+// equalValues reports whether two BSON values are equal
+// Numbers are compared by value, so int32(1) equals int64(1) and 1.0
+func equalValues(a, b interface{}) bool{
+	if cmp, ok := compareValues(a, b); ok{
+		return cmp == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compareValues orders two BSON values of the same kind
+// The second return value is false if the values cannot be compared
+func compareValues(a, b interface{}) (int, bool){
+	if af, ok := toFloat(a); ok{
+		bf, ok := toFloat(b)
+		if !ok{
+			return 0, false
+		}
+		return compareOrdered(af, bf), true
+	}
+	switch a := a.(type){
+	case string:
+		if b, ok := b.(string); ok{
+			return strings.Compare(a, b), true
+		}
+	case primitive.DateTime:
+		if b, ok := b.(primitive.DateTime); ok{
+			return compareOrdered(a, b), true
+		}
+	case primitive.ObjectID:
+		if b, ok := b.(primitive.ObjectID); ok{
+			return bytes.Compare(a[:], b[:]), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok{
+			switch{
+			case a == b:
+				return 0, true
+			case !a:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case nil:
+		if b == nil{
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// toFloat converts any BSON number to a float64
+func toFloat(v interface{}) (float64, bool){
+	switch v := v.(type){
+	case int: // Not a BSON type, but used in sort keys built in Go code
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// compareOrdered returns -1, 0 or 1 depending on how a and b are ordered
+func compareOrdered[T int64 | float64 | primitive.DateTime](a, b T) int{
+	switch{
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareDocuments orders two documents by the given sort keys
+// Missing fields sort before every value, as they do in MongoDB
+func compareDocuments(a, b bson.M, sortKeys bson.D) int{
+	for _, key := range sortKeys{
+		direction := 1
+		if d, ok := toFloat(key.Value); ok && d < 0{
+			direction = -1
+		}
+		av, aFound := lookupField(a, key.Key)
+		bv, bFound := lookupField(b, key.Key)
+		var cmp int
+		switch{
+		case !aFound && !bFound:
+			cmp = 0
+		case !aFound:
+			cmp = -1
+		case !bFound:
+			cmp = 1
+		default:
+			cmp, _ = compareValues(av, bv)
+		}
+		if cmp != 0{
+			return cmp * direction
+		}
+	}
+	return 0
+}
+
+// lookupField returns the value of a possibly dotted field path like "price.total"
+func lookupField(doc bson.M, path string) (interface{}, bool){
+	var current interface{} = doc
+	for _, part := range strings.Split(path, "."){
+		sub, ok := current.(bson.M)
+		if !ok{
+			return nil, false
+		}
+		current, ok = sub[part]
+		if !ok{
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// setField sets a possibly dotted field path, creating missing sub-documents
+func setField(doc bson.M, path string, value interface{}) error{
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1]{
+		sub, ok := doc[part]
+		if !ok{
+			sub = bson.M{}
+			doc[part] = sub
+		}
+		doc, ok = sub.(bson.M)
+		if !ok{
+			return fmt.Errorf("memory store: cannot set %s, %s is not a document", path, part)
+		}
+	}
+	doc[parts[len(parts)-1]] = value
+	return nil
+}
+
+// unsetField removes a possibly dotted field path
+func unsetField(doc bson.M, path string){
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1]{
+		sub, ok := doc[part].(bson.M)
+		if !ok{
+			return
+		}
+		doc = sub
+	}
+	delete(doc, parts[len(parts)-1])
+}
+
+// applyUpdate applies the update operators $set, $unset, $inc, $push and $pull to the document
+func applyUpdate(doc, update bson.M) error{
+	if !isOperatorDocument(update){
+		return fmt.Errorf("memory store: update must only contain operators")
+	}
+	for op, arg := range update{
+		fields, ok := arg.(bson.M)
+		if !ok{
+			return fmt.Errorf("memory store: %s needs a document", op)
+		}
+		for path, value := range fields{
+			if path == "_id" && op != "$set"{
+				return fmt.Errorf("memory store: cannot change _id")
+			}
+			current, found := lookupField(doc, path)
+			switch op{
+			case "$set":
+				if path == "_id" && !equalValues(current, value){
+					return fmt.Errorf("memory store: cannot change _id")
+				}
+				if err := setField(doc, path, value); err != nil{
+					return err
+				}
+			case "$unset":
+				unsetField(doc, path)
+			case "$inc":
+				by, ok := toFloat(value)
+				if !ok{
+					return fmt.Errorf("memory store: $inc needs a number")
+				}
+				n, _ := toFloat(current)
+				var sum interface{} = n + by
+				// Keep integers integers, like MongoDB does
+				if _, isFloat := value.(float64); !isFloat{
+					if _, isFloat := current.(float64); !isFloat{
+						sum = int64(n + by)
+					}
+				}
+				if err := setField(doc, path, sum); err != nil{
+					return err
+				}
+			case "$push":
+				arr, ok := current.(primitive.A)
+				if found && current != nil && !ok{
+					return fmt.Errorf("memory store: cannot $push to %s, it is not an array", path)
+				}
+				if err := setField(doc, path, append(append(primitive.A{}, arr...), value)); err != nil{
+					return err
+				}
+			case "$pull":
+				arr, ok := current.(primitive.A)
+				if !ok{
+					continue
+				}
+				kept := primitive.A{}
+				for _, e := range arr{
+					if !equalValues(e, value){
+						kept = append(kept, e)
+					}
+				}
+				if err := setField(doc, path, kept); err != nil{
+					return err
+				}
+			default:
+				return fmt.Errorf("memory store: unsupported update operator %s", op)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryHotelStore implements the HotelStore interface in memory
+type MemoryHotelStore struct{
+	coll *memoryCollection // The hotels collection
+}
+
+// NewMemoryHotelStore creates a new MemoryHotelStore backed by the given in-memory database
+func NewMemoryHotelStore(database *MemoryDatabase) *MemoryHotelStore{
+	return &MemoryHotelStore{
+		coll: database.collection(hotelColl),
+	}
+}
+
+// Insert adds a new hotel and returns it with its generated ID
+func (s *MemoryHotelStore) Insert(ctx context.Context, hotel *types.Hotel) (*types.Hotel, error){
+	id, err := s.coll.insert(hotel)
+	if err != nil{
+		return nil, err
+	}
+	hotel.ID = id.(primitive.ObjectID)
+	return hotel, nil
+}
+
+// Update applies the update document to the first hotel matching the filter
+// Returns mongo.ErrNoDocuments if no hotel matches the filter
+func (s *MemoryHotelStore) Update(ctx context.Context, filter, update bson.M) error{
+	updated, err := s.coll.update(filter, update, false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// GetHotels retrieves the hotels matching the filter
+func (s *MemoryHotelStore) GetHotels(ctx context.Context, filter bson.M) ([]*types.Hotel, error){
+	return s.SearchHotels(ctx, filter, nil)
+}
+
+// GetHotelByID retrieves a hotel by its ID
+func (s *MemoryHotelStore) GetHotelByID(ctx context.Context, id primitive.ObjectID) (*types.Hotel, error){
+	var hotel types.Hotel
+	if err := s.coll.findOne(bson.M{"_id": id}, &hotel); err != nil{
+		return nil, err
+	}
+	return &hotel, nil
+}
+
+// SearchHotels retrieves one sorted page of the hotels matching the filter
+func (s *MemoryHotelStore) SearchHotels(ctx context.Context, filter bson.M, opts *FindOptions) ([]*types.Hotel, error){
+	docs, err := s.coll.find(filter, opts)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.Hotel](docs)
+}
+
+// CountHotels returns the number of hotels matching the filter
+func (s *MemoryHotelStore) CountHotels(ctx context.Context, filter bson.M) (int64, error){
+	return s.coll.count(filter)
+}
+
+// DeleteHotel removes the hotel with the given ID if it has no rooms
+// Returns ErrHotelHasRooms if it still has rooms and mongo.ErrNoDocuments if
+// there is no such hotel
+func (s *MemoryHotelStore) DeleteHotel(ctx context.Context, id primitive.ObjectID) error{
+	n, err := s.coll.delete(emptyHotelFilter(id))
+	if err != nil{
+		return err
+	}
+	if n == 0{
+		if _, err := s.GetHotelByID(ctx, id); err != nil{
+			return err
+		}
+		return ErrHotelHasRooms
+	}
+	return nil
+}
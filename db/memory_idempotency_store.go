@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// MemoryIdempotencyStore implements the IdempotencyStore interface in memory
+type MemoryIdempotencyStore struct{
+	mu   sync.Mutex        // Serializes claims so only one request gets a key
+	coll *memoryCollection // Idempotency records
+}
+
+// NewMemoryIdempotencyStore creates a new MemoryIdempotencyStore backed by the given in-memory database
+func NewMemoryIdempotencyStore(database *MemoryDatabase) *MemoryIdempotencyStore{
+	return &MemoryIdempotencyStore{
+		coll: database.collection(idempotencyColl),
+	}
+}
+
+// ClaimIdempotencyKey stores the record of a request that is about to be processed
+// It returns nil if the key was free, otherwise the record holding it. Records
+// that expired or whose request did not complete within its lease are replaced
+func (s *MemoryIdempotencyStore) ClaimIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord) (*types.IdempotencyRecord, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Nothing expires by itself in memory, so expired and abandoned records are removed here
+	if _, err := s.coll.delete(reclaimableFilter(record.ID)); err != nil{
+		return nil, err
+	}
+	_, err := s.coll.insert(record)
+	if err == nil{
+		return nil, nil
+	}
+	if !errors.Is(err, errDuplicateID){
+		return nil, err
+	}
+
+	var existing types.IdempotencyRecord
+	if err := s.coll.findOne(bson.M{"_id": record.ID}, &existing); err != nil{
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// RenewIdempotencyKey extends the lease of a request that is still being processed
+// Returns ErrIdempotencyClaimLost if the lease ran out and a retry claimed the key
+func (s *MemoryIdempotencyStore) RenewIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord, lease time.Duration) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.coll.update(runningClaimFilter(record), renewIdempotencyUpdate(lease), false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// CompleteIdempotencyKey stores the response the request got
+// Returns ErrIdempotencyClaimLost if the lease ran out and a retry claimed the key
+func (s *MemoryIdempotencyStore) CompleteIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord, statusCode int, contentType string, body []byte) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated, err := s.coll.update(claimFilter(record), completeIdempotencyUpdate(statusCode, contentType, body), false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		return ErrIdempotencyClaimLost
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the record, so the key can be used again
+// A record that a retry has claimed since is left alone
+func (s *MemoryIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, record *types.IdempotencyRecord) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.coll.delete(claimFilter(record))
+	return err
+}
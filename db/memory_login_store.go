@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryLoginStore implements the LoginStore interface in memory
+type MemoryLoginStore struct{
+	mu       sync.Mutex        // Serializes reservations so the check and the upsert of a counter are atomic
+	attempts *memoryCollection // Failed login counters
+	events   *memoryCollection // Lockout events
+}
+
+// NewMemoryLoginStore creates a new MemoryLoginStore backed by the given in-memory database
+func NewMemoryLoginStore(database *MemoryDatabase) *MemoryLoginStore{
+	return &MemoryLoginStore{
+		attempts: database.collection(loginAttemptColl),
+		events:   database.collection(lockoutEventColl),
+	}
+}
+
+// ReserveLoginAttempt counts a login for the key as failed if it still has the given
+// number of failures and returns the updated record, ErrLoginAttemptTaken otherwise
+func (s *MemoryLoginStore) ReserveLoginAttempt(ctx context.Context, key string, failures int, ttl time.Duration) (*types.LoginAttempts, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	// Nothing expires by itself in memory, so expired records start over here
+	if _, err := s.attempts.delete(bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}); err != nil{
+		return nil, err
+	}
+	current, err := s.attempts.count(bson.M{"_id": key})
+	if err != nil{
+		return nil, err
+	}
+	if current == 0 && failures != 0{
+		return nil, ErrLoginAttemptTaken
+	}
+	updated, err := s.attempts.update(bson.M{"_id": key, "failures": failures}, bson.M{
+		"$inc": bson.M{"failures": 1},
+		"$set": bson.M{"lastFailure": now, "expiresAt": now.Add(ttl)},
+	}, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		if current > 0{
+			return nil, ErrLoginAttemptTaken
+		}
+		attempts := &types.LoginAttempts{ID: key, Failures: 1, LastFailure: now, ExpiresAt: now.Add(ttl)}
+		if _, err := s.attempts.insert(attempts); err != nil{
+			return nil, err
+		}
+		return attempts, nil
+	}
+	var attempts types.LoginAttempts
+	if err := fromDocument(updated[0], &attempts); err != nil{
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// CountLoginAttempt counts a login for the key as failed without any condition and
+// returns the updated record. lastFailure is left alone, a new record lives for ttl
+func (s *MemoryLoginStore) CountLoginAttempt(ctx context.Context, key string, ttl time.Duration) (*types.LoginAttempts, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	// Nothing expires by itself in memory, so expired records start over here
+	if _, err := s.attempts.delete(bson.M{"_id": key, "expiresAt": bson.M{"$lte": now}}); err != nil{
+		return nil, err
+	}
+	updated, err := s.attempts.update(bson.M{"_id": key}, bson.M{"$inc": bson.M{"failures": 1}}, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		attempts := &types.LoginAttempts{ID: key, Failures: 1, ExpiresAt: now.Add(ttl)}
+		if _, err := s.attempts.insert(attempts); err != nil{
+			return nil, err
+		}
+		return attempts, nil
+	}
+	var attempts types.LoginAttempts
+	if err := fromDocument(updated[0], &attempts); err != nil{
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// RecordLoginFailure marks the login of the key counted by CountLoginAttempt as failed
+// now and keeps the record for ttl from now, starting a new one if it is gone
+func (s *MemoryLoginStore) RecordLoginFailure(ctx context.Context, key string, ttl time.Duration) (*types.LoginAttempts, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	updated, err := s.attempts.update(bson.M{"_id": key}, bson.M{
+		"$set": bson.M{"lastFailure": now, "expiresAt": now.Add(ttl)},
+	}, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		attempts := &types.LoginAttempts{ID: key, Failures: 1, LastFailure: now, ExpiresAt: now.Add(ttl)}
+		if _, err := s.attempts.insert(attempts); err != nil{
+			return nil, err
+		}
+		return attempts, nil
+	}
+	var attempts types.LoginAttempts
+	if err := fromDocument(updated[0], &attempts); err != nil{
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// ReleaseLoginAttempt takes back a login of the key counted by ReserveLoginAttempt or CountLoginAttempt
+func (s *MemoryLoginStore) ReleaseLoginAttempt(ctx context.Context, key string) error{
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.attempts.update(bson.M{"_id": key, "failures": bson.M{"$gt": 0}}, bson.M{"$inc": bson.M{"failures": -1}}, false)
+	return err
+}
+
+// GetLoginAttempts finds the unexpired record of the key
+func (s *MemoryLoginStore) GetLoginAttempts(ctx context.Context, key string) (*types.LoginAttempts, error){
+	var attempts types.LoginAttempts
+	if err := s.attempts.findOne(unexpiredFilter(key), &attempts); err != nil{
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// ClearLoginAttempts forgets the failed logins of the key
+func (s *MemoryLoginStore) ClearLoginAttempts(ctx context.Context, key string) error{
+	_, err := s.attempts.delete(bson.M{"_id": key})
+	return err
+}
+
+// InsertLockoutEvent stores a new lockout event
+func (s *MemoryLoginStore) InsertLockoutEvent(ctx context.Context, event *types.LockoutEvent) (*types.LockoutEvent, error){
+	if event.ID.IsZero(){
+		event.ID = primitive.NewObjectID()
+	}
+	if _, err := s.events.insert(event); err != nil{
+		return nil, err
+	}
+	return event, nil
+}
+
+// GetLockoutEvents retrieves the lockout events matching the filter, newest first
+func (s *MemoryLoginStore) GetLockoutEvents(ctx context.Context, filter bson.M) ([]*types.LockoutEvent, error){
+	docs, err := s.events.find(filter, lockoutEventOrder)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.LockoutEvent](docs)
+}
+
+// UnlockLockoutEvents marks the open lockout events of the email as lifted by the admin
+func (s *MemoryLoginStore) UnlockLockoutEvents(ctx context.Context, email string, adminID primitive.ObjectID) error{
+	_, err := s.events.update(openLockoutFilter(email), bson.M{
+		"$set": bson.M{"unlockedAt": time.Now().UTC(), "unlockedBy": adminID},
+	}, true)
+	return err
+}
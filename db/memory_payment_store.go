@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryPaymentStore implements the PaymentStore interface in memory
+type MemoryPaymentStore struct{
+	coll *memoryCollection
+}
+
+// NewMemoryPaymentStore creates a new MemoryPaymentStore backed by the given in-memory database
+func NewMemoryPaymentStore(database *MemoryDatabase) *MemoryPaymentStore{
+	return &MemoryPaymentStore{
+		coll: database.collection(paymentColl),
+	}
+}
+
+// InsertPayment stores a new payment
+func (s *MemoryPaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) (*types.Payment, error){
+	if payment.ID.IsZero(){
+		payment.ID = primitive.NewObjectID()
+	}
+	if _, err := s.coll.insert(payment); err != nil{
+		return nil, err
+	}
+	return payment, nil
+}
+
+// GetPayments retrieves the payments matching the filter, oldest first
+func (s *MemoryPaymentStore) GetPayments(ctx context.Context, filter bson.M) ([]*types.Payment, error){
+	docs, err := s.coll.find(filter, paymentOrder)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.Payment](docs)
+}
+
+// GetPaymentByProviderRef finds the payment with the given reference at the gateway
+func (s *MemoryPaymentStore) GetPaymentByProviderRef(ctx context.Context, provider, ref string) (*types.Payment, error){
+	var payment types.Payment
+	if err := s.coll.findOne(bson.M{"provider": provider, "providerRef": ref}, &payment); err != nil{
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// UpdatePaymentStatus moves the payment from status from to status to
+// Returns ErrPaymentStatusChanged if the payment no longer has status from
+func (s *MemoryPaymentStore) UpdatePaymentStatus(ctx context.Context, id primitive.ObjectID, from, to types.PaymentStatus, failureReason string) (*types.Payment, error){
+	return s.updatePayment(id, from, paymentStatusUpdate(to, failureReason))
+}
+
+// CapturePayment records that amount of the payment was charged and marks it captured
+// Returns ErrPaymentStatusChanged if the payment no longer has status from
+func (s *MemoryPaymentStore) CapturePayment(ctx context.Context, id primitive.ObjectID, from types.PaymentStatus, amount float64) (*types.Payment, error){
+	return s.updatePayment(id, from, paymentCaptureUpdate(amount))
+}
+
+// updatePayment applies the update to the payment if it still has status from
+func (s *MemoryPaymentStore) updatePayment(id primitive.ObjectID, from types.PaymentStatus, update bson.M) (*types.Payment, error){
+	updated, err := s.coll.update(bson.M{"_id": id, "status": from}, update, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		// Tell a missing payment apart from one in another state
+		var payment types.Payment
+		if err := s.coll.findOne(bson.M{"_id": id}, &payment); err != nil{
+			return nil, err
+		}
+		return nil, ErrPaymentStatusChanged
+	}
+
+	var payment types.Payment
+	if err := fromDocument(updated[0], &payment); err != nil{
+		return nil, err
+	}
+	return &payment, nil
+}
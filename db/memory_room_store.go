@@ -0,0 +1,129 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryRoomStore implements the RoomStore interface in memory
+type MemoryRoomStore struct{
+	coll     *memoryCollection // The rooms collection
+	bookings *memoryCollection // The bookings collection, checked for conflicts by GetAvailableRooms
+	HotelStore                 // Embedded HotelStore for hotel operations
+}
+
+// NewMemoryRoomStore creates a new MemoryRoomStore backed by the given in-memory database
+// Like NewMongoRoomStore it requires a HotelStore to keep the hotels' room lists up to date
+func NewMemoryRoomStore(database *MemoryDatabase, hotelStore HotelStore) *MemoryRoomStore{
+	return &MemoryRoomStore{
+		coll:       database.collection(roomColl),
+		bookings:   database.collection(bookingColl),
+		HotelStore: hotelStore,
+	}
+}
+
+// InsertRoom adds a new room and adds its ID to the hotel's rooms
+// If the hotel is gone the room is removed again and mongo.ErrNoDocuments is returned
+func (s *MemoryRoomStore) InsertRoom(ctx context.Context, room *types.Room) (*types.Room, error){
+	id, err := s.coll.insert(room)
+	if err != nil{
+		return nil, err
+	}
+	room.ID = id.(primitive.ObjectID)
+
+	filter := bson.M{"_id": room.HotelID}
+	update := bson.M{"$push": bson.M{"rooms": room.ID}}
+	if err := s.HotelStore.Update(ctx, filter, update); err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			s.coll.delete(bson.M{"_id": room.ID})
+		}
+		return nil, err
+	}
+	return room, nil
+}
+
+// GetRooms retrieves the rooms matching the filter
+func (s *MemoryRoomStore) GetRooms(ctx context.Context, filter bson.M) ([]*types.Room, error){
+	docs, err := s.coll.find(filter, nil)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.Room](docs)
+}
+
+// GetRoomByID retrieves a room by its ID
+func (s *MemoryRoomStore) GetRoomByID(ctx context.Context, id primitive.ObjectID) (*types.Room, error){
+	var room types.Room
+	if err := s.coll.findOne(bson.M{"_id": id}, &room); err != nil{
+		return nil, err
+	}
+	return &room, nil
+}
+
+// GetAvailableRooms retrieves the rooms matching roomFilter that have no booking matching bookingFilter
+func (s *MemoryRoomStore) GetAvailableRooms(ctx context.Context, roomFilter, bookingFilter bson.M) ([]*types.Room, error){
+	rooms, err := s.GetRooms(ctx, roomFilter)
+	if err != nil{
+		return nil, err
+	}
+	available := []*types.Room{}
+	for _, room := range rooms{
+		// Restrict the booking filter to this room, keeping every condition of the caller
+		conflictFilter := bson.M{"$and": []bson.M{{"roomID": room.ID}, bookingFilter}}
+		if bookingFilter == nil{
+			conflictFilter = bson.M{"roomID": room.ID}
+		}
+		conflicts, err := s.bookings.count(conflictFilter)
+		if err != nil{
+			return nil, err
+		}
+		if conflicts == 0{
+			available = append(available, room)
+		}
+	}
+	return available, nil
+}
+
+// UpdateRoom sets the given fields of the room
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MemoryRoomStore) UpdateRoom(ctx context.Context, id primitive.ObjectID, update bson.M) error{
+	updated, err := s.coll.update(bson.M{"_id": id}, bson.M{"$set": update}, false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteRoom removes a room and removes its ID from the hotel's rooms
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MemoryRoomStore) DeleteRoom(ctx context.Context, id primitive.ObjectID) error{
+	room, err := s.GetRoomByID(ctx, id)
+	if err != nil{
+		return err
+	}
+	if _, err := s.coll.delete(bson.M{"_id": id}); err != nil{
+		return err
+	}
+	return pullRoom(ctx, s.HotelStore, room)
+}
+
+// SetRoomDeleting sets or clears the mark that the room is being deleted
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MemoryRoomStore) SetRoomDeleting(ctx context.Context, id primitive.ObjectID, deleting bool) error{
+	updated, err := s.coll.update(bson.M{"_id": id}, roomDeletingUpdate(deleting), false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
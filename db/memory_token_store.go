@@ -0,0 +1,153 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryTokenStore implements the TokenStore interface in memory
+// Unlike MongoTokenStore nothing expires by itself, which makes no difference
+// to callers since expired tokens are rejected anyway
+type MemoryTokenStore struct{
+	coll       *memoryCollection // Refresh tokens
+	revoked    *memoryCollection // Revoked access tokens
+	userTokens *memoryCollection // Single-use user tokens
+}
+
+// NewMemoryTokenStore creates a new MemoryTokenStore backed by the given in-memory database
+func NewMemoryTokenStore(database *MemoryDatabase) *MemoryTokenStore{
+	return &MemoryTokenStore{
+		coll:       database.collection(refreshTokenColl),
+		revoked:    database.collection(revokedTokenColl),
+		userTokens: database.collection(userTokenColl),
+	}
+}
+
+// InsertRefreshToken stores a new refresh token
+func (s *MemoryTokenStore) InsertRefreshToken(ctx context.Context, token *types.RefreshToken) (*types.RefreshToken, error){
+	if token.ID.IsZero(){
+		token.ID = primitive.NewObjectID()
+	}
+	if _, err := s.coll.insert(token); err != nil{
+		return nil, err
+	}
+	return token, nil
+}
+
+// GetRefreshToken finds a refresh token by its hash
+func (s *MemoryTokenStore) GetRefreshToken(ctx context.Context, tokenHash string) (*types.RefreshToken, error){
+	var token types.RefreshToken
+	if err := s.coll.findOne(bson.M{"tokenHash": tokenHash}, &token); err != nil{
+		return nil, err
+	}
+	return &token, nil
+}
+
+// UseRefreshToken marks the refresh token as used
+// Returns ErrRefreshTokenReused if it was already used or revoked
+func (s *MemoryTokenStore) UseRefreshToken(ctx context.Context, id primitive.ObjectID) error{
+	updated, err := s.coll.update(usableRefreshTokenFilter(id), bson.M{"$set": bson.M{"used": true}}, false)
+	if err != nil{
+		return err
+	}
+	if len(updated) == 0{
+		var token types.RefreshToken
+		if err := s.coll.findOne(bson.M{"_id": id}, &token); err != nil{
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// RevokeTokenFamily revokes every refresh token of the family together with
+// the access tokens that were issued alongside them
+func (s *MemoryTokenStore) RevokeTokenFamily(ctx context.Context, familyID primitive.ObjectID) error{
+	return s.revokeRefreshTokens(ctx, bson.M{"familyID": familyID})
+}
+
+// RevokeUserSessions revokes every refresh token of the user together with
+// the access tokens that were issued alongside them
+func (s *MemoryTokenStore) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID) error{
+	return s.revokeRefreshTokens(ctx, bson.M{"userID": userID})
+}
+
+// revokeRefreshTokens revokes the refresh tokens matching the filter and their access tokens
+func (s *MemoryTokenStore) revokeRefreshTokens(ctx context.Context, filter bson.M) error{
+	updated, err := s.coll.update(filter, bson.M{"$set": bson.M{"revoked": true}}, true)
+	if err != nil{
+		return err
+	}
+	tokens, err := decodeDocuments[types.RefreshToken](updated)
+	if err != nil{
+		return err
+	}
+	for _, token := range tokens{
+		if token.AccessTokenID == ""{
+			continue
+		}
+		if err := s.RevokeAccessToken(ctx, token.AccessTokenID, token.AccessExpiresAt); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAccessToken adds the access token to the revocation list
+func (s *MemoryTokenStore) RevokeAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error{
+	_, err := s.revoked.insert(types.RevokedToken{ID: tokenID, ExpiresAt: expiresAt})
+	// Revoking the same token twice is not an error
+	if errors.Is(err, errDuplicateID){
+		return nil
+	}
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token has been revoked
+func (s *MemoryTokenStore) IsAccessTokenRevoked(ctx context.Context, tokenID string) (bool, error){
+	n, err := s.revoked.count(bson.M{"_id": tokenID})
+	if err != nil{
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// InsertUserToken stores a new single-use user token
+func (s *MemoryTokenStore) InsertUserToken(ctx context.Context, token *types.UserToken) (*types.UserToken, error){
+	if token.ID.IsZero(){
+		token.ID = primitive.NewObjectID()
+	}
+	if _, err := s.userTokens.insert(token); err != nil{
+		return nil, err
+	}
+	return token, nil
+}
+
+// UseUserToken marks the token with the given hash used and returns it
+// Returns mongo.ErrNoDocuments if the token is unknown, used or expired
+func (s *MemoryTokenStore) UseUserToken(ctx context.Context, purpose types.TokenPurpose, tokenHash string) (*types.UserToken, error){
+	updated, err := s.userTokens.update(usableUserTokenFilter(purpose, tokenHash), bson.M{"$set": bson.M{"used": true}}, false)
+	if err != nil{
+		return nil, err
+	}
+	if len(updated) == 0{
+		return nil, mongo.ErrNoDocuments
+	}
+	var token types.UserToken
+	if err := fromDocument(updated[0], &token); err != nil{
+		return nil, err
+	}
+	return &token, nil
+}
+
+// InvalidateUserTokens marks every unused token of the user for the purpose used
+func (s *MemoryTokenStore) InvalidateUserTokens(ctx context.Context, userID primitive.ObjectID, purpose types.TokenPurpose) error{
+	_, err := s.userTokens.update(bson.M{"userID": userID, "purpose": purpose, "used": false}, bson.M{"$set": bson.M{"used": true}}, true)
+	return err
+}
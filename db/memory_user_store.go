@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MemoryUserStore implements the UserStore interface in memory
+// It behaves like MongoUserStore, including the case-insensitive unique email index
+type MemoryUserStore struct{
+	mu   sync.Mutex        // Serializes inserts so the email check and the insert are atomic
+	coll *memoryCollection // The users collection
+}
+
+// NewMemoryUserStore creates a new MemoryUserStore backed by the given in-memory database
+func NewMemoryUserStore(database *MemoryDatabase) *MemoryUserStore{
+	return &MemoryUserStore{
+		coll: database.collection(usesrColl),
+	}
+}
+
+// GetUserById retrieves a user by their ID
+func (s *MemoryUserStore) GetUserById(ctx context.Context, id string) (*types.User, error){
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil{
+		return nil, err
+	}
+	var user types.User
+	if err := s.coll.findOne(bson.M{"_id": oid}, &user); err != nil{
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUsers retrieves all users
+func (s *MemoryUserStore) GetUsers(ctx context.Context) ([]*types.User, error){
+	docs, err := s.coll.find(bson.M{}, nil)
+	if err != nil{
+		return nil, err
+	}
+	return decodeDocuments[types.User](docs)
+}
+
+// InsertUser adds a new user
+// Returns ErrDuplicateEmail if another user already has the same email (ignoring case)
+func (s *MemoryUserStore) InsertUser(ctx context.Context, user *types.User) (*types.User, error){
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users, err := s.GetUsers(ctx)
+	if err != nil{
+		return nil, err
+	}
+	for _, other := range users{
+		if strings.EqualFold(other.Email, user.Email){
+			return nil, ErrDuplicateEmail
+		}
+	}
+
+	id, err := s.coll.insert(user)
+	if err != nil{
+		return nil, err
+	}
+	user.ID = id.(primitive.ObjectID)
+	return user, nil
+}
+
+// DeleteUser removes a user by ID
+func (s *MemoryUserStore) DeleteUser(ctx context.Context, id string) error{
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil{
+		return err
+	}
+	_, err = s.coll.delete(bson.M{"_id": oid})
+	return err
+}
+
+// UpdateUser sets the fields in update on the first user matching the filter
+func (s *MemoryUserStore) UpdateUser(ctx context.Context, filter bson.M, update bson.M) error{
+	_, err := s.coll.update(filter, bson.M{"$set": update}, false)
+	return err
+}
+
+// Drop deletes every user
+func (s *MemoryUserStore) Drop(ctx context.Context) error{
+	s.coll.drop()
+	return nil
+}
+
+// GetUserByEmail finds a user by their email address, ignoring case
+func (s *MemoryUserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error){
+	users, err := s.GetUsers(ctx)
+	if err != nil{
+		return nil, err
+	}
+	for _, user := range users{
+		if strings.EqualFold(user.Email, email){
+			return user, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
@@ -0,0 +1,143 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrPaymentStatusChanged is returned when updating a payment that no longer has
+// the expected status, e.g. because the same webhook was delivered twice
+var ErrPaymentStatusChanged = errors.New("payment status has changed")
+
+// Name of the MongoDB collection for payments
+const paymentColl = "payments"
+
+// paymentOrder lists the oldest payments first
+var paymentOrder = &FindOptions{Sort: bson.D{{Key: "createdAt", Value: 1}}}
+
+// PaymentStore keeps the payments made for bookings
+type PaymentStore interface{
+	InsertPayment(context.Context,*types.Payment) (*types.Payment,error)
+	GetPayments(context.Context,bson.M) ([]*types.Payment,error)                          // Get matching payments, oldest first
+	GetPaymentByProviderRef(ctx context.Context,provider,ref string) (*types.Payment,error) // Find the payment a webhook is about
+	UpdatePaymentStatus(ctx context.Context,id primitive.ObjectID,from,to types.PaymentStatus,failureReason string) (*types.Payment,error) // Move a payment on if it still has status from
+	CapturePayment(ctx context.Context,id primitive.ObjectID,from types.PaymentStatus,amount float64) (*types.Payment,error)                  // Record the amount charged if it still has status from
+}
+
+// paymentStatusUpdate sets the status of a payment
+func paymentStatusUpdate(status types.PaymentStatus, failureReason string) bson.M{
+	set := bson.M{"status": status, "updatedAt": time.Now().UTC()}
+	if failureReason != ""{
+		set["failureReason"] = failureReason
+	}
+	return bson.M{"$set": set}
+}
+
+// paymentCaptureUpdate records the amount charged on a payment
+func paymentCaptureUpdate(amount float64) bson.M{
+	return bson.M{"$set": bson.M{
+		"status":    types.PaymentStatusCaptured,
+		"captured":  amount,
+		"updatedAt": time.Now().UTC(),
+	}}
+}
+
+type MongoPaymentStore struct{
+	client *mongo.Client
+	coll   *mongo.Collection
+	collIndex indexOnce
+}
+
+// NewMongoPaymentStore creates a new MongoPaymentStore using the collection in database dbname
+func NewMongoPaymentStore(client *mongo.Client, dbname string) *MongoPaymentStore{
+	return &MongoPaymentStore{
+		client: client,
+		coll: client.Database(dbname).Collection(paymentColl),
+	}
+}
+
+// InsertPayment stores a new payment
+// The unique index on the gateway reference makes sure a payment is recorded only once
+func (s *MongoPaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) (*types.Payment,error){
+	if err := s.collIndex.ensure(ctx, s.coll,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "providerRef", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "bookingID", Value: 1}},
+		},
+	); err != nil{
+		return nil,err
+	}
+
+	if payment.ID.IsZero(){
+		payment.ID = primitive.NewObjectID()
+	}
+	if _, err := s.coll.InsertOne(ctx, payment); err != nil{
+		return nil,err
+	}
+	return payment,nil
+}
+
+// GetPayments retrieves the payments matching the filter, oldest first
+func (s *MongoPaymentStore) GetPayments(ctx context.Context, filter bson.M) ([]*types.Payment,error){
+	cur, err := s.coll.Find(ctx, filter, paymentOrder.toMongo())
+	if err != nil{
+		return nil,err
+	}
+	payments := []*types.Payment{}
+	if err := cur.All(ctx, &payments); err != nil{
+		return nil,err
+	}
+	return payments,nil
+}
+
+// GetPaymentByProviderRef finds the payment with the given reference at the gateway
+func (s *MongoPaymentStore) GetPaymentByProviderRef(ctx context.Context, provider, ref string) (*types.Payment,error){
+	var payment types.Payment
+	if err := s.coll.FindOne(ctx, bson.M{"provider": provider, "providerRef": ref}).Decode(&payment); err != nil{
+		return nil,err
+	}
+	return &payment,nil
+}
+
+// UpdatePaymentStatus moves the payment from status from to status to
+// Returns ErrPaymentStatusChanged if the payment no longer has status from,
+// so only one of two concurrent updates takes effect
+func (s *MongoPaymentStore) UpdatePaymentStatus(ctx context.Context, id primitive.ObjectID, from, to types.PaymentStatus, failureReason string) (*types.Payment,error){
+	return s.updatePayment(ctx, id, from, paymentStatusUpdate(to, failureReason))
+}
+
+// CapturePayment records that amount of the payment was charged and marks it captured
+// A captured payment can be captured again to record a partial refund
+// Returns ErrPaymentStatusChanged if the payment no longer has status from
+func (s *MongoPaymentStore) CapturePayment(ctx context.Context, id primitive.ObjectID, from types.PaymentStatus, amount float64) (*types.Payment,error){
+	return s.updatePayment(ctx, id, from, paymentCaptureUpdate(amount))
+}
+
+// updatePayment applies the update to the payment if it still has status from
+func (s *MongoPaymentStore) updatePayment(ctx context.Context, id primitive.ObjectID, from types.PaymentStatus, update bson.M) (*types.Payment,error){
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var payment types.Payment
+	err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": from}, update, opts).Decode(&payment)
+	if err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			// Tell a missing payment apart from one in another state
+			if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Err(); err != nil{
+				return nil,err
+			}
+			return nil,ErrPaymentStatusChanged
+		}
+		return nil,err
+	}
+	return &payment,nil
+}
@@ -2,6 +2,8 @@ package db
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
@@ -9,11 +11,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// Name of the MongoDB collection for rooms
+const roomColl = "rooms"
+
 // RoomStore defines the interface for room data operations
 // Any implementation of RoomStore must provide these methods
 type RoomStore interface{
 	InsertRoom(context.Context,*types.Room) (*types.Room, error)  // Add a new room
 	GetRooms(context.Context,bson.M)([]*types.Room,error)         // Get rooms with optional filters
+	GetRoomByID(context.Context,primitive.ObjectID)(*types.Room,error) // Find a room by ID
+	GetAvailableRooms(context.Context,bson.M,bson.M)([]*types.Room,error) // Get rooms without a booking matching a filter
+	UpdateRoom(context.Context,primitive.ObjectID,bson.M) error         // $set fields of a room
+	DeleteRoom(context.Context,primitive.ObjectID) error                // Remove a room and take it off its hotel
+	SetRoomDeleting(context.Context,primitive.ObjectID,bool) error      // Mark a room as being deleted, or clear the mark again
 }
 
 // MongoRoomStore implements the RoomStore interface with MongoDB
@@ -25,18 +35,19 @@ type MongoRoomStore struct{
 }
 
 // NewMongoRoomStore creates a new MongoRoomStore with the provided MongoDB client
-// This is a factory function that sets up the connection to the rooms collection
+// This is a factory function that sets up the connection to the rooms collection in database dbname
 // It requires a HotelStore because rooms belong to hotels and need to update them
-func NewMongoRoomStore(client *mongo.Client,hotelStore HotelStore) *MongoRoomStore{
+func NewMongoRoomStore(client *mongo.Client,dbname string,hotelStore HotelStore) *MongoRoomStore{
 	return &MongoRoomStore{
 		client: client,
-		coll: client.Database(DBNAME).Collection("rooms"),
+		coll: client.Database(dbname).Collection(roomColl),
 		HotelStore: hotelStore,
 	}
 }
 
 // InsertRoom adds a new room to the database
-// It also updates the associated hotel to include the new room ID
+// It also updates the associated hotel to include the new room ID. If the hotel
+// is gone the room is removed again and mongo.ErrNoDocuments is returned
 func (s *MongoRoomStore) InsertRoom(ctx context.Context, room *types.Room)(*types.Room,error){
 	// Insert the room document
 	resp,err :=s.coll.InsertOne(ctx,room)
@@ -53,6 +64,9 @@ func (s *MongoRoomStore) InsertRoom(ctx context.Context, room *types.Room)(*type
 
 	// Update the hotel document to include this room
 	if err := s.HotelStore.Update(ctx,filter,update);err != nil{
+		if errors.Is(err, mongo.ErrNoDocuments){
+			s.coll.DeleteOne(context.Background(),bson.M{"_id":room.ID})
+		}
 		return nil,err
 	}
 	
@@ -74,4 +88,136 @@ func (s *MongoRoomStore) GetRooms(ctx context.Context,filter bson.M) ([]*types.R
 		return nil,err
 	}
 	return rooms,nil
-}
\ No newline at end of file
+}
+
+// GetRoomByID retrieves a room by its ID
+// Returns the room or an error if not found
+func (s *MongoRoomStore) GetRoomByID(ctx context.Context,id primitive.ObjectID) (*types.Room,error){
+	var room types.Room
+
+	// Find and decode the room document
+	if err := s.coll.FindOne(ctx,bson.M{"_id":id}).Decode(&room); err != nil{
+		return nil,err
+	}
+	return &room,nil
+}
+
+// GetAvailableRooms retrieves the rooms matching roomFilter that have no booking matching bookingFilter
+// Everything runs as a single aggregation: each room is joined with at most one of its
+// conflicting bookings and only rooms without any are kept
+func (s *MongoRoomStore) GetAvailableRooms(ctx context.Context,roomFilter,bookingFilter bson.M) ([]*types.Room,error){
+	if roomFilter == nil{
+		roomFilter = bson.M{}
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: roomFilter}},
+		{{Key: "$lookup", Value: bson.M{
+			"from": bookingColl,
+			"let":  bson.M{"roomID": "$_id"},
+			"pipeline": bson.A{
+				bson.M{"$match": bson.M{"$expr": bson.M{"$eq": bson.A{"$roomID", "$$roomID"}}}},
+				bson.M{"$match": bookingFilter},
+				bson.M{"$limit": 1},
+			},
+			"as": "conflicts",
+		}}},
+		{{Key: "$match", Value: bson.M{"conflicts": bson.M{"$size": 0}}}},
+		{{Key: "$project", Value: bson.M{"conflicts": 0}}},
+	}
+
+	// Run the aggregation and decode the remaining rooms
+	resp,err := s.coll.Aggregate(ctx,pipeline)
+	if err != nil{
+		return nil,err
+	}
+	rooms := []*types.Room{}
+	if err := resp.All(ctx,&rooms);err != nil{
+		return nil,err
+	}
+	return rooms,nil
+}
+
+// UpdateRoom sets the given fields of the room
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MongoRoomStore) UpdateRoom(ctx context.Context,id primitive.ObjectID,update bson.M) error{
+	res, err := s.coll.UpdateOne(ctx,bson.M{"_id":id},bson.M{"$set":update})
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// DeleteRoom removes a room from the database
+// It is the inverse of InsertRoom and also removes the room ID from its hotel.
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MongoRoomStore) DeleteRoom(ctx context.Context,id primitive.ObjectID) error{
+	// Delete the room, keeping the document to know which hotel it belonged to
+	var room types.Room
+	if err := s.coll.FindOneAndDelete(ctx,bson.M{"_id":id}).Decode(&room); err != nil{
+		return err
+	}
+
+	// Remove the room ID from the hotel's rooms array
+	return pullRoom(ctx,s.HotelStore,&room)
+}
+
+// SetRoomDeleting sets or clears the mark that the room is being deleted
+// While it is set the booking stores refuse to book new nights of the room and
+// room listings leave it out, until it is older than roomDeletionTimeout.
+// Returns mongo.ErrNoDocuments if there is no such room
+func (s *MongoRoomStore) SetRoomDeleting(ctx context.Context,id primitive.ObjectID,deleting bool) error{
+	res, err := s.coll.UpdateOne(ctx,bson.M{"_id":id},roomDeletingUpdate(deleting))
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// roomDeletionTimeout is how long the mark that a room is being deleted holds
+// Deleting a room takes a few queries; an older mark was left behind by a
+// request that never finished, e.g. because the server stopped, and is ignored
+const roomDeletionTimeout = 5 * time.Minute
+
+// roomDeletingUpdate marks a room as being deleted from now on, or clears the mark
+func roomDeletingUpdate(deleting bool) bson.M{
+	if !deleting{
+		return bson.M{"$unset": bson.M{"deletingSince": ""}}
+	}
+	return bson.M{"$set": bson.M{"deletingSince": time.Now().UTC()}}
+}
+
+// notDeletingFilter matches rooms that are not being deleted at the given time
+// Marks older than roomDeletionTimeout do not count
+func notDeletingFilter(now time.Time) bson.M{
+	return bson.M{"$or": []bson.M{
+		{"deletingSince": bson.M{"$exists": false}},
+		{"deletingSince": bson.M{"$lte": now.Add(-roomDeletionTimeout)}},
+	}}
+}
+
+// ListedRoomsFilter narrows a room filter down to the rooms that can be listed
+// at the given time, leaving out the ones being deleted
+func ListedRoomsFilter(filter bson.M, now time.Time) bson.M{
+	listed := bson.M{"$and": []bson.M{notDeletingFilter(now)}}
+	for key, value := range filter{
+		listed[key] = value
+	}
+	return listed
+}
+
+// pullRoom takes the deleted room off the rooms of its hotel
+// A hotel that is already gone has nothing to take it off
+func pullRoom(ctx context.Context,hotels HotelStore,room *types.Room) error{
+	filter := bson.M{"_id": room.HotelID}
+	update := bson.M{"$pull": bson.M{"rooms": room.ID}}
+	if err := hotels.Update(ctx,filter,update); err != nil && !errors.Is(err, mongo.ErrNoDocuments){
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrRefreshTokenReused is returned when a refresh token that was already used
+// or revoked is used again
+var ErrRefreshTokenReused = errors.New("refresh token already used")
+
+// Name of the MongoDB collection for refresh tokens
+const refreshTokenColl = "refreshTokens"
+
+// Name of the MongoDB collection for revoked access tokens
+const revokedTokenColl = "revokedTokens"
+
+// Name of the MongoDB collection for single-use user tokens, e.g. password resets
+const userTokenColl = "userTokens"
+
+// TokenStore keeps the refresh tokens, the list of revoked access tokens and
+// the single-use tokens emailed to users
+type TokenStore interface{
+	InsertRefreshToken(context.Context,*types.RefreshToken) (*types.RefreshToken,error)
+	GetRefreshToken(ctx context.Context,tokenHash string) (*types.RefreshToken,error) // Find a refresh token by its hash
+	UseRefreshToken(context.Context,primitive.ObjectID) error                        // Mark a token used, fails with ErrRefreshTokenReused if it already was
+	RevokeTokenFamily(ctx context.Context,familyID primitive.ObjectID) error          // Revoke every refresh token of a family and their access tokens
+	RevokeUserSessions(ctx context.Context,userID primitive.ObjectID) error           // Revoke every refresh token of a user and their access tokens
+	RevokeAccessToken(ctx context.Context,tokenID string,expiresAt time.Time) error   // Reject an access token until it expires
+	IsAccessTokenRevoked(ctx context.Context,tokenID string) (bool,error)
+	InsertUserToken(context.Context,*types.UserToken) (*types.UserToken,error)
+	UseUserToken(ctx context.Context,purpose types.TokenPurpose,tokenHash string) (*types.UserToken,error) // Mark a usable token used and return it
+	InvalidateUserTokens(ctx context.Context,userID primitive.ObjectID,purpose types.TokenPurpose) error   // Mark every unused token of a user for the purpose used
+}
+
+// usableRefreshTokenFilter matches the refresh token with the given ID if it
+// has neither been used nor revoked
+func usableRefreshTokenFilter(id primitive.ObjectID) bson.M{
+	return bson.M{
+		"_id":     id,
+		"used":    false,
+		"revoked": false,
+	}
+}
+
+// usableUserTokenFilter matches the unused, unexpired token with the given hash
+// if it was issued for the purpose
+func usableUserTokenFilter(purpose types.TokenPurpose, tokenHash string) bson.M{
+	return bson.M{
+		"tokenHash": tokenHash,
+		"purpose":   purpose,
+		"used":      false,
+		"expiresAt": bson.M{"$gt": time.Now().UTC()},
+	}
+}
+
+type MongoTokenStore struct{
+	client     *mongo.Client
+	coll       *mongo.Collection // Refresh tokens
+	revoked    *mongo.Collection // Revoked access tokens
+	userTokens *mongo.Collection // Single-use user tokens
+	collIndex      indexOnce
+	revokedIndex   indexOnce
+	userTokenIndex indexOnce
+}
+
+// NewMongoTokenStore creates a new MongoTokenStore using the collections in database dbname
+func NewMongoTokenStore(client *mongo.Client, dbname string) *MongoTokenStore{
+	return &MongoTokenStore{
+		client: client,
+		coll: client.Database(dbname).Collection(refreshTokenColl),
+		revoked: client.Database(dbname).Collection(revokedTokenColl),
+		userTokens: client.Database(dbname).Collection(userTokenColl),
+	}
+}
+
+// InsertRefreshToken stores a new refresh token
+// Expired tokens are removed by MongoDB through a TTL index
+func (s *MongoTokenStore) InsertRefreshToken(ctx context.Context, token *types.RefreshToken) (*types.RefreshToken,error){
+	if err := s.collIndex.ensure(ctx, s.coll,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "familyID", Value: 1}},
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	); err != nil{
+		return nil,err
+	}
+
+	if token.ID.IsZero(){
+		token.ID = primitive.NewObjectID()
+	}
+	if _, err := s.coll.InsertOne(ctx, token); err != nil{
+		return nil,err
+	}
+	return token,nil
+}
+
+// GetRefreshToken finds a refresh token by its hash
+func (s *MongoTokenStore) GetRefreshToken(ctx context.Context, tokenHash string) (*types.RefreshToken,error){
+	var token types.RefreshToken
+	if err := s.coll.FindOne(ctx, bson.M{"tokenHash": tokenHash}).Decode(&token); err != nil{
+		return nil,err
+	}
+	return &token,nil
+}
+
+// UseRefreshToken marks the refresh token as used
+// The check and the update are a single operation, so of two concurrent
+// refreshes with the same token only one succeeds
+func (s *MongoTokenStore) UseRefreshToken(ctx context.Context, id primitive.ObjectID) error{
+	res, err := s.coll.UpdateOne(ctx, usableRefreshTokenFilter(id), bson.M{"$set": bson.M{"used": true}})
+	if err != nil{
+		return err
+	}
+	if res.MatchedCount == 0{
+		// Tell a missing token apart from one that was used before
+		if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Err(); err != nil{
+			return err
+		}
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// RevokeTokenFamily revokes every refresh token of the family together with
+// the access tokens that were issued alongside them
+func (s *MongoTokenStore) RevokeTokenFamily(ctx context.Context, familyID primitive.ObjectID) error{
+	return s.revokeRefreshTokens(ctx, bson.M{"familyID": familyID})
+}
+
+// RevokeUserSessions revokes every refresh token of the user together with
+// the access tokens that were issued alongside them, signing the user out everywhere
+func (s *MongoTokenStore) RevokeUserSessions(ctx context.Context, userID primitive.ObjectID) error{
+	return s.revokeRefreshTokens(ctx, bson.M{"userID": userID})
+}
+
+// revokeRefreshTokens revokes the refresh tokens matching the filter and their access tokens
+func (s *MongoTokenStore) revokeRefreshTokens(ctx context.Context, filter bson.M) error{
+	if _, err := s.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revoked": true}}); err != nil{
+		return err
+	}
+
+	cur, err := s.coll.Find(ctx, filter)
+	if err != nil{
+		return err
+	}
+	var tokens []*types.RefreshToken
+	if err := cur.All(ctx, &tokens); err != nil{
+		return err
+	}
+	for _, token := range tokens{
+		if token.AccessTokenID == ""{
+			continue
+		}
+		if err := s.RevokeAccessToken(ctx, token.AccessTokenID, token.AccessExpiresAt); err != nil{
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAccessToken adds the access token to the revocation list
+// The entry is removed by MongoDB through a TTL index once the token has expired
+func (s *MongoTokenStore) RevokeAccessToken(ctx context.Context, tokenID string, expiresAt time.Time) error{
+	if err := s.revokedIndex.ensure(ctx, s.revoked, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}); err != nil{
+		return err
+	}
+
+	// Revoking the same token twice is not an error
+	_, err := s.revoked.UpdateOne(ctx,
+		bson.M{"_id": tokenID},
+		bson.M{"$set": bson.M{"expiresAt": expiresAt}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
+
+// IsAccessTokenRevoked reports whether the access token has been revoked
+func (s *MongoTokenStore) IsAccessTokenRevoked(ctx context.Context, tokenID string) (bool,error){
+	n, err := s.revoked.CountDocuments(ctx, bson.M{"_id": tokenID})
+	if err != nil{
+		return false,err
+	}
+	return n > 0,nil
+}
+
+// InsertUserToken stores a new single-use user token
+// Expired tokens are removed by MongoDB through a TTL index
+func (s *MongoTokenStore) InsertUserToken(ctx context.Context, token *types.UserToken) (*types.UserToken,error){
+	if err := s.userTokenIndex.ensure(ctx, s.userTokens,
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "tokenHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "purpose", Value: 1}},
+		},
+		mongo.IndexModel{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	); err != nil{
+		return nil,err
+	}
+
+	if token.ID.IsZero(){
+		token.ID = primitive.NewObjectID()
+	}
+	if _, err := s.userTokens.InsertOne(ctx, token); err != nil{
+		return nil,err
+	}
+	return token,nil
+}
+
+// UseUserToken marks the token with the given hash used and returns it
+// Returns mongo.ErrNoDocuments if there is no such token, or it was issued for
+// another purpose, already used or has expired. The check and the update are a
+// single operation, so a token can only ever be used once
+func (s *MongoTokenStore) UseUserToken(ctx context.Context, purpose types.TokenPurpose, tokenHash string) (*types.UserToken,error){
+	var token types.UserToken
+	err := s.userTokens.FindOneAndUpdate(ctx,
+		usableUserTokenFilter(purpose, tokenHash),
+		bson.M{"$set": bson.M{"used": true}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&token)
+	if err != nil{
+		return nil,err
+	}
+	return &token,nil
+}
+
+// InvalidateUserTokens marks every unused token of the user for the purpose used
+func (s *MongoTokenStore) InvalidateUserTokens(ctx context.Context, userID primitive.ObjectID, purpose types.TokenPurpose) error{
+	_, err := s.userTokens.UpdateMany(ctx,
+		bson.M{"userID": userID, "purpose": purpose, "used": false},
+		bson.M{"$set": bson.M{"used": true}},
+	)
+	return err
+}
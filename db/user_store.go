@@ -2,17 +2,27 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Name of the MongoDB collection for users
 const usesrColl = "users"
 
+// ErrDuplicateEmail is returned when inserting a user whose email address is already taken
+var ErrDuplicateEmail = errors.New("email already in use")
+
+// emailCollation compares email addresses ignoring case
+// The unique index and lookups by email must use the same collation, otherwise
+// Jane@example.com could be inserted as a duplicate but not found again
+var emailCollation = &options.Collation{Locale: "en", Strength: 2}
+
 // UserStore defines the interface for user data operations
 // Any implementation of UserStore must provide these methods
 type UserStore interface{
@@ -34,12 +44,25 @@ type MongoUserStore struct{
 }
 
 // NewMongoUserStore creates a new MongoUserStore with the provided MongoDB client
-// This is a factory function that sets up the connection to the users collection
-func NewMongoUserStore(client *mongo.Client) *MongoUserStore{
-	return &MongoUserStore{
+// This is a factory function that sets up the connection to the users collection in database dbname
+// It creates the unique index on email addresses, so the store is never used without it
+func NewMongoUserStore(ctx context.Context, client *mongo.Client, dbname string) (*MongoUserStore, error){
+	s := &MongoUserStore{
 		client: client,
-		coll: client.Database(DBNAME).Collection(usesrColl),
+		dbname: dbname,
+		coll: client.Database(dbname).Collection(usesrColl),
+	}
+	// Make sure email addresses are unique, compared case-insensitively
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "email", Value: 1}},
+		Options: options.Index().
+			SetUnique(true).
+			SetCollation(emailCollation),
+	})
+	if err != nil{
+		return nil, fmt.Errorf("creating the email index of the users collection: %w", err)
 	}
+	return s, nil
 }
 
 // GetUserById retrieves a user by their ID
@@ -81,10 +104,14 @@ func (s *MongoUserStore) GetUsers(ctx context.Context) ([]*types.User,error){
 
 // InsertUser adds a new user to the database
 // Takes a user object and returns the inserted user with ID or an error
+// Returns ErrDuplicateEmail if another user already has the same email (ignoring case)
 func (s *MongoUserStore) InsertUser(ctx context.Context,user *types.User)(*types.User,error) {
 	// Insert the user document
 	res, err := s.coll.InsertOne(ctx,user)
 	if err != nil{
+		if mongo.IsDuplicateKeyError(err){
+			return nil,ErrDuplicateEmail
+		}
 		return nil,err
 	}
 	
@@ -130,11 +157,12 @@ func (s *MongoUserStore) Drop(ctx context.Context) error{
 }
 
 // GetUserByEmail finds a user by their email address
-// Email addresses are unique in the system
+// Email addresses are unique in the system and compared ignoring case, like the unique index does
 func (s *MongoUserStore) GetUserByEmail(ctx context.Context,email string) (*types.User,error){
 	var user types.User
-	// Find and decode the user document
-	if err := s.coll.FindOne(ctx,bson.M{"email": email}).Decode(&user); err != nil{
+	// Find and decode the user document, with the collation of the index so it is used
+	opts := options.FindOne().SetCollation(emailCollation)
+	if err := s.coll.FindOne(ctx,bson.M{"email": email},opts).Decode(&user); err != nil{
 		return nil,err
 	}
 	return &user,nil
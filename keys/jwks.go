@@ -0,0 +1,63 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"time"
+)
+
+// JWK is the public part of a signing key in JSON Web Key format (RFC 7517)
+type JWK struct{
+	Kty string `json:"kty"`           // Key type, RSA or OKP
+	Kid string `json:"kid"`           // Key ID, matches the kid header of the tokens
+	Use string `json:"use"`           // Always "sig"
+	Alg string `json:"alg"`           // Signing algorithm, RS256 or EdDSA
+	N   string `json:"n,omitempty"`   // RSA modulus
+	E   string `json:"e,omitempty"`   // RSA public exponent
+	Crv string `json:"crv,omitempty"` // Curve of an OKP key, Ed25519
+	X   string `json:"x,omitempty"`   // Ed25519 public key
+}
+
+// JWKSet is a set of public keys as served at /.well-known/jwks.json
+type JWKSet struct{
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys other services need to verify our tokens
+// Keys that are not active yet are included, so verifiers already know them
+// when they start signing; retired keys and the HS256 secret are not
+func (s *KeySet) JWKS() JWKSet{
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range s.keys{
+		if key.isRetired(now){
+			continue
+		}
+		jwk := JWK{
+			Kid: key.ID,
+			Use: "sig",
+			Alg: key.Method.Alg(),
+		}
+		switch public := key.public.(type){
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encode(public.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encode(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// encode encodes bytes as unpadded base64url, as JWK requires
+func encode(b []byte) string{
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,201 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key accepted for signing
+const minRSABits = 2048
+
+// ErrNoSigningKey is returned when no key is active for signing
+var ErrNoSigningKey = errors.New("no active signing key")
+
+// Key is a key tokens are signed or verified with
+type Key struct{
+	ID         string            // Key ID, the kid header of the tokens signed with it
+	Method     jwt.SigningMethod // Signing method, e.g. RS256
+	ActiveFrom time.Time         // When the key starts signing
+	RetireAt   time.Time         // When tokens signed with the key stop being accepted, zero means never
+	signKey    interface{}       // Private key or HMAC secret
+	verifyKey  interface{}       // Public key or HMAC secret
+	public     crypto.PublicKey  // Public key published in the JWKS, nil for HMAC secrets
+}
+
+// isRetired reports whether tokens signed with the key are no longer accepted at the given time
+func (k *Key) isRetired(now time.Time) bool{
+	return !k.RetireAt.IsZero() && !now.Before(k.RetireAt)
+}
+
+// KeySet holds every key the API signs and verifies tokens with
+// Asymmetric keys are looked up by the kid header of a token, which lets several
+// keys be valid at the same time while they are rotated. The HS256 secret, if
+// configured, is used for tokens without a kid
+type KeySet struct{
+	keys   []*Key // Asymmetric keys, newest activeFrom first
+	secret *Key   // The HS256 secret, nil if not configured
+}
+
+// NewKeySet loads the keys of the configuration
+// The private keys are read from disk once, so the files can be kept readable
+// only by the user starting the server
+func NewKeySet(cfg config.AuthConfig) (*KeySet, error){
+	set := &KeySet{}
+	if cfg.JWTSecret != ""{
+		set.secret = &Key{
+			Method:    jwt.SigningMethodHS256,
+			signKey:   []byte(cfg.JWTSecret),
+			verifyKey: []byte(cfg.JWTSecret),
+		}
+	}
+	for _, keyCfg := range cfg.SigningKeys{
+		key, err := loadKey(keyCfg)
+		if err != nil{
+			return nil, fmt.Errorf("loading signing key %s: %w", keyCfg.ID, err)
+		}
+		set.keys = append(set.keys, key)
+	}
+	sort.SliceStable(set.keys, func(i, j int) bool{
+		return set.keys[i].ActiveFrom.After(set.keys[j].ActiveFrom)
+	})
+	if set.secret == nil && len(set.keys) == 0{
+		return nil, ErrNoSigningKey
+	}
+	return set, nil
+}
+
+// loadKey reads the private key of a signing key from its PEM file
+func loadKey(cfg config.SigningKeyConfig) (*Key, error){
+	data, err := os.ReadFile(cfg.PrivateKeyFile)
+	if err != nil{
+		return nil, err
+	}
+	key := &Key{
+		ID:         cfg.ID,
+		ActiveFrom: cfg.ActiveFrom,
+		RetireAt:   cfg.RetireAt,
+	}
+	switch cfg.Algorithm{
+	case config.AlgorithmRS256:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+		if err != nil{
+			return nil, err
+		}
+		if private.N.BitLen() < minRSABits{
+			return nil, fmt.Errorf("RSA key has %d bits, at least %d are required", private.N.BitLen(), minRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+		key.signKey = private
+		key.verifyKey = &private.PublicKey
+		key.public = &private.PublicKey
+	case config.AlgorithmEdDSA:
+		parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+		if err != nil{
+			return nil, err
+		}
+		private, ok := parsed.(ed25519.PrivateKey)
+		if !ok{
+			return nil, errors.New("not an Ed25519 private key")
+		}
+		public := private.Public().(ed25519.PublicKey)
+		key.Method = jwt.SigningMethodEdDSA
+		key.signKey = private
+		key.verifyKey = public
+		key.public = public
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", cfg.Algorithm)
+	}
+	return key, nil
+}
+
+// SigningKey returns the key new tokens are signed with
+// That is the asymmetric key activated most recently, or the HS256 secret if no
+// asymmetric key is active yet
+func (s *KeySet) SigningKey() (*Key, error){
+	now := time.Now()
+	for _, key := range s.keys{
+		if !key.ActiveFrom.After(now) && !key.isRetired(now){
+			return key, nil
+		}
+	}
+	if s.secret != nil{
+		return s.secret, nil
+	}
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the current signing key and names the key in the kid header
+func (s *KeySet) Sign(claims jwt.Claims) (string, error){
+	key, err := s.SigningKey()
+	if err != nil{
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != ""{
+		token.Header["kid"] = key.ID
+	}
+	return token.SignedString(key.signKey)
+}
+
+// Keyfunc returns the key a token must be verified with, for use with jwt.Parse
+// Tokens naming an unknown or retired key are rejected, as are tokens whose
+// algorithm does not match the key, e.g. HS256 tokens "signed" with a public key
+func (s *KeySet) Keyfunc(token *jwt.Token) (interface{}, error){
+	key, err := s.verificationKey(token)
+	if err != nil{
+		return nil, err
+	}
+	if token.Method.Alg() != key.Method.Alg(){
+		return nil, fmt.Errorf("token is signed with %s, key %q uses %s", token.Method.Alg(), key.ID, key.Method.Alg())
+	}
+	return key.verifyKey, nil
+}
+
+// verificationKey finds the key named by the kid header of the token
+func (s *KeySet) verificationKey(token *jwt.Token) (*Key, error){
+	kid, _ := token.Header["kid"].(string)
+	if kid == ""{
+		if s.secret == nil{
+			return nil, errors.New("token has no kid")
+		}
+		return s.secret, nil
+	}
+	for _, key := range s.keys{
+		if key.ID != kid{
+			continue
+		}
+		if key.isRetired(time.Now()){
+			return nil, fmt.Errorf("key %q is retired", kid)
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// ValidMethods returns the signing methods of every key in the set
+// Pass them to jwt.WithValidMethods so no other algorithm is ever accepted
+func (s *KeySet) ValidMethods() []string{
+	seen := map[string]bool{}
+	var methods []string
+	add := func(key *Key){
+		if !seen[key.Method.Alg()]{
+			seen[key.Method.Alg()] = true
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	for _, key := range s.keys{
+		add(key)
+	}
+	if s.secret != nil{
+		add(s.secret)
+	}
+	return methods
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
-	"flag"
 	"log"
+	"os"
 
 	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/keys"
 	"github.com/0x0Glitch/hotel-reservation/middleware"
+	"github.com/0x0Glitch/hotel-reservation/notify"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -15,34 +20,59 @@ import (
 
 // Fiber configuration for custom error handling
 // This ensures all errors are returned in a consistent JSON format
-var config = fiber.Config{
-    // Override default error handler to return JSON instead of plain text
-    ErrorHandler: func(c *fiber.Ctx, err error) error {
-        return c.JSON(map[string]string{"error":err.Error()})
-    },
+var fiberConfig = fiber.Config{
+    // Override default error handler to return JSON with the matching HTTP status
+    ErrorHandler: api.ErrorHandler,
 }
 
 // main is the entry point of the application
 // It sets up the database connection, handlers, and starts the HTTP server
 func main(){
-	// Parse command line flags
+	// Load the configuration from the config file, environment variables and flags
 	// You can specify a different port using: go run main.go -listenAddr=:8080
-	listenAddr := flag.String("listenAddr",":5001","The listen address of the API server")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil{
+		log.Fatal(err)
+	}
+	// Refuse to start with missing settings, e.g. without a JWT secret
+	if err := cfg.Validate(); err != nil{
+		log.Fatal(err)
+	}
+	// Load the keys tokens are signed with
+	keySet, err := keys.NewKeySet(cfg.Auth)
+	if err != nil{
+		log.Fatal(err)
+	}
+	// Emails go out over SMTP, or into the mail directory during development
+	mailer, err := notify.NewMailer(cfg.Mail)
+	if err != nil{
+		log.Fatal(err)
+	}
+	// Bookings are paid through the configured payment gateway
+	paymentProvider, err := payments.NewProvider(cfg.Payments)
+	if err != nil{
+		log.Fatal(err)
+	}
 
 	// Connect to MongoDB
-	// The URI is defined in the db package
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(db.DBURI))
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(cfg.DB.URI))
 	if err != nil{
 		log.Fatal(err)
 	} 
 	
 	// Initialize database stores
 	// These provide access to different collections in MongoDB
-	hotelStore := db.NewMongoHotelStore(client)
-	roomStore := db.NewMongoRoomStore(client,hotelStore)
-	userStore := db.NewMongoUserStore(client)
-	bookingStore := db.NewMongoBookingStore(client)
+	hotelStore := db.NewMongoHotelStore(client,cfg.DB.Name)
+	roomStore := db.NewMongoRoomStore(client,cfg.DB.Name,hotelStore)
+	userStore, err := db.NewMongoUserStore(context.TODO(),client,cfg.DB.Name)
+	if err != nil{
+		log.Fatal(err)
+	}
+	bookingStore := db.NewMongoBookingStore(client,cfg.DB.Name)
+	tokenStore := db.NewMongoTokenStore(client,cfg.DB.Name)
+	loginStore := db.NewMongoLoginStore(client,cfg.DB.Name)
+	idempotencyStore := db.NewMongoIdempotencyStore(client,cfg.DB.Name)
+	paymentStore := db.NewMongoPaymentStore(client,cfg.DB.Name)
 	
 	// Create a central store with all sub-stores
 	store := &db.Store{
@@ -50,36 +80,75 @@ func main(){
 		Room: roomStore,
 		User: userStore,
 		Booking:bookingStore,
+		Token: tokenStore,
+		Login: loginStore,
+		Idempotency: idempotencyStore,
+		Payment: paymentStore,
 	}
+
+	// Release holds that ran out, so their nights can be booked again
+	go db.SweepHolds(context.Background(),bookingStore,cfg.Booking.HoldSweepInterval)
 	
 	// Initialize API handlers
 	// These handle HTTP requests and use the stores to interact with the database
 	userHandler := api.NewUserHandler(userStore)
 	hotelHandler := api.NewHotelHandler(store)
-	authHandler := api.NewAuthHandler(userStore)
-	roomHandler := api.NewRoomHandler(store)
+	loginLimiter := api.NewLoginLimiter(loginStore,cfg.Login)
+	authHandler := api.NewAuthHandler(userStore,tokenStore,keySet,mailer,loginLimiter,cfg.Auth)
+	lockoutHandler := api.NewLockoutHandler(userStore,loginStore,loginLimiter)
+	jwksHandler := api.NewJWKSHandler(keySet)
+	roomHandler := api.NewRoomHandler(store,cfg.Booking,paymentProvider,cfg.Payments)
+	bookingHandler := api.NewBookingHandler(store,paymentProvider,cfg.Payments)
+	paymentHandler := api.NewPaymentHandler(store,paymentProvider,cfg.Payments)
+	availabilityHandler := api.NewAvailabilityHandler(store)
 	
 	// Create a new Fiber app with our custom config
-	app := fiber.New(config)
+	// Behind a reverse proxy the client IP, which failed logins are counted by, comes from its header
+	fiberConfig.ProxyHeader = cfg.ProxyHeader
+	app := fiber.New(fiberConfig)
 	
 	// Create API routes
 	// auth group is for non-authenticated endpoints
 	auth := app.Group("/api")
 	
 	// apiv1 group requires JWT authentication for all routes
-	apiv1 := app.Group("/api/v1",middleware.JWTAuthentication(userStore))	
+	authenticated := middleware.JWTAuthentication(userStore,tokenStore,keySet,cfg.Auth)
+	apiv1 := app.Group("/api/v1",authenticated)	
+
+	// Public keys for services verifying our tokens
+	app.Get("/.well-known/jwks.json",jwksHandler.HandleGetJWKS)
+
+	// The payment gateway authenticates its webhooks with a signature instead of a token
+	auth.Post("/payments/webhook",paymentHandler.HandleWebhook)
 
 	// Authentication routes
 	// These don't require authentication to access
 	auth.Post("/auth",authHandler.HandleAuthentication)
+	auth.Post("/register",authHandler.HandleRegister)
+	auth.Post("/auth/refresh",authHandler.HandleRefresh)              // Exchange a refresh token for new tokens
+	auth.Post("/auth/logout",authenticated,authHandler.HandleLogout) // Revoke the current tokens
+	auth.Post("/auth/forgot",authHandler.HandleForgotPassword)       // Email a password reset link
+	auth.Post("/auth/reset",authHandler.HandleResetPassword)         // Set a new password with the emailed token
+	auth.Get("/auth/verify",authHandler.HandleVerifyEmail)           // Confirm an email address with the emailed token
+	auth.Post("/auth/verify/resend",authenticated,authHandler.HandleResendVerification) // Email a new verification link
+
+	// Only admins may manage other accounts
+	admin := middleware.RequireRoles(types.RoleAdmin)
+	// Depending on the configuration only users with a verified email may book
+	verified := middleware.RequireVerifiedEmail(cfg.Auth.RequireVerifiedEmail)
+	// Retries of creating requests sent with an Idempotency-Key get the original response
+	idempotent := middleware.Idempotency(idempotencyStore,cfg.Idempotency)
 
 	// User routes
 	// All of these require authentication
-	apiv1.Post("/user",userHandler.HandlePostUser)         // Create a new user
-	apiv1.Delete("/user/:id",userHandler.HandleDeleteUser) // Delete a user
-	apiv1.Get("/user", userHandler.HandleGetUsers)         // Get all users
-	apiv1.Get("/user/:id",userHandler.HandleGetUser)       // Get a specific user
-	apiv1.Put("user/:id",userHandler.HandlePutUser)        // Update a user
+	apiv1.Post("/user",admin,idempotent,userHandler.HandlePostUser) // Create a new user
+	apiv1.Delete("/user/:id",admin,userHandler.HandleDeleteUser) // Delete a user
+	apiv1.Get("/user",admin,userHandler.HandleGetUsers)          // Get all users
+	apiv1.Get("/user/:id",userHandler.HandleGetUser)             // Get yourself, or anyone as admin
+	apiv1.Put("/user/me/password",authHandler.HandleChangePassword) // Change the current user's password
+	apiv1.Put("/user/:id",userHandler.HandlePutUser)                 // Update yourself, or anyone as admin
+	apiv1.Post("/user/:id/unlock",admin,lockoutHandler.HandleUnlockUser) // Lift the login lockout of a user
+	apiv1.Get("/lockout",admin,lockoutHandler.HandleGetLockouts)         // List login lockouts
 	
 	// Hotel routes
 	// All of these require authentication
@@ -89,9 +158,27 @@ func main(){
 	apiv1.Get("/room",roomHandler.HandleGetRooms)
 	 // Get rooms for a hotel
 
-	apiv1.Post("/room/:id/book",roomHandler.HandleBookRoom)
+	// Only admins may manage hotels and rooms
+	apiv1.Post("/hotel",admin,hotelHandler.HandlePostHotel)                      // Create a hotel
+	apiv1.Put("/hotel/:id",admin,hotelHandler.HandlePutHotel)                    // Update a hotel
+	apiv1.Delete("/hotel/:id",admin,hotelHandler.HandleDeleteHotel)              // Delete a hotel without rooms
+	apiv1.Post("/hotel/:id/rooms",admin,hotelHandler.HandlePostRoom)             // Add a room to a hotel
+	apiv1.Put("/hotel/:id/rooms/:roomID",admin,hotelHandler.HandlePutRoom)       // Update a room
+	apiv1.Delete("/hotel/:id/rooms/:roomID",admin,hotelHandler.HandleDeleteRoom) // Delete a room without upcoming bookings
+
+	apiv1.Post("/room/:id/book",verified,idempotent,roomHandler.HandleBookRoom)
+	apiv1.Post("/room/:id/hold",verified,idempotent,roomHandler.HandleHoldRoom) // Hold a room while the guest pays
+	apiv1.Post("/room/:id/quote",roomHandler.HandleGetQuote) // Price a stay without booking it
+	apiv1.Get("/availability",availabilityHandler.HandleGetAvailability) // Search hotels with rooms free over a date range
+
+	// Booking routes
+	apiv1.Get("/booking",bookingHandler.HandleGetBookings)                // Get the current user's bookings
+	apiv1.Get("/booking/:id",bookingHandler.HandleGetBooking)             // Get a booking with its room and hotel
+	apiv1.Put("/booking/:id/cancel",bookingHandler.HandleCancelBooking)   // Cancel a booking
+	apiv1.Put("/booking/:id/confirm",bookingHandler.HandleConfirmBooking) // Confirm a hold
+	apiv1.Patch("/booking/:id",bookingHandler.HandleModifyBooking)        // Change the dates, guests or room of a booking
 	// Start the server
-	app.Listen(*listenAddr)
+	app.Listen(cfg.ListenAddr)
 }
 
 
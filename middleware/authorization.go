@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireRoles is a middleware function that only lets users with one of the given roles through
+// It must run after JWTAuthentication, which puts the authenticated user on the request
+// Users without a matching role get a 403 Forbidden error
+func RequireRoles(roles ...types.Role) fiber.Handler{
+	return func(c *fiber.Ctx) error {
+		// Get the user that JWTAuthentication stored on the request
+		user, ok := c.Context().UserValue("user").(*types.User)
+		if !ok {
+			return api.ErrUnauthorized()
+		}
+
+		// Stop the request if the user's role is not allowed on this route
+		if !user.HasRole(roles...) {
+			return api.ErrForbidden()
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireVerifiedEmail creates middleware that only lets users with a verified
+// email address through, if required is set
+// The policy is a configuration option, so with required unset the middleware
+// does nothing and routes can use it unconditionally
+func RequireVerifiedEmail(required bool) fiber.Handler{
+	return func(c *fiber.Ctx) error {
+		if !required {
+			return c.Next()
+		}
+		user, ok := c.Context().UserValue("user").(*types.User)
+		if !ok {
+			return api.ErrUnauthorized()
+		}
+		if !user.EmailVerified {
+			return api.NewError(http.StatusForbidden, "email_not_verified", "verify your email address first")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,131 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// IdempotencyKeyHeader is the header clients send to make a request safe to retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader is set on responses replayed for a retried request
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// maxIdempotencyKeyLen is the longest Idempotency-Key accepted
+const maxIdempotencyKeyLen = 255
+
+// Idempotency creates middleware that makes requests with an Idempotency-Key header safe to retry
+// It must run after JWTAuthentication, since keys are scoped to the user.
+// The first request with a key is processed and its response kept for the
+// retention window; a retry with the same key and body gets that response again,
+// marked with the Idempotent-Replayed header. Reusing a key for a different
+// request returns 422, and retrying while the first request is still running
+// returns 409. The first request renews its lease while it runs, so only a
+// request whose server stopped loses its key, after the lease has run out
+// without a renewal; a retry then takes over the key. Server errors are not
+// kept, so the request can be retried.
+// Requests without the header are processed as usual
+func Idempotency(store db.IdempotencyStore, cfg config.IdempotencyConfig) fiber.Handler{
+	return func(c *fiber.Ctx) error {
+		key := c.Get(IdempotencyKeyHeader)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			return api.ErrBadRequest("Idempotency-Key is too long")
+		}
+		user, ok := c.Context().UserValue("user").(*types.User)
+		if !ok {
+			return api.ErrUnauthorized()
+		}
+
+		record := types.NewIdempotencyRecord(user.ID, key, types.HashRequest(c.Method(), c.Path(), c.Body()), cfg.Retention, cfg.Lease)
+		existing, err := store.ClaimIdempotencyKey(c.Context(), record)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return replayResponse(c, existing, record.RequestHash)
+		}
+
+		// Turn errors into responses here, so the response can be kept like any other
+		stopRenewing := renewLease(store, record, cfg.Lease)
+		err = c.Next()
+		stopRenewing()
+		if err != nil {
+			if err := c.App().Config().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		resp := c.Response()
+		if resp.StatusCode() >= http.StatusInternalServerError {
+			if err := store.ReleaseIdempotencyKey(c.Context(), record); err != nil {
+				log.Printf("releasing idempotency key: %v", err)
+			}
+			return nil
+		}
+		// The response has been written already, a failure here only affects retries
+		if err := store.CompleteIdempotencyKey(c.Context(), record, resp.StatusCode(), string(resp.Header.ContentType()), resp.Body()); err != nil {
+			log.Printf("storing idempotent response: %v", err)
+		}
+		return nil
+	}
+}
+
+// renewLease keeps extending the lease of the record until the returned function is called
+// Renewing three times per lease keeps the key even if a renewal is slow or fails
+// once. The request is not stopped if its key is lost, but it will not store its response
+func renewLease(store db.IdempotencyStore, record *types.IdempotencyRecord, lease time.Duration) func(){
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := store.RenewIdempotencyKey(ctx, record, lease); err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					log.Printf("renewing idempotency key: %v", err)
+					if errors.Is(err, db.ErrIdempotencyClaimLost) {
+						return
+					}
+				}
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// replayResponse answers a retried request with the response of the first one
+func replayResponse(c *fiber.Ctx, record *types.IdempotencyRecord, requestHash string) error{
+	if record.RequestHash != requestHash {
+		return api.NewError(http.StatusUnprocessableEntity, "idempotency_key_reused", "Idempotency-Key was already used for a different request")
+	}
+	if !record.Completed {
+		return api.ErrConflict("idempotency_key_in_use", "a request with this Idempotency-Key is still being processed")
+	}
+	c.Set(IdempotentReplayedHeader, "true")
+	if record.ContentType != "" {
+		c.Set(fiber.HeaderContentType, record.ContentType)
+	}
+	return c.Status(record.StatusCode).Send(record.Body)
+}
@@ -1,45 +1,56 @@
 package middleware
 
 import (
-	"fmt"
-	"os"
-	"time"
+	"errors"
+	"net/http"
+	"strings"
 
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/keys"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // JWTAuthentication is a middleware function that checks if the request has a valid JWT token
 // This ensures that only authenticated users can access protected routes
-// It extracts the token from the X-Api-Token header, validates it, and checks if it's expired
-func JWTAuthentication(userStore db.UserStore) fiber.Handler{
+// The token is taken from the Authorization: Bearer header or the X-Api-Token header,
+// its signature is checked with the key named by its kid header, expiry, issuer and
+// audience are validated against the configuration,
+// it must not have been revoked by logging out, and the user it was issued for is
+// loaded into the request context. Role checks use that user, not the role claim,
+// so they always see the current role and hotels
+func JWTAuthentication(userStore db.UserStore, tokenStore db.TokenStore, keySet *keys.KeySet, authCfg config.AuthConfig) fiber.Handler{
     return func(c *fiber.Ctx) error {
-	// Get the token from the request header
-	token := c.Get("X-Api-Token")
+	// Get the token from the request headers
+	token := tokenFromRequest(c)
+	if token == "" {
+		return api.ErrUnauthorized()
+	}
 	
 	// Validate the token and get its claims (payload data)
-	claims, err := validateToken(token)
+	claims, err := validateToken(token, keySet, authCfg)
 	if err != nil {
 		return err
 	}
-	
-	// Check if the token has expired
-	// First convert the expiration time from float64 to int64
-	expiresFloat := claims["expires"].(float64)
-	expires := int64(expiresFloat)
-	
-	// Compare current time with expiration time
-	if time.Now().Unix() > expires {
-		return fmt.Errorf("token expired")
+
+	// Tokens are revoked by their ID when logging out
+	revoked, err := tokenStore.IsAccessTokenRevoked(c.Context(), claims.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return api.NewError(http.StatusUnauthorized, "token_revoked", "token revoked")
 	}
-	userID := claims["id"].(string)
-	user,err := userStore.GetUserById(c.Context(),userID)
+	
+	user,err := userStore.GetUserById(c.Context(),claims.Subject)
 	if err != nil{
-		return fmt.Errorf("Unauthorized")
+		return api.ErrUnauthorized()
 	}
 	// set the current authenticated user to the context.
 	c.Context().SetUserValue("user",user)
+	c.Context().SetUserValue("claims",claims)
 
 	// If token is valid and not expired, continue to the next middleware or handler
 	return c.Next()
@@ -47,41 +58,47 @@ func JWTAuthentication(userStore db.UserStore) fiber.Handler{
 }
 }
 
-// validateToken checks if a JWT token is valid and returns its claims
-// It verifies the token signature using the JWT_SECRET environment variable
-// Returns the token claims if valid, or an error if not
-func validateToken(tokenStr string) (jwt.MapClaims, error) {
-	// Parse and validate the token
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		// Ensure the token uses the correct signing method (HMAC in this case)
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			fmt.Println("invalid signing methods", token.Header)
-			return nil, fmt.Errorf("Unauthorized")
+// tokenFromRequest returns the token sent with the request
+// The standard Authorization: Bearer header is preferred, X-Api-Token is still
+// accepted for existing clients
+func tokenFromRequest(c *fiber.Ctx) string {
+	if auth := c.Get(fiber.HeaderAuthorization); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if ok && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
 		}
-		
-		// Get the secret key from environment variables
-		secret := os.Getenv("JWT_SECRET")
-		return []byte(secret), nil
-	})
+		return ""
+	}
+	return c.Get("X-Api-Token")
+}
+
+// validateToken checks if a JWT token is valid and returns its claims
+// It verifies the signature with the matching key of the key set, requires an expiry, a
+// subject and an ID, and rejects tokens issued by someone else or for another audience
+func validateToken(tokenStr string, keySet *keys.KeySet, authCfg config.AuthConfig) (*api.Claims, error) {
+	claims := &api.Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, keySet.Keyfunc,
+		// Only accept the algorithms of our keys, never "none"
+		jwt.WithValidMethods(keySet.ValidMethods()),
+		jwt.WithIssuer(authCfg.Issuer),
+		jwt.WithAudience(authCfg.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	
 	// Handle parsing errors
 	if err != nil {
-		fmt.Println("failed to parse JWT token", err)
-		return nil, fmt.Errorf("unauthorized")
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, api.NewError(http.StatusUnauthorized, "token_expired", "token expired")
+		}
+		return nil, api.ErrUnauthorized()
 	}
 	
-	// Check if the token is valid overall
-	if !token.Valid {
-		fmt.Println("invalid token")
-		return nil, fmt.Errorf("unauthorized")
-	}
-
-	// Extract claims from the token
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, fmt.Errorf("unauthorized")
+	// Check if the token is valid overall, names the user it was issued for and
+	// has an ID it can be revoked by
+	if !token.Valid || claims.Subject == "" || claims.ID == "" {
+		return nil, api.ErrUnauthorized()
 	}
 	
 	return claims, nil
 }
-
@@ -0,0 +1,40 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileMailer writes every email to its own file instead of sending it
+// It is used during development, when no SMTP server is configured
+type FileMailer struct{
+	mu   sync.Mutex // Serializes writes so file names stay unique
+	dir  string     // Directory the emails are written to
+	from string     // Sender address written into the files
+	seq  int        // Number of emails written so far
+}
+
+// NewFileMailer creates a new FileMailer writing to dir, creating it if needed
+func NewFileMailer(dir, from string) (*FileMailer, error){
+	if err := os.MkdirAll(dir, 0700); err != nil{
+		return nil, err
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to a new .eml file in the mail directory
+func (m *FileMailer) Send(ctx context.Context, msg Message) error{
+	if err := msg.validate(); err != nil{
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().UTC().Format("20060102T150405"), m.seq)
+	// The emails contain reset links, so only the owner may read them
+	return os.WriteFile(filepath.Join(m.dir, name), format(m.from, msg), 0600)
+}
@@ -0,0 +1,44 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+)
+
+// Message is an email sent to a user
+type Message struct{
+	To      string // Recipient address
+	Subject string // Subject line
+	Body    string // Plain text body
+}
+
+// Mailer sends emails
+// The application sends mail through SMTPMailer; FileMailer and MemoryMailer keep
+// the messages locally for development and tests
+type Mailer interface{
+	Send(context.Context, Message) error
+}
+
+// NewMailer creates the mailer described by the configuration
+// Mail is sent over SMTP if a host is configured, otherwise it is written to files
+// in the mail directory, so nothing leaves the machine during development
+func NewMailer(cfg config.MailConfig) (Mailer, error){
+	if cfg.SMTP.Host != ""{
+		return NewSMTPMailer(cfg), nil
+	}
+	return NewFileMailer(cfg.Dir, cfg.From)
+}
+
+// validate rejects messages that cannot be sent or would let a user inject headers
+func (m Message) validate() error{
+	if m.To == ""{
+		return errors.New("message has no recipient")
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n"){
+		return errors.New("message headers must not contain line breaks")
+	}
+	return nil
+}
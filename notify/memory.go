@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryMailer keeps the emails in memory instead of sending them
+// Tests use it to check what was sent and to follow the links in the emails
+type MemoryMailer struct{
+	mu   sync.Mutex
+	sent []Message // Sent messages, oldest first
+}
+
+// NewMemoryMailer creates a new, empty MemoryMailer
+func NewMemoryMailer() *MemoryMailer{
+	return &MemoryMailer{}
+}
+
+// Send records the message
+func (m *MemoryMailer) Send(ctx context.Context, msg Message) error{
+	if err := msg.validate(); err != nil{
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// Messages returns the messages sent to the given address, oldest first
+func (m *MemoryMailer) Messages(to string) []Message{
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var messages []Message
+	for _, msg := range m.sent{
+		if msg.To == to{
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// Last returns the last message sent to the given address
+// The second return value is false if nothing was sent to it
+func (m *MemoryMailer) Last(to string) (Message, bool){
+	messages := m.Messages(to)
+	if len(messages) == 0{
+		return Message{}, false
+	}
+	return messages[len(messages)-1], true
+}
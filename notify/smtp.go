@@ -0,0 +1,70 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+)
+
+// SMTPMailer sends emails through an SMTP server
+// The connection is upgraded with STARTTLS when the server offers it, which
+// net/smtp requires before it sends any credentials
+type SMTPMailer struct{
+	addr string    // host:port of the SMTP server
+	from string    // Sender address
+	auth smtp.Auth // Credentials, nil if the server needs none
+}
+
+// NewSMTPMailer creates a new SMTPMailer for the configured server
+func NewSMTPMailer(cfg config.MailConfig) *SMTPMailer{
+	m := &SMTPMailer{
+		addr: net.JoinHostPort(cfg.SMTP.Host, strconv.Itoa(cfg.SMTP.Port)),
+		from: cfg.From,
+	}
+	if cfg.SMTP.Username != ""{
+		m.auth = smtp.PlainAuth("", cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.Host)
+	}
+	return m
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error{
+	if err := msg.validate(); err != nil{
+		return err
+	}
+	from, err := mail.ParseAddress(m.from)
+	if err != nil{
+		return fmt.Errorf("invalid sender address: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil{
+		return fmt.Errorf("invalid recipient address: %w", err)
+	}
+
+	// smtp.SendMail takes no context, so honor a cancelled request at least up front
+	if err := ctx.Err(); err != nil{
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, from.Address, []string{to.Address}, format(m.from, msg))
+}
+
+// format renders the message in Internet Message Format (RFC 5322)
+func format(from string, msg Message) []byte{
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(msg.Body, "\r\n", "\n"), "\n", "\r\n"))
+	return []byte(b.String())
+}
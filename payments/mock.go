@@ -0,0 +1,189 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/types"
+)
+
+// Payment methods understood by MockProvider
+const (
+	MockCardApproved = "pm_card_approved" // Authorized right away
+	MockCardDeclined = "pm_card_declined" // Declined right away
+	MockCardPending  = "pm_card_pending"  // Pending until CompletePayment reports the outcome
+)
+
+// MockProvider is a payment gateway that runs in the process
+// It is used during development and in tests: the outcome of an authorization
+// only depends on the payment method, see MockCardApproved and the others, and
+// payment references are numbered in order, so runs are repeatable. Nothing
+// survives a restart
+type MockProvider struct{
+	mu       sync.Mutex              // Guards payments and seq
+	secret   []byte                  // Key the webhooks are signed with
+	payments map[string]*mockPayment // Payments by reference
+	seq      int                     // Number of payments and events created so far
+}
+
+// mockPayment is the state of a payment at the mock gateway
+type mockPayment struct{
+	status   types.PaymentStatus
+	amount   float64 // Authorized amount
+	captured float64 // Amount charged
+}
+
+// NewMockProvider creates a MockProvider signing its webhooks with secret
+func NewMockProvider(secret string) *MockProvider{
+	return &MockProvider{
+		secret:   []byte(secret),
+		payments: map[string]*mockPayment{},
+	}
+}
+
+// Name returns the name stored with the payments of this provider
+func (p *MockProvider) Name() string{
+	return config.PaymentProviderMock
+}
+
+// Authorize reserves the amount, declines it or leaves it pending depending on the payment method
+// Unknown payment methods are declined
+func (p *MockProvider) Authorize(ctx context.Context, req AuthorizeRequest) (*Result, error){
+	if req.Amount <= 0{
+		return nil, errors.New("amount must be positive")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.seq++
+	ref := fmt.Sprintf("mock_pay_%d", p.seq)
+	payment := &mockPayment{amount: req.Amount}
+	result := &Result{PaymentRef: ref}
+	switch req.PaymentMethod{
+	case MockCardApproved:
+		payment.status = types.PaymentStatusAuthorized
+	case MockCardPending:
+		payment.status = types.PaymentStatusPending
+	case MockCardDeclined:
+		payment.status = types.PaymentStatusFailed
+		result.FailureReason = "card_declined"
+	default:
+		payment.status = types.PaymentStatusFailed
+		result.FailureReason = "invalid_payment_method"
+	}
+	p.payments[ref] = payment
+	result.Status = payment.status
+	return result, nil
+}
+
+// Capture charges up to the authorized amount of the payment
+func (p *MockProvider) Capture(ctx context.Context, paymentRef string, amount float64) (*Result, error){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentRef]
+	if !ok{
+		return nil, ErrUnknownPayment
+	}
+	if payment.status != types.PaymentStatusAuthorized{
+		return nil, fmt.Errorf("cannot capture a payment that is %s", payment.status)
+	}
+	if amount <= 0 || amount > payment.amount{
+		return nil, fmt.Errorf("can capture at most %.2f", payment.amount)
+	}
+	payment.status = types.PaymentStatusCaptured
+	payment.captured = amount
+	return &Result{PaymentRef: paymentRef, Status: payment.status}, nil
+}
+
+// Refund gives back the captured amount of the payment
+// An authorized payment that was never captured is released as a whole instead
+func (p *MockProvider) Refund(ctx context.Context, paymentRef string, amount float64) (*Result, error){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentRef]
+	if !ok{
+		return nil, ErrUnknownPayment
+	}
+	switch payment.status{
+	case types.PaymentStatusAuthorized:
+	case types.PaymentStatusCaptured:
+		if amount <= 0 || amount > payment.captured{
+			return nil, fmt.Errorf("can refund at most %.2f", payment.captured)
+		}
+		payment.captured = math.Round((payment.captured-amount)*100) / 100
+	default:
+		return nil, fmt.Errorf("cannot refund a payment that is %s", payment.status)
+	}
+	if payment.captured == 0{
+		payment.status = types.PaymentStatusRefunded
+	}
+	return &Result{PaymentRef: paymentRef, Status: payment.status}, nil
+}
+
+// CompletePayment decides a pending payment, like a real gateway does once the
+// guest has confirmed it with their bank, and returns the webhook the gateway
+// would send about it together with its signature
+func (p *MockProvider) CompletePayment(paymentRef string, succeeded bool) ([]byte, string, error){
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentRef]
+	if !ok{
+		return nil, "", ErrUnknownPayment
+	}
+	if payment.status != types.PaymentStatusPending{
+		return nil, "", fmt.Errorf("payment is already %s", payment.status)
+	}
+	p.seq++
+	event := Event{ID: fmt.Sprintf("mock_evt_%d", p.seq), PaymentRef: paymentRef}
+	if succeeded{
+		payment.status = types.PaymentStatusAuthorized
+		event.Type = EventPaymentSucceeded
+	} else{
+		payment.status = types.PaymentStatusFailed
+		event.Type = EventPaymentFailed
+		event.FailureReason = "authentication_failed"
+	}
+	payload, err := json.Marshal(event)
+	if err != nil{
+		return nil, "", err
+	}
+	return payload, p.Sign(payload), nil
+}
+
+// Sign returns the signature of a webhook payload: the hex encoded HMAC-SHA256
+// of the payload, keyed with the webhook secret
+func (p *MockProvider) Sign(payload []byte) string{
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ParseWebhook checks the signature of the payload and decodes the event in it
+// Without a secret anybody could sign a webhook, so none is accepted
+func (p *MockProvider) ParseWebhook(payload []byte, signature string) (*Event, error){
+	if len(p.secret) == 0{
+		return nil, ErrNoWebhookSecret
+	}
+	if !hmac.Equal([]byte(signature), []byte(p.Sign(payload))){
+		return nil, ErrInvalidSignature
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil{
+		return nil, err
+	}
+	if err := event.validate(); err != nil{
+		return nil, err
+	}
+	return &event, nil
+}
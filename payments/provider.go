@@ -0,0 +1,87 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/types"
+)
+
+// ErrInvalidSignature is returned for webhooks that were not signed by the gateway
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// ErrNoWebhookSecret is returned when webhooks are received without a secret to verify them with
+var ErrNoWebhookSecret = errors.New("no webhook secret configured")
+
+// ErrUnknownPayment is returned when the gateway does not know the payment
+var ErrUnknownPayment = errors.New("unknown payment")
+
+// Provider talks to a payment gateway
+// Bookings are paid in two steps: the total is authorized, i.e. reserved on the
+// guest's payment method, when the booking is made and captured later. A decline
+// is a normal outcome reported in the Result; errors mean the gateway could not
+// be asked. Amounts are in the major unit of the currency, like booking prices
+type Provider interface{
+	Name() string                                                                    // Name stored with the payments, e.g. mock
+	Authorize(context.Context, AuthorizeRequest) (*Result, error)                    // Reserve the amount on the payment method
+	Capture(ctx context.Context, paymentRef string, amount float64) (*Result, error) // Charge up to the authorized amount
+	Refund(ctx context.Context, paymentRef string, amount float64) (*Result, error)  // Give back a captured amount, or release an authorization
+	ParseWebhook(payload []byte, signature string) (*Event, error)                   // Verify and decode a webhook sent by the gateway
+}
+
+// AuthorizeRequest describes the amount to authorize for a booking
+type AuthorizeRequest struct{
+	Reference     string  // Our reference for the payment, the booking ID
+	Amount        float64 // Amount to reserve
+	Currency      string  // ISO 4217 currency of the amount
+	PaymentMethod string  // Token of the guest's payment method, created by the gateway's client library
+}
+
+// Result is the state of a payment after a call to the gateway
+type Result struct{
+	PaymentRef    string              // ID of the payment at the gateway
+	Status        types.PaymentStatus // State of the payment
+	FailureReason string              // Why the gateway declined the payment, if it did
+}
+
+// EventType says what happened to a payment
+type EventType string
+
+const (
+	EventPaymentSucceeded EventType = "payment.succeeded" // A pending payment was authorized
+	EventPaymentFailed    EventType = "payment.failed"    // A pending payment was declined
+)
+
+// Event is a change to a payment reported by the gateway through a webhook
+type Event struct{
+	ID            string    `json:"id"`                      // ID of the event at the gateway
+	Type          EventType `json:"type"`                    // What happened
+	PaymentRef    string    `json:"paymentRef"`              // ID of the payment at the gateway
+	FailureReason string    `json:"failureReason,omitempty"` // Why the payment was declined
+}
+
+// validate rejects events the API does not know how to handle
+func (e *Event) validate() error{
+	if e.PaymentRef == ""{
+		return errors.New("event has no payment")
+	}
+	switch e.Type{
+	case EventPaymentSucceeded, EventPaymentFailed:
+		return nil
+	}
+	return fmt.Errorf("unsupported event type %q", e.Type)
+}
+
+// NewProvider creates the payment provider described by the configuration
+func NewProvider(cfg config.PaymentsConfig) (Provider, error){
+	switch cfg.Provider{
+	case config.PaymentProviderMock:
+		if cfg.WebhookSecret == ""{
+			return nil, ErrNoWebhookSecret
+		}
+		return NewMockProvider(cfg.WebhookSecret), nil
+	}
+	return nil, fmt.Errorf("unsupported payment provider %q", cfg.Provider)
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -17,6 +19,7 @@ var hotelStore db.HotelStore    // Interface to work with hotels collection
 var roomStore db.RoomStore      // Interface to work with rooms collection
 var ctx = context.Background()  // Context for database operations
 var userStore db.UserStore      // Interface to work with users collection
+var adminPassword string        // Password of the admin account, from SEED_ADMIN_PASSWORD
 
 // seedHotel creates a new hotel with the given parameters and adds two rooms to it
 // This is a helper function to populate the database with sample hotel data
@@ -32,11 +35,17 @@ func seedHotel(rating int, name, location string) {
 	// Define sample rooms to add to this hotel
 	rooms := []types.Room{
 		{
+			Type: types.SingleRoomType,
 			Size: "small",
 			Price: 99,
 		}, {
+			Type: types.DoubleRoomType,
 			Size: "normal",
 			Price: 899,
+		}, {
+			Type: types.DeluxRoomType,
+			Size: "large",
+			Price: 1499,
 		},
 	}
 	
@@ -63,45 +72,69 @@ func main() {
 	seedHotel(3, "Bellucia", "France")
 	seedHotel(4, "Sandrosso", "Roorkee")
 	
-	// Seed a sample user
-	seedUser("anshuman", "yadav", "anshumaniitre9@gmail.com")
+	// Seed a sample user and an administrator
+	seedUser("anshuman", "yadav", "anshumaniitre9@gmail.com", "upersecrepassword", types.RoleGuest)
+	seedUser("admin", "admin", "admin@hotel-reservation.com", adminPassword, types.RoleAdmin)
 }
 
 // init is called before main() automatically by Go
 // It sets up the database connection and initializes the stores
 func init() {
-	var err error
-	
+	// Seed the same database the API server uses
+	// Only the database settings matter here, so the configuration is not validated
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The admin account gets full access, so it never has a well-known password
+	// Checked before the database is dropped, so a missing password changes nothing
+	adminPassword = os.Getenv("SEED_ADMIN_PASSWORD")
+	if adminPassword == "" {
+		log.Fatal("SEED_ADMIN_PASSWORD must be set to the password of the admin account")
+	}
+
 	// Connect to MongoDB
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(db.DBURI))
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(cfg.DB.URI))
 	if err != nil {
 		log.Fatal(err)
 	}
 	
 	// Drop the existing database to start fresh
-	if err := client.Database(db.DBNAME).Drop(ctx); err != nil {
+	if err := client.Database(cfg.DB.Name).Drop(ctx); err != nil {
 		log.Fatal(err)
 	}
 	
 	// Initialize stores for database operations
-	hotelStore = db.NewMongoHotelStore(client)
-	roomStore = db.NewMongoRoomStore(client, hotelStore)
-	userStore = db.NewMongoUserStore(client)
+	hotelStore = db.NewMongoHotelStore(client, cfg.DB.Name)
+	roomStore = db.NewMongoRoomStore(client, cfg.DB.Name, hotelStore)
+	userStore, err = db.NewMongoUserStore(ctx, client, cfg.DB.Name)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
-// seedUser creates a new user with the given parameters
+// seedUser creates a new user with the given parameters and role
 // This is a helper function to populate the database with sample user data
-func seedUser(fname, lname, email string) {
-	// Create a new user from parameters
-	user, err := types.NewUserFromParams(types.CreateUserParams{
+func seedUser(fname, lname, email, password string, role types.Role) {
+	params := types.CreateUserParams{
 		Email: email,
 		FirstName: fname,
 		LastName: lname,
-		Password: "upersecrepassword",  // Note: In a real app, use strong unique passwords
-	})
+		Password: password,
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		log.Fatalf("invalid user %s: %v", email, errors)
+	}
+
+	// Create a new user from parameters
+	user, err := types.NewUserFromParams(params)
 	if err != nil {
 		log.Fatal(err)
 	}
+	user.Role = role
+	// Sample users cannot receive the verification email
+	user.EmailVerified = true
 	
 	// Insert the user into the database
 	_, err = userStore.InsertUser(ctx, user)
@@ -6,17 +6,45 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/0x0Glitch/hotel-reservation/middleware"
+	"github.com/0x0Glitch/hotel-reservation/notify"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// testAuthConfig is the token configuration of the test server
+var testAuthConfig = config.AuthConfig{
+	JWTSecret:  "test-jwt-secret",
+	TokenTTL:   time.Hour,
+	RefreshTTL: 24 * time.Hour,
+	Issuer:     "hotel-reservation-test",
+	Audience:   "hotel-reservation-test-api",
+	ResetTTL:   time.Hour,
+	ResetURL:   "http://localhost:5001/reset-password",
+	VerifyTTL:  time.Hour,
+	VerifyURL:  "http://localhost:5001/api/auth/verify",
+}
+
+// testLoginConfig is the login throttling of the test server
+// The backoff is short enough not to get in the way of tests retrying a login
+var testLoginConfig = config.LoginConfig{
+	MaxFailures:   5,
+	MaxIPFailures: 20,
+	Backoff:       time.Millisecond,
+	MaxBackoff:    time.Millisecond,
+	Lockout:       time.Hour,
+}
+
 // Test login request parameters
 type loginReq struct {
 	Email    string `json:"email"`
@@ -25,49 +53,72 @@ type loginReq struct {
 
 // Test login response structure
 type loginResp struct {
-	Token string `json:"token"`
-	User  types.User `json:"user"`
+	Token        string     `json:"token"`
+	RefreshToken string     `json:"refreshToken"`
+	User         types.User `json:"user"`
 }
 
 // setupAuth creates a test server with auth routes configured
-func setupAuth(t *testing.T) (*fiber.App, *mongo.Client, func()) {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		t.Fatalf("Error connecting to MongoDB: %v", err)
-	}
+func setupAuth(t *testing.T) (*fiber.App, *db.MemoryDatabase, func()) {
+	return setupAuthWith(t, testAuthConfig)
+}
 
-	// Initialize user store and handlers
-	userStore := db.NewMongoUserStore(client)
-	authHandler := api.NewAuthHandler(userStore)
+// setupAuthWith creates a test server with auth routes using the given token configuration
+func setupAuthWith(t *testing.T, authCfg config.AuthConfig) (*fiber.App, *db.MemoryDatabase, func()) {
+	return setupAuthMailer(t, authCfg, notify.NewMemoryMailer())
+}
+
+// setupAuthMailer creates a test server with auth routes that sends its emails with the given mailer
+func setupAuthMailer(t *testing.T, authCfg config.AuthConfig, mailer notify.Mailer) (*fiber.App, *db.MemoryDatabase, func()) {
+	return setupAuthServer(t, authCfg, testLoginConfig, mailer)
+}
+
+// setupAuthServer creates a test server with auth routes using the given token and login configuration
+func setupAuthServer(t *testing.T, authCfg config.AuthConfig, loginCfg config.LoginConfig, mailer notify.Mailer) (*fiber.App, *db.MemoryDatabase, func()) {
+	database := db.NewMemoryDatabase()
 
-	// Clean up the user collection before test
-	_, err = client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
+	// Initialize user store and handlers
+	userStore := db.NewMemoryUserStore(database)
+	tokenStore := db.NewMemoryTokenStore(database)
+	loginStore := db.NewMemoryLoginStore(database)
+	keySet, err := keys.NewKeySet(authCfg)
 	if err != nil {
-		t.Fatalf("Error cleaning users collection: %v", err)
+		t.Fatalf("Error loading keys: %v", err)
 	}
+	limiter := api.NewLoginLimiter(loginStore, loginCfg)
+	authHandler := api.NewAuthHandler(userStore, tokenStore, keySet, mailer, limiter, authCfg)
+	lockoutHandler := api.NewLockoutHandler(userStore, loginStore, limiter)
+	authenticated := middleware.JWTAuthentication(userStore, tokenStore, keySet, authCfg)
+	admin := middleware.RequireRoles(types.RoleAdmin)
 
 	// Setup Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
 	
 	// Setup auth routes manually
 	app.Post("/api/auth/login", authHandler.HandleAuthentication)
+	app.Post("/api/register", authHandler.HandleRegister)
+	app.Get("/.well-known/jwks.json", api.NewJWKSHandler(keySet).HandleGetJWKS)
+	app.Post("/api/auth/refresh", authHandler.HandleRefresh)
+	app.Post("/api/auth/forgot", authHandler.HandleForgotPassword)
+	app.Post("/api/auth/reset", authHandler.HandleResetPassword)
+	app.Get("/api/auth/verify", authHandler.HandleVerifyEmail)
+	app.Post("/api/auth/verify/resend", authenticated, authHandler.HandleResendVerification)
+	app.Post("/api/auth/logout", authenticated, authHandler.HandleLogout)
+	app.Put("/api/v1/user/me/password", authenticated, authHandler.HandleChangePassword)
+	app.Post("/api/v1/user/:id/unlock", authenticated, admin, lockoutHandler.HandleUnlockUser)
+	app.Get("/api/v1/lockout", authenticated, admin, lockoutHandler.HandleGetLockouts)
+	// A protected route to check which access tokens are still accepted
+	app.Get("/api/v1/me", authenticated, func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
 
 	// Return test server and cleanup function
-	return app, client, func() {
-		// Clean up after test
-		_, err := client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
-		if err != nil {
-			t.Fatalf("Error cleaning users collection: %v", err)
-		}
-		if err := client.Disconnect(context.TODO()); err != nil {
-			t.Fatalf("Error disconnecting from MongoDB: %v", err)
-		}
-	}
+	return app, database, func() {}
 }
 
 // createTestUser creates a user for testing authentication
-func createTestUser(t *testing.T, client *mongo.Client) *types.User {
-	userStore := db.NewMongoUserStore(client)
+func createTestUser(t *testing.T, database *db.MemoryDatabase) *types.User {
+	userStore := db.NewMemoryUserStore(database)
 	
 	// Use a fixed ID for consistent test results
 	userID := primitive.NewObjectID()
@@ -100,11 +151,11 @@ func createTestUser(t *testing.T, client *mongo.Client) *types.User {
 
 // TestUserLogin tests the user login authentication
 func TestUserLogin(t *testing.T) {
-	app, client, cleanup := setupAuth(t)
+	app, database, cleanup := setupAuth(t)
 	defer cleanup()
 	
 	// Create a test user first
-	user := createTestUser(t, client)
+	user := createTestUser(t, database)
 	
 	// Prepare login request
 	login := loginReq{
@@ -141,8 +192,9 @@ func TestUserLogin(t *testing.T) {
 	
 	// Verify token is present
 	if loginResponse.Token == "" {
-		t.Errorf("Expected token to be present in response")
+		t.Fatalf("Expected token to be present in response")
 	}
+	checkTokenClaims(t, loginResponse.Token, user)
 	
 	// Verify user info
 	if loginResponse.User.ID != user.ID {
@@ -154,13 +206,48 @@ func TestUserLogin(t *testing.T) {
 	}
 }
 
+// checkTokenClaims verifies that the token uses the registered claims for the user
+func checkTokenClaims(t *testing.T, tokenStr string, user *types.User) {
+	t.Helper()
+
+	claims := &api.Claims{}
+	_, err := jwt.ParseWithClaims(tokenStr, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(testAuthConfig.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{"HS256"}))
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+
+	if claims.Subject != user.ID.Hex() {
+		t.Errorf("Expected sub %s, got %s", user.ID.Hex(), claims.Subject)
+	}
+	if claims.Issuer != testAuthConfig.Issuer {
+		t.Errorf("Expected iss %s, got %s", testAuthConfig.Issuer, claims.Issuer)
+	}
+	if len(claims.Audience) != 1 || claims.Audience[0] != testAuthConfig.Audience {
+		t.Errorf("Expected aud [%s], got %v", testAuthConfig.Audience, claims.Audience)
+	}
+	if claims.ID == "" {
+		t.Errorf("Expected jti to be set")
+	}
+	if claims.Email != user.Email {
+		t.Errorf("Expected email %s, got %s", user.Email, claims.Email)
+	}
+	if claims.IssuedAt == nil || claims.NotBefore == nil || claims.ExpiresAt == nil {
+		t.Fatalf("Expected iat, nbf and exp to be set")
+	}
+	if ttl := claims.ExpiresAt.Sub(claims.IssuedAt.Time); ttl != testAuthConfig.TokenTTL {
+		t.Errorf("Expected token to live %v, got %v", testAuthConfig.TokenTTL, ttl)
+	}
+}
+
 // TestFailedLogin tests authentication failure due to wrong password
 func TestFailedLogin(t *testing.T) {
-	app, client, cleanup := setupAuth(t)
+	app, database, cleanup := setupAuth(t)
 	defer cleanup()
 	
 	// Create a test user first
-	user := createTestUser(t, client)
+	user := createTestUser(t, database)
 	
 	// Prepare login request with wrong password
 	login := loginReq{
@@ -184,17 +271,358 @@ func TestFailedLogin(t *testing.T) {
 	}
 	defer resp.Body.Close()
 	
-	// Check status code - should be unauthorized or similar error
-	if resp.StatusCode == http.StatusOK {
-		t.Errorf("Expected non-OK status code for wrong password, got %v", resp.StatusCode)
+	// Check status code - should be unauthorized
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for wrong password, got %v", resp.StatusCode)
 	}
 	
-	// Don't try to parse the error response as JSON, just check that it's not empty
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	errorBody := buf.String()
+	// Parse the error response
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
 	
-	if errorBody == "" {
+	if apiErr.Reason != "invalid_credentials" {
+		t.Errorf("Expected reason invalid_credentials, got %q", apiErr.Reason)
+	}
+	if apiErr.Err == "" {
 		t.Errorf("Expected error message in response body")
 	}
-} 
\ No newline at end of file
+} 
+
+// register sends a registration request with the given params
+func register(t *testing.T, app *fiber.App, params types.CreateUserParams) *http.Response {
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Fatalf("Error marshaling registration params: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// TestRegister tests self-service registration
+func TestRegister(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	params := types.CreateUserParams{
+		FirstName: "New",
+		LastName:  "Guest",
+		Email:     "newguest@example.com",
+		Password:  "password123",
+	}
+	resp := register(t, app, params)
+	defer resp.Body.Close()
+
+	// Check status code
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v", resp.StatusCode)
+	}
+
+	// Parse response
+	var registerResponse loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&registerResponse); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	// Verify the user is logged in right away
+	if registerResponse.Token == "" {
+		t.Fatalf("Expected token to be present in response")
+	}
+	checkTokenClaims(t, registerResponse.Token, &registerResponse.User)
+	if registerResponse.User.Email != params.Email {
+		t.Errorf("Expected email %s, got %s", params.Email, registerResponse.User.Email)
+	}
+	if registerResponse.User.Role != types.RoleGuest {
+		t.Errorf("Expected role %s, got %s", types.RoleGuest, registerResponse.User.Role)
+	}
+
+	// The new credentials work for logging in
+	body, _ := json.Marshal(loginReq{Email: params.Email, Password: params.Password})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	loginResp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer loginResp.Body.Close()
+
+	if loginResp.StatusCode != http.StatusOK {
+		t.Errorf("Expected login with new account to succeed, got %v", loginResp.StatusCode)
+	}
+}
+
+// TestRegisterDuplicateEmail tests that an email address can only be registered once
+func TestRegisterDuplicateEmail(t *testing.T) {
+	app, database, cleanup := setupAuth(t)
+	defer cleanup()
+
+	// Create a test user first
+	user := createTestUser(t, database)
+
+	// Register again with the same email, in different case
+	resp := register(t, app, types.CreateUserParams{
+		FirstName: "Second",
+		LastName:  "Account",
+		Email:     strings.ToUpper(user.Email),
+		Password:  "password123",
+	})
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status Conflict, got %v", resp.StatusCode)
+	}
+}
+
+// TestRegisterInvalidParams tests that registration validates its input
+func TestRegisterInvalidParams(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	resp := register(t, app, types.CreateUserParams{
+		FirstName: "N",
+		LastName:  "Guest",
+		Email:     "not-an-email",
+		Password:  "short",
+	})
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status Bad Request, got %v", resp.StatusCode)
+	}
+
+	// The response lists every invalid field
+	var errors map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&errors); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	for _, field := range []string{"firstName", "email", "password"} {
+		if _, ok := errors[field]; !ok {
+			t.Errorf("Expected validation error for %s", field)
+		}
+	}
+}
+
+// login logs the test user in and returns the issued tokens
+func login(t *testing.T, app *fiber.App, user *types.User) loginResp {
+	body, _ := json.Marshal(loginReq{Email: user.Email, Password: "password123"})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected login to succeed, got %v", resp.StatusCode)
+	}
+
+	var tokens loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("Expected an access and a refresh token, got %+v", tokens)
+	}
+	return tokens
+}
+
+// refresh exchanges the refresh token and returns the response
+func refresh(t *testing.T, app *fiber.App, refreshToken string) *http.Response {
+	body, _ := json.Marshal(api.RefreshParams{RefreshToken: refreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/refresh", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// accessStatus returns the status of a protected request made with the access token
+func accessStatus(t *testing.T, app *fiber.App, token string) int {
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// expectReason checks the status and reason of an error response
+func expectReason(t *testing.T, resp *http.Response, status int, reason string) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("Expected status %v, got %v", status, resp.StatusCode)
+	}
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
+	if apiErr.Reason != reason {
+		t.Errorf("Expected reason %s, got %q", reason, apiErr.Reason)
+	}
+}
+
+// TestRefreshToken tests that refresh tokens are rotated on every use
+func TestRefreshToken(t *testing.T) {
+	app, database, cleanup := setupAuth(t)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+
+	resp := refresh(t, app, tokens.RefreshToken)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	var refreshed loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	// Both tokens are new and the access token works
+	if refreshed.Token == "" || refreshed.Token == tokens.Token {
+		t.Errorf("Expected a new access token")
+	}
+	if refreshed.RefreshToken == "" || refreshed.RefreshToken == tokens.RefreshToken {
+		t.Errorf("Expected a new refresh token")
+	}
+	checkTokenClaims(t, refreshed.Token, user)
+	if status := accessStatus(t, app, refreshed.Token); status != http.StatusOK {
+		t.Errorf("Expected the new access token to be accepted, got %v", status)
+	}
+
+	// The new refresh token can be used in turn
+	next := refresh(t, app, refreshed.RefreshToken)
+	defer next.Body.Close()
+	if next.StatusCode != http.StatusOK {
+		t.Errorf("Expected the rotated refresh token to work, got %v", next.StatusCode)
+	}
+}
+
+// TestRefreshTokenReuse tests that replaying a used refresh token revokes the whole family
+func TestRefreshTokenReuse(t *testing.T) {
+	app, database, cleanup := setupAuth(t)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+
+	// The legitimate client refreshes
+	resp := refresh(t, app, tokens.RefreshToken)
+	defer resp.Body.Close()
+	var refreshed loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	// Someone replays the old refresh token
+	replay := refresh(t, app, tokens.RefreshToken)
+	defer replay.Body.Close()
+	expectReason(t, replay, http.StatusUnauthorized, "refresh_token_reused")
+
+	// Every token of the family is dead now
+	rotated := refresh(t, app, refreshed.RefreshToken)
+	defer rotated.Body.Close()
+	expectReason(t, rotated, http.StatusUnauthorized, "invalid_refresh_token")
+	for _, token := range []string{tokens.Token, refreshed.Token} {
+		if status := accessStatus(t, app, token); status != http.StatusUnauthorized {
+			t.Errorf("Expected the access tokens of the family to be revoked, got %v", status)
+		}
+	}
+
+	// Other logins are not affected
+	other := login(t, app, user)
+	if status := accessStatus(t, app, other.Token); status != http.StatusOK {
+		t.Errorf("Expected a new login to work, got %v", status)
+	}
+}
+
+// TestRefreshTokenInvalid tests that unknown refresh tokens are rejected
+func TestRefreshTokenInvalid(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	resp := refresh(t, app, "not-a-refresh-token")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusUnauthorized, "invalid_refresh_token")
+
+	missing := refresh(t, app, "")
+	defer missing.Body.Close()
+	expectReason(t, missing, http.StatusBadRequest, "bad_request")
+}
+
+// TestLogout tests that logging out revokes the access and the refresh token
+func TestLogout(t *testing.T) {
+	app, database, cleanup := setupAuth(t)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+	other := login(t, app, user)
+
+	body, _ := json.Marshal(api.RefreshParams{RefreshToken: tokens.RefreshToken})
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status No Content, got %v", resp.StatusCode)
+	}
+
+	// The access token is rejected although it has not expired yet
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/me", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	me, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer me.Body.Close()
+	expectReason(t, me, http.StatusUnauthorized, "token_revoked")
+
+	// And so is the refresh token
+	refreshResp := refresh(t, app, tokens.RefreshToken)
+	defer refreshResp.Body.Close()
+	expectReason(t, refreshResp, http.StatusUnauthorized, "invalid_refresh_token")
+
+	// Sessions on other devices stay logged in
+	if status := accessStatus(t, app, other.Token); status != http.StatusOK {
+		t.Errorf("Expected the other session to stay valid, got %v", status)
+	}
+}
+
+// TestLogoutUnauthenticated tests that logging out requires an access token
+func TestLogoutUnauthenticated(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized, got %v", resp.StatusCode)
+	}
+}
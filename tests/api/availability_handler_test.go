@@ -0,0 +1,197 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestGetAvailability tests that only rooms free for the whole range are returned, grouped by hotel
+func TestGetAvailability(t *testing.T) {
+	app, store, user, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	availabilityHandler := api.NewAvailabilityHandler(store)
+	app.Get("/api/v1/availability", availabilityHandler.HandleGetAvailability)
+
+	rome, err := store.Hotel.Insert(context.TODO(), &types.Hotel{Name: "Rome Hotel", Location: "Rome", Rooms: []primitive.ObjectID{}, Rating: 4})
+	if err != nil {
+		t.Fatalf("Error inserting test hotel: %v", err)
+	}
+	paris, err := store.Hotel.Insert(context.TODO(), &types.Hotel{Name: "Paris Hotel", Location: "Paris", Rooms: []primitive.ObjectID{}, Rating: 5})
+	if err != nil {
+		t.Fatalf("Error inserting test hotel: %v", err)
+	}
+
+	var rooms []*types.Room
+	for _, hotelID := range []primitive.ObjectID{rome.ID, rome.ID, paris.ID} {
+		room, err := store.Room.InsertRoom(context.TODO(), &types.Room{Size: "normal", Price: 100, HotelID: hotelID})
+		if err != nil {
+			t.Fatalf("Error inserting test room: %v", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	// The first Rome room is booked over part of the range, the second one's booking was cancelled
+	from := time.Now().AddDate(0, 0, 10)
+	till := from.AddDate(0, 0, 4)
+	bookings := []*types.Booking{
+		{UserID: user.ID, RoomID: rooms[0].ID, FromDate: from.AddDate(0, 0, 2), TillDate: till.AddDate(0, 0, 2), Status: types.BookingStatusConfirmed},
+		{UserID: user.ID, RoomID: rooms[1].ID, FromDate: from, TillDate: till, Status: types.BookingStatusCancelled},
+	}
+	for _, booking := range bookings {
+		if _, err := store.Booking.InsertBooking(context.TODO(), booking); err != nil {
+			t.Fatalf("Error inserting test booking: %v", err)
+		}
+	}
+
+	query := "from=" + from.Format("2006-01-02") + "&till=" + till.Format("2006-01-02")
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected map[primitive.ObjectID][]primitive.ObjectID
+	}{
+		{
+			name:  "all locations",
+			query: query,
+			expected: map[primitive.ObjectID][]primitive.ObjectID{
+				rome.ID:  {rooms[1].ID},
+				paris.ID: {rooms[2].ID},
+			},
+		},
+		{
+			name:  "by location",
+			query: query + "&location=rom",
+			expected: map[primitive.ObjectID][]primitive.ObjectID{
+				rome.ID: {rooms[1].ID},
+			},
+		},
+		{
+			name:     "unknown location",
+			query:    query + "&location=berlin",
+			expected: map[primitive.ObjectID][]primitive.ObjectID{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/availability?"+tc.query, nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+			}
+
+			var result []api.HotelAvailability
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatalf("Error decoding response: %v", err)
+			}
+
+			if len(result) != len(tc.expected) {
+				t.Fatalf("Expected %d hotels, got %d", len(tc.expected), len(result))
+			}
+			for _, availability := range result {
+				expectedRooms, ok := tc.expected[availability.Hotel.ID]
+				if !ok {
+					t.Errorf("Unexpected hotel %s in results", availability.Hotel.Name)
+					continue
+				}
+				if len(availability.Rooms) != len(expectedRooms) {
+					t.Errorf("Expected %d free rooms in %s, got %d", len(expectedRooms), availability.Hotel.Name, len(availability.Rooms))
+					continue
+				}
+				for i, room := range availability.Rooms {
+					if room.ID != expectedRooms[i] {
+						t.Errorf("Expected room %v in %s, got %v", expectedRooms[i], availability.Hotel.Name, room.ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+// TestGetAvailabilityCheckOutDay tests that stays are compared by their nights, so a room is
+// free from the morning its guest checks out and until the afternoon the next guest checks in
+func TestGetAvailabilityCheckOutDay(t *testing.T) {
+	app, store, user, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	availabilityHandler := api.NewAvailabilityHandler(store)
+	app.Get("/api/v1/availability", availabilityHandler.HandleGetAvailability)
+
+	hotel, err := store.Hotel.Insert(context.TODO(), &types.Hotel{Name: "Rome Hotel", Location: "Rome", Rooms: []primitive.ObjectID{}, Rating: 4})
+	if err != nil {
+		t.Fatalf("Error inserting test hotel: %v", err)
+	}
+	room, err := store.Room.InsertRoom(context.TODO(), &types.Room{Size: "normal", Price: 100, HotelID: hotel.ID})
+	if err != nil {
+		t.Fatalf("Error inserting test room: %v", err)
+	}
+
+	// Search from the 10th to the 12th, one guest leaves on the 10th at 11:00, the next arrives on the 12th at 15:00
+	checkIn := time.Now().UTC().AddDate(0, 0, 10).Truncate(24 * time.Hour)
+	checkOut := checkIn.AddDate(0, 0, 2)
+	bookings := []*types.Booking{
+		{UserID: user.ID, RoomID: room.ID, FromDate: checkIn.AddDate(0, 0, -2).Add(15 * time.Hour), TillDate: checkIn.Add(11 * time.Hour), Status: types.BookingStatusConfirmed},
+		{UserID: user.ID, RoomID: room.ID, FromDate: checkOut.Add(15 * time.Hour), TillDate: checkOut.AddDate(0, 0, 2).Add(11 * time.Hour), Status: types.BookingStatusConfirmed},
+	}
+	for _, booking := range bookings {
+		if _, err := store.Booking.InsertBooking(context.TODO(), booking); err != nil {
+			t.Fatalf("Error inserting test booking: %v", err)
+		}
+	}
+
+	var result []api.HotelAvailability
+	resp := getJSON(t, app, "/api/v1/availability?from="+checkIn.Format("2006-01-02")+"&till="+checkOut.Format("2006-01-02"), &result)
+	defer resp.Body.Close()
+	if len(result) != 1 || len(result[0].Rooms) != 1 || result[0].Rooms[0].ID != room.ID {
+		t.Errorf("Expected the room to be free, got %+v", result)
+	}
+}
+
+// TestGetAvailabilityInvalidParams tests that malformed searches are rejected
+func TestGetAvailabilityInvalidParams(t *testing.T) {
+	app, store, _, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	availabilityHandler := api.NewAvailabilityHandler(store)
+	app.Get("/api/v1/availability", availabilityHandler.HandleGetAvailability)
+
+	from := time.Now().AddDate(0, 0, 10).Format("2006-01-02")
+	till := time.Now().AddDate(0, 0, 12).Format("2006-01-02")
+
+	queries := map[string]string{
+		"missing dates":     "",
+		"malformed date":    "from=tomorrow&till=" + till,
+		"till before from":  "from=" + till + "&till=" + from,
+		"dates in the past": "from=2020-01-01&till=2020-01-03",
+		"negative guests":   "from=" + from + "&till=" + till + "&guests=-1",
+	}
+
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/availability?"+query, nil)
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request, got %v", resp.StatusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,778 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bookingTestEnv holds the server and stores for booking tests
+// Requests are authenticated as whichever user was passed to login last
+type bookingTestEnv struct {
+	app      *fiber.App
+	store    *db.Store
+	user     *types.User
+	provider *payments.MockProvider // Payment gateway the bookings are paid through
+}
+
+// login makes the following requests run as the given user
+func (env *bookingTestEnv) login(user *types.User) {
+	env.user = user
+}
+
+// setupBookingTest creates a test server with the room and booking routes
+func setupBookingTest(t *testing.T) (*bookingTestEnv, func()) {
+	database := db.NewMemoryDatabase()
+
+	// Initialize stores and handlers
+	hotelStore := db.NewMemoryHotelStore(database)
+	store := &db.Store{
+		Hotel:   hotelStore,
+		Room:    db.NewMemoryRoomStore(database, hotelStore),
+		User:    db.NewMemoryUserStore(database),
+		Booking: db.NewMemoryBookingStore(database),
+		Payment: db.NewMemoryPaymentStore(database),
+	}
+	cfg := config.Default()
+	provider := payments.NewMockProvider("test-secret")
+	roomHandler := api.NewRoomHandler(store, cfg.Booking, provider, cfg.Payments)
+	bookingHandler := api.NewBookingHandler(store, provider, cfg.Payments)
+	paymentHandler := api.NewPaymentHandler(store, provider, cfg.Payments)
+
+	env := &bookingTestEnv{store: store, provider: provider}
+
+	// Setup Fiber app, standing in for the JWT middleware by setting the user directly
+	env.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	env.app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", env.user)
+		return c.Next()
+	})
+	env.app.Post("/api/v1/room/:id/book", roomHandler.HandleBookRoom)
+	env.app.Post("/api/v1/room/:id/hold", roomHandler.HandleHoldRoom)
+	env.app.Get("/api/v1/booking", bookingHandler.HandleGetBookings)
+	env.app.Get("/api/v1/booking/:id", bookingHandler.HandleGetBooking)
+	env.app.Put("/api/v1/booking/:id/cancel", bookingHandler.HandleCancelBooking)
+	env.app.Put("/api/v1/booking/:id/confirm", bookingHandler.HandleConfirmBooking)
+	env.app.Patch("/api/v1/booking/:id", bookingHandler.HandleModifyBooking)
+	env.app.Post("/api/payments/webhook", paymentHandler.HandleWebhook)
+
+	// Return test environment and cleanup function
+	return env, func() {}
+}
+
+// newTestGuest returns a user that is not stored anywhere, which is enough for
+// handlers that only read the authenticated user from the request
+func newTestGuest(email string) *types.User {
+	return &types.User{
+		ID:        primitive.NewObjectID(),
+		FirstName: "Guest",
+		LastName:  "User",
+		Email:     email,
+	}
+}
+
+// createTestBooking books the room for three nights starting in ten days
+func createTestBooking(t *testing.T, env *bookingTestEnv, room *types.Room) *types.Booking {
+	from := time.Now().AddDate(0, 0, 10)
+	resp := bookRoom(t, env.app, room.ID, api.BookRoomParams{
+		FromDate:   from,
+		TillDate:   from.AddDate(0, 0, 3),
+		NumPersons: 1,
+	})
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK when booking, got %v", resp.StatusCode)
+	}
+
+	var booking types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&booking); err != nil {
+		t.Fatalf("Error decoding booking: %v", err)
+	}
+	return &booking
+}
+
+// cancelBooking sends a cancellation request for the given booking
+func cancelBooking(t *testing.T, app *fiber.App, bookingID primitive.ObjectID) *http.Response {
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/v1/booking/%s/cancel", bookingID.Hex()), nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// TestCancelBooking tests that a guest can cancel their booking and the nights become free again
+func TestCancelBooking(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	if booking.Status != types.BookingStatusConfirmed {
+		t.Errorf("Expected new booking to be %s, got %s", types.BookingStatusConfirmed, booking.Status)
+	}
+
+	// Cancel the booking
+	resp := cancelBooking(t, env.app, booking.ID)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	var cancelled types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&cancelled); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if cancelled.Status != types.BookingStatusCancelled {
+		t.Errorf("Expected status %s, got %s", types.BookingStatusCancelled, cancelled.Status)
+	}
+
+	// Another guest can now book the same nights
+	env.login(newTestGuest("other@example.com"))
+	createTestBooking(t, env, room)
+
+	// Cancelling twice is rejected
+	env.login(&types.User{ID: booking.UserID})
+	again := cancelBooking(t, env.app, booking.ID)
+	defer again.Body.Close()
+
+	if again.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status Conflict when cancelling twice, got %v", again.StatusCode)
+	}
+}
+
+// TestCancelBookingOfOtherUser tests that guests cannot cancel bookings they do not own
+func TestCancelBookingOfOtherUser(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	// Another guest tries to cancel
+	env.login(newTestGuest("intruder@example.com"))
+	resp := cancelBooking(t, env.app, booking.ID)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden when cancelling another user's booking, got %v", resp.StatusCode)
+	}
+
+	// An admin can cancel it
+	admin := newTestGuest("admin@example.com")
+	admin.Role = types.RoleAdmin
+	env.login(admin)
+	resp = cancelBooking(t, env.app, booking.ID)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected admin to cancel the booking, got %v", resp.StatusCode)
+	}
+}
+
+// getJSON sends a GET request and decodes a successful response into v
+func getJSON(t *testing.T, app *fiber.App, url string, v interface{}) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	if resp.StatusCode == http.StatusOK && v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("Error decoding response: %v", err)
+		}
+	}
+	return resp
+}
+
+// TestGetBookings tests listing the current user's bookings
+func TestGetBookings(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+
+	// Someone else books the room first
+	env.login(newTestGuest("other@example.com"))
+	other := createTestBooking(t, env, room)
+	cancelBooking(t, env.app, other.ID).Body.Close()
+
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	// Only the caller's booking is listed
+	var bookings []types.Booking
+	resp := getJSON(t, env.app, "/api/v1/booking", &bookings)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if len(bookings) != 1 || bookings[0].ID != booking.ID {
+		t.Fatalf("Expected only booking %v, got %+v", booking.ID, bookings)
+	}
+
+	// Filters
+	testCases := []struct {
+		query    string
+		expected int
+	}{
+		{"?when=upcoming", 1},
+		{"?when=past", 0},
+		{"?status=confirmed", 1},
+		{"?status=cancelled", 0},
+		{"?when=upcoming&status=confirmed", 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			var filtered []types.Booking
+			resp := getJSON(t, env.app, "/api/v1/booking"+tc.query, &filtered)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+			}
+			if len(filtered) != tc.expected {
+				t.Errorf("Expected %d bookings, got %d", tc.expected, len(filtered))
+			}
+		})
+	}
+
+	// Unknown filter value
+	bad := getJSON(t, env.app, "/api/v1/booking?when=tomorrow", nil)
+	defer bad.Body.Close()
+
+	if bad.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", bad.StatusCode)
+	}
+}
+
+// TestGetBooking tests fetching a booking with its room and hotel embedded
+func TestGetBooking(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	var detail api.BookingDetail
+	resp := getJSON(t, env.app, "/api/v1/booking/"+booking.ID.Hex(), &detail)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if detail.Booking == nil || detail.ID != booking.ID {
+		t.Fatalf("Expected booking %v in response", booking.ID)
+	}
+	if detail.Room == nil || detail.Room.ID != room.ID {
+		t.Errorf("Expected room %v to be embedded", room.ID)
+	}
+	if detail.Hotel == nil || detail.Hotel.ID != room.HotelID {
+		t.Errorf("Expected hotel %v to be embedded", room.HotelID)
+	}
+}
+
+// TestGetBookingDeletedRoom tests that bookings of deleted rooms can still be read
+func TestGetBookingDeletedRoom(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+	resp := cancelBooking(t, env.app, booking.ID)
+	resp.Body.Close()
+	if err := env.store.Room.DeleteRoom(context.TODO(), room.ID); err != nil {
+		t.Fatalf("Error deleting room: %v", err)
+	}
+
+	var detail api.BookingDetail
+	resp = getJSON(t, env.app, "/api/v1/booking/"+booking.ID.Hex(), &detail)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if detail.Booking == nil || detail.ID != booking.ID || detail.Room != nil || detail.Hotel != nil {
+		t.Errorf("Expected the booking without room and hotel, got %+v", detail)
+	}
+}
+
+// TestGetBookingOfOtherUser tests that reading someone else's booking is forbidden
+func TestGetBookingOfOtherUser(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	env.login(newTestGuest("intruder@example.com"))
+	resp := getJSON(t, env.app, "/api/v1/booking/"+booking.ID.Hex(), nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+	}
+}
+
+// TestGetBookingAsStaff tests that staff can only see bookings of the hotels they work for
+func TestGetBookingAsStaff(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	// Staff of another hotel
+	staff := newTestGuest("staff@example.com")
+	staff.Role = types.RoleStaff
+	staff.HotelIDs = []primitive.ObjectID{primitive.NewObjectID()}
+	env.login(staff)
+
+	resp := getJSON(t, env.app, "/api/v1/booking/"+booking.ID.Hex(), nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+	}
+
+	// Staff of the booked hotel
+	staff.HotelIDs = append(staff.HotelIDs, room.HotelID)
+	resp = getJSON(t, env.app, "/api/v1/booking/"+booking.ID.Hex(), nil)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK, got %v", resp.StatusCode)
+	}
+}
+
+// TestChangeBookingAsStaff tests that staff of the booked hotel cannot modify or confirm bookings
+func TestChangeBookingAsStaff(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+	hold := createTestHold(t, env, insertSecondRoom(t, env.store, room, 100))
+
+	staff := newTestGuest("staff@example.com")
+	staff.Role = types.RoleStaff
+	staff.HotelIDs = []primitive.ObjectID{room.HotelID}
+	env.login(staff)
+
+	resp := modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{NumPersons: 2})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden modifying, got %v", resp.StatusCode)
+	}
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden confirming, got %v", resp.StatusCode)
+	}
+	if paid := bookingPayments(t, env, hold.ID); len(paid) != 0 {
+		t.Errorf("Expected nothing to be charged, got %+v", paid)
+	}
+
+	// Admins still can
+	admin := newTestGuest("admin@example.com")
+	admin.Role = types.RoleAdmin
+	env.login(admin)
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status OK confirming as admin, got %v", resp.StatusCode)
+	}
+}
+
+// modifyBooking sends the changes for the given booking
+func modifyBooking(t *testing.T, app *fiber.App, bookingID primitive.ObjectID, params api.ModifyBookingParams) *http.Response {
+	// Like in bookRoom, a new total is paid with a card the mock gateway always accepts
+	if params.PaymentMethod == "" {
+		params.PaymentMethod = payments.MockCardApproved
+	}
+	body, _ := json.Marshal(params)
+	req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/api/v1/booking/%s", bookingID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// insertSecondRoom adds another room to the hotel of the given room
+func insertSecondRoom(t *testing.T, store *db.Store, room *types.Room, price float64) *types.Room {
+	second, err := store.Room.InsertRoom(context.TODO(), &types.Room{
+		Size:    "large",
+		Price:   price,
+		HotelID: room.HotelID,
+	})
+	if err != nil {
+		t.Fatalf("Error inserting test room: %v", err)
+	}
+	return second
+}
+
+// TestModifyBooking tests extending a stay and moving it to another room
+func TestModifyBooking(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+	owner := env.user
+
+	// Someone else has the room right after the stay
+	env.login(newTestGuest("next@example.com"))
+	resp := bookRoom(t, env.app, room.ID, api.BookRoomParams{
+		FromDate:   booking.TillDate.AddDate(0, 0, 1),
+		TillDate:   booking.TillDate.AddDate(0, 0, 2),
+		NumPersons: 1,
+	})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the second booking to succeed, got %v", resp.StatusCode)
+	}
+
+	// One more night still fits, two do not
+	env.login(owner)
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 2)})
+	expectReason(t, resp, http.StatusConflict, "room_already_booked")
+	resp.Body.Close()
+
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 1)})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK extending the stay, got %v", resp.StatusCode)
+	}
+	var extended types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&extended); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if extended.Price.Total != 528 || len(extended.Price.Nights) != 4 {
+		t.Errorf("Expected the price of four nights, got %+v", extended.Price)
+	}
+	if len(extended.History) != 1 || extended.History[0].Price.Total != booking.Price.Total || extended.History[0].ChangedBy != owner.ID {
+		t.Errorf("Expected the previous terms in the history, got %+v", extended.History)
+	}
+
+	// Moving to another room of the hotel frees the old one
+	second := insertSecondRoom(t, env.store, room, 200)
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{RoomID: second.ID, NumPersons: 2})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK moving the booking, got %v", resp.StatusCode)
+	}
+	var moved types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&moved); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if moved.RoomID != second.ID || moved.NumPerson != 2 || moved.Price.Total != 880 || len(moved.History) != 2 {
+		t.Errorf("Expected the booking in the second room, got %+v", moved)
+	}
+	env.login(newTestGuest("other@example.com"))
+	createTestBooking(t, env, room)
+}
+
+// TestModifyBookingInvalid tests the changes that are rejected
+func TestModifyBookingInvalid(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	otherHotelRoom := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+	owner := env.user
+
+	testCases := []struct {
+		name     string
+		params   api.ModifyBookingParams
+		expected int
+		reason   string
+	}{
+		{"nothing to change", api.ModifyBookingParams{NumPersons: booking.NumPerson}, http.StatusBadRequest, "bad_request"},
+		{"in the past", api.ModifyBookingParams{FromDate: time.Now().AddDate(0, 0, -1)}, http.StatusBadRequest, "bad_request"},
+		{"check-out before check-in", api.ModifyBookingParams{TillDate: booking.FromDate.AddDate(0, 0, -1)}, http.StatusBadRequest, "bad_request"},
+		{"too many guests", api.ModifyBookingParams{NumPersons: 5}, http.StatusBadRequest, "capacity_exceeded"},
+		{"other hotel", api.ModifyBookingParams{RoomID: otherHotelRoom.ID}, http.StatusBadRequest, "different_hotel"},
+		{"unknown room", api.ModifyBookingParams{RoomID: primitive.NewObjectID()}, http.StatusNotFound, "not_found"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := modifyBooking(t, env.app, booking.ID, tc.params)
+			defer resp.Body.Close()
+			expectReason(t, resp, tc.expected, tc.reason)
+		})
+	}
+
+	// Other guests cannot touch the booking
+	env.login(newTestGuest("intruder@example.com"))
+	resp := modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{NumPersons: 2})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+	}
+
+	// Cancelled bookings stay as they are
+	env.login(owner)
+	cancelBooking(t, env.app, booking.ID).Body.Close()
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{NumPersons: 2})
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusConflict, "booking_not_modifiable")
+}
+
+// holdRoom sends a request to hold the room for three nights starting in ten days
+func holdRoom(t *testing.T, app *fiber.App, roomID primitive.ObjectID) *http.Response {
+	from := time.Now().AddDate(0, 0, 10)
+	body, _ := json.Marshal(api.BookRoomParams{
+		FromDate:   from,
+		TillDate:   from.AddDate(0, 0, 3),
+		NumPersons: 1,
+	})
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/room/%s/hold", roomID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// createTestHold holds the room and returns the held booking
+func createTestHold(t *testing.T, env *bookingTestEnv, room *types.Room) *types.Booking {
+	resp := holdRoom(t, env.app, room.ID)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK when holding, got %v", resp.StatusCode)
+	}
+	var hold types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&hold); err != nil {
+		t.Fatalf("Error decoding booking: %v", err)
+	}
+	return &hold
+}
+
+// confirmBooking sends a request to pay for the given hold with the payment method and confirm it
+func confirmBooking(t *testing.T, app *fiber.App, bookingID primitive.ObjectID, paymentMethod string) *http.Response {
+	body, _ := json.Marshal(api.ConfirmBookingParams{PaymentMethod: paymentMethod})
+	req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/v1/booking/%s/confirm", bookingID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// TestHoldRoom tests that a hold reserves the room until it is confirmed
+func TestHoldRoom(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	owner := env.user
+	hold := createTestHold(t, env, room)
+
+	if hold.Status != types.BookingStatusHeld || hold.HoldExpiresAt == nil {
+		t.Fatalf("Expected a held booking with an expiry, got %+v", hold)
+	}
+	ttl := config.Default().Booking.HoldTTL
+	if d := time.Until(*hold.HoldExpiresAt); d <= 0 || d > ttl {
+		t.Errorf("Expected the hold to expire within %v, expires in %v", ttl, d)
+	}
+
+	// Nobody else can book the held nights
+	env.login(newTestGuest("other@example.com"))
+	resp := holdRoom(t, env.app, room.ID)
+	expectReason(t, resp, http.StatusConflict, "room_already_booked")
+	resp.Body.Close()
+
+	// Only the guest who holds the room can confirm it
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+	}
+
+	env.login(owner)
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK confirming the hold, got %v", resp.StatusCode)
+	}
+	var confirmed types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&confirmed); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if confirmed.Status != types.BookingStatusConfirmed || confirmed.HoldExpiresAt != nil {
+		t.Errorf("Expected a confirmed booking, got %+v", confirmed)
+	}
+
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusConflict, "booking_not_held")
+}
+
+// TestConfirmExpiredHold tests that a hold cannot be confirmed once it has been released
+func TestConfirmExpiredHold(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	owner := env.user
+	hold := createTestHold(t, env, room)
+
+	// The sweeper runs after the hold has run out
+	n, err := env.store.Booking.ExpireHolds(context.TODO(), hold.HoldExpiresAt.Add(time.Second))
+	if err != nil || n != 1 {
+		t.Fatalf("Expected the hold to expire, got %d, %v", n, err)
+	}
+
+	resp := confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusConflict, "hold_expired")
+
+	// The nights are free again
+	env.login(newTestGuest("other@example.com"))
+	createTestBooking(t, env, room)
+
+	env.login(owner)
+	var detail api.BookingDetail
+	resp = getJSON(t, env.app, fmt.Sprintf("/api/v1/booking/%s", hold.ID.Hex()), &detail)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if detail.Status != types.BookingStatusExpired {
+		t.Errorf("Expected status expired, got %s", detail.Status)
+	}
+}
+
+// TestCancelBookingPolicy tests that cancelling charges the penalty of the cancellation policy and refunds the rest
+func TestCancelBookingPolicy(t *testing.T) {
+	// The test room costs 120 a night, three nights are 396 with taxes
+	tests := []struct {
+		name          string
+		policy        types.CancellationPolicy
+		penalty       float64
+		paymentStatus types.PaymentStatus
+	}{
+		{"free until 48h", types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFull}, 0, types.PaymentStatusRefunded},
+		{"non-refundable", types.CancellationPolicy{Penalty: types.CancellationPenaltyFull}, 396, types.PaymentStatusCaptured},
+		{"first-night penalty", types.CancellationPolicy{Penalty: types.CancellationPenaltyFirstNight}, 132, types.PaymentStatusCaptured},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env, cleanup := setupBookingTest(t)
+			defer cleanup()
+
+			room := insertBookableRoom(t, env.store)
+			setCancellationPolicy(t, env.store, room, tc.policy)
+			env.login(newTestGuest("owner@example.com"))
+			booking := createTestBooking(t, env, room)
+
+			resp := cancelBooking(t, env.app, booking.ID)
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+			}
+			var cancelled types.Booking
+			if err := json.NewDecoder(resp.Body).Decode(&cancelled); err != nil {
+				t.Fatalf("Error decoding response: %v", err)
+			}
+			c := cancelled.Cancellation
+			if c == nil || c.Penalty != tc.penalty || c.Refund != 396-tc.penalty {
+				t.Fatalf("Expected a penalty of %.2f, got %+v", tc.penalty, c)
+			}
+
+			paid := bookingPayments(t, env, booking.ID)
+			if len(paid) != 1 || paid[0].Status != tc.paymentStatus || paid[0].Captured != tc.penalty {
+				t.Errorf("Expected a %s payment with %.2f captured, got %+v", tc.paymentStatus, tc.penalty, paid)
+			}
+		})
+	}
+}
+
+// TestBookingKeepsCancellationPolicy tests that a booking is cancelled under the policy it was made under
+func TestBookingKeepsCancellationPolicy(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	setCancellationPolicy(t, env.store, room, types.CancellationPolicy{Penalty: types.CancellationPenaltyFirstNight})
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	// The hotel relaxes its policy after the booking was made
+	setCancellationPolicy(t, env.store, room, types.DefaultCancellationPolicy)
+
+	var detail api.BookingDetail
+	resp := getJSON(t, env.app, fmt.Sprintf("/api/v1/booking/%s", booking.ID.Hex()), &detail)
+	resp.Body.Close()
+	if detail.CancellationPolicy.Penalty != types.CancellationPenaltyFirstNight || detail.CancellationPolicyText != "Cancellations are charged the first night." {
+		t.Errorf("Expected the first-night policy, got %+v, %q", detail.CancellationPolicy, detail.CancellationPolicyText)
+	}
+
+	resp = cancelBooking(t, env.app, booking.ID)
+	resp.Body.Close()
+	cancelled, err := env.store.Booking.GetBookingByID(context.TODO(), booking.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if cancelled.Cancellation == nil || cancelled.Cancellation.Penalty != 132 {
+		t.Errorf("Expected the first night to be charged, got %+v", cancelled.Cancellation)
+	}
+}
+
+// TestCancelHoldPolicy tests that cancelling a hold costs nothing, whatever the policy
+func TestCancelHoldPolicy(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	setCancellationPolicy(t, env.store, room, types.CancellationPolicy{Penalty: types.CancellationPenaltyFull})
+	env.login(newTestGuest("owner@example.com"))
+	hold := createTestHold(t, env, room)
+
+	resp := cancelBooking(t, env.app, hold.ID)
+	defer resp.Body.Close()
+	var cancelled types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&cancelled); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if cancelled.Status != types.BookingStatusCancelled || cancelled.Cancellation == nil || cancelled.Cancellation.Penalty != 0 {
+		t.Errorf("Expected a cancellation without penalty, got %+v", cancelled)
+	}
+}
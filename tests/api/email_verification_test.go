@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/notify"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// newGuestParams is the registration of the guest in the verification tests
+var newGuestParams = types.CreateUserParams{
+	FirstName: "New",
+	LastName:  "Guest",
+	Email:     "verify@example.com",
+	Password:  "password123",
+}
+
+// registerGuest registers newGuestParams and returns the tokens of the new account
+func registerGuest(t *testing.T, app *fiber.App) loginResp {
+	resp := register(t, app, newGuestParams)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v", resp.StatusCode)
+	}
+	var tokens loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	return tokens
+}
+
+// verifyEmail follows the verification link with the token and returns the response
+func verifyEmail(t *testing.T, app *fiber.App, token string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/verify?token="+url.QueryEscape(token), nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// resendVerification asks for a new verification email with the access token
+func resendVerification(t *testing.T, app *fiber.App, accessToken string) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/verify/resend", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// isVerified reports whether the stored user with the email is verified
+func isVerified(t *testing.T, database *db.MemoryDatabase, email string) bool {
+	user, err := db.NewMemoryUserStore(database).GetUserByEmail(context.TODO(), email)
+	if err != nil {
+		t.Fatalf("Error getting user: %v", err)
+	}
+	return user.EmailVerified
+}
+
+// TestEmailVerification tests verifying the email address with the link sent on registration
+func TestEmailVerification(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	tokens := registerGuest(t, app)
+	if tokens.User.EmailVerified {
+		t.Errorf("Expected a new account to be unverified")
+	}
+	token := linkToken(t, mailer, newGuestParams.Email, testAuthConfig.VerifyURL)
+
+	resp := verifyEmail(t, app, token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if !isVerified(t, database, newGuestParams.Email) {
+		t.Errorf("Expected the email to be verified")
+	}
+
+	// The link works only once
+	resp = verifyEmail(t, app, token)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_verification_token")
+
+	// Verified users cannot ask for another link
+	resp = resendVerification(t, app, tokens.Token)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusConflict, "email_already_verified")
+}
+
+// TestEmailVerification_Resend tests that a resent link replaces the old one
+func TestEmailVerification_Resend(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	tokens := registerGuest(t, app)
+	oldToken := linkToken(t, mailer, newGuestParams.Email, testAuthConfig.VerifyURL)
+
+	resp := resendVerification(t, app, tokens.Token)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v", resp.StatusCode)
+	}
+	if n := len(mailer.Messages(newGuestParams.Email)); n != 2 {
+		t.Fatalf("Expected 2 emails, got %d", n)
+	}
+	newToken := linkToken(t, mailer, newGuestParams.Email, testAuthConfig.VerifyURL)
+
+	resp = verifyEmail(t, app, oldToken)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_verification_token")
+
+	resp = verifyEmail(t, app, newToken)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the new link to work, got %v", resp.StatusCode)
+	}
+	if !isVerified(t, database, newGuestParams.Email) {
+		t.Errorf("Expected the email to be verified")
+	}
+}
+
+// TestEmailVerification_PasswordReset tests that a password reset verifies the email as well
+func TestEmailVerification_PasswordReset(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	forgotPassword(t, app, user.Email).Body.Close()
+	resetPassword(t, app, resetToken(t, mailer, user), "newpassword456").Body.Close()
+
+	if !isVerified(t, database, user.Email) {
+		t.Errorf("Expected the email to be verified by the password reset")
+	}
+}
+
+// TestVerifyEmail_Invalid tests the verification requests that are rejected
+func TestVerifyEmail_Invalid(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	resp := verifyEmail(t, app, "not-a-real-token")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_verification_token")
+
+	req := httptest.NewRequest(http.MethodGet, "/api/auth/verify", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request without a token, got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TestErrorHandler checks the status code and reason reported for handler errors
+func TestErrorHandler(t *testing.T) {
+	_, hexErr := primitive.ObjectIDFromHex("zzzzzzzzzzzzzzzzzzzzzzzz")
+
+	testCases := []struct {
+		name   string
+		err    error
+		code   int
+		reason string
+	}{
+		{"api error", api.ErrForbidden(), http.StatusForbidden, "forbidden"},
+		{"wrapped api error", fmt.Errorf("checking access: %w", api.ErrUnauthorized()), http.StatusUnauthorized, "unauthorized"},
+		{"no documents", mongo.ErrNoDocuments, http.StatusNotFound, "not_found"},
+		{"short object id", primitive.ErrInvalidHex, http.StatusBadRequest, "invalid_id"},
+		{"non-hex object id", hexErr, http.StatusBadRequest, "invalid_id"},
+		{"room already booked", db.ErrRoomAlreadyBooked, http.StatusConflict, "room_already_booked"},
+		{"booking not cancellable", db.ErrBookingNotCancellable, http.StatusConflict, "booking_not_cancellable"},
+		{"duplicate email", db.ErrDuplicateEmail, http.StatusConflict, "duplicate_email"},
+		{"fiber error", fiber.ErrMethodNotAllowed, http.StatusMethodNotAllowed, "http_error"},
+		{"unknown error", errors.New("connection reset"), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+			app.Get("/", func(c *fiber.Ctx) error {
+				return tc.err
+			})
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.code {
+				t.Errorf("Expected status %v, got %v", tc.code, resp.StatusCode)
+			}
+
+			var apiErr api.Error
+			if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+				t.Fatalf("Error decoding error response: %v", err)
+			}
+			if apiErr.Code != tc.code {
+				t.Errorf("Expected code %v in body, got %v", tc.code, apiErr.Code)
+			}
+			if apiErr.Reason != tc.reason {
+				t.Errorf("Expected reason %q, got %q", tc.reason, apiErr.Reason)
+			}
+		})
+	}
+}
+
+// TestErrorHandlerHidesInternalErrors checks that unexpected errors do not leak details
+func TestErrorHandlerHidesInternalErrors(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Get("/", func(c *fiber.Ctx) error {
+		return errors.New("dial tcp 10.0.0.1:27017: connection refused")
+	})
+
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
+	if apiErr.Err != "internal server error" {
+		t.Errorf("Expected a generic message, got %q", apiErr.Err)
+	}
+}
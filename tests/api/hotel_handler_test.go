@@ -6,28 +6,33 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/0x0Glitch/hotel-reservation/api"
 	"github.com/0x0Glitch/hotel-reservation/db"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// hotelPage mirrors api.ResourceResponse with the data decoded as hotels
+type hotelPage struct {
+	Data    []types.Hotel `json:"data"`
+	Results int           `json:"results"`
+	Total   int64         `json:"total"`
+	Page    int64         `json:"page"`
+	Limit   int64         `json:"limit"`
+}
+
 // setupHotelTest creates a test server with the hotel routes
-func setupHotelTest(t *testing.T) (*fiber.App, *mongo.Client, func()) {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		t.Fatalf("Error connecting to MongoDB: %v", err)
-	}
+func setupHotelTest(t *testing.T) (*fiber.App, *db.MemoryDatabase, func()) {
+	database := db.NewMemoryDatabase()
 
 	// Initialize stores and handlers
-	hotelStore := db.NewMongoHotelStore(client)
-	roomStore := db.NewMongoRoomStore(client, hotelStore)
+	hotelStore := db.NewMemoryHotelStore(database)
+	roomStore := db.NewMemoryRoomStore(database, hotelStore)
 
 	// Create a Store that wraps all stores
 	store := &db.Store{
@@ -37,17 +42,8 @@ func setupHotelTest(t *testing.T) (*fiber.App, *mongo.Client, func()) {
 
 	hotelHandler := api.NewHotelHandler(store)
 
-	// Clean up collections before test
-	collections := []string{"hotels", "rooms"}
-	for _, coll := range collections {
-		_, err = client.Database(db.DBNAME).Collection(coll).DeleteMany(context.TODO(), bson.M{})
-		if err != nil {
-			t.Fatalf("Error cleaning %s collection: %v", coll, err)
-		}
-	}
-
 	// Setup Fiber app
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
 	
 	// Setup hotel routes manually
 	app.Get("/api/hotels", hotelHandler.HandleGetHotels)
@@ -55,23 +51,12 @@ func setupHotelTest(t *testing.T) (*fiber.App, *mongo.Client, func()) {
 	app.Get("/api/hotels/:id/rooms", hotelHandler.HandleGetRooms)
 
 	// Return test server and cleanup function
-	return app, client, func() {
-		// Clean up after test
-		for _, coll := range collections {
-			_, err = client.Database(db.DBNAME).Collection(coll).DeleteMany(context.TODO(), bson.M{})
-			if err != nil {
-				t.Fatalf("Error cleaning %s collection: %v", coll, err)
-			}
-		}
-		if err := client.Disconnect(context.TODO()); err != nil {
-			t.Fatalf("Error disconnecting from MongoDB: %v", err)
-		}
-	}
+	return app, database, func() {}
 }
 
 // insertTestHotels inserts test hotels into the database
-func insertTestHotels(t *testing.T, client *mongo.Client) []*types.Hotel {
-	hotelStore := db.NewMongoHotelStore(client)
+func insertTestHotels(t *testing.T, database *db.MemoryDatabase) []*types.Hotel {
+	hotelStore := db.NewMemoryHotelStore(database)
 	
 	// Create test hotels
 	hotels := []*types.Hotel{
@@ -103,8 +88,8 @@ func insertTestHotels(t *testing.T, client *mongo.Client) []*types.Hotel {
 }
 
 // insertTestRooms inserts test rooms for a hotel
-func insertTestRoom(t *testing.T, client *mongo.Client, hotelID primitive.ObjectID) *types.Room {
-	roomStore := db.NewMongoRoomStore(client, db.NewMongoHotelStore(client))
+func insertTestRoom(t *testing.T, database *db.MemoryDatabase, hotelID primitive.ObjectID) *types.Room {
+	roomStore := db.NewMemoryRoomStore(database, db.NewMemoryHotelStore(database))
 	
 	// Create test room
 	room := &types.Room{
@@ -125,11 +110,11 @@ func insertTestRoom(t *testing.T, client *mongo.Client, hotelID primitive.Object
 
 // TestGetHotels tests fetching all hotels
 func TestGetHotels(t *testing.T) {
-	app, client, cleanup := setupHotelTest(t)
+	app, database, cleanup := setupHotelTest(t)
 	defer cleanup()
 	
 	// Insert test hotels
-	hotels := insertTestHotels(t, client)
+	hotels := insertTestHotels(t, database)
 	
 	// Create HTTP request to get all hotels
 	req := httptest.NewRequest(http.MethodGet, "/api/hotels", nil)
@@ -147,24 +132,27 @@ func TestGetHotels(t *testing.T) {
 	}
 	
 	// Parse response
-	var fetchedHotels []types.Hotel
-	if err := json.NewDecoder(resp.Body).Decode(&fetchedHotels); err != nil {
+	var page hotelPage
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
 		t.Fatalf("Error decoding response: %v", err)
 	}
 	
 	// Verify we got the expected number of hotels
-	if len(fetchedHotels) != len(hotels) {
-		t.Errorf("Expected %d hotels, got %d", len(hotels), len(fetchedHotels))
+	if len(page.Data) != len(hotels) {
+		t.Errorf("Expected %d hotels, got %d", len(hotels), len(page.Data))
+	}
+	if page.Total != int64(len(hotels)) {
+		t.Errorf("Expected total %d, got %d", len(hotels), page.Total)
 	}
 }
 
 // TestGetHotelByID tests fetching a specific hotel
 func TestGetHotelByID(t *testing.T) {
-	app, client, cleanup := setupHotelTest(t)
+	app, database, cleanup := setupHotelTest(t)
 	defer cleanup()
 	
 	// Insert test hotels
-	hotels := insertTestHotels(t, client)
+	hotels := insertTestHotels(t, database)
 	hotel := hotels[0] // Get first hotel
 	
 	// Create HTTP request to get the hotel
@@ -205,15 +193,15 @@ func TestGetHotelByID(t *testing.T) {
 
 // TestGetHotelRooms tests fetching rooms for a specific hotel
 func TestGetHotelRooms(t *testing.T) {
-	app, client, cleanup := setupHotelTest(t)
+	app, database, cleanup := setupHotelTest(t)
 	defer cleanup()
 	
 	// Insert test hotels
-	hotels := insertTestHotels(t, client)
+	hotels := insertTestHotels(t, database)
 	hotel := hotels[0] // Get first hotel
 	
 	// Insert a room for the hotel
-	room := insertTestRoom(t, client, hotel.ID)
+	room := insertTestRoom(t, database, hotel.ID)
 	
 	// Create HTTP request to get hotel rooms
 	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/hotels/%s/rooms", hotel.ID.Hex()), nil)
@@ -260,4 +248,396 @@ func TestGetHotelRooms(t *testing.T) {
 			t.Errorf("Expected hotel ID %v, got %v", hotel.ID, fetchedRoom.HotelID)
 		}
 	}
-} 
\ No newline at end of file
+} 
+
+// TestGetHotelRoomsBeingDeleted tests that rooms being deleted are not listed
+func TestGetHotelRoomsBeingDeleted(t *testing.T) {
+	app, database, cleanup := setupHotelTest(t)
+	defer cleanup()
+
+	hotel := insertTestHotels(t, database)[0]
+	room := insertTestRoom(t, database, hotel.ID)
+	kept := insertTestRoom(t, database, hotel.ID)
+	roomStore := db.NewMemoryRoomStore(database, db.NewMemoryHotelStore(database))
+	if err := roomStore.SetRoomDeleting(context.TODO(), room.ID, true); err != nil {
+		t.Fatalf("Error marking room: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/api/hotels/%s/rooms", hotel.ID.Hex()), nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	var rooms []types.Room
+	if err := json.NewDecoder(resp.Body).Decode(&rooms); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if len(rooms) != 1 || rooms[0].ID != kept.ID {
+		t.Errorf("Expected only room %s, got %+v", kept.ID.Hex(), rooms)
+	}
+}
+
+// TestGetHotelNotFound tests fetching a hotel that does not exist
+func TestGetHotelNotFound(t *testing.T) {
+	app, _, cleanup := setupHotelTest(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/hotels/"+primitive.NewObjectID().Hex(), nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected status Not Found, got %v", resp.StatusCode)
+	}
+
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
+	if apiErr.Code != http.StatusNotFound || apiErr.Reason != "not_found" {
+		t.Errorf("Expected not_found error with code 404, got %+v", apiErr)
+	}
+}
+
+// TestSearchHotels tests filtering, sorting and paginating hotels
+func TestSearchHotels(t *testing.T) {
+	app, database, cleanup := setupHotelTest(t)
+	defer cleanup()
+
+	// Insert hotels to search through
+	hotelStore := db.NewMemoryHotelStore(database)
+	for _, hotel := range []*types.Hotel{
+		{Name: "Grand Palace", Location: "Paris", Rating: 5},
+		{Name: "Grand Budget", Location: "Paris", Rating: 2},
+		{Name: "Seaside Inn", Location: "Nice", Rating: 4},
+		{Name: "City Hostel", Location: "London", Rating: 1},
+		{Name: "Royal Grand", Location: "London", Rating: 3},
+	} {
+		hotel.Rooms = []primitive.ObjectID{}
+		if _, err := hotelStore.Insert(context.TODO(), hotel); err != nil {
+			t.Fatalf("Error inserting test hotel: %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		query    string
+		expected []string
+		total    int64
+	}{
+		{"default sort by name", "", []string{"City Hostel", "Grand Budget", "Grand Palace", "Royal Grand", "Seaside Inn"}, 5},
+		{"rating range", "?minRating=3&maxRating=4&sort=rating", []string{"Royal Grand", "Seaside Inn"}, 2},
+		{"location ignores case", "?location=PARIS&sort=-rating", []string{"Grand Palace", "Grand Budget"}, 2},
+		{"name substring", "?name=grand&sort=-rating", []string{"Grand Palace", "Royal Grand", "Grand Budget"}, 3},
+		{"name is not a pattern", "?name=Gr.nd", []string{}, 0},
+		{"first page", "?sort=-rating&limit=2", []string{"Grand Palace", "Seaside Inn"}, 5},
+		{"second page", "?sort=-rating&limit=2&page=2", []string{"Royal Grand", "Grand Budget"}, 5},
+		{"last page", "?sort=-rating&limit=2&page=3", []string{"City Hostel"}, 5},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/hotels"+tc.query, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+			}
+
+			var page hotelPage
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatalf("Error decoding response: %v", err)
+			}
+
+			if page.Total != tc.total {
+				t.Errorf("Expected total %d, got %d", tc.total, page.Total)
+			}
+			if page.Results != len(tc.expected) || len(page.Data) != len(tc.expected) {
+				t.Fatalf("Expected %d results, got %d", len(tc.expected), len(page.Data))
+			}
+			for i, name := range tc.expected {
+				if page.Data[i].Name != name {
+					t.Errorf("Expected hotel %d to be %s, got %s", i, name, page.Data[i].Name)
+				}
+			}
+		})
+	}
+}
+
+// TestSearchHotelsInvalidParams tests that bad search parameters are rejected
+func TestSearchHotelsInvalidParams(t *testing.T) {
+	app, _, cleanup := setupHotelTest(t)
+	defer cleanup()
+
+	for _, query := range []string{
+		"?sort=price",
+		"?minRating=4&maxRating=2",
+		"?page=-1",
+		"?limit=abc",
+	} {
+		t.Run(query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/hotels"+query, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request, got %v", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// setupHotelAdminTest creates a test server with the hotel and room management routes
+func setupHotelAdminTest(t *testing.T) (*fiber.App, *db.MemoryDatabase, *db.Store) {
+	database := db.NewMemoryDatabase()
+	hotelStore := db.NewMemoryHotelStore(database)
+	store := &db.Store{
+		Hotel:   hotelStore,
+		Room:    db.NewMemoryRoomStore(database, hotelStore),
+		Booking: db.NewMemoryBookingStore(database),
+	}
+	hotelHandler := api.NewHotelHandler(store)
+
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Post("/api/v1/hotel", hotelHandler.HandlePostHotel)
+	app.Put("/api/v1/hotel/:id", hotelHandler.HandlePutHotel)
+	app.Delete("/api/v1/hotel/:id", hotelHandler.HandleDeleteHotel)
+	app.Post("/api/v1/hotel/:id/rooms", hotelHandler.HandlePostRoom)
+	app.Put("/api/v1/hotel/:id/rooms/:roomID", hotelHandler.HandlePutRoom)
+	app.Delete("/api/v1/hotel/:id/rooms/:roomID", hotelHandler.HandleDeleteRoom)
+	return app, database, store
+}
+
+// sendJSON sends the raw JSON body with the method and returns the response
+func sendJSON(t *testing.T, app *fiber.App, method, path, body string) *http.Response {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// expectStatus closes the response and checks its status
+func expectStatus(t *testing.T, resp *http.Response, expected int) {
+	t.Helper()
+	resp.Body.Close()
+	if resp.StatusCode != expected {
+		t.Errorf("Expected status %v, got %v", expected, resp.StatusCode)
+	}
+}
+
+// TestManageHotel tests creating, updating and deleting a hotel
+func TestManageHotel(t *testing.T) {
+	app, database, store := setupHotelAdminTest(t)
+
+	// Invalid hotels are rejected with every invalid field
+	resp := sendJSON(t, app, http.MethodPost, "/api/v1/hotel", `{"name":"X","rating":9}`)
+	var errors map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&errors); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expectStatus(t, resp, http.StatusBadRequest)
+	for _, field := range []string{"name", "location", "rating"} {
+		if _, ok := errors[field]; !ok {
+			t.Errorf("Expected validation error for %s", field)
+		}
+	}
+
+	resp = sendJSON(t, app, http.MethodPost, "/api/v1/hotel", `{"name":"Grand Hotel","location":"Paris","rating":4}`)
+	var hotel types.Hotel
+	if err := json.NewDecoder(resp.Body).Decode(&hotel); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expectStatus(t, resp, http.StatusCreated)
+	if hotel.ID.IsZero() || hotel.Name != "Grand Hotel" || len(hotel.Rooms) != 0 {
+		t.Fatalf("Expected the new hotel without rooms, got %+v", hotel)
+	}
+
+	path := "/api/v1/hotel/" + hotel.ID.Hex()
+	expectStatus(t, sendJSON(t, app, http.MethodPut, path, `{"rating":5}`), http.StatusOK)
+	expectStatus(t, sendJSON(t, app, http.MethodPut, path, `{"rating":6}`), http.StatusBadRequest)
+	expectStatus(t, sendJSON(t, app, http.MethodPut, "/api/v1/hotel/"+primitive.NewObjectID().Hex(), `{"rating":5}`), http.StatusNotFound)
+	updated, err := store.Hotel.GetHotelByID(context.TODO(), hotel.ID)
+	if err != nil {
+		t.Fatalf("Error fetching hotel: %v", err)
+	}
+	if updated.Rating != 5 || updated.Name != hotel.Name || updated.Location != hotel.Location {
+		t.Errorf("Expected only the rating to change, got %+v", updated)
+	}
+
+	// A hotel can only be deleted once it has no rooms
+	room := insertTestRoom(t, database, hotel.ID)
+	resp = sendJSON(t, app, http.MethodDelete, path, "")
+	expectReason(t, resp, http.StatusConflict, "hotel_has_rooms")
+	resp.Body.Close()
+	expectStatus(t, sendJSON(t, app, http.MethodDelete, fmt.Sprintf("%s/rooms/%s", path, room.ID.Hex()), ""), http.StatusOK)
+	expectStatus(t, sendJSON(t, app, http.MethodDelete, path, ""), http.StatusOK)
+	expectStatus(t, sendJSON(t, app, http.MethodDelete, path, ""), http.StatusNotFound)
+}
+
+// TestHotelCancellationPolicy tests setting the cancellation policy of a hotel
+func TestHotelCancellationPolicy(t *testing.T) {
+	app, _, store := setupHotelAdminTest(t)
+
+	resp := sendJSON(t, app, http.MethodPost, "/api/v1/hotel", `{"name":"Grand Hotel","location":"Paris","rating":4,"cancellationPolicy":{"penalty":"half"}}`)
+	var errors map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&errors); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expectStatus(t, resp, http.StatusBadRequest)
+	if _, ok := errors["cancellationPolicy.penalty"]; !ok {
+		t.Errorf("Expected validation error for the penalty, got %v", errors)
+	}
+
+	resp = sendJSON(t, app, http.MethodPost, "/api/v1/hotel", `{"name":"Grand Hotel","location":"Paris","rating":4,"cancellationPolicy":{"freeCancellationHours":48,"penalty":"full"}}`)
+	var hotel types.Hotel
+	if err := json.NewDecoder(resp.Body).Decode(&hotel); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expectStatus(t, resp, http.StatusCreated)
+	if p := hotel.CancellationPolicy; p == nil || p.FreeCancellationHours != 48 || p.Penalty != types.CancellationPenaltyFull {
+		t.Fatalf("Expected free cancellation until 48h, got %+v", p)
+	}
+
+	path := "/api/v1/hotel/" + hotel.ID.Hex()
+	expectStatus(t, sendJSON(t, app, http.MethodPut, path, `{"cancellationPolicy":{"penalty":"first-night"}}`), http.StatusOK)
+	updated, err := store.Hotel.GetHotelByID(context.TODO(), hotel.ID)
+	if err != nil {
+		t.Fatalf("Error fetching hotel: %v", err)
+	}
+	if p := updated.ApplicableCancellationPolicy(); p.FreeCancellationHours != 0 || p.Penalty != types.CancellationPenaltyFirstNight {
+		t.Errorf("Expected the first-night penalty, got %+v", p)
+	}
+}
+
+// TestManageRooms tests adding, updating and deleting the rooms of a hotel
+func TestManageRooms(t *testing.T) {
+	app, database, store := setupHotelAdminTest(t)
+	hotels := insertTestHotels(t, database)
+	hotel, other := hotels[0], hotels[1]
+	path := fmt.Sprintf("/api/v1/hotel/%s/rooms", hotel.ID.Hex())
+
+	expectStatus(t, sendJSON(t, app, http.MethodPost, path, `{"size":"small","price":0}`), http.StatusBadRequest)
+	expectStatus(t, sendJSON(t, app, http.MethodPost, path, `{"type":7,"price":80}`), http.StatusBadRequest)
+	expectStatus(t, sendJSON(t, app, http.MethodPost, fmt.Sprintf("/api/v1/hotel/%s/rooms", primitive.NewObjectID().Hex()), `{"price":80}`), http.StatusNotFound)
+
+	resp := sendJSON(t, app, http.MethodPost, path, `{"type":2,"size":"normal","price":80}`)
+	var room types.Room
+	if err := json.NewDecoder(resp.Body).Decode(&room); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	expectStatus(t, resp, http.StatusCreated)
+	if room.ID.IsZero() || room.HotelID != hotel.ID || room.Price != 80 {
+		t.Fatalf("Expected the new room of the hotel, got %+v", room)
+	}
+	fetched, err := store.Hotel.GetHotelByID(context.TODO(), hotel.ID)
+	if err != nil {
+		t.Fatalf("Error fetching hotel: %v", err)
+	}
+	if len(fetched.Rooms) != 1 || fetched.Rooms[0] != room.ID {
+		t.Errorf("Expected the hotel to list the new room, got %v", fetched.Rooms)
+	}
+
+	roomPath := fmt.Sprintf("%s/%s", path, room.ID.Hex())
+	expectStatus(t, sendJSON(t, app, http.MethodPut, roomPath, `{"price":95,"seaside":true}`), http.StatusOK)
+	expectStatus(t, sendJSON(t, app, http.MethodPut, roomPath, `{}`), http.StatusBadRequest)
+	// The room has to belong to the hotel in the URL
+	expectStatus(t, sendJSON(t, app, http.MethodPut, fmt.Sprintf("/api/v1/hotel/%s/rooms/%s", other.ID.Hex(), room.ID.Hex()), `{"price":1}`), http.StatusNotFound)
+	updated, err := store.Room.GetRoomByID(context.TODO(), room.ID)
+	if err != nil {
+		t.Fatalf("Error fetching room: %v", err)
+	}
+	if updated.Price != 95 || !updated.Seaside || updated.Size != "normal" || updated.Type != types.DoubleRoomType {
+		t.Errorf("Expected only price and seaside to change, got %+v", updated)
+	}
+}
+
+// TestDeleteRoomWithBookings tests that rooms with upcoming bookings are kept
+func TestDeleteRoomWithBookings(t *testing.T) {
+	app, database, store := setupHotelAdminTest(t)
+	hotel := insertTestHotels(t, database)[0]
+	room := insertTestRoom(t, database, hotel.ID)
+	path := fmt.Sprintf("/api/v1/hotel/%s/rooms/%s", hotel.ID.Hex(), room.ID.Hex())
+
+	book := func(from time.Time, status types.BookingStatus) *types.Booking {
+		booking, err := store.Booking.InsertBooking(context.TODO(), &types.Booking{
+			RoomID:   room.ID,
+			UserID:   primitive.NewObjectID(),
+			FromDate: from,
+			TillDate: from.AddDate(0, 0, 2),
+			Status:   status,
+		})
+		if err != nil {
+			t.Fatalf("Error inserting booking: %v", err)
+		}
+		return booking
+	}
+	// Past and cancelled bookings do not keep a room
+	book(time.Now().AddDate(0, 0, -10), types.BookingStatusCheckedOut)
+	book(time.Now().AddDate(0, 0, 20), types.BookingStatusCancelled)
+	upcoming := book(time.Now().AddDate(0, 0, 10), types.BookingStatusConfirmed)
+
+	resp := sendJSON(t, app, http.MethodDelete, path, "")
+	expectReason(t, resp, http.StatusConflict, "room_has_bookings")
+	resp.Body.Close()
+
+	if _, err := store.Booking.CancelBooking(context.TODO(), upcoming.ID, nil); err != nil {
+		t.Fatalf("Error cancelling booking: %v", err)
+	}
+	expectStatus(t, sendJSON(t, app, http.MethodDelete, path, ""), http.StatusOK)
+	if _, err := store.Room.GetRoomByID(context.TODO(), room.ID); err == nil {
+		t.Errorf("Expected the room to be deleted")
+	}
+	fetched, err := store.Hotel.GetHotelByID(context.TODO(), hotel.ID)
+	if err != nil {
+		t.Fatalf("Error fetching hotel: %v", err)
+	}
+	if len(fetched.Rooms) != 0 {
+		t.Errorf("Expected the hotel to have no rooms left, got %v", fetched.Rooms)
+	}
+}
+
+// TestDeleteRoomWithHeldNights tests that nights claimed in the ledger keep a room
+// and that a room stays bookable when its deletion is refused
+func TestDeleteRoomWithHeldNights(t *testing.T) {
+	app, database, store := setupHotelAdminTest(t)
+	hotel := insertTestHotels(t, database)[0]
+	room := insertTestRoom(t, database, hotel.ID)
+	path := fmt.Sprintf("/api/v1/hotel/%s/rooms/%s", hotel.ID.Hex(), room.ID.Hex())
+
+	from := time.Now().AddDate(0, 0, 10)
+	book := func(from time.Time) (*types.Booking, error) {
+		return store.Booking.InsertBookingIfAvailable(context.TODO(), &types.Booking{
+			RoomID:   room.ID,
+			UserID:   primitive.NewObjectID(),
+			FromDate: from,
+			TillDate: from.AddDate(0, 0, 2),
+			Status:   types.BookingStatusConfirmed,
+		})
+	}
+	if _, err := book(from); err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+
+	resp := sendJSON(t, app, http.MethodDelete, path, "")
+	expectReason(t, resp, http.StatusConflict, "room_has_bookings")
+	resp.Body.Close()
+
+	if _, err := book(from.AddDate(0, 0, 5)); err != nil {
+		t.Errorf("Expected the room to stay bookable, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// rsaAuthConfig returns the test token configuration signing with a new RSA key
+func rsaAuthConfig(t *testing.T, kid string) config.AuthConfig {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), kid+".pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+
+	authCfg := testAuthConfig
+	authCfg.JWTSecret = ""
+	authCfg.SigningKeys = []config.SigningKeyConfig{{
+		ID:             kid,
+		Algorithm:      config.AlgorithmRS256,
+		PrivateKeyFile: path,
+	}}
+	return authCfg
+}
+
+// TestGetJWKS tests that tokens can be verified with nothing but the published keys
+func TestGetJWKS(t *testing.T) {
+	app, database, cleanup := setupAuthWith(t, rsaAuthConfig(t, "test-key"))
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+
+	// The access token is accepted by the API itself
+	if status := accessStatus(t, app, tokens.Token); status != http.StatusOK {
+		t.Fatalf("Expected the RS256 token to be accepted, got %v", status)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Cache-Control") == "" {
+		t.Errorf("Expected the key set to be cacheable")
+	}
+
+	var jwks keys.JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "test-key" || jwks.Keys[0].Kty != "RSA" {
+		t.Fatalf("Expected the RSA key, got %+v", jwks.Keys)
+	}
+
+	// Verify the token the way a partner service would
+	n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+	e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	_, err = jwt.Parse(tokens.Token, func(token *jwt.Token) (interface{}, error) {
+		if token.Header["kid"] != jwks.Keys[0].Kid {
+			t.Errorf("Expected kid %s, got %v", jwks.Keys[0].Kid, token.Header["kid"])
+		}
+		return public, nil
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithAudience(testAuthConfig.Audience))
+	if err != nil {
+		t.Errorf("Expected the token to verify with the published key, got %v", err)
+	}
+}
+
+// TestGetJWKSWithSecret tests that the HS256 secret is never published
+func TestGetJWKSWithSecret(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var jwks keys.JWKSet
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if jwks.Keys == nil || len(jwks.Keys) != 0 {
+		t.Errorf("Expected an empty key set, got %+v", jwks.Keys)
+	}
+}
@@ -0,0 +1,324 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/notify"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// setupLoginTest creates a test server with the given login throttling and a user to log in as
+func setupLoginTest(t *testing.T, loginCfg config.LoginConfig) (*fiber.App, *db.MemoryDatabase, *types.User) {
+	app, database, _ := setupAuthServer(t, testAuthConfig, loginCfg, notify.NewMemoryMailer())
+	return app, database, createTestUser(t, database)
+}
+
+// attemptLogin logs in with the email and password and returns the response
+func attemptLogin(t *testing.T, app *fiber.App, email, password string) *http.Response {
+	return postJSON(t, app, "/api/auth/login", loginReq{Email: email, Password: password})
+}
+
+// failLogins makes n logins with a wrong password for the email, waiting out the backoff in between
+func failLogins(t *testing.T, app *fiber.App, email string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		time.Sleep(2 * time.Millisecond)
+		resp := attemptLogin(t, app, email, "wrongpassword")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("Expected failed login %d to be Unauthorized, got %v", i+1, resp.StatusCode)
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+}
+
+// expectRetryAfter checks that the response asks to wait about the given duration
+func expectRetryAfter(t *testing.T, resp *http.Response, wait time.Duration) {
+	t.Helper()
+	seconds, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter))
+	if err != nil {
+		t.Fatalf("Expected a Retry-After header in seconds, got %q", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	if got := time.Duration(seconds) * time.Second; got > wait || got < wait-time.Minute {
+		t.Errorf("Expected to wait about %v, got %v", wait, got)
+	}
+}
+
+// createTestAdmin creates an admin and returns their access token
+func createTestAdmin(t *testing.T, app *fiber.App, database *db.MemoryDatabase) string {
+	admin, err := types.NewUserFromParams(types.CreateUserParams{
+		FirstName: "Test",
+		LastName:  "Admin",
+		Email:     "testadmin@example.com",
+		Password:  "password123",
+	})
+	if err != nil {
+		t.Fatalf("Error creating admin: %v", err)
+	}
+	admin.Role = types.RoleAdmin
+	if _, err := db.NewMemoryUserStore(database).InsertUser(context.TODO(), admin); err != nil {
+		t.Fatalf("Error inserting admin: %v", err)
+	}
+	return login(t, app, admin).Token
+}
+
+// TestLogin_Backoff tests that a failed login makes the next attempt wait
+func TestLogin_Backoff(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.Backoff = time.Hour
+	loginCfg.MaxBackoff = time.Hour
+	app, _, user := setupLoginTest(t, loginCfg)
+
+	resp := attemptLogin(t, app, user.Email, "wrongpassword")
+	resp.Body.Close()
+
+	// Even the right password has to wait
+	resp = attemptLogin(t, app, user.Email, "password123")
+	defer resp.Body.Close()
+	expectRetryAfter(t, resp, time.Hour)
+	expectReason(t, resp, http.StatusTooManyRequests, "too_many_attempts")
+}
+
+// TestLogin_ParallelBurst tests that of parallel attempts only one gets to compare the password
+func TestLogin_ParallelBurst(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.Backoff = time.Hour
+	loginCfg.MaxBackoff = time.Hour
+	app, _, user := setupLoginTest(t, loginCfg)
+
+	const attempts = 10
+	statuses := make(chan int, attempts)
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := attemptLogin(t, app, user.Email, "wrongpassword")
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := map[int]int{}
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusUnauthorized] != 1 || counts[http.StatusTooManyRequests] != attempts-1 {
+		t.Errorf("Expected 1 Unauthorized and %d Too Many Requests, got %v", attempts-1, counts)
+	}
+}
+
+// TestLogin_SuccessNotCountedForIP tests that successful logins do not count against the client IP
+func TestLogin_SuccessNotCountedForIP(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxIPFailures = 2
+	app, _, user := setupLoginTest(t, loginCfg)
+
+	for i := 0; i < 3; i++ {
+		login(t, app, user)
+	}
+}
+
+// TestLogin_ParallelLoginsFromOneIP tests that users behind one client IP can log in at the same time
+func TestLogin_ParallelLoginsFromOneIP(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.Backoff = time.Hour
+	loginCfg.MaxBackoff = time.Hour
+	app, database, _ := setupLoginTest(t, loginCfg)
+
+	const users = 5
+	emails := make([]string, users)
+	for i := range emails {
+		user, err := types.NewUserFromParams(types.CreateUserParams{
+			FirstName: "Parallel",
+			LastName:  "User",
+			Email:     "parallel" + strconv.Itoa(i) + "@example.com",
+			Password:  "password123",
+		})
+		if err != nil {
+			t.Fatalf("Error creating user: %v", err)
+		}
+		if _, err := db.NewMemoryUserStore(database).InsertUser(context.TODO(), user); err != nil {
+			t.Fatalf("Error inserting user: %v", err)
+		}
+		emails[i] = user.Email
+	}
+
+	for round := 0; round < 2; round++ {
+		statuses := make(chan int, users)
+		var wg sync.WaitGroup
+		for _, email := range emails {
+			wg.Add(1)
+			go func(email string) {
+				defer wg.Done()
+				resp := attemptLogin(t, app, email, "password123")
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}(email)
+		}
+		wg.Wait()
+		close(statuses)
+		for status := range statuses {
+			if status != http.StatusOK {
+				t.Errorf("Expected every login of round %d to succeed, got %v", round+1, status)
+			}
+		}
+	}
+}
+
+// TestLogin_Lockout tests that too many failures lock the account until an admin unlocks it
+func TestLogin_Lockout(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxFailures = 3
+	app, database, user := setupLoginTest(t, loginCfg)
+
+	failLogins(t, app, user.Email, 3)
+	resp := attemptLogin(t, app, user.Email, "password123")
+	defer resp.Body.Close()
+	expectRetryAfter(t, resp, loginCfg.Lockout)
+	expectReason(t, resp, http.StatusTooManyRequests, "account_locked")
+
+	// The lockout is recorded for the admins
+	adminToken := createTestAdmin(t, app, database)
+	var events []types.LockoutEvent
+	getLockouts(t, app, adminToken, "/api/v1/lockout?active=true", &events)
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 active lockout, got %d", len(events))
+	}
+	if events[0].Email != user.Email || events[0].UserID == nil || *events[0].UserID != user.ID || events[0].Failures != 3 {
+		t.Errorf("Expected a lockout of the user after 3 failures, got %+v", events[0])
+	}
+
+	// Unlocking lets the user log in again
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/"+user.ID.Hex()+"/unlock", nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	login(t, app, user)
+
+	getLockouts(t, app, adminToken, "/api/v1/lockout?active=true", &events)
+	if len(events) != 0 {
+		t.Errorf("Expected no active lockout after unlocking, got %d", len(events))
+	}
+	getLockouts(t, app, adminToken, "/api/v1/lockout?email="+user.Email, &events)
+	if len(events) != 1 || events[0].UnlockedAt == nil {
+		t.Errorf("Expected the lockout to be marked unlocked, got %+v", events)
+	}
+}
+
+// getLockouts lists the lockout events at url as the admin
+func getLockouts(t *testing.T, app *fiber.App, adminToken, url string, events *[]types.LockoutEvent) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("Authorization", "Bearer "+adminToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	*events = nil
+	if err := json.NewDecoder(resp.Body).Decode(events); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+}
+
+// TestLogin_UnknownEmailLockout tests that failures for unknown emails are counted like any other
+func TestLogin_UnknownEmailLockout(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxFailures = 2
+	app, _, _ := setupLoginTest(t, loginCfg)
+
+	failLogins(t, app, "nobody@example.com", 2)
+	resp := attemptLogin(t, app, "Nobody@Example.com", "wrongpassword")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusTooManyRequests, "account_locked")
+}
+
+// TestLogin_IPLockout tests that trying many accounts from one client locks the client
+func TestLogin_IPLockout(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxIPFailures = 3
+	app, _, user := setupLoginTest(t, loginCfg)
+
+	for _, email := range []string{"a@example.com", "b@example.com", "c@example.com"} {
+		failLogins(t, app, email, 1)
+	}
+	resp := attemptLogin(t, app, user.Email, "password123")
+	defer resp.Body.Close()
+	expectRetryAfter(t, resp, loginCfg.Lockout)
+	expectReason(t, resp, http.StatusTooManyRequests, "too_many_attempts")
+}
+
+// TestLogin_SuccessResetsFailures tests that a successful login forgets the earlier failures
+func TestLogin_SuccessResetsFailures(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxFailures = 3
+	app, _, user := setupLoginTest(t, loginCfg)
+
+	failLogins(t, app, user.Email, 2)
+	login(t, app, user)
+	failLogins(t, app, user.Email, 2)
+	login(t, app, user)
+}
+
+// TestChangePassword_Throttled tests that wrong current passwords count as failed logins of the account
+func TestChangePassword_Throttled(t *testing.T) {
+	loginCfg := testLoginConfig
+	loginCfg.MaxFailures = 2
+	app, _, user := setupLoginTest(t, loginCfg)
+	tokens := login(t, app, user)
+
+	for i := 0; i < 2; i++ {
+		time.Sleep(2 * time.Millisecond)
+		resp := changePassword(t, app, tokens.Token, types.ChangePasswordParams{CurrentPassword: "wrongpassword", NewPassword: "newpassword456"})
+		expectReason(t, resp, http.StatusForbidden, "invalid_current_password")
+		resp.Body.Close()
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	// The account is locked, for changing the password as well as for logging in
+	resp := changePassword(t, app, tokens.Token, types.ChangePasswordParams{CurrentPassword: "password123", NewPassword: "newpassword456"})
+	expectReason(t, resp, http.StatusTooManyRequests, "account_locked")
+	resp.Body.Close()
+	resp = attemptLogin(t, app, user.Email, "password123")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusTooManyRequests, "account_locked")
+}
+
+// TestUnlockUser_RequiresAdmin tests that only admins can unlock accounts
+func TestUnlockUser_RequiresAdmin(t *testing.T) {
+	app, _, user := setupLoginTest(t, testLoginConfig)
+	tokens := login(t, app, user)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/user/"+user.ID.Hex()+"/unlock", nil)
+	req.Header.Set("Authorization", "Bearer "+tokens.Token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status Forbidden, got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/notify"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// linkPattern finds the link in an email
+var linkPattern = regexp.MustCompile(`http://\S+`)
+
+// forgotPassword requests a reset email for the address and returns the response
+func forgotPassword(t *testing.T, app *fiber.App, email string) *http.Response {
+	return postJSON(t, app, "/api/auth/forgot", types.ForgotPasswordParams{Email: email})
+}
+
+// resetPassword sets a new password with the token and returns the response
+func resetPassword(t *testing.T, app *fiber.App, token, password string) *http.Response {
+	return postJSON(t, app, "/api/auth/reset", types.ResetPasswordParams{Token: token, Password: password})
+}
+
+// postJSON posts the value as JSON to the path
+func postJSON(t *testing.T, app *fiber.App, path string, v interface{}) *http.Response {
+	body, _ := json.Marshal(v)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Without a timeout, parallel bcrypt comparisons can take longer than the default
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// resetToken returns the token of the last reset email sent to the user
+func resetToken(t *testing.T, mailer *notify.MemoryMailer, user *types.User) string {
+	return linkToken(t, mailer, user.Email, testAuthConfig.ResetURL)
+}
+
+// linkToken returns the token of the link to base in the last email sent to the address
+func linkToken(t *testing.T, mailer *notify.MemoryMailer, email, base string) string {
+	t.Helper()
+	msg, ok := mailer.Last(email)
+	if !ok {
+		t.Fatalf("Expected an email to %s", email)
+	}
+	link, err := url.Parse(linkPattern.FindString(msg.Body))
+	if err != nil {
+		t.Fatalf("Error parsing link: %v", err)
+	}
+	if !strings.HasPrefix(link.String(), base+"?") {
+		t.Fatalf("Expected the link to point to %s, got %s", base, link)
+	}
+	token := link.Query().Get("token")
+	if token == "" {
+		t.Fatalf("Expected a token in the link, got %s", link)
+	}
+	return token
+}
+
+// loginWith logs in with the password and returns the status
+func loginWith(t *testing.T, app *fiber.App, user *types.User, password string) int {
+	resp := postJSON(t, app, "/api/auth/login", loginReq{Email: user.Email, Password: password})
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestPasswordReset tests resetting a password with the emailed link
+func TestPasswordReset(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+
+	resp := forgotPassword(t, app, user.Email)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("Expected status Accepted, got %v", resp.StatusCode)
+	}
+	token := resetToken(t, mailer, user)
+
+	resp = resetPassword(t, app, token, "newpassword456")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected status No Content, got %v", resp.StatusCode)
+	}
+
+	if status := loginWith(t, app, user, "newpassword456"); status != http.StatusOK {
+		t.Errorf("Expected login with the new password to succeed, got %v", status)
+	}
+	if status := loginWith(t, app, user, "password123"); status != http.StatusUnauthorized {
+		t.Errorf("Expected login with the old password to fail, got %v", status)
+	}
+
+	// Sessions started with the old password are ended
+	if status := accessStatus(t, app, tokens.Token); status != http.StatusUnauthorized {
+		t.Errorf("Expected the old access token to be rejected, got %v", status)
+	}
+	resp = refresh(t, app, tokens.RefreshToken)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusUnauthorized, "invalid_refresh_token")
+}
+
+// TestPasswordReset_TokenSingleUse tests that a reset token works only once
+func TestPasswordReset_TokenSingleUse(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	forgotPassword(t, app, user.Email).Body.Close()
+	token := resetToken(t, mailer, user)
+
+	resetPassword(t, app, token, "newpassword456").Body.Close()
+
+	resp := resetPassword(t, app, token, "otherpassword789")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_reset_token")
+	if status := loginWith(t, app, user, "newpassword456"); status != http.StatusOK {
+		t.Errorf("Expected the password of the first reset to stay, got %v", status)
+	}
+}
+
+// TestPasswordReset_OnlyNewestLink tests that requesting a new link invalidates older ones
+func TestPasswordReset_OnlyNewestLink(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	forgotPassword(t, app, user.Email).Body.Close()
+	oldToken := resetToken(t, mailer, user)
+	forgotPassword(t, app, user.Email).Body.Close()
+	newToken := resetToken(t, mailer, user)
+
+	resp := resetPassword(t, app, oldToken, "newpassword456")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_reset_token")
+
+	resp = resetPassword(t, app, newToken, "newpassword456")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected the newest link to work, got %v", resp.StatusCode)
+	}
+}
+
+// TestPasswordReset_ExpiredToken tests that reset tokens stop working after the configured lifetime
+func TestPasswordReset_ExpiredToken(t *testing.T) {
+	authCfg := testAuthConfig
+	authCfg.ResetTTL = time.Millisecond
+	mailer := notify.NewMemoryMailer()
+	app, database, cleanup := setupAuthMailer(t, authCfg, mailer)
+	defer cleanup()
+
+	user := createTestUser(t, database)
+	forgotPassword(t, app, user.Email).Body.Close()
+	token := resetToken(t, mailer, user)
+	time.Sleep(10 * time.Millisecond)
+
+	resp := resetPassword(t, app, token, "newpassword456")
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusBadRequest, "invalid_reset_token")
+}
+
+// TestForgotPassword_UnknownEmail tests that unknown addresses get the same response and no email
+func TestForgotPassword_UnknownEmail(t *testing.T) {
+	mailer := notify.NewMemoryMailer()
+	app, _, cleanup := setupAuthMailer(t, testAuthConfig, mailer)
+	defer cleanup()
+
+	resp := forgotPassword(t, app, "nobody@example.com")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("Expected status Accepted, got %v", resp.StatusCode)
+	}
+	if messages := mailer.Messages("nobody@example.com"); len(messages) != 0 {
+		t.Errorf("Expected no email, got %d", len(messages))
+	}
+}
+
+// TestResetPassword_Invalid tests the reset requests that are rejected
+func TestResetPassword_Invalid(t *testing.T) {
+	app, _, cleanup := setupAuth(t)
+	defer cleanup()
+
+	tests := []struct {
+		name     string
+		token    string
+		password string
+		reason   string
+	}{
+		{"unknown token", "not-a-real-token", "newpassword456", "invalid_reset_token"},
+		{"missing token", "", "newpassword456", ""},
+		{"short password", "not-a-real-token", "short", ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := resetPassword(t, app, tc.token, tc.password)
+			defer resp.Body.Close()
+			if tc.reason != "" {
+				expectReason(t, resp, http.StatusBadRequest, tc.reason)
+			} else if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status Bad Request, got %v", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// changePassword changes the password of the user the access token belongs to
+func changePassword(t *testing.T, app *fiber.App, token string, params types.ChangePasswordParams) *http.Response {
+	body, _ := json.Marshal(params)
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/user/me/password", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	// Changing the password hashes twice, which can take longer than the default timeout
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// TestChangePassword tests changing the password with the current one
+func TestChangePassword(t *testing.T) {
+	app, database, cleanup := setupAuth(t)
+	defer cleanup()
+	user := createTestUser(t, database)
+	tokens := login(t, app, user)
+	other := login(t, app, user)
+
+	// The current password is required
+	resp := changePassword(t, app, tokens.Token, types.ChangePasswordParams{CurrentPassword: "wrongpassword", NewPassword: "newpassword456"})
+	expectReason(t, resp, http.StatusForbidden, "invalid_current_password")
+	resp.Body.Close()
+	// Wait out the backoff of the failure
+	time.Sleep(2 * time.Millisecond)
+
+	resp = changePassword(t, app, tokens.Token, types.ChangePasswordParams{CurrentPassword: "password123", NewPassword: "short"})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a short password, got %v", resp.StatusCode)
+	}
+
+	resp = changePassword(t, app, tokens.Token, types.ChangePasswordParams{CurrentPassword: "password123", NewPassword: "newpassword456"})
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	var fresh loginResp
+	if err := json.NewDecoder(resp.Body).Decode(&fresh); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	// Every earlier session is logged out, the new tokens work
+	for _, token := range []string{tokens.Token, other.Token} {
+		if status := accessStatus(t, app, token); status != http.StatusUnauthorized {
+			t.Errorf("Expected old access token to be rejected, got %v", status)
+		}
+	}
+	if status := accessStatus(t, app, fresh.Token); status != http.StatusOK {
+		t.Errorf("Expected new access token to be accepted, got %v", status)
+	}
+	if status := loginWith(t, app, user, "newpassword456"); status != http.StatusOK {
+		t.Errorf("Expected login with the new password to succeed, got %v", status)
+	}
+	if status := loginWith(t, app, user, "password123"); status != http.StatusUnauthorized {
+		t.Errorf("Expected login with the old password to fail, got %v", status)
+	}
+}
@@ -0,0 +1,549 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// bookWithPaymentMethod books the room for three nights starting in ten days, paying with the given method
+func bookWithPaymentMethod(t *testing.T, env *bookingTestEnv, roomID primitive.ObjectID, paymentMethod string) *http.Response {
+	from := time.Now().AddDate(0, 0, 10)
+	resp := bookRoom(t, env.app, roomID, api.BookRoomParams{
+		FromDate:      from,
+		TillDate:      from.AddDate(0, 0, 3),
+		NumPersons:    1,
+		PaymentMethod: paymentMethod,
+	})
+	if resp == nil {
+		t.FailNow()
+	}
+	return resp
+}
+
+// sendWebhook posts the payload to the webhook endpoint with the given signature
+func sendWebhook(t *testing.T, app *fiber.App, payload []byte, signature string) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, "/api/payments/webhook", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(api.PaymentSignatureHeader, signature)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// bookingPayments returns the recorded payments of the booking
+func bookingPayments(t *testing.T, env *bookingTestEnv, bookingID primitive.ObjectID) []*types.Payment {
+	paid, err := env.store.Payment.GetPayments(context.TODO(), bson.M{"bookingID": bookingID})
+	if err != nil {
+		t.Fatalf("Error getting payments: %v", err)
+	}
+	return paid
+}
+
+// TestBookRoomPayment tests that a booking is paid for when it is made
+func TestBookRoomPayment(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	paid := bookingPayments(t, env, booking.ID)
+	if len(paid) != 1 {
+		t.Fatalf("Expected one payment, got %d", len(paid))
+	}
+	if paid[0].Status != types.PaymentStatusAuthorized || paid[0].Amount != booking.Price.Total || paid[0].Currency != "EUR" {
+		t.Errorf("Expected an authorized payment of %.2f EUR, got %+v", booking.Price.Total, paid[0])
+	}
+
+	// The payment is part of the booking detail
+	var detail api.BookingDetail
+	resp := getJSON(t, env.app, fmt.Sprintf("/api/v1/booking/%s", booking.ID.Hex()), &detail)
+	defer resp.Body.Close()
+	if len(detail.Payments) != 1 || detail.Payments[0].ID != paid[0].ID {
+		t.Errorf("Expected the payment in the booking detail, got %+v", detail.Payments)
+	}
+}
+
+// TestBookRoomWithoutPaymentMethod tests that a booking needs a payment method
+func TestBookRoomWithoutPaymentMethod(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+
+	from := time.Now().AddDate(0, 0, 10)
+	body, _ := json.Marshal(api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 3), NumPersons: 1})
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/room/%s/book", room.ID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := env.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status Bad Request, got %v", resp.StatusCode)
+	}
+}
+
+// TestBookRoomPaymentDeclined tests that a declined payment fails the booking and frees the nights
+func TestBookRoomPaymentDeclined(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+
+	resp := bookWithPaymentMethod(t, env, room.ID, payments.MockCardDeclined)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusPaymentRequired, "payment_declined")
+
+	bookings, err := env.store.Booking.GetBookings(context.TODO(), bson.M{"roomID": room.ID})
+	if err != nil {
+		t.Fatalf("Error getting bookings: %v", err)
+	}
+	if len(bookings) != 1 || bookings[0].Status != types.BookingStatusFailed {
+		t.Fatalf("Expected one failed booking, got %+v", bookings)
+	}
+	if paid := bookingPayments(t, env, bookings[0].ID); len(paid) != 1 || paid[0].Status != types.PaymentStatusFailed {
+		t.Errorf("Expected a failed payment, got %+v", paid)
+	}
+
+	// Someone else can book the nights
+	env.login(newTestGuest("other@example.com"))
+	createTestBooking(t, env, room)
+}
+
+// TestPendingPaymentWebhook tests that a pending payment is decided by the webhook of the gateway
+func TestPendingPaymentWebhook(t *testing.T) {
+	tests := []struct {
+		name          string
+		succeeded     bool
+		bookingStatus types.BookingStatus
+		paymentStatus types.PaymentStatus
+	}{
+		{"succeeded", true, types.BookingStatusConfirmed, types.PaymentStatusAuthorized},
+		{"failed", false, types.BookingStatusFailed, types.PaymentStatusFailed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env, cleanup := setupBookingTest(t)
+			defer cleanup()
+
+			room := insertBookableRoom(t, env.store)
+			env.login(newTestGuest("owner@example.com"))
+
+			resp := bookWithPaymentMethod(t, env, room.ID, payments.MockCardPending)
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("Expected status Accepted, got %v", resp.StatusCode)
+			}
+			var booking types.Booking
+			if err := json.NewDecoder(resp.Body).Decode(&booking); err != nil {
+				t.Fatalf("Error decoding booking: %v", err)
+			}
+			if booking.Status != types.BookingStatusPending {
+				t.Fatalf("Expected a pending booking, got %s", booking.Status)
+			}
+
+			paid := bookingPayments(t, env, booking.ID)
+			if len(paid) != 1 {
+				t.Fatalf("Expected one payment, got %d", len(paid))
+			}
+			payload, signature, err := env.provider.CompletePayment(paid[0].ProviderRef, tc.succeeded)
+			if err != nil {
+				t.Fatalf("Error completing payment: %v", err)
+			}
+
+			// Gateways deliver events at least once, the second delivery changes nothing
+			for i := 0; i < 2; i++ {
+				resp := sendWebhook(t, env.app, payload, signature)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Fatalf("Expected status OK for delivery %d, got %v", i+1, resp.StatusCode)
+				}
+			}
+
+			updated, err := env.store.Booking.GetBookingByID(context.TODO(), booking.ID)
+			if err != nil {
+				t.Fatalf("Error getting booking: %v", err)
+			}
+			if updated.Status != tc.bookingStatus {
+				t.Errorf("Expected booking status %s, got %s", tc.bookingStatus, updated.Status)
+			}
+			if paid := bookingPayments(t, env, booking.ID); paid[0].Status != tc.paymentStatus {
+				t.Errorf("Expected payment status %s, got %s", tc.paymentStatus, paid[0].Status)
+			}
+		})
+	}
+}
+
+// TestWebhookInvalidSignature tests that webhooks not signed with the secret are rejected
+func TestWebhookInvalidSignature(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	resp := bookWithPaymentMethod(t, env, room.ID, payments.MockCardPending)
+	resp.Body.Close()
+
+	bookings, err := env.store.Booking.GetBookings(context.TODO(), bson.M{"roomID": room.ID})
+	if err != nil || len(bookings) != 1 {
+		t.Fatalf("Expected one booking, got %d, %v", len(bookings), err)
+	}
+	paid := bookingPayments(t, env, bookings[0].ID)
+	payload, _, err := env.provider.CompletePayment(paid[0].ProviderRef, true)
+	if err != nil {
+		t.Fatalf("Error completing payment: %v", err)
+	}
+
+	forged := payments.NewMockProvider("other-secret").Sign(payload)
+	resp = sendWebhook(t, env.app, payload, forged)
+	defer resp.Body.Close()
+	expectReason(t, resp, http.StatusUnauthorized, "invalid_signature")
+
+	if booking, _ := env.store.Booking.GetBookingByID(context.TODO(), bookings[0].ID); booking.Status != types.BookingStatusPending {
+		t.Errorf("Expected the booking to stay pending, got %s", booking.Status)
+	}
+}
+
+// TestCancelBookingRefund tests that cancelling a booking refunds its payment
+func TestCancelBookingRefund(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	resp := cancelBooking(t, env.app, booking.ID)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	paid := bookingPayments(t, env, booking.ID)
+	if len(paid) != 1 || paid[0].Status != types.PaymentStatusRefunded {
+		t.Errorf("Expected a refunded payment, got %+v", paid)
+	}
+
+	// A booking nothing was paid for refunds nothing
+	from := time.Now().AddDate(0, 1, 0)
+	unpaid, err := env.store.Booking.InsertBookingIfAvailable(context.TODO(), &types.Booking{
+		RoomID:    room.ID,
+		UserID:    env.user.ID,
+		FromDate:  from,
+		TillDate:  from.AddDate(0, 0, 2),
+		NumPerson: 1,
+		Status:    types.BookingStatusConfirmed,
+		Price:     types.NewPriceBreakdown(room.Price, from, from.AddDate(0, 0, 2)),
+	})
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	resp = cancelBooking(t, env.app, unpaid.ID)
+	defer resp.Body.Close()
+	var cancelled types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&cancelled); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if c := cancelled.Cancellation; c == nil || c.Refund != 0 || cancelled.IsSettlementPending() {
+		t.Errorf("Expected a settled cancellation without refund, got %+v", c)
+	}
+}
+
+// TestCancelBookingSettlementRetry tests that a cancellation the gateway could not
+// settle stays pending until the cancellation is retried
+func TestCancelBookingSettlementRetry(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+
+	// A later payment the gateway does not know makes the settlement fail after
+	// the payment of the booking has been refunded
+	unknown, err := env.store.Payment.InsertPayment(context.TODO(), &types.Payment{
+		BookingID:   booking.ID,
+		UserID:      booking.UserID,
+		Provider:    env.provider.Name(),
+		ProviderRef: "mock_pay_unknown",
+		Amount:      10,
+		Currency:    "EUR",
+		Status:      types.PaymentStatusAuthorized,
+		CreatedAt:   time.Now().Add(time.Hour),
+		UpdatedAt:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Error inserting payment: %v", err)
+	}
+
+	resp := cancelBooking(t, env.app, booking.ID)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("Expected status Internal Server Error, got %v", resp.StatusCode)
+	}
+	pending, err := env.store.Booking.GetBookingByID(context.TODO(), booking.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if !pending.IsSettlementPending() {
+		t.Fatalf("Expected the cancellation to wait for its settlement, got %+v", pending)
+	}
+
+	// Once the gateway works again, retrying finishes the settlement
+	if _, err := env.store.Payment.UpdatePaymentStatus(context.TODO(), unknown.ID, types.PaymentStatusAuthorized, types.PaymentStatusFailed, "gateway_error"); err != nil {
+		t.Fatalf("Error updating payment: %v", err)
+	}
+	resp = cancelBooking(t, env.app, booking.ID)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	var settled types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&settled); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if settled.Status != types.BookingStatusCancelled || settled.IsSettlementPending() {
+		t.Errorf("Expected a settled cancellation, got %+v", settled)
+	}
+	// The refund made by the first attempt is part of what was given back
+	if c := settled.Cancellation; c == nil || c.Refund != booking.Price.Total {
+		t.Errorf("Expected a refund of %.2f, got %+v", booking.Price.Total, c)
+	}
+	paid := bookingPayments(t, env, booking.ID)
+	if len(paid) != 2 || paid[0].Status != types.PaymentStatusRefunded {
+		t.Errorf("Expected the payment to be refunded, got %+v", paid)
+	}
+
+	// Once settled, cancelling again is rejected
+	again := cancelBooking(t, env.app, booking.ID)
+	defer again.Body.Close()
+	if again.StatusCode != http.StatusConflict {
+		t.Errorf("Expected status Conflict, got %v", again.StatusCode)
+	}
+}
+
+// failingPaymentStore is a PaymentStore that cannot record new payments
+type failingPaymentStore struct {
+	db.PaymentStore
+}
+
+// InsertPayment fails like a database that is down
+func (s failingPaymentStore) InsertPayment(context.Context, *types.Payment) (*types.Payment, error) {
+	return nil, errors.New("database unavailable")
+}
+
+// TestBookRoomPaymentNotRecorded tests that an authorization is released when it cannot be recorded
+func TestBookRoomPaymentNotRecorded(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+
+	failing := *env.store
+	failing.Payment = failingPaymentStore{env.store.Payment}
+	roomHandler := api.NewRoomHandler(&failing, config.Default().Booking, env.provider, config.Default().Payments)
+	env.app.Post("/api/v2/room/:id/book", roomHandler.HandleBookRoom)
+
+	from := time.Now().AddDate(0, 0, 10)
+	body, _ := json.Marshal(api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 3), NumPersons: 1, PaymentMethod: payments.MockCardApproved})
+	resp := sendJSON(t, env.app, http.MethodPost, fmt.Sprintf("/api/v2/room/%s/book", room.ID.Hex()), string(body))
+	expectStatus(t, resp, http.StatusInternalServerError)
+
+	// The mock gateway numbers its payments, the first one was released
+	if _, err := env.provider.Capture(context.TODO(), "mock_pay_1", 1); err == nil {
+		t.Error("Expected the unrecorded authorization to be released")
+	}
+	bookings, err := env.store.Booking.GetBookings(context.TODO(), bson.M{"roomID": room.ID})
+	if err != nil {
+		t.Fatalf("Error getting bookings: %v", err)
+	}
+	if len(bookings) != 1 || bookings[0].Status != types.BookingStatusFailed {
+		t.Errorf("Expected the booking to fail, got %+v", bookings)
+	}
+}
+
+// TestConfirmHoldPaymentDeclined tests that a hold stays held when its payment is declined
+func TestConfirmHoldPaymentDeclined(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	hold := createTestHold(t, env, room)
+
+	resp := confirmBooking(t, env.app, hold.ID, payments.MockCardDeclined)
+	expectReason(t, resp, http.StatusPaymentRequired, "payment_declined")
+	resp.Body.Close()
+
+	booking, err := env.store.Booking.GetBookingByID(context.TODO(), hold.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if booking.Status != types.BookingStatusHeld {
+		t.Fatalf("Expected the booking to stay held, got %s", booking.Status)
+	}
+
+	// The guest can try another card before the hold expires
+	resp = confirmBooking(t, env.app, hold.ID, payments.MockCardApproved)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+	paid := bookingPayments(t, env, hold.ID)
+	if len(paid) != 2 || paid[0].Status != types.PaymentStatusFailed || paid[1].Status != types.PaymentStatusAuthorized {
+		t.Errorf("Expected a failed and an authorized payment, got %+v", paid)
+	}
+}
+
+// activeTotal returns the amount of the authorized or captured payments of the booking
+func activeTotal(t *testing.T, env *bookingTestEnv, bookingID primitive.ObjectID) float64 {
+	total := 0.0
+	for _, payment := range bookingPayments(t, env, bookingID) {
+		switch payment.Status {
+		case types.PaymentStatusAuthorized:
+			total += payment.Amount
+		case types.PaymentStatusCaptured:
+			total += payment.Captured
+		}
+	}
+	return math.Round(total*100) / 100
+}
+
+// TestModifyBookingPayment tests that the payments of a confirmed booking follow its total
+func TestModifyBookingPayment(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	booking := createTestBooking(t, env, room)
+	path := "/api/v1/booking/" + booking.ID.Hex()
+
+	// A new total needs a payment method
+	till := booking.TillDate.AddDate(0, 0, 1).Format(time.RFC3339)
+	resp := sendJSON(t, env.app, http.MethodPatch, path, fmt.Sprintf(`{"tillDate":%q}`, till))
+	expectReason(t, resp, http.StatusBadRequest, "bad_request")
+	resp.Body.Close()
+
+	// A declined card leaves the booking and its payment as they are
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 1), PaymentMethod: payments.MockCardDeclined})
+	expectReason(t, resp, http.StatusPaymentRequired, "payment_declined")
+	resp.Body.Close()
+	if total := activeTotal(t, env, booking.ID); total != booking.Price.Total {
+		t.Errorf("Expected %.2f to stay authorized, got %.2f", booking.Price.Total, total)
+	}
+
+	// So does a payment the gateway cannot decide right away
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 1), PaymentMethod: payments.MockCardPending})
+	expectReason(t, resp, http.StatusPaymentRequired, "payment_not_authorized")
+	resp.Body.Close()
+
+	// Until the gateway decides that payment the booking cannot be changed; once it
+	// is authorized it is given back, since the change was not made
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 1)})
+	expectReason(t, resp, http.StatusConflict, "booking_not_modifiable")
+	resp.Body.Close()
+	paid := bookingPayments(t, env, booking.ID)
+	payload, signature, err := env.provider.CompletePayment(paid[len(paid)-1].ProviderRef, true)
+	if err != nil {
+		t.Fatalf("Error completing payment: %v", err)
+	}
+	resp = sendWebhook(t, env.app, payload, signature)
+	expectStatus(t, resp, http.StatusOK)
+	if paid := bookingPayments(t, env, booking.ID); paid[len(paid)-1].Status != types.PaymentStatusRefunded {
+		t.Errorf("Expected the late authorization to be given back, got %+v", paid)
+	}
+
+	// Extending and shortening the stay authorizes the new total in place of the old one
+	for _, tillDate := range []time.Time{booking.TillDate.AddDate(0, 0, 1), booking.TillDate.AddDate(0, 0, -1)} {
+		resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: tillDate})
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+		}
+		var modified types.Booking
+		if err := json.NewDecoder(resp.Body).Decode(&modified); err != nil {
+			t.Fatalf("Error decoding booking: %v", err)
+		}
+		resp.Body.Close()
+		if total := activeTotal(t, env, booking.ID); total != modified.Price.Total {
+			t.Errorf("Expected the payments to add up to %.2f, got %.2f", modified.Price.Total, total)
+		}
+	}
+
+	// The same total needs no new payment
+	resp = sendJSON(t, env.app, http.MethodPatch, path, `{"numPersons":2}`)
+	expectStatus(t, resp, http.StatusOK)
+}
+
+// TestModifyPendingBooking tests that a booking waiting for its payment cannot be changed
+func TestModifyPendingBooking(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	resp := bookWithPaymentMethod(t, env, room.ID, payments.MockCardPending)
+	var booking types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&booking); err != nil {
+		t.Fatalf("Error decoding booking: %v", err)
+	}
+	resp.Body.Close()
+
+	resp = modifyBooking(t, env.app, booking.ID, api.ModifyBookingParams{TillDate: booking.TillDate.AddDate(0, 0, 1)})
+	expectReason(t, resp, http.StatusConflict, "booking_not_modifiable")
+	resp.Body.Close()
+	if paid := bookingPayments(t, env, booking.ID); len(paid) != 1 {
+		t.Errorf("Expected no new payment, got %+v", paid)
+	}
+}
+
+// TestModifyHoldWithPendingPayment tests that a hold whose confirmation is waiting
+// for the gateway cannot be changed, so it is not confirmed for terms it was not paid for
+func TestModifyHoldWithPendingPayment(t *testing.T) {
+	env, cleanup := setupBookingTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, env.store)
+	env.login(newTestGuest("owner@example.com"))
+	hold := createTestHold(t, env, room)
+
+	resp := confirmBooking(t, env.app, hold.ID, payments.MockCardPending)
+	expectStatus(t, resp, http.StatusAccepted)
+
+	resp = modifyBooking(t, env.app, hold.ID, api.ModifyBookingParams{TillDate: hold.TillDate.AddDate(0, 0, 1)})
+	expectReason(t, resp, http.StatusConflict, "booking_not_modifiable")
+	resp.Body.Close()
+	booking, err := env.store.Booking.GetBookingByID(context.TODO(), hold.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if booking.Status != types.BookingStatusHeld || len(booking.History) != 0 {
+		t.Errorf("Expected the hold to keep its terms, got %+v", booking)
+	}
+}
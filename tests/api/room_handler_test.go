@@ -0,0 +1,357 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setupRoomTest creates a test server with the room routes
+// Requests are authenticated as the returned user
+func setupRoomTest(t *testing.T) (*fiber.App, *db.Store, *types.User, func()) {
+	database := db.NewMemoryDatabase()
+
+	// Initialize stores and handlers
+	hotelStore := db.NewMemoryHotelStore(database)
+	store := &db.Store{
+		Hotel:   hotelStore,
+		Room:    db.NewMemoryRoomStore(database, hotelStore),
+		User:    db.NewMemoryUserStore(database),
+		Booking: db.NewMemoryBookingStore(database),
+		Payment: db.NewMemoryPaymentStore(database),
+	}
+	cfg := config.Default()
+	roomHandler := api.NewRoomHandler(store, cfg.Booking, payments.NewMockProvider("test-secret"), cfg.Payments)
+
+	user := &types.User{
+		ID:        primitive.NewObjectID(),
+		FirstName: "Room",
+		LastName:  "Tester",
+		Email:     "roomtester@example.com",
+	}
+
+	// Setup Fiber app, standing in for the JWT middleware by setting the user directly
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", user)
+		return c.Next()
+	})
+	app.Post("/api/v1/room/:id/book", roomHandler.HandleBookRoom)
+	app.Post("/api/v1/room/:id/quote", roomHandler.HandleGetQuote)
+
+	// Return test server and cleanup function
+	return app, store, user, func() {}
+}
+
+// insertBookableRoom inserts a hotel with a single room
+func insertBookableRoom(t *testing.T, store *db.Store) *types.Room {
+	hotel, err := store.Hotel.Insert(context.TODO(), &types.Hotel{
+		Name:     "Booking Hotel",
+		Location: "Rome",
+		Rooms:    []primitive.ObjectID{},
+		Rating:   4,
+	})
+	if err != nil {
+		t.Fatalf("Error inserting test hotel: %v", err)
+	}
+
+	room, err := store.Room.InsertRoom(context.TODO(), &types.Room{
+		Size:    "normal",
+		Price:   120,
+		HotelID: hotel.ID,
+	})
+	if err != nil {
+		t.Fatalf("Error inserting test room: %v", err)
+	}
+	return room
+}
+
+// setCancellationPolicy gives the hotel of the room the cancellation policy
+func setCancellationPolicy(t *testing.T, store *db.Store, room *types.Room, policy types.CancellationPolicy) {
+	if err := store.Hotel.Update(context.TODO(), bson.M{"_id": room.HotelID}, bson.M{"$set": bson.M{"cancellationPolicy": policy}}); err != nil {
+		t.Fatalf("Error setting cancellation policy: %v", err)
+	}
+}
+
+// bookRoom sends a booking request for the given room
+func bookRoom(t *testing.T, app *fiber.App, roomID primitive.ObjectID, params api.BookRoomParams) *http.Response {
+	// Tests not about payments pay with a card the mock gateway always accepts
+	if params.PaymentMethod == "" {
+		params.PaymentMethod = payments.MockCardApproved
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		t.Errorf("Error marshaling booking params: %v", err)
+		return nil
+	}
+
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/room/%s/book", roomID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Errorf("Error making request: %v", err)
+		return nil
+	}
+	return resp
+}
+
+// TestBookRoom tests booking a free room
+func TestBookRoom(t *testing.T) {
+	app, store, user, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+
+	resp := bookRoom(t, app, room.ID, api.BookRoomParams{
+		FromDate:   from,
+		TillDate:   from.AddDate(0, 0, 3),
+		NumPersons: 2,
+	})
+	if resp == nil {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+
+	// Check status code
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	// Parse response
+	var booking types.Booking
+	if err := json.NewDecoder(resp.Body).Decode(&booking); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+
+	// Verify booking fields
+	if booking.ID.IsZero() {
+		t.Errorf("Expected booking ID to be set")
+	}
+	if booking.RoomID != room.ID {
+		t.Errorf("Expected room ID %v, got %v", room.ID, booking.RoomID)
+	}
+	if booking.UserID != user.ID {
+		t.Errorf("Expected user ID %v, got %v", user.ID, booking.UserID)
+	}
+
+	// Verify the price was stored: 3 nights at 120 plus 10% taxes
+	if booking.Price == nil {
+		t.Fatalf("Expected booking price to be set")
+	}
+	if len(booking.Price.Nights) != 3 {
+		t.Errorf("Expected 3 priced nights, got %d", len(booking.Price.Nights))
+	}
+	if booking.Price.Subtotal != 360 || booking.Price.Taxes != 36 || booking.Price.Total != 396 {
+		t.Errorf("Expected subtotal 360, taxes 36 and total 396, got %+v", booking.Price)
+	}
+}
+
+// TestGetQuote tests pricing a stay without booking the room
+func TestGetQuote(t *testing.T) {
+	app, store, _, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, store)
+	setCancellationPolicy(t, store, room, types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFirstNight})
+	from := time.Now().AddDate(0, 0, 10)
+
+	body, _ := json.Marshal(api.BookRoomParams{
+		FromDate:   from,
+		TillDate:   from.AddDate(0, 0, 2),
+		NumPersons: 1,
+	})
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/room/%s/quote", room.ID.Hex()), bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	var quote api.BookingQuote
+	if err := json.NewDecoder(resp.Body).Decode(&quote); err != nil {
+		t.Fatalf("Error decoding response: %v", err)
+	}
+	if quote.Price == nil || quote.Price.Total != 264 {
+		t.Errorf("Expected total 264, got %+v", quote.Price)
+	}
+	expected := "Free cancellation until 48 hours before check-in. Later cancellations are charged the first night."
+	if quote.CancellationPolicy.Penalty != types.CancellationPenaltyFirstNight || quote.CancellationPolicyText != expected {
+		t.Errorf("Expected the hotel's cancellation policy, got %+v, %q", quote.CancellationPolicy, quote.CancellationPolicyText)
+	}
+
+	// Verify nothing was booked
+	bookings, err := store.Booking.GetBookings(context.TODO(), bson.M{"roomID": room.ID})
+	if err != nil {
+		t.Fatalf("Error getting bookings: %v", err)
+	}
+	if len(bookings) != 0 {
+		t.Errorf("Expected no stored bookings, got %d", len(bookings))
+	}
+}
+
+// TestBookRoomConcurrent fires many parallel bookings for the same room and dates
+// and checks that exactly one of them wins
+func TestBookRoomConcurrent(t *testing.T) {
+	app, store, _, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	params := api.BookRoomParams{
+		FromDate:   from,
+		TillDate:   from.AddDate(0, 0, 2),
+		NumPersons: 1,
+	}
+
+	const attempts = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		booked   int
+		conflict int
+	)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := bookRoom(t, app, room.ID, params)
+			if resp == nil {
+				return
+			}
+			defer resp.Body.Close()
+			mu.Lock()
+			defer mu.Unlock()
+			switch resp.StatusCode {
+			case http.StatusOK:
+				booked++
+			case http.StatusConflict:
+				conflict++
+			}
+		}()
+	}
+	wg.Wait()
+
+	if booked != 1 {
+		t.Errorf("Expected exactly 1 successful booking, got %d", booked)
+	}
+	if conflict != attempts-1 {
+		t.Errorf("Expected %d conflicts, got %d", attempts-1, conflict)
+	}
+
+	// Verify only one booking was stored
+	bookings, err := store.Booking.GetBookings(context.TODO(), bson.M{"roomID": room.ID})
+	if err != nil {
+		t.Fatalf("Error getting bookings: %v", err)
+	}
+	if len(bookings) != 1 {
+		t.Errorf("Expected 1 stored booking, got %d", len(bookings))
+	}
+}
+
+// TestBookRoomErrors tests the status codes of rejected booking requests
+func TestBookRoomErrors(t *testing.T) {
+	app, store, _, cleanup := setupRoomTest(t)
+	defer cleanup()
+
+	room := insertBookableRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	checkIn := time.Date(from.Year(), from.Month(), from.Day(), 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		roomID   string
+		params   api.BookRoomParams
+		expected int
+	}{
+		{
+			name:     "invalid room id",
+			roomID:   "not-an-id",
+			params:   api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 1), NumPersons: 1},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "dates in the past",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: from.AddDate(0, 0, -20), TillDate: from, NumPersons: 1},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "fromDate after tillDate",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: from.AddDate(0, 0, 2), TillDate: from, NumPersons: 1},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "no guests",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 1), NumPersons: 0},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "more guests than the room sleeps",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 1), NumPersons: types.DefaultRoomCapacity + 1},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "unknown room",
+			roomID:   primitive.NewObjectID().Hex(),
+			params:   api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, 1), NumPersons: 1},
+			expected: http.StatusNotFound,
+		},
+		{
+			name:     "no night covered",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: checkIn, TillDate: checkIn.Add(8 * time.Hour), NumPersons: 1},
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "stay too long",
+			roomID:   room.ID.Hex(),
+			params:   api.BookRoomParams{FromDate: from, TillDate: from.AddDate(0, 0, types.MaxBookingNights+1), NumPersons: 1},
+			expected: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.params.PaymentMethod = payments.MockCardApproved
+			body, _ := json.Marshal(tc.params)
+			req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/api/v1/room/%s/book", tc.roomID), bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+}
@@ -13,29 +13,23 @@ import (
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// Setup creates a test server with the specified MongoDB client and test database
-func setup(t *testing.T) (*fiber.App, *mongo.Client, func()) {
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		t.Fatalf("Error connecting to MongoDB: %v", err)
-	}
+// Setup creates a test server backed by an in-memory user store
+func setup(t *testing.T) (*fiber.App, *db.MemoryDatabase, func()) {
+	database := db.NewMemoryDatabase()
 
 	// Initialize user store and handler
-	userStore := db.NewMongoUserStore(client)
+	userStore := db.NewMemoryUserStore(database)
 	userHandler := api.NewUserHandler(userStore)
 
-	// Clean up the user collection before test
-	_, err = client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
-	if err != nil {
-		t.Fatalf("Error cleaning users collection: %v", err)
-	}
-
-	// Setup Fiber app
-	app := fiber.New()
+	// Setup Fiber app, standing in for the JWT middleware by running every request as an admin
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	admin := &types.User{ID: primitive.NewObjectID(), Role: types.RoleAdmin}
+	app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", admin)
+		return c.Next()
+	})
 	
 	// Setup user routes manually
 	app.Post("/api/users", userHandler.HandlePostUser)
@@ -45,16 +39,7 @@ func setup(t *testing.T) (*fiber.App, *mongo.Client, func()) {
 	app.Put("/api/users/:id", userHandler.HandlePutUser)
 
 	// Return test server and cleanup function
-	return app, client, func() {
-		// Clean up after test
-		_, err := client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
-		if err != nil {
-			t.Fatalf("Error cleaning users collection: %v", err)
-		}
-		if err := client.Disconnect(context.TODO()); err != nil {
-			t.Fatalf("Error disconnecting from MongoDB: %v", err)
-		}
-	}
+	return app, database, func() {}
 }
 
 // TestCreateUser tests the creation of a new user through the API
@@ -115,11 +100,11 @@ func TestCreateUser(t *testing.T) {
 
 // TestGetUser tests retrieving a user by ID through the API
 func TestGetUser(t *testing.T) {
-	app, client, cleanup := setup(t)
+	app, database, cleanup := setup(t)
 	defer cleanup()
 
 	// Create a user directly in the database first
-	userStore := db.NewMongoUserStore(client)
+	userStore := db.NewMemoryUserStore(database)
 	user := types.User{
 		ID:                primitive.NewObjectID(),
 		FirstName:         "Jane",
@@ -171,11 +156,11 @@ func TestGetUser(t *testing.T) {
 
 // TestGetUsers tests retrieving all users through the API
 func TestGetUsers(t *testing.T) {
-	app, client, cleanup := setup(t)
+	app, database, cleanup := setup(t)
 	defer cleanup()
 
 	// Create multiple users directly in the database
-	userStore := db.NewMongoUserStore(client)
+	userStore := db.NewMemoryUserStore(database)
 	users := []types.User{
 		{
 			ID:                primitive.NewObjectID(),
@@ -226,4 +211,218 @@ func TestGetUsers(t *testing.T) {
 	if len(fetchedUsers) != len(users) {
 		t.Errorf("Expected %d users, got %d", len(users), len(fetchedUsers))
 	}
-} 
\ No newline at end of file
+} 
+// TestGetUserErrors tests the status codes for unknown and malformed user IDs
+func TestGetUserErrors(t *testing.T) {
+	app, _, cleanup := setup(t)
+	defer cleanup()
+
+	testCases := []struct {
+		name     string
+		id       string
+		expected int
+	}{
+		{"unknown id", primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"malformed id", "12345", http.StatusBadRequest},
+		{"non-hex id", "zzzzzzzzzzzzzzzzzzzzzzzz", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/users/"+tc.id, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestCreateUserDuplicateEmail tests that creating a user with a taken email returns 409
+func TestCreateUserDuplicateEmail(t *testing.T) {
+	app, _, cleanup := setup(t)
+	defer cleanup()
+
+	params := types.CreateUserParams{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john@example.com",
+		Password:  "password123",
+	}
+	body, _ := json.Marshal(params)
+
+	for i, expected := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest(http.MethodPost, "/api/users", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("Error making request: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != expected {
+			t.Errorf("Attempt %d: expected status %v, got %v", i+1, expected, resp.StatusCode)
+		}
+	}
+}
+
+// setupUserUpdate creates a test server with the update route, running every
+// request as the user *as points to
+func setupUserUpdate(t *testing.T, as **types.User) (*fiber.App, db.UserStore) {
+	database := db.NewMemoryDatabase()
+	userStore := db.NewMemoryUserStore(database)
+	userHandler := api.NewUserHandler(userStore)
+
+	// Stand in for the JWT middleware by setting the user directly
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", *as)
+		return c.Next()
+	})
+	app.Get("/api/v1/user/:id", userHandler.HandleGetUser)
+	app.Put("/api/v1/user/:id", userHandler.HandlePutUser)
+	return app, userStore
+}
+
+// insertUpdateTestUser stores a user with the given role
+func insertUpdateTestUser(t *testing.T, userStore db.UserStore, email string, role types.Role) *types.User {
+	user := &types.User{
+		ID:                primitive.NewObjectID(),
+		FirstName:         "Update",
+		LastName:          "Tester",
+		Email:             email,
+		EncryptedPassword: "somehashedpassword",
+		Role:              role,
+	}
+	if _, err := userStore.InsertUser(context.TODO(), user); err != nil {
+		t.Fatalf("Error inserting test user: %v", err)
+	}
+	return user
+}
+
+// putUser sends the raw JSON body as an update of the user
+func putUser(t *testing.T, app *fiber.App, id, body string) *http.Response {
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/user/"+id, bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	return resp
+}
+
+// TestUpdateUser tests which users may update whom and which fields
+func TestUpdateUser(t *testing.T) {
+	var as *types.User
+	app, userStore := setupUserUpdate(t, &as)
+	guest := insertUpdateTestUser(t, userStore, "guest@example.com", types.RoleGuest)
+	other := insertUpdateTestUser(t, userStore, "other@example.com", types.RoleGuest)
+	admin := insertUpdateTestUser(t, userStore, "admin@example.com", types.RoleAdmin)
+
+	testCases := []struct {
+		name     string
+		as       *types.User
+		id       string
+		body     string
+		expected int
+	}{
+		{"own name", guest, guest.ID.Hex(), `{"firstName":"Jane"}`, http.StatusOK},
+		{"other user", guest, other.ID.Hex(), `{"firstName":"Jane"}`, http.StatusForbidden},
+		{"own role", guest, guest.ID.Hex(), `{"role":"admin"}`, http.StatusForbidden},
+		{"own hotels", guest, guest.ID.Hex(), `{"hotelIDs":[]}`, http.StatusForbidden},
+		{"short name", guest, guest.ID.Hex(), `{"lastName":"D"}`, http.StatusBadRequest},
+		{"nothing to update", guest, guest.ID.Hex(), `{}`, http.StatusBadRequest},
+		{"admin updates other", admin, other.ID.Hex(), `{"lastName":"Smith"}`, http.StatusOK},
+		{"admin sets role", admin, other.ID.Hex(), `{"role":"staff"}`, http.StatusOK},
+		{"unknown role", admin, other.ID.Hex(), `{"role":"owner"}`, http.StatusBadRequest},
+		{"unknown user", admin, primitive.NewObjectID().Hex(), `{"firstName":"Jane"}`, http.StatusNotFound},
+		{"malformed id", admin, "12345", `{"firstName":"Jane"}`, http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			as = tc.as
+			resp := putUser(t, app, tc.id, tc.body)
+			resp.Body.Close()
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+
+	updated, err := userStore.GetUserById(context.TODO(), other.ID.Hex())
+	if err != nil {
+		t.Fatalf("Error fetching user: %v", err)
+	}
+	if updated.FirstName != other.FirstName || updated.LastName != "Smith" || updated.Role != types.RoleStaff {
+		t.Errorf("Expected only the admin's changes to be applied, got %+v", updated)
+	}
+}
+
+// TestGetUserAccess tests that users can only get their own account unless they are an admin
+func TestGetUserAccess(t *testing.T) {
+	var as *types.User
+	app, userStore := setupUserUpdate(t, &as)
+	guest := insertUpdateTestUser(t, userStore, "guest@example.com", types.RoleGuest)
+	other := insertUpdateTestUser(t, userStore, "other@example.com", types.RoleGuest)
+	admin := insertUpdateTestUser(t, userStore, "admin@example.com", types.RoleAdmin)
+
+	testCases := []struct {
+		name     string
+		as       *types.User
+		id       string
+		expected int
+	}{
+		{"own account", guest, guest.ID.Hex(), http.StatusOK},
+		{"other user", guest, other.ID.Hex(), http.StatusForbidden},
+		{"admin gets other", admin, other.ID.Hex(), http.StatusOK},
+		{"unknown user", admin, primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"malformed id", admin, "12345", http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			as = tc.as
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/api/v1/user/"+tc.id, nil))
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestUpdateUserIgnoresOtherFields tests that fields outside UpdateUserParams are never written
+func TestUpdateUserIgnoresOtherFields(t *testing.T) {
+	var as *types.User
+	app, userStore := setupUserUpdate(t, &as)
+	as = insertUpdateTestUser(t, userStore, "guest@example.com", types.RoleGuest)
+
+	body := `{"firstName":"Jane","email":"taken@example.com","EncryptedPassword":"x","emailVerified":true,"_id":"000000000000000000000000"}`
+	resp := putUser(t, app, as.ID.Hex(), body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+	}
+
+	updated, err := userStore.GetUserById(context.TODO(), as.ID.Hex())
+	if err != nil {
+		t.Fatalf("Error fetching user: %v", err)
+	}
+	if updated.FirstName != "Jane" {
+		t.Errorf("Expected first name Jane, got %s", updated.FirstName)
+	}
+	if updated.Email != as.Email || updated.EncryptedPassword != as.EncryptedPassword || updated.EmailVerified {
+		t.Errorf("Expected email, password and verification to be unchanged, got %+v", updated)
+	}
+}
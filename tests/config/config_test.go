@@ -0,0 +1,469 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+)
+
+// envFrom returns a lookup function reading from the given map instead of the process environment
+func envFrom(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
+
+// writeConfigFile writes a YAML config file into a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Error writing config file: %v", err)
+	}
+	return path
+}
+
+// TestLoadDefaults checks the values used when nothing is configured
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := config.Load(nil, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.ListenAddr != config.DefaultListenAddr {
+		t.Errorf("Expected listen address %q, got %q", config.DefaultListenAddr, cfg.ListenAddr)
+	}
+	if cfg.DB.URI != config.DefaultDBURI || cfg.DB.Name != config.DefaultDBName {
+		t.Errorf("Expected the default database, got %+v", cfg.DB)
+	}
+	if cfg.Auth.TokenTTL != config.DefaultTokenTTL {
+		t.Errorf("Expected token TTL %v, got %v", config.DefaultTokenTTL, cfg.Auth.TokenTTL)
+	}
+	if cfg.Auth.RefreshTTL != config.DefaultRefreshTTL {
+		t.Errorf("Expected refresh token TTL %v, got %v", config.DefaultRefreshTTL, cfg.Auth.RefreshTTL)
+	}
+	if cfg.Auth.Issuer != config.DefaultIssuer || cfg.Auth.Audience != config.DefaultAudience {
+		t.Errorf("Expected the default issuer and audience, got %q and %q", cfg.Auth.Issuer, cfg.Auth.Audience)
+	}
+}
+
+// TestLoadPrecedence checks that flags beat environment variables, which beat the file
+func TestLoadPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+listenAddr: ":7000"
+db:
+  uri: "mongodb://file:27017"
+  name: "file-db"
+auth:
+  jwtSecret: "file-secret"
+  tokenTTL: 2h
+  issuer: "file-issuer"
+  audience: "file-audience"
+`)
+	env := map[string]string{
+		config.EnvConfigFile: path,
+		config.EnvDBName:     "env-db",
+		config.EnvJWTSecret:  "env-secret",
+		config.EnvAudience:   "env-audience",
+	}
+
+	cfg, err := config.Load([]string{"-dbName", "flag-db"}, envFrom(env))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+
+	if cfg.ListenAddr != ":7000" {
+		t.Errorf("Expected the listen address from the file, got %q", cfg.ListenAddr)
+	}
+	if cfg.DB.URI != "mongodb://file:27017" {
+		t.Errorf("Expected the database URI from the file, got %q", cfg.DB.URI)
+	}
+	if cfg.DB.Name != "flag-db" {
+		t.Errorf("Expected the database name from the flag, got %q", cfg.DB.Name)
+	}
+	if cfg.Auth.JWTSecret != "env-secret" {
+		t.Errorf("Expected the JWT secret from the environment, got %q", cfg.Auth.JWTSecret)
+	}
+	if cfg.Auth.TokenTTL != 2*time.Hour {
+		t.Errorf("Expected the token TTL from the file, got %v", cfg.Auth.TokenTTL)
+	}
+	if cfg.Auth.Issuer != "file-issuer" {
+		t.Errorf("Expected the token issuer from the file, got %q", cfg.Auth.Issuer)
+	}
+	if cfg.Auth.Audience != "env-audience" {
+		t.Errorf("Expected the token audience from the environment, got %q", cfg.Auth.Audience)
+	}
+}
+
+// TestLoadErrors checks that broken configuration sources are reported
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{
+			name: "missing file",
+			args: []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")},
+		},
+		{
+			name: "unknown key in file",
+			args: []string{"-config", writeConfigFile(t, "jwtSecret: misplaced\n")},
+		},
+		{
+			name: "invalid token TTL",
+			env:  map[string]string{config.EnvTokenTTL: "forever"},
+		},
+		{
+			name: "invalid refresh token TTL",
+			env:  map[string]string{config.EnvRefreshTTL: "a month"},
+		},
+		{
+			name: "invalid password reset TTL",
+			env:  map[string]string{config.EnvResetTTL: "soon"},
+		},
+		{
+			name: "invalid SMTP port",
+			env:  map[string]string{config.EnvSMTPPort: "smtp"},
+		},
+		{
+			name: "invalid email verification TTL",
+			env:  map[string]string{config.EnvVerifyTTL: "two days"},
+		},
+		{
+			name: "invalid login failure limit",
+			env:  map[string]string{config.EnvLoginMaxFailures: "five"},
+		},
+		{
+			name: "invalid login lockout",
+			env:  map[string]string{config.EnvLoginLockout: "a while"},
+		},
+		{
+			name: "invalid verified email policy",
+			env:  map[string]string{config.EnvRequireVerified: "sometimes"},
+		},
+		{
+			name: "unknown flag",
+			args: []string{"-port", "80"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := config.Load(tc.args, envFrom(tc.env)); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// TestValidate checks that the application refuses to start without required values
+func TestValidate(t *testing.T) {
+	cfg := config.Default()
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), config.EnvJWTSecret) {
+		t.Errorf("Expected an error about the missing JWT secret, got %v", err)
+	}
+
+	cfg.Auth.JWTSecret = "   "
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a blank JWT secret to be rejected")
+	}
+
+	cfg.Auth.JWTSecret = "secret"
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), config.EnvPaymentWebhookSecret) {
+		t.Errorf("Expected an error about the missing webhook secret, got %v", err)
+	}
+
+	cfg.Payments.WebhookSecret = "whsec"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a valid configuration, got %v", err)
+	}
+
+	cfg.Auth.RefreshTTL = cfg.Auth.TokenTTL / 2
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a refresh token TTL shorter than the token TTL to be rejected")
+	}
+
+	cfg.Auth.RefreshTTL = config.DefaultRefreshTTL
+	cfg.Auth.Audience = ""
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a missing token audience to be rejected")
+	}
+
+	cfg.Auth.Audience = config.DefaultAudience
+	cfg.DB.Name = ""
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a missing database name to be rejected")
+	}
+}
+
+// TestMailConfig checks the mail and password reset settings
+func TestMailConfig(t *testing.T) {
+	env := map[string]string{
+		config.EnvResetTTL:             "30m",
+		config.EnvResetURL:             "https://hotel.example.com/reset",
+		config.EnvMailFrom:             "Hotel <mail@hotel.example.com>",
+		config.EnvSMTPHost:             "smtp.example.com",
+		config.EnvSMTPPort:             "2525",
+		config.EnvSMTPUser:             "mailer",
+		config.EnvSMTPPass:             "mail-password",
+		config.EnvJWTSecret:            "secret",
+		config.EnvPaymentWebhookSecret: "whsec",
+	}
+	cfg, err := config.Load(nil, envFrom(env))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Auth.ResetTTL != 30*time.Minute || cfg.Auth.ResetURL != "https://hotel.example.com/reset" {
+		t.Errorf("Expected the reset settings from the environment, got %v and %q", cfg.Auth.ResetTTL, cfg.Auth.ResetURL)
+	}
+	want := config.SMTPConfig{Host: "smtp.example.com", Port: 2525, Username: "mailer", Password: "mail-password"}
+	if cfg.Mail.SMTP != want {
+		t.Errorf("Expected SMTP settings %+v, got %+v", want, cfg.Mail.SMTP)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(cfg *config.Config)
+	}{
+		{"relative reset URL", func(cfg *config.Config) { cfg.Auth.ResetURL = "/reset" }},
+		{"zero reset TTL", func(cfg *config.Config) { cfg.Auth.ResetTTL = 0 }},
+		{"invalid sender", func(cfg *config.Config) { cfg.Mail.From = "not an address" }},
+		{"invalid SMTP port", func(cfg *config.Config) { cfg.Mail.SMTP.Port = 70000 }},
+		{"no way to deliver", func(cfg *config.Config) { cfg.Mail.SMTP.Host = ""; cfg.Mail.Dir = "" }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			broken := *cfg
+			tc.modify(&broken)
+			if err := broken.Validate(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// TestEmailVerificationConfig checks the email verification settings
+func TestEmailVerificationConfig(t *testing.T) {
+	cfg, err := config.Load(nil, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Auth.RequireVerifiedEmail {
+		t.Errorf("Expected verified emails not to be required by default")
+	}
+
+	env := map[string]string{
+		config.EnvVerifyTTL:            "24h",
+		config.EnvVerifyURL:            "https://api.hotel.example.com/api/auth/verify",
+		config.EnvRequireVerified:      "true",
+		config.EnvJWTSecret:            "secret",
+		config.EnvPaymentWebhookSecret: "whsec",
+	}
+	cfg, err = config.Load(nil, envFrom(env))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Auth.VerifyTTL != 24*time.Hour || cfg.Auth.VerifyURL != "https://api.hotel.example.com/api/auth/verify" {
+		t.Errorf("Expected the verification settings from the environment, got %v and %q", cfg.Auth.VerifyTTL, cfg.Auth.VerifyURL)
+	}
+	if !cfg.Auth.RequireVerifiedEmail {
+		t.Errorf("Expected verified emails to be required")
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+
+	cfg.Auth.VerifyURL = "api/auth/verify"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a relative verification URL to be rejected")
+	}
+}
+
+// TestLoginConfig checks the login throttling settings
+func TestLoginConfig(t *testing.T) {
+	env := map[string]string{
+		config.EnvLoginMaxFailures:     "3",
+		config.EnvLoginMaxIPFailures:   "50",
+		config.EnvLoginBackoff:         "2s",
+		config.EnvLoginMaxBackoff:      "1m",
+		config.EnvLoginLockout:         "1h",
+		config.EnvProxyHeader:          "X-Forwarded-For",
+		config.EnvJWTSecret:            "secret",
+		config.EnvPaymentWebhookSecret: "whsec",
+	}
+	cfg, err := config.Load(nil, envFrom(env))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	want := config.LoginConfig{MaxFailures: 3, MaxIPFailures: 50, Backoff: 2 * time.Second, MaxBackoff: time.Minute, Lockout: time.Hour}
+	if cfg.Login != want {
+		t.Errorf("Expected login settings %+v, got %+v", want, cfg.Login)
+	}
+	if cfg.ProxyHeader != "X-Forwarded-For" {
+		t.Errorf("Expected the proxy header from the environment, got %q", cfg.ProxyHeader)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Expected a valid configuration, got %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(cfg *config.Config)
+	}{
+		{"no failures allowed", func(cfg *config.Config) { cfg.Login.MaxFailures = 0 }},
+		{"no IP failures allowed", func(cfg *config.Config) { cfg.Login.MaxIPFailures = -1 }},
+		{"zero backoff", func(cfg *config.Config) { cfg.Login.Backoff = 0 }},
+		{"backoff above maximum", func(cfg *config.Config) { cfg.Login.MaxBackoff = time.Second }},
+		{"zero lockout", func(cfg *config.Config) { cfg.Login.Lockout = 0 }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			broken := *cfg
+			tc.modify(&broken)
+			if err := broken.Validate(); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// TestBookingConfig checks the room hold settings
+func TestBookingConfig(t *testing.T) {
+	env := map[string]string{
+		config.EnvHoldTTL:           "15m",
+		config.EnvHoldSweepInterval: "30s",
+		config.EnvJWTSecret:         "secret",
+	}
+	cfg, err := config.Load(nil, envFrom(env))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	want := config.BookingConfig{HoldTTL: 15 * time.Minute, HoldSweepInterval: 30 * time.Second}
+	if cfg.Booking != want {
+		t.Errorf("Expected booking settings %+v, got %+v", want, cfg.Booking)
+	}
+
+	cfg.Booking.HoldTTL = 0
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a zero hold TTL to be rejected")
+	}
+}
+
+// TestIdempotencyConfig checks the retention of idempotency keys
+func TestIdempotencyConfig(t *testing.T) {
+	cfg, err := config.Load(nil, envFrom(map[string]string{
+		config.EnvIdempotencyRetention: "2h",
+		config.EnvIdempotencyLease:     "30s",
+		config.EnvJWTSecret:            "secret",
+	}))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Idempotency.Retention != 2*time.Hour || cfg.Idempotency.Lease != 30*time.Second {
+		t.Errorf("Expected a retention of 2h and a lease of 30s, got %+v", cfg.Idempotency)
+	}
+
+	cfg.Idempotency.Lease = 3 * time.Hour
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a lease longer than the retention to be rejected")
+	}
+	cfg.Idempotency.Lease = time.Millisecond
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a lease shorter than a second to be rejected")
+	}
+	cfg.Idempotency.Lease = time.Minute
+
+	cfg.Idempotency.Retention = 0
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a zero retention to be rejected")
+	}
+}
+
+// TestPaymentsConfig checks loading and validating the payment gateway settings
+func TestPaymentsConfig(t *testing.T) {
+	cfg, err := config.Load(nil, envFrom(map[string]string{
+		config.EnvPaymentCurrency:      "USD",
+		config.EnvPaymentWebhookSecret: "whsec",
+		config.EnvJWTSecret:            "secret",
+	}))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if cfg.Payments.Provider != config.PaymentProviderMock || cfg.Payments.Currency != "USD" || cfg.Payments.WebhookSecret != "whsec" {
+		t.Errorf("Expected the mock provider charging USD, got %+v", cfg.Payments)
+	}
+
+	cfg.Payments.Currency = "usd"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected a lowercase currency to be rejected")
+	}
+	cfg.Payments.Currency = "USD"
+	cfg.Payments.Provider = "stripe"
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("Expected an unknown provider to be rejected")
+	}
+}
+
+// TestSigningKeys checks loading and validating asymmetric signing keys
+func TestSigningKeys(t *testing.T) {
+	path := writeConfigFile(t, `
+auth:
+  signingKeys:
+    - id: "2026-09"
+      algorithm: "EdDSA"
+      privateKeyFile: "/etc/hotel/2026-09.pem"
+    - id: "2026-10"
+      algorithm: "RS256"
+      privateKeyFile: "/etc/hotel/2026-10.pem"
+      activeFrom: 2026-10-01T00:00:00Z
+payments:
+  webhookSecret: "whsec"
+`)
+	cfg, err := config.Load([]string{"-config", path}, envFrom(nil))
+	if err != nil {
+		t.Fatalf("Error loading config: %v", err)
+	}
+	if len(cfg.Auth.SigningKeys) != 2 {
+		t.Fatalf("Expected 2 signing keys, got %d", len(cfg.Auth.SigningKeys))
+	}
+	next := cfg.Auth.SigningKeys[1]
+	if next.Algorithm != config.AlgorithmRS256 || !next.ActiveFrom.Equal(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the scheduled RS256 key, got %+v", next)
+	}
+
+	// Signing keys replace the JWT secret
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a valid configuration without JWT secret, got %v", err)
+	}
+
+	testCases := []struct {
+		name   string
+		change func(key *config.SigningKeyConfig)
+	}{
+		{"missing id", func(key *config.SigningKeyConfig) { key.ID = "" }},
+		{"duplicate id", func(key *config.SigningKeyConfig) { key.ID = "2026-09" }},
+		{"unsupported algorithm", func(key *config.SigningKeyConfig) { key.Algorithm = "HS256" }},
+		{"missing file", func(key *config.SigningKeyConfig) { key.PrivateKeyFile = "" }},
+		{"retired before active", func(key *config.SigningKeyConfig) { key.RetireAt = key.ActiveFrom.Add(-time.Hour) }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			broken := *cfg
+			broken.Auth.SigningKeys = append([]config.SigningKeyConfig{}, cfg.Auth.SigningKeys...)
+			tc.change(&broken.Auth.SigningKeys[1])
+			if err := broken.Validate(); err == nil {
+				t.Errorf("Expected the configuration to be rejected")
+			}
+		})
+	}
+}
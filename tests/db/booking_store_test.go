@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TestMongoBookingStore_ReleaseOrphanedNights tests freeing nights claimed for a
+// booking that was never written
+func TestMongoBookingStore_ReleaseOrphanedNights(t *testing.T) {
+	// Skip integration tests when running in short mode
+	if testing.Short() {
+		t.Skip("Skipping MongoDB integration test in short mode")
+	}
+
+	ctx := context.TODO()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(testDBURI))
+	if err != nil {
+		t.Fatalf("Error connecting to MongoDB: %v", err)
+	}
+	collections := []string{"hotels", "rooms", "Bookings", "roomNights"}
+	clean := func() {
+		for _, coll := range collections {
+			if _, err := client.Database(testDBName).Collection(coll).DeleteMany(ctx, bson.M{}); err != nil {
+				t.Fatalf("Error cleaning %s collection: %v", coll, err)
+			}
+		}
+	}
+	clean()
+	defer func() {
+		clean()
+		if err := client.Disconnect(ctx); err != nil {
+			t.Fatalf("Error disconnecting from MongoDB: %v", err)
+		}
+	}()
+
+	hotelStore := db.NewMongoHotelStore(client, testDBName)
+	store := &db.Store{
+		Hotel:   hotelStore,
+		Room:    db.NewMongoRoomStore(client, testDBName, hotelStore),
+		Booking: db.NewMongoBookingStore(client, testDBName),
+	}
+	roomID := insertContractRoom(t, store)
+	from, _ := types.StayDays(time.Now().AddDate(0, 0, 10), time.Now())
+	newBooking := func(from time.Time) *types.Booking {
+		return &types.Booking{
+			RoomID:    roomID,
+			UserID:    primitive.NewObjectID(),
+			FromDate:  from,
+			TillDate:  from.AddDate(0, 0, 1),
+			NumPerson: 1,
+			Status:    types.BookingStatusConfirmed,
+		}
+	}
+
+	// Claims as left behind by a request that died before writing its booking,
+	// one long ago and one that may still be in flight
+	nights := client.Database(testDBName).Collection("roomNights")
+	claims := []interface{}{
+		bson.M{"roomID": roomID, "night": from, "bookingID": primitive.NewObjectID(), "claimedAt": time.Now().Add(-time.Hour)},
+		bson.M{"roomID": roomID, "night": from.AddDate(0, 0, 1), "bookingID": primitive.NewObjectID(), "claimedAt": time.Now()},
+	}
+	if _, err := nights.InsertMany(ctx, claims); err != nil {
+		t.Fatalf("Error inserting claims: %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Fatalf("Expected the orphaned night to be taken, got %v", err)
+	}
+
+	n, err := store.Booking.ReleaseOrphanedNights(ctx, time.Now().Add(-10*time.Minute))
+	if err != nil {
+		t.Fatalf("Error releasing orphaned nights: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("Expected 1 released night, got %d", n)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from)); err != nil {
+		t.Errorf("Expected the released night to be bookable, got %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 1))); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected the recent claim to be kept, got %v", err)
+	}
+}
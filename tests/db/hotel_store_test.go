@@ -26,10 +26,10 @@ func setupHotelTest(t *testing.T) *hotelTestDB {
 		t.Fatalf("Error connecting to MongoDB: %v", err)
 	}
 
-	hotelStore := db.NewMongoHotelStore(client)
+	hotelStore := db.NewMongoHotelStore(client, testDBName)
 	
 	// Clean up any previous test data to ensure a fresh start
-	_, err = client.Database(db.DBNAME).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
+	_, err = client.Database(testDBName).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up test collection: %v", err)
 	}
@@ -43,7 +43,7 @@ func setupHotelTest(t *testing.T) *hotelTestDB {
 // teardown cleans up after tests
 func (tdb *hotelTestDB) teardown(t *testing.T) {
 	// Delete all test data
-	_, err := tdb.client.Database(db.DBNAME).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
+	_, err := tdb.client.Database(testDBName).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up test collection: %v", err)
 	}
@@ -248,4 +248,55 @@ func TestMongoHotelStore_GetHotelByID(t *testing.T) {
 	if err == nil {
 		t.Errorf("expected error when getting non-existent hotel")
 	}
-} 
\ No newline at end of file
+}
+
+// TestMongoHotelStore_SearchHotels tests sorted, paginated hotel queries and counting
+func TestMongoHotelStore_SearchHotels(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping MongoDB integration test in short mode")
+	}
+
+	tdb := setupHotelTest(t)
+	defer tdb.teardown(t)
+
+	// Insert hotels with ratings 1 to 5
+	for rating := 1; rating <= 5; rating++ {
+		_, err := tdb.hotelStore.Insert(context.TODO(), &types.Hotel{
+			Name:     "Hotel",
+			Location: "Location",
+			Rooms:    []primitive.ObjectID{},
+			Rating:   rating,
+		})
+		if err != nil {
+			t.Fatalf("error inserting hotel: %v", err)
+		}
+	}
+
+	filter := bson.M{"rating": bson.M{"$gte": 2}}
+	opts := &db.FindOptions{
+		Sort:  bson.D{{Key: "rating", Value: -1}},
+		Page:  2,
+		Limit: 3,
+	}
+
+	// The second page of three holds only the lowest rated match
+	hotels, err := tdb.hotelStore.SearchHotels(context.TODO(), filter, opts)
+	if err != nil {
+		t.Fatalf("error searching hotels: %v", err)
+	}
+	if len(hotels) != 1 {
+		t.Fatalf("expected 1 hotel on the second page, got %d", len(hotels))
+	}
+	if hotels[0].Rating != 2 {
+		t.Errorf("expected hotel with rating 2, got %d", hotels[0].Rating)
+	}
+
+	// Counting ignores paging
+	count, err := tdb.hotelStore.CountHotels(context.TODO(), filter)
+	if err != nil {
+		t.Fatalf("error counting hotels: %v", err)
+	}
+	if count != 4 {
+		t.Errorf("expected 4 matching hotels, got %d", count)
+	}
+}
@@ -3,6 +3,7 @@ package db
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/0x0Glitch/hotel-reservation/db"
 	"github.com/0x0Glitch/hotel-reservation/types"
@@ -31,20 +32,25 @@ func setupRoomTest(t *testing.T) *roomTestDB {
 		t.Fatalf("Error connecting to MongoDB: %v", err)
 	}
 
-	hotelStore := db.NewMongoHotelStore(client)
-	roomStore := db.NewMongoRoomStore(client, hotelStore)
+	hotelStore := db.NewMongoHotelStore(client, testDBName)
+	roomStore := db.NewMongoRoomStore(client, testDBName, hotelStore)
 	
 	// Clean up any previous test data to ensure a fresh start
-	_, err = client.Database(db.DBNAME).Collection("rooms").DeleteMany(context.TODO(), bson.M{})
+	_, err = client.Database(testDBName).Collection("rooms").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up rooms collection: %v", err)
 	}
 	
-	_, err = client.Database(db.DBNAME).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
+	_, err = client.Database(testDBName).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up hotels collection: %v", err)
 	}
 
+	_, err = client.Database(testDBName).Collection("Bookings").DeleteMany(context.TODO(), bson.M{})
+	if err != nil {
+		t.Fatalf("Error cleaning up bookings collection: %v", err)
+	}
+
 	return &roomTestDB{
 		client:    client,
 		roomStore: roomStore,
@@ -55,16 +61,21 @@ func setupRoomTest(t *testing.T) *roomTestDB {
 // teardown cleans up after tests
 func (tdb *roomTestDB) teardown(t *testing.T) {
 	// Delete all test data
-	_, err := tdb.client.Database(db.DBNAME).Collection("rooms").DeleteMany(context.TODO(), bson.M{})
+	_, err := tdb.client.Database(testDBName).Collection("rooms").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up rooms collection: %v", err)
 	}
 	
-	_, err = tdb.client.Database(db.DBNAME).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
+	_, err = tdb.client.Database(testDBName).Collection("hotels").DeleteMany(context.TODO(), bson.M{})
 	if err != nil {
 		t.Fatalf("Error cleaning up hotels collection: %v", err)
 	}
 
+	_, err = tdb.client.Database(testDBName).Collection("Bookings").DeleteMany(context.TODO(), bson.M{})
+	if err != nil {
+		t.Fatalf("Error cleaning up bookings collection: %v", err)
+	}
+
 	// Disconnect from MongoDB
 	if err := tdb.client.Disconnect(context.TODO()); err != nil {
 		t.Fatalf("Error disconnecting from MongoDB: %v", err)
@@ -223,4 +234,57 @@ func TestMongoRoomStore_GetRooms(t *testing.T) {
 	if filteredRooms[0].Price <= 100 {
 		t.Errorf("expected room price > 100, got %.2f", filteredRooms[0].Price)
 	}
-} 
\ No newline at end of file
+} 
+// TestMongoRoomStore_GetAvailableRooms tests that rooms with a conflicting booking are left out
+func TestMongoRoomStore_GetAvailableRooms(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping MongoDB integration test in short mode")
+	}
+
+	tdb := setupRoomTest(t)
+	defer tdb.teardown(t)
+
+	hotel, err := tdb.hotelStore.Insert(context.TODO(), &types.Hotel{
+		Name:     "Test Hotel",
+		Location: "Test Location",
+		Rooms:    []primitive.ObjectID{},
+		Rating:   4,
+	})
+	if err != nil {
+		t.Fatalf("error inserting hotel: %v", err)
+	}
+
+	booked, err := tdb.roomStore.InsertRoom(context.TODO(), &types.Room{Size: "small", Price: 89.99, HotelID: hotel.ID})
+	if err != nil {
+		t.Fatalf("error inserting room: %v", err)
+	}
+	free, err := tdb.roomStore.InsertRoom(context.TODO(), &types.Room{Size: "large", Price: 149.99, HotelID: hotel.ID})
+	if err != nil {
+		t.Fatalf("error inserting room: %v", err)
+	}
+
+	// Book the first room
+	from := time.Now().AddDate(0, 0, 5)
+	bookingStore := db.NewMongoBookingStore(tdb.client, testDBName)
+	_, err = bookingStore.InsertBooking(context.TODO(), &types.Booking{
+		UserID:   primitive.NewObjectID(),
+		RoomID:   booked.ID,
+		FromDate: from,
+		TillDate: from.AddDate(0, 0, 2),
+		Status:   types.BookingStatusConfirmed,
+	})
+	if err != nil {
+		t.Fatalf("error inserting booking: %v", err)
+	}
+
+	rooms, err := tdb.roomStore.GetAvailableRooms(context.TODO(), bson.M{"hotelID": hotel.ID}, bson.M{"status": types.BookingStatusConfirmed})
+	if err != nil {
+		t.Fatalf("error getting available rooms: %v", err)
+	}
+	if len(rooms) != 1 {
+		t.Fatalf("expected 1 available room, got %d", len(rooms))
+	}
+	if rooms[0].ID != free.ID {
+		t.Errorf("expected room %v to be available, got %v", free.ID, rooms[0].ID)
+	}
+}
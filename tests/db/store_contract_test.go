@@ -0,0 +1,1339 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The contract tests describe how every store implementation must behave
+// They run against both the in-memory and the MongoDB stores, so the memory
+// stores used by the API tests can be trusted to behave like the real ones
+
+// storeFactory creates a fresh, empty set of stores and a function cleaning them up
+type storeFactory func(t *testing.T) (*db.Store, func())
+
+// storeContract lists the contract tests by name
+var storeContract = []struct {
+	name string
+	run  func(t *testing.T, store *db.Store)
+}{
+	{"UserStore", testUserStoreContract},
+	{"HotelStore", testHotelStoreContract},
+	{"RoomStore", testRoomStoreContract},
+	{"BookingStore", testBookingStoreContract},
+	{"BookingHolds", testBookingHoldContract},
+	{"PendingBookings", testPendingBookingContract},
+	{"RoomDeletion", testRoomDeletionContract},
+	{"TokenStore", testTokenStoreContract},
+	{"UserTokens", testUserTokenContract},
+	{"LoginStore", testLoginStoreContract},
+	{"IdempotencyStore", testIdempotencyStoreContract},
+	{"PaymentStore", testPaymentStoreContract},
+}
+
+// runStoreContract runs every contract test on stores created by newStore
+func runStoreContract(t *testing.T, newStore storeFactory) {
+	for _, tc := range storeContract {
+		t.Run(tc.name, func(t *testing.T) {
+			store, cleanup := newStore(t)
+			defer cleanup()
+			tc.run(t, store)
+		})
+	}
+}
+
+// TestMemoryStoreContract runs the contract tests against the in-memory stores
+func TestMemoryStoreContract(t *testing.T) {
+	runStoreContract(t, func(t *testing.T) (*db.Store, func()) {
+		database := db.NewMemoryDatabase()
+		hotelStore := db.NewMemoryHotelStore(database)
+		return &db.Store{
+			User:        db.NewMemoryUserStore(database),
+			Hotel:       hotelStore,
+			Room:        db.NewMemoryRoomStore(database, hotelStore),
+			Booking:     db.NewMemoryBookingStore(database),
+			Token:       db.NewMemoryTokenStore(database),
+			Login:       db.NewMemoryLoginStore(database),
+			Idempotency: db.NewMemoryIdempotencyStore(database),
+			Payment:     db.NewMemoryPaymentStore(database),
+		}, func() {}
+	})
+}
+
+// TestMongoStoreContract runs the contract tests against the MongoDB stores
+func TestMongoStoreContract(t *testing.T) {
+	// Skip integration tests when running in short mode
+	if testing.Short() {
+		t.Skip("Skipping MongoDB integration test in short mode")
+	}
+
+	runStoreContract(t, func(t *testing.T) (*db.Store, func()) {
+		client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(testDBURI))
+		if err != nil {
+			t.Fatalf("Error connecting to MongoDB: %v", err)
+		}
+
+		// Start from empty collections and leave them empty
+		collections := []string{"users", "hotels", "rooms", "Bookings", "roomNights", "refreshTokens", "revokedTokens", "userTokens", "loginAttempts", "lockoutEvents", "idempotencyKeys", "payments"}
+		clean := func() {
+			for _, coll := range collections {
+				if _, err := client.Database(testDBName).Collection(coll).DeleteMany(context.TODO(), bson.M{}); err != nil {
+					t.Fatalf("Error cleaning %s collection: %v", coll, err)
+				}
+			}
+		}
+		clean()
+
+		hotelStore := db.NewMongoHotelStore(client, testDBName)
+		userStore, err := db.NewMongoUserStore(context.TODO(), client, testDBName)
+		if err != nil {
+			t.Fatalf("Error creating user store: %v", err)
+		}
+		return &db.Store{
+			User:        userStore,
+			Hotel:       hotelStore,
+			Room:        db.NewMongoRoomStore(client, testDBName, hotelStore),
+			Booking:     db.NewMongoBookingStore(client, testDBName),
+			Token:       db.NewMongoTokenStore(client, testDBName),
+			Login:       db.NewMongoLoginStore(client, testDBName),
+			Idempotency: db.NewMongoIdempotencyStore(client, testDBName),
+			Payment:     db.NewMongoPaymentStore(client, testDBName),
+		}, func() {
+			clean()
+			if err := client.Disconnect(context.TODO()); err != nil {
+				t.Fatalf("Error disconnecting from MongoDB: %v", err)
+			}
+		}
+	})
+}
+
+// testUserStoreContract checks inserting, finding, updating and deleting users
+func testUserStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	user, err := store.User.InsertUser(ctx, &types.User{
+		ID:        primitive.NewObjectID(),
+		FirstName: "Contract",
+		LastName:  "User",
+		Email:     "contract@example.com",
+		Role:      types.RoleGuest,
+	})
+	if err != nil {
+		t.Fatalf("Error inserting user: %v", err)
+	}
+
+	// Emails are unique, ignoring case
+	_, err = store.User.InsertUser(ctx, &types.User{ID: primitive.NewObjectID(), Email: "CONTRACT@example.com"})
+	if !errors.Is(err, db.ErrDuplicateEmail) {
+		t.Errorf("Expected ErrDuplicateEmail, got %v", err)
+	}
+
+	found, err := store.User.GetUserById(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Error getting user by ID: %v", err)
+	}
+	if found.Email != user.Email || found.Role != types.RoleGuest {
+		t.Errorf("Expected %+v, got %+v", user, found)
+	}
+	if _, err := store.User.GetUserByEmail(ctx, "contract@example.com"); err != nil {
+		t.Errorf("Error getting user by email: %v", err)
+	}
+	// Lookups ignore case like the unique index does
+	if found, err := store.User.GetUserByEmail(ctx, "Contract@Example.COM"); err != nil || found.ID != user.ID {
+		t.Errorf("Expected to find the user ignoring case, got %+v, %v", found, err)
+	}
+	if _, err := store.User.GetUserByEmail(ctx, "missing@example.com"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing user, got %v", err)
+	}
+	if _, err := store.User.GetUserById(ctx, "not-an-id"); err == nil {
+		t.Errorf("Expected an error for an invalid ID")
+	}
+
+	if err := store.User.UpdateUser(ctx, bson.M{"_id": user.ID}, bson.M{"firstName": "Updated"}); err != nil {
+		t.Fatalf("Error updating user: %v", err)
+	}
+	found, err = store.User.GetUserById(ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Error getting updated user: %v", err)
+	}
+	if found.FirstName != "Updated" || found.LastName != "User" {
+		t.Errorf("Expected only the first name to change, got %+v", found)
+	}
+
+	users, err := store.User.GetUsers(ctx)
+	if err != nil {
+		t.Fatalf("Error getting users: %v", err)
+	}
+	if len(users) != 1 {
+		t.Errorf("Expected 1 user, got %d", len(users))
+	}
+
+	if err := store.User.DeleteUser(ctx, user.ID.Hex()); err != nil {
+		t.Fatalf("Error deleting user: %v", err)
+	}
+	if _, err := store.User.GetUserById(ctx, user.ID.Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments after deleting, got %v", err)
+	}
+}
+
+// testHotelStoreContract checks inserting, updating and searching hotels
+func testHotelStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	for _, hotel := range []*types.Hotel{
+		{Name: "Alpha Inn", Location: "Rome", Rating: 3},
+		{Name: "Bravo Hotel", Location: "Paris", Rating: 5},
+		{Name: "Charlie Lodge", Location: "Roma Nord", Rating: 4},
+	} {
+		hotel.Rooms = []primitive.ObjectID{}
+		if _, err := store.Hotel.Insert(ctx, hotel); err != nil {
+			t.Fatalf("Error inserting hotel: %v", err)
+		}
+		if hotel.ID.IsZero() {
+			t.Fatalf("Expected the hotel ID to be set")
+		}
+	}
+
+	// Case-insensitive substring and range filters
+	filter := bson.M{
+		"location": primitive.Regex{Pattern: "rom", Options: "i"},
+		"rating":   bson.M{"$gte": 4},
+	}
+	hotels, err := store.Hotel.GetHotels(ctx, filter)
+	if err != nil {
+		t.Fatalf("Error getting hotels: %v", err)
+	}
+	if len(hotels) != 1 || hotels[0].Name != "Charlie Lodge" {
+		t.Errorf("Expected only Charlie Lodge, got %v", hotels)
+	}
+
+	// Sorting and pagination
+	opts := &db.FindOptions{Sort: bson.D{{Key: "rating", Value: -1}}, Page: 2, Limit: 2}
+	page, err := store.Hotel.SearchHotels(ctx, bson.M{}, opts)
+	if err != nil {
+		t.Fatalf("Error searching hotels: %v", err)
+	}
+	if len(page) != 1 || page[0].Name != "Alpha Inn" {
+		t.Errorf("Expected the second page to only hold Alpha Inn, got %v", page)
+	}
+	total, err := store.Hotel.CountHotels(ctx, bson.M{"rating": bson.M{"$lt": 5}})
+	if err != nil {
+		t.Fatalf("Error counting hotels: %v", err)
+	}
+	if total != 2 {
+		t.Errorf("Expected 2 hotels rated below 5, got %d", total)
+	}
+
+	// Updates
+	charlie := hotels[0]
+	if err := store.Hotel.Update(ctx, bson.M{"_id": charlie.ID}, bson.M{"$set": bson.M{"rating": 1}}); err != nil {
+		t.Fatalf("Error updating hotel: %v", err)
+	}
+	updated, err := store.Hotel.GetHotelByID(ctx, charlie.ID)
+	if err != nil {
+		t.Fatalf("Error getting hotel: %v", err)
+	}
+	if updated.Rating != 1 || updated.Name != charlie.Name {
+		t.Errorf("Expected only the rating to change, got %+v", updated)
+	}
+	if _, err := store.Hotel.GetHotelByID(ctx, primitive.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing hotel, got %v", err)
+	}
+	if err := store.Hotel.Update(ctx, bson.M{"_id": primitive.NewObjectID()}, bson.M{"$set": bson.M{"rating": 1}}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments updating a missing hotel, got %v", err)
+	}
+
+	// Hotels with rooms are kept
+	room, err := store.Room.InsertRoom(ctx, &types.Room{Size: "small", Price: 100, HotelID: charlie.ID})
+	if err != nil {
+		t.Fatalf("Error inserting room: %v", err)
+	}
+	if err := store.Hotel.DeleteHotel(ctx, charlie.ID); !errors.Is(err, db.ErrHotelHasRooms) {
+		t.Errorf("Expected ErrHotelHasRooms, got %v", err)
+	}
+	if err := store.Room.DeleteRoom(ctx, room.ID); err != nil {
+		t.Fatalf("Error deleting room: %v", err)
+	}
+
+	// Deleting
+	if err := store.Hotel.DeleteHotel(ctx, charlie.ID); err != nil {
+		t.Fatalf("Error deleting hotel: %v", err)
+	}
+	if _, err := store.Hotel.GetHotelByID(ctx, charlie.ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected the deleted hotel to be gone, got %v", err)
+	}
+	if err := store.Hotel.DeleteHotel(ctx, charlie.ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments deleting a missing hotel, got %v", err)
+	}
+}
+
+// testRoomStoreContract checks inserting rooms and finding the available ones
+func testRoomStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	hotel, err := store.Hotel.Insert(ctx, &types.Hotel{Name: "Room Hotel", Location: "Rome", Rooms: []primitive.ObjectID{}})
+	if err != nil {
+		t.Fatalf("Error inserting hotel: %v", err)
+	}
+	var rooms []*types.Room
+	for _, size := range []string{"small", "large"} {
+		room, err := store.Room.InsertRoom(ctx, &types.Room{Size: size, Price: 100, HotelID: hotel.ID})
+		if err != nil {
+			t.Fatalf("Error inserting room: %v", err)
+		}
+		rooms = append(rooms, room)
+	}
+
+	// Inserting a room adds it to the hotel
+	hotel, err = store.Hotel.GetHotelByID(ctx, hotel.ID)
+	if err != nil {
+		t.Fatalf("Error getting hotel: %v", err)
+	}
+	if len(hotel.Rooms) != 2 || hotel.Rooms[0] != rooms[0].ID {
+		t.Errorf("Expected the hotel to list both rooms, got %v", hotel.Rooms)
+	}
+
+	found, err := store.Room.GetRooms(ctx, bson.M{"hotelID": hotel.ID, "size": "large"})
+	if err != nil {
+		t.Fatalf("Error getting rooms: %v", err)
+	}
+	if len(found) != 1 || found[0].ID != rooms[1].ID {
+		t.Errorf("Expected only the large room, got %v", found)
+	}
+
+	// Book the first room and check which rooms are still free
+	from := time.Now().AddDate(0, 0, 10)
+	till := from.AddDate(0, 0, 2)
+	if _, err := store.Booking.InsertBooking(ctx, &types.Booking{
+		RoomID:   rooms[0].ID,
+		FromDate: from,
+		TillDate: till,
+		Status:   types.BookingStatusConfirmed,
+	}); err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	overlapping := bson.M{
+		"status":   bson.M{"$ne": types.BookingStatusCancelled},
+		"fromDate": bson.M{"$lt": till},
+		"tillDate": bson.M{"$gt": from},
+	}
+	available, err := store.Room.GetAvailableRooms(ctx, bson.M{"hotelID": bson.M{"$in": []primitive.ObjectID{hotel.ID}}}, overlapping)
+	if err != nil {
+		t.Fatalf("Error getting available rooms: %v", err)
+	}
+	if len(available) != 1 || available[0].ID != rooms[1].ID {
+		t.Errorf("Expected only the second room to be available, got %v", available)
+	}
+
+	// A stay starting on the check-out day does not overlap
+	later := bson.M{
+		"fromDate": bson.M{"$lt": till.AddDate(0, 0, 1)},
+		"tillDate": bson.M{"$gt": till},
+	}
+	available, err = store.Room.GetAvailableRooms(ctx, bson.M{}, later)
+	if err != nil {
+		t.Fatalf("Error getting available rooms: %v", err)
+	}
+	if len(available) != 2 {
+		t.Errorf("Expected both rooms to be available later, got %d", len(available))
+	}
+
+	// Updating sets only the given fields
+	if err := store.Room.UpdateRoom(ctx, rooms[1].ID, bson.M{"price": 150.0}); err != nil {
+		t.Fatalf("Error updating room: %v", err)
+	}
+	room, err := store.Room.GetRoomByID(ctx, rooms[1].ID)
+	if err != nil {
+		t.Fatalf("Error getting room: %v", err)
+	}
+	if room.Price != 150 || room.Size != "large" {
+		t.Errorf("Expected only the price to change, got %+v", room)
+	}
+	if err := store.Room.UpdateRoom(ctx, primitive.NewObjectID(), bson.M{"price": 150.0}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments updating a missing room, got %v", err)
+	}
+
+	// Deleting a room takes it off the hotel again
+	if err := store.Room.DeleteRoom(ctx, rooms[0].ID); err != nil {
+		t.Fatalf("Error deleting room: %v", err)
+	}
+	if _, err := store.Room.GetRoomByID(ctx, rooms[0].ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected the deleted room to be gone, got %v", err)
+	}
+	hotel, err = store.Hotel.GetHotelByID(ctx, hotel.ID)
+	if err != nil {
+		t.Fatalf("Error getting hotel: %v", err)
+	}
+	if len(hotel.Rooms) != 1 || hotel.Rooms[0] != rooms[1].ID {
+		t.Errorf("Expected the hotel to only list the second room, got %v", hotel.Rooms)
+	}
+	if err := store.Room.DeleteRoom(ctx, rooms[0].ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments deleting a missing room, got %v", err)
+	}
+
+	// Rooms cannot be added to a hotel that does not exist
+	orphan, err := store.Room.InsertRoom(ctx, &types.Room{Size: "small", Price: 100, HotelID: primitive.NewObjectID()})
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatalf("Expected ErrNoDocuments adding a room to a missing hotel, got %v, %v", orphan, err)
+	}
+	if left, err := store.Room.GetRooms(ctx, bson.M{"hotelID": bson.M{"$ne": hotel.ID}}); err != nil || len(left) != 0 {
+		t.Errorf("Expected the room to be removed again, got %v, %v", left, err)
+	}
+}
+
+// insertContractRoom inserts a room of a new hotel and returns its ID
+func insertContractRoom(t *testing.T, store *db.Store) primitive.ObjectID {
+	ctx := context.TODO()
+	hotel, err := store.Hotel.Insert(ctx, &types.Hotel{Name: "Booking Hotel", Location: "Oslo", Rooms: []primitive.ObjectID{}})
+	if err != nil {
+		t.Fatalf("Error inserting hotel: %v", err)
+	}
+	room, err := store.Room.InsertRoom(ctx, &types.Room{Size: "small", Price: 100, HotelID: hotel.ID})
+	if err != nil {
+		t.Fatalf("Error inserting room: %v", err)
+	}
+	return room.ID
+}
+
+// testRoomDeletionContract checks that rooms being deleted cannot be booked
+// and that their upcoming stays are found
+func testRoomDeletionContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	roomID := insertContractRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	newBooking := func(from time.Time) *types.Booking {
+		return &types.Booking{
+			RoomID:    roomID,
+			UserID:    primitive.NewObjectID(),
+			FromDate:  from,
+			TillDate:  from.AddDate(0, 0, 2),
+			NumPerson: 1,
+			Status:    types.BookingStatusConfirmed,
+		}
+	}
+
+	upcoming, err := store.Booking.HasUpcomingStays(ctx, roomID, time.Now())
+	if err != nil || upcoming {
+		t.Fatalf("Expected no upcoming stays, got %v, %v", upcoming, err)
+	}
+	booking, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	if upcoming, err := store.Booking.HasUpcomingStays(ctx, roomID, time.Now()); err != nil || !upcoming {
+		t.Errorf("Expected the booking to be an upcoming stay, got %v, %v", upcoming, err)
+	}
+
+	// A room being deleted refuses new nights and keeps none of them
+	if err := store.Room.SetRoomDeleting(ctx, roomID, true); err != nil {
+		t.Fatalf("Error marking room: %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 5))); !errors.Is(err, db.ErrRoomDeleted) {
+		t.Errorf("Expected ErrRoomDeleted, got %v", err)
+	}
+	modified := *booking
+	modified.TillDate = booking.TillDate.AddDate(0, 0, 1)
+	if _, err := store.Booking.ModifyBooking(ctx, &modified, types.NewBookingChange(booking, booking.UserID)); !errors.Is(err, db.ErrRoomDeleted) {
+		t.Errorf("Expected ErrRoomDeleted extending the stay, got %v", err)
+	}
+	listed, err := store.Room.GetRooms(ctx, db.ListedRoomsFilter(bson.M{"_id": roomID}, time.Now()))
+	if err != nil {
+		t.Fatalf("Error getting rooms: %v", err)
+	}
+	if len(listed) != 0 {
+		t.Errorf("Expected the room being deleted not to be listed, got %d rooms", len(listed))
+	}
+
+	// A mark left behind by a deletion that never finished runs out
+	if err := store.Room.UpdateRoom(ctx, roomID, bson.M{"deletingSince": time.Now().Add(-time.Hour)}); err != nil {
+		t.Fatalf("Error backdating mark: %v", err)
+	}
+	listed, err = store.Room.GetRooms(ctx, db.ListedRoomsFilter(bson.M{"_id": roomID}, time.Now()))
+	if err != nil {
+		t.Fatalf("Error getting rooms: %v", err)
+	}
+	if len(listed) != 1 {
+		t.Errorf("Expected the room with a stale mark to be listed, got %d rooms", len(listed))
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 20))); err != nil {
+		t.Errorf("Expected a room with a stale mark to be bookable, got %v", err)
+	}
+
+	// Once the mark is cleared the nights that were refused are free
+	if err := store.Room.SetRoomDeleting(ctx, roomID, false); err != nil {
+		t.Fatalf("Error clearing mark: %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 5))); err != nil {
+		t.Errorf("Error inserting booking: %v", err)
+	}
+	if _, err := store.Booking.ModifyBooking(ctx, &modified, types.NewBookingChange(booking, booking.UserID)); err != nil {
+		t.Errorf("Error extending the stay: %v", err)
+	}
+
+	// Bookings inserted without the ledger count as well
+	other := insertContractRoom(t, store)
+	legacy := newBooking(from)
+	legacy.RoomID = other
+	if _, err := store.Booking.InsertBooking(ctx, legacy); err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	if upcoming, err := store.Booking.HasUpcomingStays(ctx, other, time.Now()); err != nil || !upcoming {
+		t.Errorf("Expected the legacy booking to be an upcoming stay, got %v, %v", upcoming, err)
+	}
+
+	// Rooms that do not exist cannot be booked
+	missing := newBooking(from)
+	missing.RoomID = primitive.NewObjectID()
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, missing); !errors.Is(err, db.ErrRoomDeleted) {
+		t.Errorf("Expected ErrRoomDeleted for a missing room, got %v", err)
+	}
+	if err := store.Room.SetRoomDeleting(ctx, primitive.NewObjectID(), true); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments marking a missing room, got %v", err)
+	}
+}
+
+// testBookingStoreContract checks the reservation ledger and cancellations
+func testBookingStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	roomID := insertContractRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	newBooking := func(from time.Time, nights int) *types.Booking {
+		return &types.Booking{
+			RoomID:    roomID,
+			UserID:    primitive.NewObjectID(),
+			FromDate:  from,
+			TillDate:  from.AddDate(0, 0, nights),
+			NumPerson: 1,
+			Status:    types.BookingStatusConfirmed,
+		}
+	}
+
+	booking, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from, 3))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	if booking.ID.IsZero() {
+		t.Fatalf("Expected the booking ID to be set")
+	}
+
+	// Any shared night is a conflict, the check-out day is not
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 2), 2)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected ErrRoomAlreadyBooked, got %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from.AddDate(0, 0, 3), 1)); err != nil {
+		t.Errorf("Expected the check-out day to be bookable, got %v", err)
+	}
+
+	bookings, err := store.Booking.GetBookings(ctx, bson.M{"roomID": roomID, "tillDate": bson.M{"$gt": from.AddDate(0, 0, 3)}})
+	if err != nil {
+		t.Fatalf("Error getting bookings: %v", err)
+	}
+	if len(bookings) != 1 {
+		t.Errorf("Expected 1 booking ending after the first one, got %d", len(bookings))
+	}
+
+	found, err := store.Booking.GetBookingByID(ctx, booking.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if found.RoomID != roomID || found.Status != types.BookingStatusConfirmed {
+		t.Errorf("Expected the stored booking, got %+v", found)
+	}
+
+	// Cancelling records what it cost and frees the nights
+	cancellation := &types.Cancellation{CancelledAt: time.Now().UTC().Truncate(time.Millisecond), Penalty: 40, SettlementPending: true}
+	cancelled, err := store.Booking.CancelBooking(ctx, booking.ID, cancellation)
+	if err != nil {
+		t.Fatalf("Error cancelling booking: %v", err)
+	}
+	if cancelled.Status != types.BookingStatusCancelled {
+		t.Errorf("Expected status cancelled, got %s", cancelled.Status)
+	}
+	if c := cancelled.Cancellation; c == nil || c.Penalty != 40 || c.Refund != 0 || !c.CancelledAt.Equal(cancellation.CancelledAt) || !cancelled.IsSettlementPending() {
+		t.Errorf("Expected the cancellation to be recorded, got %+v", c)
+	}
+	rebooked, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(from, 3))
+	if err != nil {
+		t.Errorf("Expected the cancelled nights to be bookable again, got %v", err)
+	}
+
+	// Settling records the refund and keeps what the cancellation cost
+	settled, err := store.Booking.SettleCancellation(ctx, booking.ID, 290)
+	if err != nil {
+		t.Fatalf("Error settling cancellation: %v", err)
+	}
+	if c := settled.Cancellation; settled.IsSettlementPending() || c == nil || c.Penalty != 40 || c.Refund != 290 {
+		t.Errorf("Expected the settled cancellation, got %+v", c)
+	}
+	if rebooked != nil {
+		if _, err := store.Booking.SettleCancellation(ctx, rebooked.ID, 0); !errors.Is(err, mongo.ErrNoDocuments) {
+			t.Errorf("Expected ErrNoDocuments for a booking that is not cancelled, got %v", err)
+		}
+	}
+	if _, err := store.Booking.CancelBooking(ctx, booking.ID, nil); !errors.Is(err, db.ErrBookingNotCancellable) {
+		t.Errorf("Expected ErrBookingNotCancellable, got %v", err)
+	}
+	if _, err := store.Booking.CancelBooking(ctx, primitive.NewObjectID(), nil); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing booking, got %v", err)
+	}
+
+	// Modifying keeps the nights the booking already holds
+	later := from.AddDate(0, 1, 0)
+	stay, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(later, 2))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	blocker, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(later.AddDate(0, 0, 4), 1))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	modify := func(booking *types.Booking, from time.Time, nights int) (*types.Booking, error) {
+		stored, err := store.Booking.GetBookingByID(ctx, booking.ID)
+		if err != nil {
+			t.Fatalf("Error getting booking: %v", err)
+		}
+		modified := *stored
+		modified.FromDate = from
+		modified.TillDate = from.AddDate(0, 0, nights)
+		return store.Booking.ModifyBooking(ctx, &modified, types.NewBookingChange(stored, stored.UserID))
+	}
+	if _, err := modify(stay, later, 5); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected ErrRoomAlreadyBooked extending into another booking, got %v", err)
+	}
+	extended, err := modify(stay, later, 4)
+	if err != nil {
+		t.Fatalf("Error extending booking: %v", err)
+	}
+	if len(extended.History) != 1 || !extended.TillDate.Equal(later.AddDate(0, 0, 4).Truncate(time.Millisecond)) {
+		t.Errorf("Expected the extended stay with one history entry, got %+v", extended)
+	}
+	if _, err := modify(stay, later.AddDate(0, 0, 1), 2); err != nil {
+		t.Fatalf("Error shortening booking: %v", err)
+	}
+	// The night given up at the start is free again, the ones after the stay as well
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(later, 1)); err != nil {
+		t.Errorf("Expected the released night to be bookable, got %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(later.AddDate(0, 0, 3), 1)); err != nil {
+		t.Errorf("Expected the released night to be bookable, got %v", err)
+	}
+
+	// Stale terms and cancelled bookings are rejected
+	stored, err := store.Booking.GetBookingByID(ctx, stay.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	stale := types.NewBookingChange(stored, stored.UserID)
+	stale.FromDate = later
+	if _, err := store.Booking.ModifyBooking(ctx, stored, stale); !errors.Is(err, db.ErrBookingNotModifiable) {
+		t.Errorf("Expected ErrBookingNotModifiable for stale terms, got %v", err)
+	}
+	if _, err := store.Booking.CancelBooking(ctx, blocker.ID, nil); err != nil {
+		t.Fatalf("Error cancelling booking: %v", err)
+	}
+	if _, err := modify(blocker, later.AddDate(0, 0, 5), 1); !errors.Is(err, db.ErrBookingNotModifiable) {
+		t.Errorf("Expected ErrBookingNotModifiable for a cancelled booking, got %v", err)
+	}
+
+	// Nights held by a booking are never orphaned, however old their claim
+	if n, err := store.Booking.ReleaseOrphanedNights(ctx, time.Now().Add(time.Hour)); err != nil || n != 0 {
+		t.Errorf("Expected no orphaned nights, got %d, %v", n, err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newBooking(later.AddDate(0, 0, 1), 2)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected the modified booking to keep its nights, got %v", err)
+	}
+}
+
+// testBookingHoldContract checks confirming holds and releasing the ones that ran out
+func testBookingHoldContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	roomID := insertContractRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	newHold := func(from time.Time, expiresIn time.Duration) *types.Booking {
+		expiresAt := time.Now().Add(expiresIn)
+		return &types.Booking{
+			RoomID:        roomID,
+			UserID:        primitive.NewObjectID(),
+			FromDate:      from,
+			TillDate:      from.AddDate(0, 0, 2),
+			NumPerson:     1,
+			Status:        types.BookingStatusHeld,
+			HoldExpiresAt: &expiresAt,
+		}
+	}
+
+	// A running hold reserves the room until it is confirmed
+	hold, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(from, time.Hour))
+	if err != nil {
+		t.Fatalf("Error inserting hold: %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(from, time.Hour)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected ErrRoomAlreadyBooked for a held room, got %v", err)
+	}
+	confirmed, err := store.Booking.ConfirmHold(ctx, hold.ID)
+	if err != nil {
+		t.Fatalf("Error confirming hold: %v", err)
+	}
+	if confirmed.Status != types.BookingStatusConfirmed || confirmed.HoldExpiresAt != nil {
+		t.Errorf("Expected a confirmed booking without expiry, got %+v", confirmed)
+	}
+	if _, err := store.Booking.ConfirmHold(ctx, hold.ID); !errors.Is(err, db.ErrBookingNotHeld) {
+		t.Errorf("Expected ErrBookingNotHeld confirming twice, got %v", err)
+	}
+	if _, err := store.Booking.ConfirmHold(ctx, primitive.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing booking, got %v", err)
+	}
+
+	// A hold that ran out no longer blocks the room, even before it is swept
+	later := from.AddDate(0, 1, 0)
+	lapsed, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(later, -time.Minute))
+	if err != nil {
+		t.Fatalf("Error inserting hold: %v", err)
+	}
+	if _, err := store.Booking.ConfirmHold(ctx, lapsed.ID); !errors.Is(err, db.ErrHoldExpired) {
+		t.Errorf("Expected ErrHoldExpired, got %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(later, time.Hour)); err != nil {
+		t.Errorf("Expected the nights of an expired hold to be bookable, got %v", err)
+	}
+	stored, err := store.Booking.GetBookingByID(ctx, lapsed.ID)
+	if err != nil {
+		t.Fatalf("Error getting booking: %v", err)
+	}
+	if stored.Status != types.BookingStatusExpired {
+		t.Errorf("Expected status expired, got %s", stored.Status)
+	}
+
+	// The sweeper releases every hold that ran out and nothing else
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(later.AddDate(0, 0, 5), time.Minute)); err != nil {
+		t.Fatalf("Error inserting hold: %v", err)
+	}
+	n, err := store.Booking.ExpireHolds(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("Error expiring holds: %v", err)
+	}
+	if n != 2 {
+		t.Errorf("Expected 2 expired holds, got %d", n)
+	}
+	if n, err := store.Booking.ExpireHolds(ctx, time.Now().Add(2*time.Hour)); err != nil || n != 0 {
+		t.Errorf("Expected nothing left to expire, got %d, %v", n, err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(later.AddDate(0, 0, 5), time.Hour)); err != nil {
+		t.Errorf("Expected the swept nights to be bookable, got %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newHold(from, time.Hour)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected the confirmed booking to keep its nights, got %v", err)
+	}
+}
+
+// testPendingBookingContract checks confirming and failing bookings that wait for their payment
+func testPendingBookingContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	roomID := insertContractRoom(t, store)
+	from := time.Now().AddDate(0, 0, 10)
+	newPending := func(from time.Time) *types.Booking {
+		return &types.Booking{
+			RoomID:    roomID,
+			UserID:    primitive.NewObjectID(),
+			FromDate:  from,
+			TillDate:  from.AddDate(0, 0, 2),
+			NumPerson: 1,
+			Status:    types.BookingStatusPending,
+		}
+	}
+
+	// A pending booking reserves the room while the payment is decided
+	pending, err := store.Booking.InsertBookingIfAvailable(ctx, newPending(from))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newPending(from)); !errors.Is(err, db.ErrRoomAlreadyBooked) {
+		t.Errorf("Expected ErrRoomAlreadyBooked for a pending booking, got %v", err)
+	}
+
+	// Its payment is for the current terms, so they cannot change meanwhile
+	extended := *pending
+	extended.TillDate = pending.TillDate.AddDate(0, 0, 1)
+	if _, err := store.Booking.ModifyBooking(ctx, &extended, types.NewBookingChange(pending, pending.UserID)); !errors.Is(err, db.ErrBookingNotModifiable) {
+		t.Errorf("Expected ErrBookingNotModifiable for a pending booking, got %v", err)
+	}
+
+	confirmed, err := store.Booking.ConfirmPendingBooking(ctx, pending.ID)
+	if err != nil {
+		t.Fatalf("Error confirming booking: %v", err)
+	}
+	if confirmed.Status != types.BookingStatusConfirmed {
+		t.Errorf("Expected status confirmed, got %s", confirmed.Status)
+	}
+	// Terms computed while it was pending do not apply to the confirmed booking
+	if _, err := store.Booking.ModifyBooking(ctx, &extended, types.NewBookingChange(pending, pending.UserID)); !errors.Is(err, db.ErrBookingNotModifiable) {
+		t.Errorf("Expected ErrBookingNotModifiable after the status changed, got %v", err)
+	}
+	if _, err := store.Booking.ConfirmPendingBooking(ctx, pending.ID); !errors.Is(err, db.ErrBookingNotPending) {
+		t.Errorf("Expected ErrBookingNotPending confirming twice, got %v", err)
+	}
+	if _, err := store.Booking.FailPendingBooking(ctx, pending.ID); !errors.Is(err, db.ErrBookingNotPending) {
+		t.Errorf("Expected ErrBookingNotPending failing a confirmed booking, got %v", err)
+	}
+	if _, err := store.Booking.ConfirmPendingBooking(ctx, primitive.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing booking, got %v", err)
+	}
+
+	// A failed booking gives its nights back
+	later := from.AddDate(0, 1, 0)
+	declined, err := store.Booking.InsertBookingIfAvailable(ctx, newPending(later))
+	if err != nil {
+		t.Fatalf("Error inserting booking: %v", err)
+	}
+	failed, err := store.Booking.FailPendingBooking(ctx, declined.ID)
+	if err != nil {
+		t.Fatalf("Error failing booking: %v", err)
+	}
+	if failed.Status != types.BookingStatusFailed {
+		t.Errorf("Expected status failed, got %s", failed.Status)
+	}
+	if _, err := store.Booking.InsertBookingIfAvailable(ctx, newPending(later)); err != nil {
+		t.Errorf("Expected the nights of a failed booking to be bookable, got %v", err)
+	}
+}
+
+// testTokenStoreContract checks using, revoking and replaying refresh tokens
+func testTokenStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	userID := primitive.NewObjectID()
+	familyID := primitive.NewObjectID()
+	newToken := func(accessTokenID string) *types.RefreshToken {
+		token, secret, err := types.NewRefreshToken(userID, familyID, time.Hour)
+		if err != nil {
+			t.Fatalf("Error creating refresh token: %v", err)
+		}
+		if token.TokenHash != types.HashToken(secret) {
+			t.Fatalf("Expected the token to be stored by the hash of its secret")
+		}
+		token.AccessTokenID = accessTokenID
+		token.AccessExpiresAt = time.Now().Add(time.Hour)
+		if _, err := store.Token.InsertRefreshToken(ctx, token); err != nil {
+			t.Fatalf("Error inserting refresh token: %v", err)
+		}
+		return token
+	}
+
+	first := newToken("access-1")
+	found, err := store.Token.GetRefreshToken(ctx, first.TokenHash)
+	if err != nil {
+		t.Fatalf("Error getting refresh token: %v", err)
+	}
+	if found.ID != first.ID || found.FamilyID != familyID || found.Used || found.Revoked {
+		t.Errorf("Expected the stored refresh token, got %+v", found)
+	}
+	if _, err := store.Token.GetRefreshToken(ctx, "unknown"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for an unknown token, got %v", err)
+	}
+
+	// A token can only be used once
+	if err := store.Token.UseRefreshToken(ctx, first.ID); err != nil {
+		t.Fatalf("Error using refresh token: %v", err)
+	}
+	if err := store.Token.UseRefreshToken(ctx, first.ID); !errors.Is(err, db.ErrRefreshTokenReused) {
+		t.Errorf("Expected ErrRefreshTokenReused, got %v", err)
+	}
+	if err := store.Token.UseRefreshToken(ctx, primitive.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing token, got %v", err)
+	}
+
+	// Revoking the family revokes its refresh tokens and their access tokens
+	second := newToken("access-2")
+	if err := store.Token.RevokeTokenFamily(ctx, familyID); err != nil {
+		t.Fatalf("Error revoking token family: %v", err)
+	}
+	found, err = store.Token.GetRefreshToken(ctx, second.TokenHash)
+	if err != nil {
+		t.Fatalf("Error getting refresh token: %v", err)
+	}
+	if !found.Revoked {
+		t.Errorf("Expected the refresh token to be revoked")
+	}
+	if err := store.Token.UseRefreshToken(ctx, second.ID); !errors.Is(err, db.ErrRefreshTokenReused) {
+		t.Errorf("Expected a revoked token to be unusable, got %v", err)
+	}
+	for _, id := range []string{"access-1", "access-2"} {
+		revoked, err := store.Token.IsAccessTokenRevoked(ctx, id)
+		if err != nil {
+			t.Fatalf("Error checking access token: %v", err)
+		}
+		if !revoked {
+			t.Errorf("Expected access token %s to be revoked", id)
+		}
+	}
+
+	// Revoking an access token twice is fine, others stay valid
+	if err := store.Token.RevokeAccessToken(ctx, "access-1", time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("Error revoking an access token twice: %v", err)
+	}
+	revoked, err := store.Token.IsAccessTokenRevoked(ctx, "access-3")
+	if err != nil {
+		t.Fatalf("Error checking access token: %v", err)
+	}
+	if revoked {
+		t.Errorf("Expected an unrelated access token to stay valid")
+	}
+}
+
+// testUserTokenContract checks the single-use user tokens and revoking every session of a user
+func testUserTokenContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	userID := primitive.NewObjectID()
+	newToken := func(purpose types.TokenPurpose, ttl time.Duration) *types.UserToken {
+		token, secret, err := types.NewUserToken(userID, purpose, ttl)
+		if err != nil {
+			t.Fatalf("Error creating user token: %v", err)
+		}
+		if token.TokenHash != types.HashToken(secret) {
+			t.Fatalf("Expected the token to be stored by the hash of its secret")
+		}
+		if _, err := store.Token.InsertUserToken(ctx, token); err != nil {
+			t.Fatalf("Error inserting user token: %v", err)
+		}
+		return token
+	}
+	purpose := types.TokenPurposePasswordReset
+
+	// A token can only be used once
+	first := newToken(purpose, time.Hour)
+	used, err := store.Token.UseUserToken(ctx, purpose, first.TokenHash)
+	if err != nil {
+		t.Fatalf("Error using user token: %v", err)
+	}
+	if used.ID != first.ID || used.UserID != userID || !used.Used {
+		t.Errorf("Expected the used token, got %+v", used)
+	}
+	if _, err := store.Token.UseUserToken(ctx, purpose, first.TokenHash); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a used token, got %v", err)
+	}
+	if _, err := store.Token.UseUserToken(ctx, purpose, "unknown"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for an unknown token, got %v", err)
+	}
+
+	// Expired tokens and tokens of another purpose are not usable
+	expired := newToken(purpose, -time.Minute)
+	if _, err := store.Token.UseUserToken(ctx, purpose, expired.TokenHash); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for an expired token, got %v", err)
+	}
+	other := newToken(types.TokenPurpose("other"), time.Hour)
+	if _, err := store.Token.UseUserToken(ctx, purpose, other.TokenHash); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a token of another purpose, got %v", err)
+	}
+
+	// Invalidating only affects tokens of the purpose
+	second := newToken(purpose, time.Hour)
+	if err := store.Token.InvalidateUserTokens(ctx, userID, purpose); err != nil {
+		t.Fatalf("Error invalidating user tokens: %v", err)
+	}
+	if _, err := store.Token.UseUserToken(ctx, purpose, second.TokenHash); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for an invalidated token, got %v", err)
+	}
+	if _, err := store.Token.UseUserToken(ctx, other.Purpose, other.TokenHash); err != nil {
+		t.Errorf("Expected the token of another purpose to stay usable, got %v", err)
+	}
+
+	// Revoking the sessions of a user revokes all their token families, and only theirs
+	var tokens []*types.RefreshToken
+	for i, owner := range []primitive.ObjectID{userID, userID, primitive.NewObjectID()} {
+		token, _, err := types.NewRefreshToken(owner, primitive.NewObjectID(), time.Hour)
+		if err != nil {
+			t.Fatalf("Error creating refresh token: %v", err)
+		}
+		token.AccessTokenID = fmt.Sprintf("session-%d", i)
+		token.AccessExpiresAt = time.Now().Add(time.Hour)
+		if _, err := store.Token.InsertRefreshToken(ctx, token); err != nil {
+			t.Fatalf("Error inserting refresh token: %v", err)
+		}
+		tokens = append(tokens, token)
+	}
+	if err := store.Token.RevokeUserSessions(ctx, userID); err != nil {
+		t.Fatalf("Error revoking user sessions: %v", err)
+	}
+	for i, token := range tokens {
+		found, err := store.Token.GetRefreshToken(ctx, token.TokenHash)
+		if err != nil {
+			t.Fatalf("Error getting refresh token: %v", err)
+		}
+		revoked, err := store.Token.IsAccessTokenRevoked(ctx, token.AccessTokenID)
+		if err != nil {
+			t.Fatalf("Error checking access token: %v", err)
+		}
+		want := token.UserID == userID
+		if found.Revoked != want || revoked != want {
+			t.Errorf("Session %d: expected revoked %v, got refresh %v and access %v", i, want, found.Revoked, revoked)
+		}
+	}
+}
+
+// testLoginStoreContract checks reserving login attempts and recording lockout events
+func testLoginStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	key := types.EmailLoginKey("Locked@Example.com")
+	if key != "email:locked@example.com" {
+		t.Errorf("Expected emails to be keyed in lower case, got %s", key)
+	}
+	if _, err := store.Login.GetLoginAttempts(ctx, key); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments before any failure, got %v", err)
+	}
+
+	// Every reserved attempt is counted
+	for i := 1; i <= 3; i++ {
+		attempts, err := store.Login.ReserveLoginAttempt(ctx, key, i-1, time.Hour)
+		if err != nil {
+			t.Fatalf("Error reserving login attempt: %v", err)
+		}
+		if attempts.Failures != i {
+			t.Errorf("Expected %d failures, got %d", i, attempts.Failures)
+		}
+		if attempts.ExpiresAt.Sub(attempts.LastFailure) != time.Hour {
+			t.Errorf("Expected the record to expire an hour after the failure, got %+v", attempts)
+		}
+	}
+	attempts, err := store.Login.GetLoginAttempts(ctx, key)
+	if err != nil {
+		t.Fatalf("Error getting login attempts: %v", err)
+	}
+	if attempts.Failures != 3 {
+		t.Errorf("Expected 3 failures, got %d", attempts.Failures)
+	}
+
+	// Only one of several attempts that read the same failures is counted
+	if _, err := store.Login.ReserveLoginAttempt(ctx, key, 2, time.Hour); !errors.Is(err, db.ErrLoginAttemptTaken) {
+		t.Errorf("Expected ErrLoginAttemptTaken for outdated failures, got %v", err)
+	}
+	if _, err := store.Login.ReserveLoginAttempt(ctx, types.EmailLoginKey("new@example.com"), 1, time.Hour); !errors.Is(err, db.ErrLoginAttemptTaken) {
+		t.Errorf("Expected ErrLoginAttemptTaken for a key without failures, got %v", err)
+	}
+	if _, err := store.Login.ReserveLoginAttempt(ctx, key, 3, time.Hour); err != nil {
+		t.Fatalf("Error reserving login attempt: %v", err)
+	}
+
+	// Releasing takes back one attempt
+	if err := store.Login.ReleaseLoginAttempt(ctx, key); err != nil {
+		t.Fatalf("Error releasing login attempt: %v", err)
+	}
+	if attempts, err := store.Login.GetLoginAttempts(ctx, key); err != nil || attempts.Failures != 3 {
+		t.Errorf("Expected 3 failures after releasing, got %+v, %v", attempts, err)
+	}
+
+	// Clearing forgets the failures of the key only
+	other := types.IPLoginKey("192.0.2.1")
+	if _, err := store.Login.ReserveLoginAttempt(ctx, other, 0, time.Hour); err != nil {
+		t.Fatalf("Error reserving login attempt: %v", err)
+	}
+	if err := store.Login.ClearLoginAttempts(ctx, key); err != nil {
+		t.Fatalf("Error clearing login attempts: %v", err)
+	}
+	if _, err := store.Login.GetLoginAttempts(ctx, key); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments after clearing, got %v", err)
+	}
+	if _, err := store.Login.GetLoginAttempts(ctx, other); err != nil {
+		t.Errorf("Expected the other key to keep its failures, got %v", err)
+	}
+
+	// Expired records are not returned and start over
+	if _, err := store.Login.ReserveLoginAttempt(ctx, key, 0, -time.Second); err != nil {
+		t.Fatalf("Error reserving login attempt: %v", err)
+	}
+	if _, err := store.Login.GetLoginAttempts(ctx, key); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for an expired record, got %v", err)
+	}
+	attempts, err = store.Login.ReserveLoginAttempt(ctx, key, 0, time.Hour)
+	if err != nil {
+		t.Fatalf("Error reserving login attempt: %v", err)
+	}
+	if attempts.Failures != 1 {
+		t.Errorf("Expected an expired record to start over, got %d failures", attempts.Failures)
+	}
+
+	// Counting always counts and only a recorded failure starts the backoff
+	ip := types.IPLoginKey("192.0.2.2")
+	for i := 1; i <= 2; i++ {
+		attempts, err := store.Login.CountLoginAttempt(ctx, ip, time.Hour)
+		if err != nil {
+			t.Fatalf("Error counting login attempt: %v", err)
+		}
+		if attempts.Failures != i || !attempts.LastFailure.IsZero() {
+			t.Errorf("Expected %d failures and no failure time, got %+v", i, attempts)
+		}
+	}
+	if err := store.Login.ReleaseLoginAttempt(ctx, ip); err != nil {
+		t.Fatalf("Error releasing login attempt: %v", err)
+	}
+	attempts, err = store.Login.RecordLoginFailure(ctx, ip, time.Hour)
+	if err != nil {
+		t.Fatalf("Error recording login failure: %v", err)
+	}
+	if attempts.Failures != 1 || attempts.LastFailure.IsZero() || attempts.ExpiresAt.Sub(attempts.LastFailure) != time.Hour {
+		t.Errorf("Expected 1 failure expiring an hour after it, got %+v", attempts)
+	}
+	if err := store.Login.ClearLoginAttempts(ctx, ip); err != nil {
+		t.Fatalf("Error clearing login attempts: %v", err)
+	}
+	if attempts, err := store.Login.RecordLoginFailure(ctx, ip, time.Hour); err != nil || attempts.Failures != 1 {
+		t.Errorf("Expected a failure without a record to start one, got %+v, %v", attempts, err)
+	}
+
+	// Lockout events are listed newest first and can be unlocked
+	userID := primitive.NewObjectID()
+	adminID := primitive.NewObjectID()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	for i, email := range []string{"locked@example.com", "locked@example.com", ""} {
+		event := &types.LockoutEvent{
+			Email:       email,
+			IP:          "192.0.2.1",
+			Failures:    5,
+			LockedUntil: now.Add(15 * time.Minute),
+			CreatedAt:   now.Add(time.Duration(i) * time.Second),
+		}
+		if email != "" {
+			event.UserID = &userID
+		}
+		if _, err := store.Login.InsertLockoutEvent(ctx, event); err != nil {
+			t.Fatalf("Error inserting lockout event: %v", err)
+		}
+	}
+	events, err := store.Login.GetLockoutEvents(ctx, bson.M{})
+	if err != nil {
+		t.Fatalf("Error getting lockout events: %v", err)
+	}
+	if len(events) != 3 || events[0].Email != "" || !events[1].CreatedAt.After(events[2].CreatedAt) {
+		t.Fatalf("Expected 3 events newest first, got %+v", events)
+	}
+	if events[1].UserID == nil || *events[1].UserID != userID {
+		t.Errorf("Expected the event to name the user, got %v", events[1].UserID)
+	}
+
+	if err := store.Login.UnlockLockoutEvents(ctx, "locked@example.com", adminID); err != nil {
+		t.Fatalf("Error unlocking lockout events: %v", err)
+	}
+	events, err = store.Login.GetLockoutEvents(ctx, bson.M{"email": "locked@example.com"})
+	if err != nil {
+		t.Fatalf("Error getting lockout events: %v", err)
+	}
+	for _, event := range events {
+		if event.UnlockedAt == nil || event.UnlockedBy == nil || *event.UnlockedBy != adminID {
+			t.Errorf("Expected the event to be unlocked by the admin, got %+v", event)
+		}
+	}
+	open, err := store.Login.GetLockoutEvents(ctx, bson.M{"unlockedAt": bson.M{"$exists": false}})
+	if err != nil {
+		t.Fatalf("Error getting lockout events: %v", err)
+	}
+	if len(open) != 1 || open[0].Email != "" {
+		t.Errorf("Expected only the IP lockout to stay open, got %+v", open)
+	}
+}
+
+// testIdempotencyStoreContract checks claiming, completing and releasing idempotency keys
+func testIdempotencyStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	userID := primitive.NewObjectID()
+	hash := types.HashRequest("POST", "/api/v1/user", []byte(`{"email":"a@example.com"}`))
+	record := types.NewIdempotencyRecord(userID, "key-1", hash, time.Hour, time.Minute)
+
+	existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, record)
+	if err != nil || existing != nil {
+		t.Fatalf("Expected the key to be claimed, got %+v, %v", existing, err)
+	}
+
+	// A second claim sees the request in progress
+	existing, err = store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-1", "other", time.Hour, time.Minute))
+	if err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+	if existing == nil || existing.Completed || existing.RequestHash != hash {
+		t.Fatalf("Expected the first request in progress, got %+v", existing)
+	}
+
+	// Once completed the response is returned
+	body := []byte(`{"id":"1"}`)
+	if err := store.Idempotency.CompleteIdempotencyKey(ctx, record, 201, "application/json", body); err != nil {
+		t.Fatalf("Error completing key: %v", err)
+	}
+	existing, err = store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-1", hash, time.Hour, time.Minute))
+	if err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+	if existing == nil || !existing.Completed || existing.StatusCode != 201 || existing.ContentType != "application/json" || string(existing.Body) != string(body) {
+		t.Errorf("Expected the stored response, got %+v", existing)
+	}
+
+	// Keys are scoped to the user
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(primitive.NewObjectID(), "key-1", hash, time.Hour, time.Minute)); err != nil || existing != nil {
+		t.Errorf("Expected another user to claim the same key, got %+v, %v", existing, err)
+	}
+
+	// Released and expired keys can be claimed again
+	if err := store.Idempotency.ReleaseIdempotencyKey(ctx, record); err != nil {
+		t.Fatalf("Error releasing key: %v", err)
+	}
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-1", hash, -time.Minute, -time.Minute)); err != nil || existing != nil {
+		t.Errorf("Expected the released key to be claimed, got %+v, %v", existing, err)
+	}
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-1", hash, time.Hour, time.Minute)); err != nil || existing != nil {
+		t.Errorf("Expected the expired key to be claimed, got %+v, %v", existing, err)
+	}
+
+	// A request that did not complete within its lease no longer holds the key
+	abandoned := types.NewIdempotencyRecord(userID, "key-2", hash, time.Hour, -time.Minute)
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, abandoned); err != nil || existing != nil {
+		t.Fatalf("Expected the key to be claimed, got %+v, %v", existing, err)
+	}
+	retry := types.NewIdempotencyRecord(userID, "key-2", hash, time.Hour, time.Minute)
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, retry); err != nil || existing != nil {
+		t.Fatalf("Expected the abandoned key to be claimed, got %+v, %v", existing, err)
+	}
+
+	// The abandoned request can neither renew its lease, store its response over the retry nor release its key
+	if err := store.Idempotency.RenewIdempotencyKey(ctx, abandoned, time.Minute); !errors.Is(err, db.ErrIdempotencyClaimLost) {
+		t.Errorf("Expected ErrIdempotencyClaimLost, got %v", err)
+	}
+	if err := store.Idempotency.CompleteIdempotencyKey(ctx, abandoned, 201, "application/json", body); !errors.Is(err, db.ErrIdempotencyClaimLost) {
+		t.Errorf("Expected ErrIdempotencyClaimLost, got %v", err)
+	}
+	if err := store.Idempotency.ReleaseIdempotencyKey(ctx, abandoned); err != nil {
+		t.Fatalf("Error releasing key: %v", err)
+	}
+	existing, err = store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-2", hash, time.Hour, time.Minute))
+	if err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+	if existing == nil || existing.ClaimID != retry.ClaimID || existing.Completed {
+		t.Errorf("Expected the retry to hold the key, got %+v", existing)
+	}
+
+	// A renewed lease keeps the key
+	renewed := types.NewIdempotencyRecord(userID, "key-4", hash, time.Hour, -time.Minute)
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, renewed); err != nil || existing != nil {
+		t.Fatalf("Expected the key to be claimed, got %+v, %v", existing, err)
+	}
+	if err := store.Idempotency.RenewIdempotencyKey(ctx, renewed, time.Minute); err != nil {
+		t.Fatalf("Error renewing key: %v", err)
+	}
+	existing, err = store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-4", hash, time.Hour, time.Minute))
+	if err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+	if existing == nil || existing.ClaimID != renewed.ClaimID {
+		t.Errorf("Expected the renewed request to keep the key, got %+v", existing)
+	}
+
+	// A completed request keeps its response after the lease
+	done := types.NewIdempotencyRecord(userID, "key-3", hash, time.Hour, -time.Minute)
+	if existing, err := store.Idempotency.ClaimIdempotencyKey(ctx, done); err != nil || existing != nil {
+		t.Fatalf("Expected the key to be claimed, got %+v, %v", existing, err)
+	}
+	if err := store.Idempotency.CompleteIdempotencyKey(ctx, done, 201, "application/json", body); err != nil {
+		t.Fatalf("Error completing key: %v", err)
+	}
+	existing, err = store.Idempotency.ClaimIdempotencyKey(ctx, types.NewIdempotencyRecord(userID, "key-3", hash, time.Hour, time.Minute))
+	if err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+	if existing == nil || !existing.Completed || existing.StatusCode != 201 {
+		t.Errorf("Expected the stored response, got %+v", existing)
+	}
+}
+
+// testPaymentStoreContract checks recording payments and moving them between states
+func testPaymentStoreContract(t *testing.T, store *db.Store) {
+	ctx := context.TODO()
+
+	bookingID := primitive.NewObjectID()
+	newPayment := func(ref string, createdAt time.Time) *types.Payment {
+		return &types.Payment{
+			BookingID:   bookingID,
+			UserID:      primitive.NewObjectID(),
+			Provider:    "mock",
+			ProviderRef: ref,
+			Amount:      300,
+			Currency:    "EUR",
+			Status:      types.PaymentStatusPending,
+			CreatedAt:   createdAt,
+			UpdatedAt:   createdAt,
+		}
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	second, err := store.Payment.InsertPayment(ctx, newPayment("ref-2", now))
+	if err != nil {
+		t.Fatalf("Error inserting payment: %v", err)
+	}
+	first, err := store.Payment.InsertPayment(ctx, newPayment("ref-1", now.Add(-time.Minute)))
+	if err != nil {
+		t.Fatalf("Error inserting payment: %v", err)
+	}
+
+	// Payments of a booking are listed oldest first
+	paid, err := store.Payment.GetPayments(ctx, bson.M{"bookingID": bookingID})
+	if err != nil {
+		t.Fatalf("Error getting payments: %v", err)
+	}
+	if len(paid) != 2 || paid[0].ID != first.ID || paid[1].ID != second.ID {
+		t.Errorf("Expected both payments oldest first, got %+v", paid)
+	}
+
+	found, err := store.Payment.GetPaymentByProviderRef(ctx, "mock", "ref-2")
+	if err != nil {
+		t.Fatalf("Error getting payment: %v", err)
+	}
+	if found.ID != second.ID {
+		t.Errorf("Expected payment %s, got %s", second.ID.Hex(), found.ID.Hex())
+	}
+	if _, err := store.Payment.GetPaymentByProviderRef(ctx, "other", "ref-2"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for another provider, got %v", err)
+	}
+
+	// A status only moves on from the state the caller saw
+	failed, err := store.Payment.UpdatePaymentStatus(ctx, first.ID, types.PaymentStatusPending, types.PaymentStatusFailed, "card_declined")
+	if err != nil {
+		t.Fatalf("Error updating payment: %v", err)
+	}
+	if failed.Status != types.PaymentStatusFailed || failed.FailureReason != "card_declined" || !failed.UpdatedAt.After(first.UpdatedAt) {
+		t.Errorf("Expected a failed payment with its reason, got %+v", failed)
+	}
+	if _, err := store.Payment.UpdatePaymentStatus(ctx, first.ID, types.PaymentStatusPending, types.PaymentStatusAuthorized, ""); !errors.Is(err, db.ErrPaymentStatusChanged) {
+		t.Errorf("Expected ErrPaymentStatusChanged, got %v", err)
+	}
+	if _, err := store.Payment.UpdatePaymentStatus(ctx, primitive.NewObjectID(), types.PaymentStatusPending, types.PaymentStatusAuthorized, ""); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("Expected ErrNoDocuments for a missing payment, got %v", err)
+	}
+
+	// Capturing records the amount charged, e.g. a cancellation penalty
+	if _, err := store.Payment.UpdatePaymentStatus(ctx, second.ID, types.PaymentStatusPending, types.PaymentStatusAuthorized, ""); err != nil {
+		t.Fatalf("Error updating payment: %v", err)
+	}
+	captured, err := store.Payment.CapturePayment(ctx, second.ID, types.PaymentStatusAuthorized, 120)
+	if err != nil {
+		t.Fatalf("Error capturing payment: %v", err)
+	}
+	if captured.Status != types.PaymentStatusCaptured || captured.Captured != 120 {
+		t.Errorf("Expected 120 captured, got %+v", captured)
+	}
+	if _, err := store.Payment.CapturePayment(ctx, second.ID, types.PaymentStatusAuthorized, 120); !errors.Is(err, db.ErrPaymentStatusChanged) {
+		t.Errorf("Expected ErrPaymentStatusChanged capturing twice, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/0x0Glitch/hotel-reservation/db"
@@ -29,9 +30,14 @@ func setup(t *testing.T) *testDB {
 		t.Fatalf("Error connecting to MongoDB: %v", err)
 	}
 
+	userStore, err := db.NewMongoUserStore(context.TODO(), client, testDBName)
+	if err != nil {
+		t.Fatalf("Error creating user store: %v", err)
+	}
+
 	return &testDB{
 		client:    client,
-		userStore: db.NewMongoUserStore(client),
+		userStore: userStore,
 	}
 }
 
@@ -323,4 +329,35 @@ func TestMongoUserStore_GetUserByEmail(t *testing.T) {
 	if err == nil {
 		t.Errorf("expected error when getting user with non-existent email")
 	}
-} 
\ No newline at end of file
+} 
+// TestMongoUserStore_InsertDuplicateEmail tests that email addresses are unique
+func TestMongoUserStore_InsertDuplicateEmail(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping MongoDB integration test in short mode")
+	}
+
+	tdb := setup(t)
+	defer tdb.teardown(t)
+
+	// Insert the first user
+	_, err := tdb.userStore.InsertUser(context.TODO(), &types.User{
+		ID:        primitive.NewObjectID(),
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john@example.com",
+	})
+	if err != nil {
+		t.Fatalf("error inserting user: %v", err)
+	}
+
+	// A second user with the same email, in different case, is rejected
+	_, err = tdb.userStore.InsertUser(context.TODO(), &types.User{
+		ID:        primitive.NewObjectID(),
+		FirstName: "Johnny",
+		LastName:  "Doe",
+		Email:     "John@Example.com",
+	})
+	if !errors.Is(err, db.ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail, got %v", err)
+	}
+}
@@ -0,0 +1,321 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writePEM writes the private key to a PKCS #8 PEM file and returns its path
+func writePEM(t *testing.T, private interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatalf("Error marshaling key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Error writing key: %v", err)
+	}
+	return path
+}
+
+// rsaKey generates an RSA key and returns its config and public key
+func rsaKey(t *testing.T, id string, bits int) (config.SigningKeyConfig, *rsa.PublicKey) {
+	private, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatalf("Error generating RSA key: %v", err)
+	}
+	return config.SigningKeyConfig{
+		ID:             id,
+		Algorithm:      config.AlgorithmRS256,
+		PrivateKeyFile: writePEM(t, private),
+	}, &private.PublicKey
+}
+
+// edKey generates an Ed25519 key and returns its config and public key
+func edKey(t *testing.T, id string) (config.SigningKeyConfig, ed25519.PublicKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating Ed25519 key: %v", err)
+	}
+	return config.SigningKeyConfig{
+		ID:             id,
+		Algorithm:      config.AlgorithmEdDSA,
+		PrivateKeyFile: writePEM(t, private),
+	}, public
+}
+
+// testClaims returns claims for a token valid for an hour
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "user",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+// verify parses the token with the key set and reports whether it is valid
+func verify(set *keys.KeySet, token string) error {
+	_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, set.Keyfunc, jwt.WithValidMethods(set.ValidMethods()))
+	return err
+}
+
+// kidOf returns the kid header of a token
+func kidOf(t *testing.T, token string) string {
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("Error parsing token: %v", err)
+	}
+	kid, _ := parsed.Header["kid"].(string)
+	return kid
+}
+
+// TestSignAndVerify checks that tokens signed with every key type verify
+func TestSignAndVerify(t *testing.T) {
+	rsaCfg, _ := rsaKey(t, "rsa-key", 2048)
+	edCfg, _ := edKey(t, "ed-key")
+
+	testCases := []struct {
+		name string
+		cfg  config.AuthConfig
+		alg  string
+		kid  string
+	}{
+		{"HS256 secret", config.AuthConfig{JWTSecret: "secret"}, "HS256", ""},
+		{"RS256 key", config.AuthConfig{SigningKeys: []config.SigningKeyConfig{rsaCfg}}, "RS256", "rsa-key"},
+		{"EdDSA key", config.AuthConfig{SigningKeys: []config.SigningKeyConfig{edCfg}}, "EdDSA", "ed-key"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			set, err := keys.NewKeySet(tc.cfg)
+			if err != nil {
+				t.Fatalf("Error loading keys: %v", err)
+			}
+			token, err := set.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Error signing token: %v", err)
+			}
+
+			parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatalf("Error parsing token: %v", err)
+			}
+			if parsed.Method.Alg() != tc.alg {
+				t.Errorf("Expected algorithm %s, got %s", tc.alg, parsed.Method.Alg())
+			}
+			if kid := kidOf(t, token); kid != tc.kid {
+				t.Errorf("Expected kid %q, got %q", tc.kid, kid)
+			}
+			if err := verify(set, token); err != nil {
+				t.Errorf("Expected the token to verify, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRotation checks that a scheduled key takes over signing once it is active
+// while tokens signed with the previous key keep verifying until it is retired
+func TestRotation(t *testing.T) {
+	oldCfg, _ := edKey(t, "2026-09")
+	newCfg, _ := edKey(t, "2026-10")
+	oldCfg.ActiveFrom = time.Now().Add(-30 * 24 * time.Hour)
+
+	// The new key is published but not used yet
+	newCfg.ActiveFrom = time.Now().Add(time.Hour)
+	set, err := keys.NewKeySet(config.AuthConfig{SigningKeys: []config.SigningKeyConfig{newCfg, oldCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	oldToken, err := set.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	if kid := kidOf(t, oldToken); kid != "2026-09" {
+		t.Errorf("Expected the current key to sign, got %q", kid)
+	}
+	if n := len(set.JWKS().Keys); n != 2 {
+		t.Errorf("Expected both keys to be published, got %d", n)
+	}
+
+	// Once active, the new key signs and the old key still verifies
+	newCfg.ActiveFrom = time.Now().Add(-time.Minute)
+	set, err = keys.NewKeySet(config.AuthConfig{SigningKeys: []config.SigningKeyConfig{oldCfg, newCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	newToken, err := set.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	if kid := kidOf(t, newToken); kid != "2026-10" {
+		t.Errorf("Expected the new key to sign, got %q", kid)
+	}
+	for _, token := range []string{oldToken, newToken} {
+		if err := verify(set, token); err != nil {
+			t.Errorf("Expected the token to verify, got %v", err)
+		}
+	}
+
+	// A retired key neither verifies nor is published
+	oldCfg.RetireAt = time.Now().Add(-time.Second)
+	set, err = keys.NewKeySet(config.AuthConfig{SigningKeys: []config.SigningKeyConfig{oldCfg, newCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	if err := verify(set, oldToken); err == nil {
+		t.Errorf("Expected a token of a retired key to be rejected")
+	}
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "2026-10" {
+		t.Errorf("Expected only the new key to be published, got %+v", jwks.Keys)
+	}
+}
+
+// TestSecretFallback checks that the HS256 secret signs until the first key is
+// active and keeps verifying old tokens after
+func TestSecretFallback(t *testing.T) {
+	keyCfg, _ := rsaKey(t, "rsa-key", 2048)
+	keyCfg.ActiveFrom = time.Now().Add(time.Hour)
+
+	set, err := keys.NewKeySet(config.AuthConfig{JWTSecret: "secret", SigningKeys: []config.SigningKeyConfig{keyCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	hsToken, err := set.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("Error signing token: %v", err)
+	}
+	if kid := kidOf(t, hsToken); kid != "" {
+		t.Errorf("Expected the secret to sign until the key is active, got kid %q", kid)
+	}
+
+	keyCfg.ActiveFrom = time.Time{}
+	set, err = keys.NewKeySet(config.AuthConfig{JWTSecret: "secret", SigningKeys: []config.SigningKeyConfig{keyCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	if err := verify(set, hsToken); err != nil {
+		t.Errorf("Expected the HS256 token to still verify, got %v", err)
+	}
+}
+
+// TestRejectedTokens checks tokens naming keys they were not signed with
+func TestRejectedTokens(t *testing.T) {
+	keyCfg, public := rsaKey(t, "rsa-key", 2048)
+	set, err := keys.NewKeySet(config.AuthConfig{SigningKeys: []config.SigningKeyConfig{keyCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+
+	// An HS256 token "signed" with the public key, hoping it is used as HMAC secret
+	confused := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	confused.Header["kid"] = "rsa-key"
+	publicDER, _ := x509.MarshalPKIXPublicKey(public)
+	confusedToken, _ := confused.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+
+	// A token signed with another key claiming the same kid
+	otherCfg, _ := rsaKey(t, "rsa-key", 2048)
+	other, err := keys.NewKeySet(config.AuthConfig{SigningKeys: []config.SigningKeyConfig{otherCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+	forgedToken, _ := other.Sign(testClaims())
+
+	// A token naming a key that does not exist
+	unknown := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+	unknown.Header["kid"] = "unknown"
+	unknownToken, _ := unknown.SignedString([]byte("secret"))
+
+	// A token without kid when no secret is configured
+	noKidToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString([]byte("secret"))
+
+	for name, token := range map[string]string{
+		"algorithm confusion": confusedToken,
+		"forged signature":    forgedToken,
+		"unknown kid":         unknownToken,
+		"missing kid":         noKidToken,
+	} {
+		if err := verify(set, token); err == nil {
+			t.Errorf("Expected %s token to be rejected", name)
+		}
+	}
+}
+
+// TestNewKeySetErrors checks that unusable keys are reported at startup
+func TestNewKeySetErrors(t *testing.T) {
+	weakCfg, _ := rsaKey(t, "weak", 1024)
+	edCfg, _ := edKey(t, "ed-key")
+	wrongAlg := edCfg
+	wrongAlg.Algorithm = config.AlgorithmRS256
+
+	testCases := []struct {
+		name string
+		cfg  config.AuthConfig
+	}{
+		{"no keys", config.AuthConfig{}},
+		{"missing file", config.AuthConfig{SigningKeys: []config.SigningKeyConfig{{ID: "k", Algorithm: config.AlgorithmRS256, PrivateKeyFile: filepath.Join(t.TempDir(), "missing.pem")}}}},
+		{"weak RSA key", config.AuthConfig{SigningKeys: []config.SigningKeyConfig{weakCfg}}},
+		{"key of another algorithm", config.AuthConfig{SigningKeys: []config.SigningKeyConfig{wrongAlg}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := keys.NewKeySet(tc.cfg); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+// TestJWKS checks that the published keys match the private keys
+func TestJWKS(t *testing.T) {
+	rsaCfg, rsaPublic := rsaKey(t, "rsa-key", 2048)
+	edCfg, edPublic := edKey(t, "ed-key")
+	set, err := keys.NewKeySet(config.AuthConfig{JWTSecret: "secret", SigningKeys: []config.SigningKeyConfig{rsaCfg, edCfg}})
+	if err != nil {
+		t.Fatalf("Error loading keys: %v", err)
+	}
+
+	jwks := set.JWKS()
+	if len(jwks.Keys) != 2 {
+		t.Fatalf("Expected the two public keys and not the secret, got %+v", jwks.Keys)
+	}
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatalf("Error decoding %q: %v", s, err)
+		}
+		return b
+	}
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "sig" {
+			t.Errorf("Expected use sig, got %q", jwk.Use)
+		}
+		switch jwk.Kid {
+		case "rsa-key":
+			n := new(big.Int).SetBytes(decode(jwk.N))
+			e := new(big.Int).SetBytes(decode(jwk.E))
+			if jwk.Kty != "RSA" || jwk.Alg != "RS256" || n.Cmp(rsaPublic.N) != 0 || int(e.Int64()) != rsaPublic.E {
+				t.Errorf("Expected the RSA public key, got %+v", jwk)
+			}
+		case "ed-key":
+			if jwk.Kty != "OKP" || jwk.Crv != "Ed25519" || jwk.Alg != "EdDSA" || !ed25519.PublicKey(decode(jwk.X)).Equal(edPublic) {
+				t.Errorf("Expected the Ed25519 public key, got %+v", jwk)
+			}
+		default:
+			t.Errorf("Unexpected key %q", jwk.Kid)
+		}
+	}
+}
@@ -6,116 +6,44 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 	"time"
 
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
 	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/keys"
+	"github.com/0x0Glitch/hotel-reservation/middleware"
 	"github.com/0x0Glitch/hotel-reservation/types"
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// setupAuthMiddlewareTest sets up the environment for testing auth middleware
-func setupAuthMiddlewareTest(t *testing.T) (*fiber.App, *mongo.Client, func()) {
-	// Set JWT secret for testing
-	os.Setenv("JWT_SECRET", "test-jwt-secret")
-	
-	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI("mongodb://localhost:27017"))
-	if err != nil {
-		t.Fatalf("Error connecting to MongoDB: %v", err)
-	}
+// testAuthConfig is the token configuration the test server verifies against
+var testAuthConfig = config.AuthConfig{
+	JWTSecret: "test-jwt-secret",
+	TokenTTL:  time.Hour,
+	Issuer:    "hotel-reservation-test",
+	Audience:  "hotel-reservation-test-api",
+}
+
+// setupAuthMiddlewareTest sets up a server with a route protected by JWTAuthentication
+func setupAuthMiddlewareTest(t *testing.T) (*fiber.App, *db.MemoryDatabase, func()) {
+	database := db.NewMemoryDatabase()
 
 	// Initialize user store
-	userStore := db.NewMongoUserStore(client)
+	userStore := db.NewMemoryUserStore(database)
 
-	// Clean up the user collection before test
-	_, err = client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
+	// Setup Fiber app with a protected route
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	tokenStore := db.NewMemoryTokenStore(database)
+	keySet, err := keys.NewKeySet(testAuthConfig)
 	if err != nil {
-		t.Fatalf("Error cleaning users collection: %v", err)
+		t.Fatalf("Error loading keys: %v", err)
 	}
-
-	// Setup Fiber app with a protected route
-	app := fiber.New()
-	
-	// Create a protected route that requires authentication
-	// Normally we would use middleware.JWTAuthentication here, but for test simplicity,
-	// we'll create a mock protected handler that checks for a user in the authorization header
-	app.Get("/api/protected", func(c *fiber.Ctx) error {
-		// Mock JWT authentication middleware for testing
-		auth := c.Get("Authorization")
-		if auth == "" {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Missing authorization header",
-			})
-		}
-		
-		// Extract token
-		tokenString := auth[7:] // Remove "Bearer " prefix
-		
-		// Parse the token
-		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			// Validate signing method
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-			}
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
-		
-		if err != nil || !token.Valid {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token",
-			})
-		}
-		
-		// Extract claims
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid token claims",
-			})
-		}
-		
-		// Check expiration
-		if exp, ok := claims["expires"].(float64); ok {
-			if time.Now().Unix() > int64(exp) {
-				return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-					"error": "Token expired",
-				})
-			}
-		}
-		
-		// Get user ID from token
-		userIDStr, ok := claims["id"].(string)
-		if !ok {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid user ID in token",
-			})
-		}
-		
-		userID, err := primitive.ObjectIDFromHex(userIDStr)
-		if err != nil {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "Invalid user ID format",
-			})
-		}
-		
-		// Get user from database
-		user, err := userStore.GetUserById(c.Context(), userID.Hex())
-		if err != nil {
-			return c.Status(http.StatusUnauthorized).JSON(fiber.Map{
-				"error": "User not found",
-			})
-		}
-		
-		// Set user in context
-		c.Locals("user", user)
-		
-		// Handle the protected route
-		user, ok = c.Locals("user").(*types.User)
+	app.Get("/api/protected", middleware.JWTAuthentication(userStore, tokenStore, keySet, testAuthConfig), func(c *fiber.Ctx) error {
+		user, ok := c.Context().UserValue("user").(*types.User)
 		if !ok {
 			return c.Status(http.StatusInternalServerError).JSON(fiber.Map{
 				"error": "Failed to get user from context",
@@ -128,23 +56,13 @@ func setupAuthMiddlewareTest(t *testing.T) (*fiber.App, *mongo.Client, func()) {
 	})
 
 	// Return test server and cleanup function
-	return app, client, func() {
-		// Clean up after test
-		_, err := client.Database(db.DBNAME).Collection("users").DeleteMany(context.TODO(), struct{}{})
-		if err != nil {
-			t.Fatalf("Error cleaning users collection: %v", err)
-		}
-		if err := client.Disconnect(context.TODO()); err != nil {
-			t.Fatalf("Error disconnecting from MongoDB: %v", err)
-		}
-		os.Unsetenv("JWT_SECRET")
-	}
+	return app, database, func() {}
 }
 
 // createTestUserAndToken creates a test user and generates a valid JWT token
-func createTestUserAndToken(t *testing.T, client *mongo.Client) (*types.User, string) {
-	userStore := db.NewMongoUserStore(client)
-	
+func createTestUserAndToken(t *testing.T, database *db.MemoryDatabase) (*types.User, string) {
+	userStore := db.NewMemoryUserStore(database)
+
 	// Create a user
 	userID := primitive.NewObjectID()
 	user := &types.User{
@@ -154,73 +72,94 @@ func createTestUserAndToken(t *testing.T, client *mongo.Client) (*types.User, st
 		Email:             "authtest@example.com",
 		EncryptedPassword: "some-encrypted-password",
 	}
-	
+
 	insertedUser, err := userStore.InsertUser(context.TODO(), user)
 	if err != nil {
 		t.Fatalf("Error inserting test user: %v", err)
 	}
-	
+
 	// Create a token
-	token := createToken(insertedUser)
-	
+	token := createToken(t, jwt.SigningMethodHS256, validClaims(insertedUser))
+
 	return insertedUser, token
 }
 
-// createToken generates a JWT token for the given user
-func createToken(user *types.User) string {
+// validClaims returns the claims of a token the test server accepts for the user
+func validClaims(user *types.User) jwt.MapClaims {
 	now := time.Now()
-	expires := now.Add(time.Hour).Unix()
-	
-	claims := jwt.MapClaims{
-		"id":      user.ID.Hex(), // Convert ObjectID to string
-		"email":   user.Email,
-		"expires": expires,
+	return jwt.MapClaims{
+		"jti":   primitive.NewObjectID().Hex(),
+		"sub":   user.ID.Hex(),
+		"email": user.Email,
+		"iss":   testAuthConfig.Issuer,
+		"aud":   testAuthConfig.Audience,
+		"iat":   now.Unix(),
+		"nbf":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
 	}
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	tokenString, _ := token.SignedString([]byte(secret))
-	
-	return tokenString
 }
 
-// TestAuthMiddleware_ValidToken tests authentication with a valid token
-func TestAuthMiddleware_ValidToken(t *testing.T) {
-	app, client, cleanup := setupAuthMiddlewareTest(t)
-	defer cleanup()
-	
-	// Create user and token
-	user, token := createTestUserAndToken(t, client)
-	
-	// Create HTTP request with auth header
-	req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
-	
-	// Execute request
-	resp, err := app.Test(req)
+// createToken signs the claims with the test secret
+func createToken(t *testing.T, method jwt.SigningMethod, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	tokenString, err := token.SignedString([]byte(testAuthConfig.JWTSecret))
 	if err != nil {
-		t.Fatalf("Error making request: %v", err)
+		t.Fatalf("Error signing token: %v", err)
 	}
-	defer resp.Body.Close()
-	
-	// Check status code
-	if resp.StatusCode != http.StatusOK {
-		t.Errorf("Expected status OK, got %v", resp.StatusCode)
-	}
-	
-	// Parse response to verify user ID
-	var response map[string]interface{}
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		t.Fatalf("Error decoding response: %v", err)
+	return tokenString
+}
+
+// TestAuthMiddleware_ValidToken tests authentication with a valid token in either header
+func TestAuthMiddleware_ValidToken(t *testing.T) {
+	testCases := []struct {
+		name   string
+		header string
+		value  string
+	}{
+		{"bearer", "Authorization", "Bearer %s"},
+		{"lower case bearer", "Authorization", "bearer %s"},
+		{"api token header", "X-Api-Token", "%s"},
 	}
-	
-	// Verify the user ID in the response matches our test user
-	if userID, ok := response["userID"].(string); ok {
-		if userID != user.ID.Hex() {
-			t.Errorf("Expected user ID %s, got %s", user.ID.Hex(), userID)
-		}
-	} else {
-		t.Errorf("Missing or invalid userID in response")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, database, cleanup := setupAuthMiddlewareTest(t)
+			defer cleanup()
+
+			// Create user and token
+			user, token := createTestUserAndToken(t, database)
+
+			// Create HTTP request with auth header
+			req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
+			req.Header.Set(tc.header, fmt.Sprintf(tc.value, token))
+
+			// Execute request
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			// Check status code
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("Expected status OK, got %v", resp.StatusCode)
+			}
+
+			// Parse response to verify user ID
+			var response map[string]interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+				t.Fatalf("Error decoding response: %v", err)
+			}
+
+			// Verify the user ID in the response matches our test user
+			if userID, ok := response["userID"].(string); ok {
+				if userID != user.ID.Hex() {
+					t.Errorf("Expected user ID %s, got %s", user.ID.Hex(), userID)
+				}
+			} else {
+				t.Errorf("Missing or invalid userID in response")
+			}
+		})
 	}
 }
 
@@ -228,93 +167,164 @@ func TestAuthMiddleware_ValidToken(t *testing.T) {
 func TestAuthMiddleware_NoToken(t *testing.T) {
 	app, _, cleanup := setupAuthMiddlewareTest(t)
 	defer cleanup()
-	
+
 	// Create HTTP request without auth header
 	req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
-	
+
 	// Execute request
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Fatalf("Error making request: %v", err)
 	}
 	defer resp.Body.Close()
-	
+
 	// Check status code - should be unauthorized
-	if resp.StatusCode == http.StatusOK {
-		t.Errorf("Expected non-OK status for request without token, got %v", resp.StatusCode)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for request without token, got %v", resp.StatusCode)
 	}
 }
 
-// TestAuthMiddleware_InvalidToken tests authentication with invalid token
+// TestAuthMiddleware_InvalidToken tests that malformed and tampered tokens are rejected
+// None of them may crash the middleware, whatever claims they are missing
 func TestAuthMiddleware_InvalidToken(t *testing.T) {
-	app, _, cleanup := setupAuthMiddlewareTest(t)
+	app, database, cleanup := setupAuthMiddlewareTest(t)
+	defer cleanup()
+
+	user, _ := createTestUserAndToken(t, database)
+
+	// without returns the valid claims minus the given one
+	without := func(claim string) jwt.MapClaims {
+		claims := validClaims(user)
+		delete(claims, claim)
+		return claims
+	}
+	// with returns the valid claims with one of them changed
+	with := func(claim string, value interface{}) jwt.MapClaims {
+		claims := validClaims(user)
+		claims[claim] = value
+		return claims
+	}
+
+	testCases := []struct {
+		name   string
+		header string
+	}{
+		{"malformed token", "Bearer invalid-token"},
+		{"empty bearer token", "Bearer "},
+		{"unknown scheme", "Basic " + createToken(t, jwt.SigningMethodHS256, validClaims(user))},
+		{"wrong secret", "Bearer " + func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(user)).SignedString([]byte("another-secret"))
+			return token
+		}()},
+		{"wrong signing method", "Bearer " + createToken(t, jwt.SigningMethodHS512, validClaims(user))},
+		{"unsigned token", "Bearer " + func() string {
+			token, _ := jwt.NewWithClaims(jwt.SigningMethodNone, validClaims(user)).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return token
+		}()},
+		{"missing exp", "Bearer " + createToken(t, jwt.SigningMethodHS256, without("exp"))},
+		{"missing sub", "Bearer " + createToken(t, jwt.SigningMethodHS256, without("sub"))},
+		{"missing jti", "Bearer " + createToken(t, jwt.SigningMethodHS256, without("jti"))},
+		{"missing aud", "Bearer " + createToken(t, jwt.SigningMethodHS256, without("aud"))},
+		{"missing iss", "Bearer " + createToken(t, jwt.SigningMethodHS256, without("iss"))},
+		{"wrong audience", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("aud", "another-api"))},
+		{"wrong issuer", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("iss", "someone-else"))},
+		{"exp of wrong type", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("exp", "tomorrow"))},
+		{"not valid yet", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("nbf", time.Now().Add(time.Hour).Unix()))},
+		{"issued in the future", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("iat", time.Now().Add(time.Hour).Unix()))},
+		{"unknown user", "Bearer " + createToken(t, jwt.SigningMethodHS256, with("sub", primitive.NewObjectID().Hex()))},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
+			req.Header.Set("Authorization", tc.header)
+
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			// Check status code - should be unauthorized
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("Expected status Unauthorized, got %v", resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestAuthMiddleware_ExpiredToken tests authentication with expired token
+func TestAuthMiddleware_ExpiredToken(t *testing.T) {
+	app, database, cleanup := setupAuthMiddlewareTest(t)
 	defer cleanup()
-	
-	// Create HTTP request with invalid token
+
+	user, _ := createTestUserAndToken(t, database)
+
+	// Create a token that expired 1 hour ago
+	claims := validClaims(user)
+	claims["iat"] = time.Now().Add(-2 * time.Hour).Unix()
+	claims["nbf"] = time.Now().Add(-2 * time.Hour).Unix()
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	tokenString := createToken(t, jwt.SigningMethodHS256, claims)
+
+	// Create HTTP request with expired token
 	req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
-	req.Header.Set("Authorization", "Bearer invalid-token")
-	
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
+
 	// Execute request
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Fatalf("Error making request: %v", err)
 	}
 	defer resp.Body.Close()
-	
+
 	// Check status code - should be unauthorized
-	if resp.StatusCode == http.StatusOK {
-		t.Errorf("Expected non-OK status for request with invalid token, got %v", resp.StatusCode)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status Unauthorized for expired token, got %v", resp.StatusCode)
 	}
-}
 
-// TestAuthMiddleware_ExpiredToken tests authentication with expired token
-func TestAuthMiddleware_ExpiredToken(t *testing.T) {
-	app, client, cleanup := setupAuthMiddlewareTest(t)
-	defer cleanup()
-	
-	// Create a user
-	userStore := db.NewMongoUserStore(client)
-	userID := primitive.NewObjectID()
-	user := &types.User{
-		ID:                userID,
-		FirstName:         "Expired",
-		LastName:          "Token",
-		Email:             "expired@example.com",
-		EncryptedPassword: "some-encrypted-password",
+	// The client is told to log in again rather than that the token is bad
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
 	}
-	
-	_, err := userStore.InsertUser(context.TODO(), user)
-	if err != nil {
-		t.Fatalf("Error inserting test user: %v", err)
+	if apiErr.Reason != "token_expired" {
+		t.Errorf("Expected reason token_expired, got %q", apiErr.Reason)
 	}
-	
-	// Create an expired token
-	now := time.Now()
-	expires := now.Add(-time.Hour).Unix() // Expired 1 hour ago
-	
-	claims := jwt.MapClaims{
-		"id":      user.ID.Hex(), // Convert ObjectID to string
-		"email":   user.Email,
-		"expires": expires,
+}
+
+// TestAuthMiddleware_RevokedToken tests that revoked tokens are rejected
+func TestAuthMiddleware_RevokedToken(t *testing.T) {
+	app, database, cleanup := setupAuthMiddlewareTest(t)
+	defer cleanup()
+
+	user, _ := createTestUserAndToken(t, database)
+	claims := validClaims(user)
+	tokenString := createToken(t, jwt.SigningMethodHS256, claims)
+
+	// Revoke the token by its ID, as logging out does
+	tokenStore := db.NewMemoryTokenStore(database)
+	if err := tokenStore.RevokeAccessToken(context.TODO(), claims["jti"].(string), time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("Error revoking token: %v", err)
 	}
-	
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	tokenString, _ := token.SignedString([]byte(secret))
-	
-	// Create HTTP request with expired token
+
 	req := httptest.NewRequest(http.MethodGet, "/api/protected", nil)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tokenString))
-	
-	// Execute request
+
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Fatalf("Error making request: %v", err)
 	}
 	defer resp.Body.Close()
-	
-	// Check status code - should be unauthorized
-	if resp.StatusCode == http.StatusOK {
-		t.Errorf("Expected non-OK status for request with expired token, got %v", resp.StatusCode)
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status Unauthorized for revoked token, got %v", resp.StatusCode)
 	}
-} 
\ No newline at end of file
+	var apiErr api.Error
+	if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil {
+		t.Fatalf("Error decoding error response: %v", err)
+	}
+	if apiErr.Reason != "token_revoked" {
+		t.Errorf("Expected reason token_revoked, got %q", apiErr.Reason)
+	}
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/middleware"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// setupAuthorizationTest creates a server with an admin-only route
+// The given user stands in for the one JWTAuthentication would have loaded
+func setupAuthorizationTest(user *types.User) *fiber.App {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		if user != nil {
+			c.Context().SetUserValue("user", user)
+		}
+		return c.Next()
+	})
+	app.Get("/api/admin", middleware.RequireRoles(types.RoleAdmin), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "admin route accessed successfully"})
+	})
+	app.Get("/api/staff", middleware.RequireRoles(types.RoleStaff, types.RoleAdmin), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "staff route accessed successfully"})
+	})
+	app.Get("/api/verified", middleware.RequireVerifiedEmail(true), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "verified route accessed successfully"})
+	})
+	app.Get("/api/unrestricted", middleware.RequireVerifiedEmail(false), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"message": "unrestricted route accessed successfully"})
+	})
+	return app
+}
+
+// TestRequireRoles tests which roles can access role-protected routes
+func TestRequireRoles(t *testing.T) {
+	testCases := []struct {
+		name     string
+		role     types.Role
+		path     string
+		expected int
+	}{
+		{"admin on admin route", types.RoleAdmin, "/api/admin", http.StatusOK},
+		{"staff on admin route", types.RoleStaff, "/api/admin", http.StatusForbidden},
+		{"guest on admin route", types.RoleGuest, "/api/admin", http.StatusForbidden},
+		{"user without role on admin route", "", "/api/admin", http.StatusForbidden},
+		{"staff on staff route", types.RoleStaff, "/api/staff", http.StatusOK},
+		{"admin on staff route", types.RoleAdmin, "/api/staff", http.StatusOK},
+		{"guest on staff route", types.RoleGuest, "/api/staff", http.StatusForbidden},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupAuthorizationTest(&types.User{
+				ID:   primitive.NewObjectID(),
+				Role: tc.role,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+}
+
+// TestRequireRoles_NoUser tests that unauthenticated requests are rejected
+func TestRequireRoles_NoUser(t *testing.T) {
+	app := setupAuthorizationTest(nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for request without user, got %v", resp.StatusCode)
+	}
+}
+
+// TestRequireVerifiedEmail tests that the policy only lets verified users through when it is enabled
+func TestRequireVerifiedEmail(t *testing.T) {
+	testCases := []struct {
+		name     string
+		verified bool
+		path     string
+		expected int
+	}{
+		{"verified user with policy", true, "/api/verified", http.StatusOK},
+		{"unverified user with policy", false, "/api/verified", http.StatusForbidden},
+		{"verified user without policy", true, "/api/unrestricted", http.StatusOK},
+		{"unverified user without policy", false, "/api/unrestricted", http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := setupAuthorizationTest(&types.User{
+				ID:            primitive.NewObjectID(),
+				Role:          types.RoleGuest,
+				EmailVerified: tc.verified,
+			})
+
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("Error making request: %v", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tc.expected {
+				t.Errorf("Expected status %v, got %v", tc.expected, resp.StatusCode)
+			}
+		})
+	}
+
+	// Without a user the policy cannot be checked
+	resp, err := setupAuthorizationTest(nil).Test(httptest.NewRequest(http.MethodGet, "/api/verified", nil))
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status Unauthorized for request without user, got %v", resp.StatusCode)
+	}
+}
@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/api"
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/db"
+	"github.com/0x0Glitch/hotel-reservation/middleware"
+	"github.com/0x0Glitch/hotel-reservation/types"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// idempotencyTestEnv holds a server whose routes count how often they ran
+// Requests are sent as whichever user was set last
+type idempotencyTestEnv struct {
+	app   *fiber.App
+	store db.IdempotencyStore
+	user  *types.User
+	calls int
+}
+
+// setupIdempotencyTest creates a server with idempotent routes that create
+// something, fail with a client error and fail with a server error
+func setupIdempotencyTest() *idempotencyTestEnv {
+	env := &idempotencyTestEnv{
+		store: db.NewMemoryIdempotencyStore(db.NewMemoryDatabase()),
+		user:  &types.User{ID: primitive.NewObjectID()},
+	}
+	env.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	env.app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", env.user)
+		return c.Next()
+	})
+	idempotent := middleware.Idempotency(env.store, config.Default().Idempotency)
+	env.app.Post("/api/create", idempotent, func(c *fiber.Ctx) error {
+		env.calls++
+		return c.Status(http.StatusCreated).JSON(fiber.Map{"id": primitive.NewObjectID()})
+	})
+	env.app.Post("/api/conflict", idempotent, func(c *fiber.Ctx) error {
+		env.calls++
+		return api.ErrConflict("room_already_booked", "room is already booked")
+	})
+	env.app.Post("/api/broken", idempotent, func(c *fiber.Ctx) error {
+		env.calls++
+		return c.Status(http.StatusInternalServerError).SendString("try again")
+	})
+	return env
+}
+
+// send posts the body with the Idempotency-Key, if set, and returns the status and response body
+func (env *idempotencyTestEnv) send(t *testing.T, path, key, body string) (*http.Response, string) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(middleware.IdempotencyKeyHeader, key)
+	}
+	resp, err := env.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Error making request: %v", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Error reading response: %v", err)
+	}
+	return resp, string(data)
+}
+
+// TestIdempotencyReplay tests that a retry gets the original response without running the handler again
+func TestIdempotencyReplay(t *testing.T) {
+	env := setupIdempotencyTest()
+
+	first, firstBody := env.send(t, "/api/create", "key-1", `{"name":"a"}`)
+	if first.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status Created, got %v", first.StatusCode)
+	}
+	retry, retryBody := env.send(t, "/api/create", "key-1", `{"name":"a"}`)
+	if retry.StatusCode != http.StatusCreated || retryBody != firstBody {
+		t.Errorf("Expected the original response %q, got %v %q", firstBody, retry.StatusCode, retryBody)
+	}
+	if retry.Header.Get(middleware.IdempotentReplayedHeader) != "true" || first.Header.Get(middleware.IdempotentReplayedHeader) != "" {
+		t.Errorf("Expected only the retry to be marked as replayed")
+	}
+	if retry.Header.Get("Content-Type") != first.Header.Get("Content-Type") {
+		t.Errorf("Expected content type %q, got %q", first.Header.Get("Content-Type"), retry.Header.Get("Content-Type"))
+	}
+	if env.calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", env.calls)
+	}
+
+	// The same key from another user, or no key at all, is a new request
+	env.user = &types.User{ID: primitive.NewObjectID()}
+	env.send(t, "/api/create", "key-1", `{"name":"a"}`)
+	env.send(t, "/api/create", "", `{"name":"a"}`)
+	env.send(t, "/api/create", "", `{"name":"a"}`)
+	if env.calls != 4 {
+		t.Errorf("Expected the handler to run 4 times, ran %d times", env.calls)
+	}
+}
+
+// TestIdempotencyKeyReused tests that a key cannot be used for a different request
+func TestIdempotencyKeyReused(t *testing.T) {
+	env := setupIdempotencyTest()
+
+	env.send(t, "/api/create", "key-1", `{"name":"a"}`)
+	testCases := []struct {
+		name string
+		path string
+		body string
+	}{
+		{"different body", "/api/create", `{"name":"b"}`},
+		{"different path", "/api/conflict", `{"name":"a"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, body := env.send(t, tc.path, "key-1", tc.body)
+			if resp.StatusCode != http.StatusUnprocessableEntity || !strings.Contains(body, "idempotency_key_reused") {
+				t.Errorf("Expected 422 idempotency_key_reused, got %v %s", resp.StatusCode, body)
+			}
+		})
+	}
+	if env.calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", env.calls)
+	}
+}
+
+// TestIdempotencyErrors tests which failed responses are replayed
+func TestIdempotencyErrors(t *testing.T) {
+	env := setupIdempotencyTest()
+
+	// Client errors are the answer to the request and are replayed
+	first, firstBody := env.send(t, "/api/conflict", "key-1", `{}`)
+	retry, retryBody := env.send(t, "/api/conflict", "key-1", `{}`)
+	if first.StatusCode != http.StatusConflict || retry.StatusCode != http.StatusConflict || retryBody != firstBody {
+		t.Errorf("Expected the conflict to be replayed, got %v %q and %v %q", first.StatusCode, firstBody, retry.StatusCode, retryBody)
+	}
+	if env.calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", env.calls)
+	}
+
+	// Server errors are not kept, so the request can be retried
+	env.send(t, "/api/broken", "key-2", `{}`)
+	resp, _ := env.send(t, "/api/broken", "key-2", `{}`)
+	if resp.Header.Get(middleware.IdempotentReplayedHeader) != "" || env.calls != 3 {
+		t.Errorf("Expected the failed request to run again, ran %d times", env.calls)
+	}
+}
+
+// TestIdempotencyInProgress tests that a retry is rejected while the first request is still running
+func TestIdempotencyInProgress(t *testing.T) {
+	env := setupIdempotencyTest()
+
+	body := `{"name":"a"}`
+	record := types.NewIdempotencyRecord(env.user.ID, "key-1", types.HashRequest(http.MethodPost, "/api/create", []byte(body)), config.DefaultIdempotencyRetention, config.DefaultIdempotencyLease)
+	if _, err := env.store.ClaimIdempotencyKey(context.TODO(), record); err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+
+	resp, data := env.send(t, "/api/create", "key-1", body)
+	if resp.StatusCode != http.StatusConflict || !strings.Contains(data, "idempotency_key_in_use") {
+		t.Errorf("Expected 409 idempotency_key_in_use, got %v %s", resp.StatusCode, data)
+	}
+	if env.calls != 0 {
+		t.Errorf("Expected the handler not to run, ran %d times", env.calls)
+	}
+}
+
+// TestIdempotencyLeaseExpired tests that a retry takes over a key whose request never finished
+func TestIdempotencyLeaseExpired(t *testing.T) {
+	env := setupIdempotencyTest()
+
+	body := `{"name":"a"}`
+	record := types.NewIdempotencyRecord(env.user.ID, "key-1", types.HashRequest(http.MethodPost, "/api/create", []byte(body)), config.DefaultIdempotencyRetention, -time.Second)
+	if _, err := env.store.ClaimIdempotencyKey(context.TODO(), record); err != nil {
+		t.Fatalf("Error claiming key: %v", err)
+	}
+
+	first, firstBody := env.send(t, "/api/create", "key-1", body)
+	if first.StatusCode != http.StatusCreated || env.calls != 1 {
+		t.Fatalf("Expected the retry to be processed, got %v %s", first.StatusCode, firstBody)
+	}
+	retry, retryBody := env.send(t, "/api/create", "key-1", body)
+	if retry.Header.Get(middleware.IdempotentReplayedHeader) != "true" || retryBody != firstBody || env.calls != 1 {
+		t.Errorf("Expected the response of the retry to be replayed, got %v %s", retry.StatusCode, retryBody)
+	}
+}
+
+// TestIdempotencyLeaseRenewed tests that a request running longer than its lease keeps its key
+func TestIdempotencyLeaseRenewed(t *testing.T) {
+	store := db.NewMemoryIdempotencyStore(db.NewMemoryDatabase())
+	user := &types.User{ID: primitive.NewObjectID()}
+	cfg := config.IdempotencyConfig{Retention: time.Hour, Lease: 30 * time.Millisecond}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	calls := 0
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Context().SetUserValue("user", user)
+		return c.Next()
+	})
+	app.Post("/api/slow", middleware.Idempotency(store, cfg), func(c *fiber.Ctx) error {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+		}
+		return c.Status(http.StatusCreated).SendString("created")
+	})
+	send := func() *http.Response {
+		req := httptest.NewRequest(http.MethodPost, "/api/slow", strings.NewReader(`{}`))
+		req.Header.Set(middleware.IdempotencyKeyHeader, "key-1")
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Errorf("Error making request: %v", err)
+		}
+		return resp
+	}
+
+	first := make(chan *http.Response)
+	go func() { first <- send() }()
+	<-started
+
+	// Several leases later the first request still holds the key
+	time.Sleep(4 * cfg.Lease)
+	resp := send()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("Expected 409 while the first request runs, got %v", resp.StatusCode)
+	}
+	close(release)
+	if resp := <-first; resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected the first request to succeed, got %v", resp.StatusCode)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the handler to run once, ran %d times", calls)
+	}
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/notify"
+)
+
+// TestFileMailer checks that emails are written to files in the mail directory
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := notify.NewFileMailer(dir, "Hotel <no-reply@example.com>")
+	if err != nil {
+		t.Fatalf("Error creating mailer: %v", err)
+	}
+
+	for _, to := range []string{"first@example.com", "second@example.com"} {
+		msg := notify.Message{To: to, Subject: "Reset your password", Body: "Open http://localhost/reset?token=abc\n"}
+		if err := mailer.Send(context.TODO(), msg); err != nil {
+			t.Fatalf("Error sending email: %v", err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil {
+		t.Fatalf("Error listing emails: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 email files, got %d", len(files))
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Error reading email: %v", err)
+	}
+	for _, want := range []string{
+		"From: Hotel <no-reply@example.com>\r\n",
+		"To: first@example.com\r\n",
+		"Subject: Reset your password\r\n",
+		"http://localhost/reset?token=abc",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected the email to contain %q, got:\n%s", want, data)
+		}
+	}
+	info, err := os.Stat(files[0])
+	if err != nil {
+		t.Fatalf("Error checking email file: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected the email to be readable by the owner only, got %v", perm)
+	}
+}
+
+// TestMailerRejectsInvalidMessages checks that messages without a recipient or with
+// line breaks in their headers are not sent
+func TestMailerRejectsInvalidMessages(t *testing.T) {
+	testCases := []struct {
+		name string
+		msg  notify.Message
+	}{
+		{"no recipient", notify.Message{Subject: "Hello"}},
+		{"line break in recipient", notify.Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "Hello"}},
+		{"line break in subject", notify.Message{To: "a@example.com", Subject: "Hello\nBcc: b@example.com"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mailer := notify.NewMemoryMailer()
+			if err := mailer.Send(context.TODO(), tc.msg); err == nil {
+				t.Errorf("Expected an error")
+			}
+			if _, ok := mailer.Last(tc.msg.To); ok {
+				t.Errorf("Expected the message not to be recorded")
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/0x0Glitch/hotel-reservation/config"
+	"github.com/0x0Glitch/hotel-reservation/payments"
+	"github.com/0x0Glitch/hotel-reservation/types"
+)
+
+// authorize authorizes 100 EUR with the payment method
+func authorize(t *testing.T, provider *payments.MockProvider, paymentMethod string) *payments.Result {
+	result, err := provider.Authorize(context.TODO(), payments.AuthorizeRequest{
+		Reference:     "booking-1",
+		Amount:        100,
+		Currency:      "EUR",
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil {
+		t.Fatalf("Error authorizing payment: %v", err)
+	}
+	return result
+}
+
+// TestMockAuthorize checks that the outcome of an authorization depends on the payment method
+func TestMockAuthorize(t *testing.T) {
+	tests := []struct {
+		paymentMethod string
+		status        types.PaymentStatus
+		failureReason string
+	}{
+		{payments.MockCardApproved, types.PaymentStatusAuthorized, ""},
+		{payments.MockCardPending, types.PaymentStatusPending, ""},
+		{payments.MockCardDeclined, types.PaymentStatusFailed, "card_declined"},
+		{"pm_unknown", types.PaymentStatusFailed, "invalid_payment_method"},
+	}
+
+	provider := payments.NewMockProvider("secret")
+	refs := map[string]bool{}
+	for _, tc := range tests {
+		t.Run(tc.paymentMethod, func(t *testing.T) {
+			result := authorize(t, provider, tc.paymentMethod)
+			if result.Status != tc.status || result.FailureReason != tc.failureReason {
+				t.Errorf("Expected %s (%q), got %s (%q)", tc.status, tc.failureReason, result.Status, result.FailureReason)
+			}
+			if result.PaymentRef == "" || refs[result.PaymentRef] {
+				t.Errorf("Expected a new payment reference, got %q", result.PaymentRef)
+			}
+			refs[result.PaymentRef] = true
+		})
+	}
+}
+
+// TestMockCaptureAndRefund checks that only captured amounts can be refunded
+func TestMockCaptureAndRefund(t *testing.T) {
+	provider := payments.NewMockProvider("secret")
+	ctx := context.TODO()
+
+	ref := authorize(t, provider, payments.MockCardApproved).PaymentRef
+	if _, err := provider.Capture(ctx, ref, 150); err == nil {
+		t.Error("Expected capturing more than was authorized to fail")
+	}
+	if result, err := provider.Capture(ctx, ref, 40); err != nil || result.Status != types.PaymentStatusCaptured {
+		t.Fatalf("Expected the payment to be captured, got %+v, %v", result, err)
+	}
+	if _, err := provider.Refund(ctx, ref, 50); err == nil {
+		t.Error("Expected refunding more than was captured to fail")
+	}
+	if result, err := provider.Refund(ctx, ref, 40); err != nil || result.Status != types.PaymentStatusRefunded {
+		t.Errorf("Expected the payment to be refunded, got %+v, %v", result, err)
+	}
+
+	// Refunding an authorization releases it
+	ref = authorize(t, provider, payments.MockCardApproved).PaymentRef
+	if result, err := provider.Refund(ctx, ref, 100); err != nil || result.Status != types.PaymentStatusRefunded {
+		t.Errorf("Expected the authorization to be released, got %+v, %v", result, err)
+	}
+
+	if _, err := provider.Capture(ctx, "mock_pay_unknown", 10); !errors.Is(err, payments.ErrUnknownPayment) {
+		t.Errorf("Expected ErrUnknownPayment, got %v", err)
+	}
+}
+
+// TestMockWebhook checks that webhooks are signed and only accepted with a valid signature
+func TestMockWebhook(t *testing.T) {
+	provider := payments.NewMockProvider("secret")
+	ref := authorize(t, provider, payments.MockCardPending).PaymentRef
+
+	payload, signature, err := provider.CompletePayment(ref, false)
+	if err != nil {
+		t.Fatalf("Error completing payment: %v", err)
+	}
+	event, err := provider.ParseWebhook(payload, signature)
+	if err != nil {
+		t.Fatalf("Error parsing webhook: %v", err)
+	}
+	if event.Type != payments.EventPaymentFailed || event.PaymentRef != ref || event.FailureReason == "" {
+		t.Errorf("Expected a failed payment event for %s, got %+v", ref, event)
+	}
+
+	// A payment is decided only once
+	if _, _, err := provider.CompletePayment(ref, true); err == nil {
+		t.Error("Expected completing a decided payment to fail")
+	}
+
+	forged := payments.NewMockProvider("other").Sign(payload)
+	if _, err := provider.ParseWebhook(payload, forged); !errors.Is(err, payments.ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature, got %v", err)
+	}
+
+	// Without a secret anybody could sign a webhook, even with the signature an empty key gives
+	unsigned := payments.NewMockProvider("")
+	if _, err := unsigned.ParseWebhook(payload, unsigned.Sign(payload)); !errors.Is(err, payments.ErrNoWebhookSecret) {
+		t.Errorf("Expected ErrNoWebhookSecret, got %v", err)
+	}
+}
+
+// TestNewProvider checks that the configured provider is created
+func TestNewProvider(t *testing.T) {
+	provider, err := payments.NewProvider(config.PaymentsConfig{Provider: config.PaymentProviderMock, WebhookSecret: "secret"})
+	if err != nil {
+		t.Fatalf("Error creating provider: %v", err)
+	}
+	if provider.Name() != config.PaymentProviderMock {
+		t.Errorf("Expected the mock provider, got %s", provider.Name())
+	}
+	if _, err := payments.NewProvider(config.PaymentsConfig{Provider: "stripe"}); err == nil {
+		t.Error("Expected an unknown provider to be rejected")
+	}
+	if _, err := payments.NewProvider(config.PaymentsConfig{Provider: config.PaymentProviderMock}); !errors.Is(err, payments.ErrNoWebhookSecret) {
+		t.Errorf("Expected ErrNoWebhookSecret, got %v", err)
+	}
+}
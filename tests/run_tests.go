@@ -1,4 +1,4 @@
-package main
+package tests
 
 // This file provides documentation for running tests in the Hotel Reservation Project
 // It does not contain executable code.
@@ -19,6 +19,11 @@ go test ./tests/types -v -run TestCreateUserParamsValidate
 To run tests in short mode (skipping integration tests):
 go test ./tests/... -short
 
+The API and middleware tests run against the in-memory stores (db.NewMemoryDatabase)
+and need no database. The tests in tests/db run against MongoDB at localhost:27017,
+except for TestMemoryStoreContract; the same contract tests run against MongoDB in
+TestMongoStoreContract, so both store implementations are held to the same behavior.
+
 For test coverage:
 go test ./tests/... -cover
 
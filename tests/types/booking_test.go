@@ -0,0 +1,85 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+)
+
+// TestBookingNights checks which nights a stay reserves
+func TestBookingNights(t *testing.T) {
+	day := func(d, h int) time.Time {
+		return time.Date(2030, time.March, d, h, 0, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		name     string
+		from     time.Time
+		till     time.Time
+		expected []time.Time
+	}{
+		{
+			name:     "midnight to midnight",
+			from:     day(10, 0),
+			till:     day(12, 0),
+			expected: []time.Time{day(10, 0), day(11, 0)},
+		},
+		{
+			name:     "check-in afternoon, check-out morning",
+			from:     day(10, 15),
+			till:     day(13, 11),
+			expected: []time.Time{day(10, 0), day(11, 0), day(12, 0)},
+		},
+		{
+			name:     "same day",
+			from:     day(10, 9),
+			till:     day(10, 18),
+			expected: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			nights := types.BookingNights(tc.from, tc.till)
+			if n := types.NumNights(tc.from, tc.till); n != len(tc.expected) {
+				t.Errorf("expected NumNights to be %d, got %d", len(tc.expected), n)
+			}
+
+			if len(nights) != len(tc.expected) {
+				t.Fatalf("expected %d nights, got %d", len(tc.expected), len(nights))
+			}
+			for i, night := range tc.expected {
+				if !nights[i].Equal(night) {
+					t.Errorf("expected night %d to be %v, got %v", i, night, nights[i])
+				}
+			}
+		})
+	}
+}
+
+// TestNewPriceBreakdown checks the price of a stay
+func TestNewPriceBreakdown(t *testing.T) {
+	from := time.Date(2030, time.March, 10, 15, 0, 0, 0, time.UTC)
+	till := time.Date(2030, time.March, 13, 11, 0, 0, 0, time.UTC)
+
+	price := types.NewPriceBreakdown(99.99, from, till)
+
+	if len(price.Nights) != 3 {
+		t.Fatalf("expected 3 nights, got %d", len(price.Nights))
+	}
+	for _, night := range price.Nights {
+		if night.Price != 99.99 {
+			t.Errorf("expected nightly price 99.99, got %v", night.Price)
+		}
+	}
+	if price.Subtotal != 299.97 {
+		t.Errorf("expected subtotal 299.97, got %v", price.Subtotal)
+	}
+	if price.Taxes != 30 {
+		t.Errorf("expected taxes 30, got %v", price.Taxes)
+	}
+	if price.Total != 329.97 {
+		t.Errorf("expected total 329.97, got %v", price.Total)
+	}
+}
@@ -0,0 +1,106 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/0x0Glitch/hotel-reservation/types"
+)
+
+// TestCancellationPenalty checks what cancelling a stay costs under each kind of policy
+func TestCancellationPenalty(t *testing.T) {
+	from := time.Date(2030, time.March, 10, 15, 0, 0, 0, time.UTC)
+	// Three nights at 100, 330 with taxes
+	price := types.NewPriceBreakdown(100, from, from.AddDate(0, 0, 3))
+	checkIn := time.Date(2030, time.March, 10, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name     string
+		policy   types.CancellationPolicy
+		now      time.Time
+		expected float64
+	}{
+		{"always free", types.DefaultCancellationPolicy, checkIn.Add(-time.Hour), 0},
+		{"free until 48h, in time", types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFull}, checkIn.Add(-49 * time.Hour), 0},
+		{"free until 48h, at the deadline", types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFull}, checkIn.Add(-48 * time.Hour), 330},
+		{"free until 48h, then first night", types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFirstNight}, checkIn.Add(-time.Hour), 110},
+		{"non-refundable", types.CancellationPolicy{Penalty: types.CancellationPenaltyFull}, checkIn.AddDate(0, -1, 0), 330},
+		{"first-night penalty", types.CancellationPolicy{Penalty: types.CancellationPenaltyFirstNight}, checkIn.AddDate(0, -1, 0), 110},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if penalty := tc.policy.PenaltyFor(price, from, tc.now); penalty != tc.expected {
+				t.Errorf("expected a penalty of %.2f, got %.2f", tc.expected, penalty)
+			}
+		})
+	}
+}
+
+// TestCancellationPolicyDescription checks the text shown to guests for each kind of policy
+func TestCancellationPolicyDescription(t *testing.T) {
+	testCases := []struct {
+		policy   types.CancellationPolicy
+		expected string
+	}{
+		{types.DefaultCancellationPolicy, "Free cancellation at any time."},
+		{types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFull}, "Free cancellation until 48 hours before check-in. Later cancellations are charged the full price of the stay."},
+		{types.CancellationPolicy{Penalty: types.CancellationPenaltyFull}, "Non-refundable: cancellations are charged the full price of the stay."},
+		{types.CancellationPolicy{Penalty: types.CancellationPenaltyFirstNight}, "Cancellations are charged the first night."},
+	}
+
+	for _, tc := range testCases {
+		if text := tc.policy.Description(); text != tc.expected {
+			t.Errorf("expected %q, got %q", tc.expected, text)
+		}
+	}
+}
+
+// TestCancellationPolicyValidate checks that unknown penalties and negative periods are rejected
+func TestCancellationPolicyValidate(t *testing.T) {
+	if errs := (types.CancellationPolicy{FreeCancellationHours: 48, Penalty: types.CancellationPenaltyFull}).Validate(); len(errs) != 0 {
+		t.Errorf("expected a valid policy, got %v", errs)
+	}
+	errs := types.CancellationPolicy{FreeCancellationHours: -1, Penalty: "half"}.Validate()
+	if _, ok := errs["cancellationPolicy.penalty"]; !ok {
+		t.Errorf("expected an error for the penalty, got %v", errs)
+	}
+	if _, ok := errs["cancellationPolicy.freeCancellationHours"]; !ok {
+		t.Errorf("expected an error for freeCancellationHours, got %v", errs)
+	}
+}
+
+// TestNewCancellation checks that only bookings that have been paid for are charged a penalty
+func TestNewCancellation(t *testing.T) {
+	from := time.Now().AddDate(0, 0, 10)
+	booking := &types.Booking{
+		FromDate:           from,
+		TillDate:           from.AddDate(0, 0, 2),
+		Status:             types.BookingStatusConfirmed,
+		Price:              types.NewPriceBreakdown(100, from, from.AddDate(0, 0, 2)),
+		CancellationPolicy: &types.CancellationPolicy{Penalty: types.CancellationPenaltyFirstNight},
+	}
+
+	cancellation := types.NewCancellation(booking, time.Now())
+	if cancellation.Penalty != 110 || cancellation.Refund != 0 || !cancellation.SettlementPending {
+		t.Errorf("expected a penalty of 110 to settle, got %+v", cancellation)
+	}
+
+	booking.Status = types.BookingStatusHeld
+	if cancellation := types.NewCancellation(booking, time.Now()); cancellation.Penalty != 0 || cancellation.Refund != 0 || cancellation.SettlementPending {
+		t.Errorf("expected cancelling a hold to cost nothing, got %+v", cancellation)
+	}
+
+	// Bookings made before bookings had a status were never paid for
+	booking.Status = ""
+	if cancellation := types.NewCancellation(booking, time.Now()); cancellation.Penalty != 0 || cancellation.Refund != 0 || cancellation.SettlementPending {
+		t.Errorf("expected nothing to refund, got %+v", cancellation)
+	}
+
+	// Bookings made before policies existed can always be cancelled for free
+	booking.Status = types.BookingStatusConfirmed
+	booking.CancellationPolicy = nil
+	if cancellation := types.NewCancellation(booking, time.Now()); cancellation.Penalty != 0 || !cancellation.SettlementPending {
+		t.Errorf("expected a free cancellation to settle, got %+v", cancellation)
+	}
+}
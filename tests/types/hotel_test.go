@@ -116,4 +116,26 @@ func TestRoomTypes(t *testing.T) {
 	if types.DeluxRoomType != 4 {
 		t.Errorf("expected DeluxRoomType to be 4, got %d", types.DeluxRoomType)
 	}
-} 
\ No newline at end of file
+} 
+// TestRoomCapacity checks how many guests a room sleeps
+func TestRoomCapacity(t *testing.T) {
+	testCases := []struct {
+		name     string
+		room     types.Room
+		expected int
+	}{
+		{name: "single room", room: types.Room{Type: types.SingleRoomType}, expected: 1},
+		{name: "double room", room: types.Room{Type: types.DoubleRoomType}, expected: 2},
+		{name: "deluxe room", room: types.Room{Type: types.DeluxRoomType}, expected: 4},
+		{name: "no type", room: types.Room{}, expected: types.DefaultRoomCapacity},
+		{name: "explicit occupancy wins", room: types.Room{Type: types.SingleRoomType, MaxOccupancy: 3}, expected: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.room.Capacity(); got != tc.expected {
+				t.Errorf("expected capacity %d, got %d", tc.expected, got)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"testing"
 
 	"github.com/0x0Glitch/hotel-reservation/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // TestNewUserFromParams checks if user creation from params works correctly
@@ -183,4 +184,55 @@ func TestIsValidPassword(t *testing.T) {
 	if types.IsValidPassword(user.EncryptedPassword, "wrongpassword") {
 		t.Errorf("expected password validation to fail for incorrect password")
 	}
-} 
\ No newline at end of file
+} 
+// TestUserRoles checks role lookups, including users stored before roles existed
+func TestUserRoles(t *testing.T) {
+	testCases := []struct {
+		role    types.Role
+		isAdmin bool
+		isGuest bool
+	}{
+		{types.RoleAdmin, true, false},
+		{types.RoleStaff, false, false},
+		{types.RoleGuest, false, true},
+		{"", false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.role), func(t *testing.T) {
+			user := types.User{Role: tc.role}
+
+			if user.IsAdmin() != tc.isAdmin {
+				t.Errorf("IsAdmin() = %v, expected %v", user.IsAdmin(), tc.isAdmin)
+			}
+			if user.HasRole(types.RoleGuest) != tc.isGuest {
+				t.Errorf("HasRole(guest) = %v, expected %v", user.HasRole(types.RoleGuest), tc.isGuest)
+			}
+		})
+	}
+}
+
+// TestCanManageHotel checks that staff are scoped to their hotels
+func TestCanManageHotel(t *testing.T) {
+	hotelID := primitive.NewObjectID()
+	otherHotelID := primitive.NewObjectID()
+
+	staff := types.User{Role: types.RoleStaff, HotelIDs: []primitive.ObjectID{hotelID}}
+	if !staff.CanManageHotel(hotelID) {
+		t.Errorf("expected staff to manage their own hotel")
+	}
+	if staff.CanManageHotel(otherHotelID) {
+		t.Errorf("expected staff not to manage another hotel")
+	}
+
+	admin := types.User{Role: types.RoleAdmin}
+	if !admin.CanManageHotel(otherHotelID) {
+		t.Errorf("expected admin to manage every hotel")
+	}
+
+	// A guest listing hotel IDs gains nothing from them
+	guest := types.User{Role: types.RoleGuest, HotelIDs: []primitive.ObjectID{hotelID}}
+	if guest.CanManageHotel(hotelID) {
+		t.Errorf("expected guest not to manage any hotel")
+	}
+}
@@ -1,16 +1,177 @@
 package types
 
 import (
+	"math"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// BookingStatus describes where a booking is in its lifecycle
+type BookingStatus string
+
+const (
+	BookingStatusPending    BookingStatus = "pending"     // Created, waiting for the payment to be authorized
+	BookingStatusHeld       BookingStatus = "held"        // Reserved for a short time during checkout, see Booking.HoldExpiresAt
+	BookingStatusExpired    BookingStatus = "expired"     // A hold that was not confirmed in time, the nights are free again
+	BookingStatusConfirmed  BookingStatus = "confirmed"   // The room is reserved for the guest
+	BookingStatusCancelled  BookingStatus = "cancelled"   // Cancelled, the nights are free again
+	BookingStatusFailed     BookingStatus = "failed"      // The payment was declined, the nights are free again
+	BookingStatusCheckedIn  BookingStatus = "checked-in"  // The guest has arrived
+	BookingStatusCheckedOut BookingStatus = "checked-out" // The stay is over
+)
+
 type Booking struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID    primitive.ObjectID `bson:"userID,omitempty" json:"userID,omitempty"`
-	RoomID    primitive.ObjectID `bson:"roomID,omitempty" json:"roomID,omitempty"`
-	NumPerson int                `bson:"numPersons,omitempty" json:"numPersons,omitempty`
-	FromDate  time.Time          `bson:"fromDate,omitempty" json:"fromDate,omitempty"`
-	TillDate  time.Time          `bson:"tillDate,omitempty" json:"tillDate,omitempty"`
+	ID                 primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID             primitive.ObjectID  `bson:"userID,omitempty" json:"userID,omitempty"`
+	RoomID             primitive.ObjectID  `bson:"roomID,omitempty" json:"roomID,omitempty"`
+	NumPerson          int                 `bson:"numPersons,omitempty" json:"numPersons,omitempty"`
+	FromDate           time.Time           `bson:"fromDate,omitempty" json:"fromDate,omitempty"`
+	TillDate           time.Time           `bson:"tillDate,omitempty" json:"tillDate,omitempty"`
+	Status             BookingStatus       `bson:"status,omitempty" json:"status,omitempty"`
+	Price              *PriceBreakdown     `bson:"price,omitempty" json:"price,omitempty"`                           // What the stay costs, fixed at the time of booking
+	HoldExpiresAt      *time.Time          `bson:"holdExpiresAt,omitempty" json:"holdExpiresAt,omitempty"`           // When a held booking stops reserving the room unless confirmed
+	History            []BookingChange     `bson:"history,omitempty" json:"history,omitempty"`                       // Every modification, oldest first
+	CancellationPolicy *CancellationPolicy `bson:"cancellationPolicy,omitempty" json:"cancellationPolicy,omitempty"` // Policy of the hotel at the time of booking
+	Cancellation       *Cancellation       `bson:"cancellation,omitempty" json:"cancellation,omitempty"`             // What cancelling the booking cost, set once it is cancelled
+}
+
+// IsSettlementPending reports whether the booking was cancelled but its payments
+// have not been settled with the gateway yet
+func (b *Booking) IsSettlementPending() bool{
+	return b.Status == BookingStatusCancelled && b.Cancellation != nil && b.Cancellation.SettlementPending
+}
+
+// ApplicableCancellationPolicy returns the cancellation policy the booking was made under
+// Bookings made before hotels had policies fall back to DefaultCancellationPolicy
+func (b *Booking) ApplicableCancellationPolicy() CancellationPolicy{
+	if b.CancellationPolicy == nil{
+		return DefaultCancellationPolicy
+	}
+	return *b.CancellationPolicy
+}
+
+// BookingChange records a modification of a booking
+// It keeps the terms the booking had before the change, so together with the
+// booking itself the whole history of the stay can be told
+type BookingChange struct{
+	ChangedAt time.Time          `bson:"changedAt" json:"changedAt"`             // When the booking was modified
+	ChangedBy primitive.ObjectID `bson:"changedBy" json:"changedBy"`             // The user who modified it
+	RoomID    primitive.ObjectID `bson:"roomID" json:"roomID"`                   // Room before the change
+	FromDate  time.Time          `bson:"fromDate" json:"fromDate"`               // Check-in before the change
+	TillDate  time.Time          `bson:"tillDate" json:"tillDate"`               // Check-out before the change
+	NumPerson int                `bson:"numPersons" json:"numPersons"`           // Guests before the change
+	Price     *PriceBreakdown    `bson:"price,omitempty" json:"price,omitempty"` // Price before the change
+}
+
+// NewBookingChange records the current terms of the booking as changed by the user
+func NewBookingChange(booking *Booking, changedBy primitive.ObjectID) BookingChange{
+	return BookingChange{
+		ChangedAt: time.Now().UTC(),
+		ChangedBy: changedBy,
+		RoomID:    booking.RoomID,
+		FromDate:  booking.FromDate,
+		TillDate:  booking.TillDate,
+		NumPerson: booking.NumPerson,
+		Price:     booking.Price,
+	}
+}
+
+// TaxRate is the share of the subtotal added as taxes to every stay
+const TaxRate = 0.10
+
+// NightPrice is the price charged for a single night of a stay
+type NightPrice struct{
+	Night time.Time `bson:"night" json:"night"` // The night, as midnight UTC of the check-in day
+	Price float64   `bson:"price" json:"price"` // Price of the room for that night
+}
+
+// PriceBreakdown is what a stay costs, night by night
+type PriceBreakdown struct{
+	Nights   []NightPrice `bson:"nights" json:"nights"`     // One entry per booked night
+	Subtotal float64      `bson:"subtotal" json:"subtotal"` // Sum of the nightly prices
+	Taxes    float64      `bson:"taxes" json:"taxes"`       // Taxes on the subtotal
+	Total    float64      `bson:"total" json:"total"`       // Subtotal plus taxes, the amount the guest pays
+}
+
+// NewPriceBreakdown computes the price of staying from fromDate until tillDate
+// in a room charging nightlyRate per night. Amounts are rounded to cents
+func NewPriceBreakdown(nightlyRate float64, fromDate, tillDate time.Time) *PriceBreakdown{
+	price := &PriceBreakdown{Nights: []NightPrice{}}
+	for _, night := range BookingNights(fromDate, tillDate){
+		price.Nights = append(price.Nights, NightPrice{Night: night, Price: roundCents(nightlyRate)})
+		price.Subtotal += roundCents(nightlyRate)
+	}
+	price.Subtotal = roundCents(price.Subtotal)
+	price.Taxes = roundCents(price.Subtotal * TaxRate)
+	price.Total = roundCents(price.Subtotal + price.Taxes)
+	return price
+}
+
+// roundCents rounds an amount of money to two decimal places
+func roundCents(amount float64) float64{
+	return math.Round(amount*100) / 100
+}
+
+// IsCancellable reports whether the booking can still be cancelled
+// Only bookings the guest has not started yet can be cancelled; bookings stored
+// before statuses existed have no status and count as confirmed
+func (b *Booking) IsCancellable() bool{
+	switch b.Status{
+	case "", BookingStatusPending, BookingStatusHeld, BookingStatusConfirmed:
+		return true
+	}
+	return false
+}
+
+// ReleasedBookingStatuses returns the statuses of bookings that no longer reserve their room
+func ReleasedBookingStatuses() []BookingStatus{
+	return []BookingStatus{BookingStatusCancelled, BookingStatusExpired, BookingStatusFailed}
+}
+
+// IsHoldExpired reports whether the booking is a hold that has run out at the given time
+// Expired holds no longer reserve the room, even before they are released
+func (b *Booking) IsHoldExpired(now time.Time) bool{
+	return b.Status == BookingStatusHeld && b.HoldExpiresAt != nil && !now.Before(*b.HoldExpiresAt)
+}
+
+// MaxBookingNights is the longest stay that can be booked at once
+const MaxBookingNights = 30
+
+// StayDays returns the check-in and check-out days of a stay from fromDate until
+// tillDate as UTC midnights, the boundaries of the nights returned by BookingNights
+func StayDays(fromDate, tillDate time.Time) (time.Time, time.Time){
+	return utcDay(fromDate), utcDay(tillDate)
+}
+
+// NumNights returns how many nights a stay from fromDate until tillDate covers
+// It is len(BookingNights(fromDate, tillDate)) without listing the nights
+func NumNights(fromDate, tillDate time.Time) int{
+	checkIn, checkOut := StayDays(fromDate, tillDate)
+	if !checkIn.Before(checkOut){
+		return 0
+	}
+	return int(checkOut.Sub(checkIn).Hours()/24)
+}
+
+// BookingNights returns the nights (as UTC midnights) covered by a stay from
+// fromDate until tillDate: every day from the check-in day up to, but not
+// including, the check-out day. A room is reserved per night, so this is the
+// unit used when checking two bookings of the same room against each other
+func BookingNights(fromDate, tillDate time.Time) []time.Time{
+	night := utcDay(fromDate)
+	checkout := utcDay(tillDate)
+
+	var nights []time.Time
+	for night.Before(checkout){
+		nights = append(nights, night)
+		night = night.AddDate(0, 0, 1)
+	}
+	return nights
+}
+
+// utcDay returns midnight UTC of the day t falls on
+func utcDay(t time.Time) time.Time{
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
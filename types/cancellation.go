@@ -0,0 +1,109 @@
+package types
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// CancellationPenalty says what a guest is charged for cancelling after the free cancellation period
+type CancellationPenalty string
+
+const (
+	CancellationPenaltyNone       CancellationPenalty = "none"        // Nothing, cancelling is always free
+	CancellationPenaltyFirstNight CancellationPenalty = "first-night" // The first night and its taxes
+	CancellationPenaltyFull       CancellationPenalty = "full"        // The whole stay, nothing is refunded
+)
+
+// maxFreeCancellationHours is the longest free cancellation period a policy may have
+const maxFreeCancellationHours = 365 * 24
+
+// CancellationPolicy is what a hotel charges for cancelled bookings
+// Cancelling at least FreeCancellationHours before check-in is free, later
+// cancellations are charged the Penalty. Common policies are:
+//   - free cancellation until 48h before check-in: {48, "full"}
+//   - non-refundable: {0, "full"}
+//   - first-night penalty: {0, "first-night"}
+// Bookings keep the policy they were made under, like their price
+type CancellationPolicy struct{
+	FreeCancellationHours int                 `bson:"freeCancellationHours" json:"freeCancellationHours"` // Hours before check-in until which cancelling is free, 0 if it never is
+	Penalty               CancellationPenalty `bson:"penalty" json:"penalty"`                             // Charged for later cancellations
+}
+
+// DefaultCancellationPolicy applies to hotels without a policy of their own
+// Cancelling is always free, which is how bookings were handled before hotels had policies
+var DefaultCancellationPolicy = CancellationPolicy{Penalty: CancellationPenaltyNone}
+
+// Validate checks if the CancellationPolicy contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (p CancellationPolicy) Validate() map[string]string{
+	errors := map[string]string{}
+	switch p.Penalty{
+	case CancellationPenaltyNone, CancellationPenaltyFirstNight, CancellationPenaltyFull:
+	default:
+		errors["cancellationPolicy.penalty"] = fmt.Sprintf("penalty should be %s, %s or %s", CancellationPenaltyNone, CancellationPenaltyFirstNight, CancellationPenaltyFull)
+	}
+	if p.FreeCancellationHours<0 || p.FreeCancellationHours>maxFreeCancellationHours{
+		errors["cancellationPolicy.freeCancellationHours"] = fmt.Sprintf("freeCancellationHours should be between 0 and %d", maxFreeCancellationHours)
+	}
+	return errors
+}
+
+// Description returns the policy as a sentence to show to guests
+func (p CancellationPolicy) Description() string{
+	if p.Penalty == CancellationPenaltyNone{
+		return "Free cancellation at any time."
+	}
+	charge := "the full price of the stay"
+	if p.Penalty == CancellationPenaltyFirstNight{
+		charge = "the first night"
+	}
+	if p.FreeCancellationHours == 0{
+		if p.Penalty == CancellationPenaltyFull{
+			return "Non-refundable: cancellations are charged " + charge + "."
+		}
+		return "Cancellations are charged " + charge + "."
+	}
+	return fmt.Sprintf("Free cancellation until %d hours before check-in. Later cancellations are charged %s.", p.FreeCancellationHours, charge)
+}
+
+// PenaltyFor returns what cancelling a stay of the given price at time now costs
+// The check-in counts from midnight UTC of the check-in day, like the booked nights
+func (p CancellationPolicy) PenaltyFor(price *PriceBreakdown, fromDate, now time.Time) float64{
+	if price == nil || p.Penalty == CancellationPenaltyNone{
+		return 0
+	}
+	deadline := utcDay(fromDate).Add(-time.Duration(p.FreeCancellationHours) * time.Hour)
+	if p.FreeCancellationHours > 0 && now.Before(deadline){
+		return 0
+	}
+	if p.Penalty == CancellationPenaltyFirstNight && len(price.Nights) > 0{
+		return math.Min(roundCents(price.Nights[0].Price*(1+TaxRate)), price.Total)
+	}
+	return price.Total
+}
+
+// Cancellation records when a booking was cancelled and what that cost the guest
+// The payments of the booking are settled with the gateway after the booking is
+// cancelled; until that has worked SettlementPending stays set
+type Cancellation struct{
+	CancelledAt       time.Time `bson:"cancelledAt" json:"cancelledAt"`                                 // When the booking was cancelled
+	Penalty           float64   `bson:"penalty" json:"penalty"`                                         // Charged under the cancellation policy
+	Refund            float64   `bson:"refund" json:"refund"`                                           // Paid amount given back to the guest, known once settled
+	SettlementPending bool      `bson:"settlementPending,omitempty" json:"settlementPending,omitempty"` // The penalty has not been charged or the refund not given back yet
+}
+
+// NewCancellation evaluates the cancellation policy of the booking for a cancellation at time now
+// Only confirmed bookings have been paid for, so cancelling a hold, a booking
+// whose payment is still pending or a booking made before bookings had a status,
+// and were paid for, costs nothing and has nothing to settle. The refund depends
+// on the payments actually made and is filled in when the cancellation is settled
+func NewCancellation(booking *Booking, now time.Time) *Cancellation{
+	cancellation := &Cancellation{CancelledAt: now.UTC()}
+	if booking.Status != BookingStatusConfirmed{
+		return cancellation
+	}
+	cancellation.Penalty = booking.ApplicableCancellationPolicy().PenaltyFor(booking.Price, booking.FromDate, now)
+	cancellation.SettlementPending = true
+	return cancellation
+}
@@ -1,6 +1,12 @@
 package types
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 // RoomType defines the available types of rooms in the hotel
 // Using iota for auto-incrementing integer constants
@@ -14,6 +20,36 @@ const(
 	DeluxRoomType          // Value: 4 - A luxury room with premium amenities
 )
 
+// IsValid reports whether the room type is one of the known types
+func (t RoomType) IsValid() bool{
+	return t >= SingleRoomType && t <= DeluxRoomType
+}
+
+// Constants for hotel-related validations
+const (
+	minHotelNameLen = 2 // Minimum allowed length for a hotel name
+	minHotelRating  = 1 // Lowest rating a hotel can have
+	maxHotelRating  = 5 // Highest rating a hotel can have
+)
+
+// DefaultRoomCapacity is the number of guests a room sleeps when neither its type nor
+// an explicit maximum occupancy says otherwise
+const DefaultRoomCapacity = 2
+
+// Capacity returns the number of guests a room of this type sleeps
+func (t RoomType) Capacity() int{
+	switch t{
+	case SingleRoomType:
+		return 1
+	case DoubleRoomType, SeaSideRoomType:
+		return 2
+	case DeluxRoomType:
+		return 4
+	default:
+		return DefaultRoomCapacity
+	}
+}
+
 // Hotel represents a hotel in the reservation system
 // Contains basic information about the hotel and references to its rooms
 type Hotel struct{
@@ -22,14 +58,213 @@ type Hotel struct{
 	Location string 	            `bson:"location" json:"location"`       // Physical location/address of the hotel
 	Rooms 	 []primitive.ObjectID	`bson:"rooms" json:"rooms"`             // List of room IDs belonging to this hotel
 	Rating 	 int					`bson:"rating" json:"rating"`           // Hotel rating (e.g., 1-5 stars)
+	CancellationPolicy *CancellationPolicy `bson:"cancellationPolicy,omitempty" json:"cancellationPolicy,omitempty"` // What cancelling a booking costs, DefaultCancellationPolicy if not set
+}
+
+// ApplicableCancellationPolicy returns the cancellation policy new bookings of the hotel are made under
+func (h *Hotel) ApplicableCancellationPolicy() CancellationPolicy{
+	if h.CancellationPolicy == nil{
+		return DefaultCancellationPolicy
+	}
+	return *h.CancellationPolicy
 }
 
 // Room represents an individual room in a hotel
 // Contains details about the room's features and pricing
 type Room struct{
 	ID        primitive.ObjectID     `bson:"_id,omitempty" json:"id,omitempty"` // Unique identifier for the room
+	Type      RoomType               `bson:"type,omitempty" json:"type,omitempty"` // Kind of room, determines the default capacity
+	MaxOccupancy int                 `bson:"maxOccupancy,omitempty" json:"maxOccupancy,omitempty"` // Explicit guest limit, overrides the type's capacity
 	Seaside	  bool 				     `bson:"seaside" json:"seaside"`           // Whether the room has a sea view
-	Size 	  string			     `bson: "size" json:"size"`               // Size of the room (e.g., "large", "small")
-	Price 	  float64			     `bson:"price",json:"price"`              // Cost per night in the room
+	Size 	  string			     `bson:"size" json:"size"`               // Size of the room (e.g., "large", "small")
+	Price 	  float64			     `bson:"price" json:"price"`              // Cost per night in the room
 	HotelID   primitive.ObjectID     `bson:"hotelID" json:"hotelID"`           // ID of the hotel this room belongs to
+	DeletingSince *time.Time         `bson:"deletingSince,omitempty" json:"-"` // When deleting the room started, no new nights can be booked while it runs
+}
+
+// Capacity returns the maximum number of guests that can stay in the room
+// An explicit MaxOccupancy wins over the capacity derived from the room type
+func (r *Room) Capacity() int{
+	if r.MaxOccupancy > 0{
+		return r.MaxOccupancy
+	}
+	return r.Type.Capacity()
+}
+
+// CreateHotelParams defines the data needed to create a hotel
+// Rooms are added afterwards, one at a time
+type CreateHotelParams struct{
+	Name               string              `json:"name"`               // Name of the hotel
+	Location           string              `json:"location"`           // Physical location/address of the hotel
+	Rating             int                 `json:"rating"`             // Hotel rating, 1-5 stars
+	CancellationPolicy *CancellationPolicy `json:"cancellationPolicy"` // What cancelling a booking costs, optional
+}
+
+// UpdateHotelParams defines the data needed to update a hotel
+// Empty fields are left as they are; rooms are managed through their own endpoints
+type UpdateHotelParams struct{
+	Name               string              `json:"name"`               // Name of the hotel
+	Location           string              `json:"location"`           // Physical location/address of the hotel
+	Rating             int                 `json:"rating"`             // Hotel rating, 1-5 stars
+	CancellationPolicy *CancellationPolicy `json:"cancellationPolicy"` // What cancelling new bookings costs, existing bookings keep theirs
+}
+
+// CreateRoomParams defines the data needed to add a room to a hotel
+type CreateRoomParams struct{
+	Type         RoomType `json:"type"`         // Kind of room, optional
+	MaxOccupancy int      `json:"maxOccupancy"` // Explicit guest limit, optional
+	Seaside      bool     `json:"seaside"`      // Whether the room has a sea view
+	Size         string   `json:"size"`         // Size of the room (e.g., "large", "small")
+	Price        float64  `json:"price"`        // Cost per night
+}
+
+// UpdateRoomParams defines the data needed to update a room
+// Fields left out are left as they are, which is why Seaside is a pointer
+type UpdateRoomParams struct{
+	Type         RoomType `json:"type"`         // Kind of room
+	MaxOccupancy int      `json:"maxOccupancy"` // Explicit guest limit
+	Seaside      *bool    `json:"seaside"`      // Whether the room has a sea view
+	Size         string   `json:"size"`         // Size of the room (e.g., "large", "small")
+	Price        float64  `json:"price"`        // Cost per night
+}
+
+// NewHotelFromParams creates a new Hotel without rooms from the provided parameters
+func NewHotelFromParams(params CreateHotelParams) *Hotel{
+	return &Hotel{
+		ID:                 primitive.NewObjectID(),
+		Name:               params.Name,
+		Location:           params.Location,
+		Rooms:              []primitive.ObjectID{},
+		Rating:             params.Rating,
+		CancellationPolicy: params.CancellationPolicy,
+	}
+}
+
+// NewRoomFromParams creates a new Room of the hotel from the provided parameters
+func NewRoomFromParams(hotelID primitive.ObjectID, params CreateRoomParams) *Room{
+	return &Room{
+		ID:           primitive.NewObjectID(),
+		Type:         params.Type,
+		MaxOccupancy: params.MaxOccupancy,
+		Seaside:      params.Seaside,
+		Size:         params.Size,
+		Price:        params.Price,
+		HotelID:      hotelID,
+	}
+}
+
+// Validate checks if the CreateHotelParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params CreateHotelParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if len(params.Name)<minHotelNameLen{
+		errors["name"] = fmt.Sprintf("name length should be at least %d characters",minHotelNameLen)
+	}
+	if params.Location == ""{
+		errors["location"] = "location is required"
+	}
+	if params.Rating<minHotelRating || params.Rating>maxHotelRating{
+		errors["rating"] = fmt.Sprintf("rating should be between %d and %d",minHotelRating,maxHotelRating)
+	}
+	if params.CancellationPolicy != nil{
+		for field, msg := range params.CancellationPolicy.Validate(){
+			errors[field] = msg
+		}
+	}
+	return errors
+}
+
+// Validate checks if the UpdateHotelParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params UpdateHotelParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.Name != "" && len(params.Name)<minHotelNameLen{
+		errors["name"] = fmt.Sprintf("name length should be at least %d characters",minHotelNameLen)
+	}
+	if params.Rating != 0 && (params.Rating<minHotelRating || params.Rating>maxHotelRating){
+		errors["rating"] = fmt.Sprintf("rating should be between %d and %d",minHotelRating,maxHotelRating)
+	}
+	if params.CancellationPolicy != nil{
+		for field, msg := range params.CancellationPolicy.Validate(){
+			errors[field] = msg
+		}
+	}
+	if len(errors) == 0 && len(params.ToBSON()) == 0{
+		errors["body"] = "nothing to update"
+	}
+	return errors
+}
+
+// ToBSON returns the fields to $set on the hotel document
+func (params UpdateHotelParams) ToBSON() bson.M{
+	update := bson.M{}
+	if params.Name != ""{
+		update["name"] = params.Name
+	}
+	if params.Location != ""{
+		update["location"] = params.Location
+	}
+	if params.Rating != 0{
+		update["rating"] = params.Rating
+	}
+	if params.CancellationPolicy != nil{
+		update["cancellationPolicy"] = params.CancellationPolicy
+	}
+	return update
+}
+
+// Validate checks if the CreateRoomParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params CreateRoomParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.Type != 0 && !params.Type.IsValid(){
+		errors["type"] = fmt.Sprintf("unknown room type %d",params.Type)
+	}
+	if params.MaxOccupancy<0{
+		errors["maxOccupancy"] = "maxOccupancy cannot be negative"
+	}
+	if params.Price<=0{
+		errors["price"] = "price should be greater than 0"
+	}
+	return errors
+}
+
+// Validate checks if the UpdateRoomParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params UpdateRoomParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.Type != 0 && !params.Type.IsValid(){
+		errors["type"] = fmt.Sprintf("unknown room type %d",params.Type)
+	}
+	if params.MaxOccupancy<0{
+		errors["maxOccupancy"] = "maxOccupancy cannot be negative"
+	}
+	if params.Price<0{
+		errors["price"] = "price should be greater than 0"
+	}
+	if len(errors) == 0 && len(params.ToBSON()) == 0{
+		errors["body"] = "nothing to update"
+	}
+	return errors
+}
+
+// ToBSON returns the fields to $set on the room document
+func (params UpdateRoomParams) ToBSON() bson.M{
+	update := bson.M{}
+	if params.Type != 0{
+		update["type"] = params.Type
+	}
+	if params.MaxOccupancy != 0{
+		update["maxOccupancy"] = params.MaxOccupancy
+	}
+	if params.Seaside != nil{
+		update["seaside"] = *params.Seaside
+	}
+	if params.Size != ""{
+		update["size"] = params.Size
+	}
+	if params.Price != 0{
+		update["price"] = params.Price
+	}
+	return update
 }
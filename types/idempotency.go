@@ -0,0 +1,57 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// IdempotencyRecord remembers a request sent with an Idempotency-Key header
+// and the response it got, so a retry with the same key is answered with that
+// response instead of being processed again. Keys are scoped to the user who
+// sent them
+type IdempotencyRecord struct{
+	ID             string             `bson:"_id"`                   // Scoped key, see IdempotencyScope
+	UserID         primitive.ObjectID `bson:"userID"`                // The user who sent the request
+	RequestHash    string             `bson:"requestHash"`           // Hash of the method, path and body, see HashRequest
+	ClaimID        primitive.ObjectID `bson:"claimID"`               // Identifies this claim of the key, so a request that lost its lease cannot store its response over a later one
+	Completed      bool               `bson:"completed"`             // Set once the response is stored
+	StatusCode     int                `bson:"statusCode,omitempty"`  // Status of the original response
+	ContentType    string             `bson:"contentType,omitempty"` // Content type of the original response
+	Body           []byte             `bson:"body,omitempty"`        // Body of the original response
+	CreatedAt      time.Time          `bson:"createdAt"`             // When the request was first received
+	ExpiresAt      time.Time          `bson:"expiresAt"`             // After this the key can be used for a new request
+	LeaseExpiresAt time.Time          `bson:"leaseExpiresAt"`        // If the request has not completed by then, a retry can take over the key
+}
+
+// NewIdempotencyRecord creates the record of a request that is about to be processed
+// The request holds the key for the lease; its response is kept for the retention
+func NewIdempotencyRecord(userID primitive.ObjectID, key, requestHash string, retention, lease time.Duration) *IdempotencyRecord{
+	now := time.Now().UTC()
+	return &IdempotencyRecord{
+		ID:             IdempotencyScope(userID, key),
+		UserID:         userID,
+		RequestHash:    requestHash,
+		ClaimID:        primitive.NewObjectID(),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(retention),
+		LeaseExpiresAt: now.Add(lease),
+	}
+}
+
+// IdempotencyScope returns the ID a key sent by the user is stored under
+// Two users picking the same key never see each other's responses
+func IdempotencyScope(userID primitive.ObjectID, key string) string{
+	return userID.Hex() + ":" + key
+}
+
+// HashRequest returns the hash identifying a request, so a key reused for a
+// different request can be told apart from a retry
+func HashRequest(method, path string, body []byte) string{
+	h := sha256.New()
+	h.Write([]byte(method + " " + path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
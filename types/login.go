@@ -0,0 +1,53 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginAttempts counts the failed logins for one email address or one client IP
+// The record expires together with the lockout it may cause, so failures are
+// forgotten after a quiet period, and a successful login clears the email record
+type LoginAttempts struct{
+	ID          string    `bson:"_id"`         // Throttle key, see EmailLoginKey and IPLoginKey
+	Failures    int       `bson:"failures"`    // Failed logins since the record was created
+	LastFailure time.Time `bson:"lastFailure"` // When the last login failed
+	ExpiresAt   time.Time `bson:"expiresAt"`   // When the record and any lockout end
+}
+
+// IsExpired reports whether the record no longer counts at the given time
+func (a *LoginAttempts) IsExpired(now time.Time) bool{
+	return !now.Before(a.ExpiresAt)
+}
+
+// NormalizeEmail returns the form under which failed logins for the email are tracked
+// Emails are compared ignoring case, like the unique index on users does
+func NormalizeEmail(email string) string{
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// EmailLoginKey returns the throttle key for logins with the email address
+func EmailLoginKey(email string) string{
+	return "email:" + NormalizeEmail(email)
+}
+
+// IPLoginKey returns the throttle key for logins from the client IP
+func IPLoginKey(ip string) string{
+	return "ip:" + ip
+}
+
+// LockoutEvent records that logins for an account or from an IP were locked
+// Admins list them to see attacks and to unlock accounts before the lockout ends
+type LockoutEvent struct{
+	ID          primitive.ObjectID  `bson:"_id"                  json:"id"`                   // Unique identifier
+	Email       string              `bson:"email,omitempty"      json:"email,omitempty"`      // Locked email address, empty for an IP lockout
+	UserID      *primitive.ObjectID `bson:"userID,omitempty"     json:"userID,omitempty"`     // Account of the email, if there is one
+	IP          string              `bson:"ip"                   json:"ip"`                   // Client IP of the failure that caused the lockout
+	Failures    int                 `bson:"failures"             json:"failures"`             // Failed logins that led to the lockout
+	LockedUntil time.Time           `bson:"lockedUntil"          json:"lockedUntil"`          // When the lockout ends by itself
+	CreatedAt   time.Time           `bson:"createdAt"            json:"createdAt"`            // When the lockout started
+	UnlockedAt  *time.Time          `bson:"unlockedAt,omitempty" json:"unlockedAt,omitempty"` // When an admin lifted the lockout
+	UnlockedBy  *primitive.ObjectID `bson:"unlockedBy,omitempty" json:"unlockedBy,omitempty"` // The admin who lifted it
+}
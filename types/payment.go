@@ -0,0 +1,41 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentStatus is the state of a payment at the gateway
+type PaymentStatus string
+
+const (
+	PaymentStatusPending    PaymentStatus = "pending"    // Waiting for the gateway, e.g. while the guest confirms with their bank
+	PaymentStatusAuthorized PaymentStatus = "authorized" // The amount is reserved on the guest's payment method
+	PaymentStatusCaptured   PaymentStatus = "captured"   // The amount has been charged
+	PaymentStatusFailed     PaymentStatus = "failed"     // The gateway declined the payment
+	PaymentStatusRefunded   PaymentStatus = "refunded"   // The amount was given back or the authorization released
+)
+
+// Payment records what a guest paid, or tried to pay, for a booking
+// A booking can have several payments, e.g. when the first card is declined
+type Payment struct{
+	ID            primitive.ObjectID `bson:"_id"                     json:"id"`                      // Unique identifier
+	BookingID     primitive.ObjectID `bson:"bookingID"               json:"bookingID"`               // The booking paid for
+	UserID        primitive.ObjectID `bson:"userID"                  json:"userID"`                  // The user who paid
+	Provider      string             `bson:"provider"                json:"provider"`                // Payment gateway, e.g. mock
+	ProviderRef   string             `bson:"providerRef"             json:"providerRef"`             // ID of the payment at the gateway
+	Amount        float64            `bson:"amount"                  json:"amount"`                  // Amount authorized, the booking total
+	Captured      float64            `bson:"captured,omitempty"      json:"captured,omitempty"`      // Amount charged, e.g. a cancellation penalty
+	Currency      string             `bson:"currency"                json:"currency"`                // ISO 4217 currency of the amount
+	Status        PaymentStatus      `bson:"status"                  json:"status"`                  // State at the gateway
+	FailureReason string             `bson:"failureReason,omitempty" json:"failureReason,omitempty"` // Why the gateway declined the payment
+	CreatedAt     time.Time          `bson:"createdAt"               json:"createdAt"`               // When the payment was started
+	UpdatedAt     time.Time          `bson:"updatedAt"               json:"updatedAt"`               // When the status last changed
+}
+
+// IsFinal reports whether the status of the payment can no longer change by itself
+// Only pending payments are still waiting for the gateway
+func (p *Payment) IsFinal() bool{
+	return p.Status != PaymentStatusPending
+}
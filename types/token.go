@@ -0,0 +1,123 @@
+package types
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// refreshTokenBytes is the number of random bytes in a refresh token
+const refreshTokenBytes = 32
+
+// RefreshToken is a long-lived token that is exchanged for a new access token
+// Only the hash of the token is stored. Every refresh uses the token up and issues
+// a new one of the same family; if a used token is presented again it has been
+// stolen, and the whole family is revoked
+type RefreshToken struct{
+	ID              primitive.ObjectID `bson:"_id"`             // Unique identifier
+	UserID          primitive.ObjectID `bson:"userID"`          // The user the token was issued to
+	FamilyID        primitive.ObjectID `bson:"familyID"`        // Shared by every token descending from the same login
+	TokenHash       string             `bson:"tokenHash"`       // SHA-256 hash of the token, see HashToken
+	AccessTokenID   string             `bson:"accessTokenID"`   // jti of the access token issued together with this token
+	AccessExpiresAt time.Time          `bson:"accessExpiresAt"` // When that access token expires
+	ExpiresAt       time.Time          `bson:"expiresAt"`       // After this the token can no longer be used
+	CreatedAt       time.Time          `bson:"createdAt"`       // When the token was issued
+	Used            bool               `bson:"used"`            // Set once the token has been exchanged
+	Revoked         bool               `bson:"revoked"`         // Set on logout or when the family is revoked
+}
+
+// NewRefreshToken creates a refresh token of the given family for the user
+// It returns the token to store and the secret value handed to the client
+func NewRefreshToken(userID, familyID primitive.ObjectID, ttl time.Duration) (*RefreshToken, string, error){
+	secret, err := randomToken(refreshTokenBytes)
+	if err != nil{
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	return &RefreshToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		FamilyID:  familyID,
+		TokenHash: HashToken(secret),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, secret, nil
+}
+
+// HashToken returns the hash under which a refresh or user token is stored
+// The tokens are long and random, so a plain SHA-256 is enough to make a leaked
+// database useless for refreshing or resetting passwords
+func HashToken(token string) string{
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsExpired reports whether the token can no longer be used at the given time
+func (t *RefreshToken) IsExpired(now time.Time) bool{
+	return !now.Before(t.ExpiresAt)
+}
+
+// TokenPurpose says what a user token may be used for
+type TokenPurpose string
+
+const (
+	TokenPurposePasswordReset     TokenPurpose = "password-reset"     // Set a new password without knowing the old one
+	TokenPurposeEmailVerification TokenPurpose = "email-verification" // Confirm that the user owns their email address
+)
+
+// UserToken is a single-use token emailed to a user, e.g. in a password reset or
+// email verification link
+// Like refresh tokens only the hash is stored, and a token is only accepted for
+// the purpose it was issued for
+type UserToken struct{
+	ID        primitive.ObjectID `bson:"_id"`       // Unique identifier
+	UserID    primitive.ObjectID `bson:"userID"`    // The user the token was sent to
+	Purpose   TokenPurpose       `bson:"purpose"`   // What the token may be used for
+	TokenHash string             `bson:"tokenHash"` // SHA-256 hash of the token, see HashToken
+	ExpiresAt time.Time          `bson:"expiresAt"` // After this the token can no longer be used
+	CreatedAt time.Time          `bson:"createdAt"` // When the token was issued
+	Used      bool               `bson:"used"`      // Set once the token has been used
+}
+
+// NewUserToken creates a token for the given purpose for the user
+// It returns the token to store and the secret value sent to the user
+func NewUserToken(userID primitive.ObjectID, purpose TokenPurpose, ttl time.Duration) (*UserToken, string, error){
+	secret, err := randomToken(refreshTokenBytes)
+	if err != nil{
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	return &UserToken{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: HashToken(secret),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}, secret, nil
+}
+
+// NewTokenID returns a random ID for the jti claim of an access token
+func NewTokenID() (string, error){
+	return randomToken(16)
+}
+
+// RevokedToken marks an access token that must no longer be accepted
+// It only has to be kept until the token would have expired anyway
+type RevokedToken struct{
+	ID        string    `bson:"_id"`       // jti of the revoked access token
+	ExpiresAt time.Time `bson:"expiresAt"` // When the access token expires
+}
+
+// randomToken returns n random bytes encoded for use in URLs and headers
+func randomToken(n int) (string, error){
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil{
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
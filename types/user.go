@@ -5,6 +5,7 @@ import (
 
 	"regexp"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,11 +18,42 @@ const (
 	miniPasswordLen = 7          // Minimum allowed length for password
 )
 
+// Role defines what a user is allowed to do in the system
+type Role string
+
+const (
+	RoleGuest Role = "guest" // Regular customer, can only manage their own bookings
+	RoleStaff Role = "staff" // Hotel employee, scoped to the hotels listed in User.HotelIDs
+	RoleAdmin Role = "admin" // Full access to every resource
+)
+
 // UpdateUserParams defines the data needed to update a user
 // This is used when updating an existing user's information
+// Only the fields listed here can be changed, empty fields are left as they are.
+// Email and password have endpoints of their own, role and hotels may only be
+// changed by admins
 type UpdateUserParams struct{
-	FirstName   string `json:"firstName"` // User's first name
-	LastName 	string `json:"lastName"`  // User's last name
+	FirstName   string `json:"firstName"`          // User's first name
+	LastName 	string `json:"lastName"`           // User's last name
+	Role        Role   `json:"role,omitempty"`     // New role, admins only
+	HotelIDs    []primitive.ObjectID `json:"hotelIDs,omitempty"` // Hotels a staff member works for, admins only; [] removes every hotel
+}
+
+// ChangePasswordParams defines the data needed to change the password of the current user
+type ChangePasswordParams struct{
+	CurrentPassword string `json:"currentPassword"` // The password the user logs in with now
+	NewPassword     string `json:"newPassword"`     // The password to log in with from now on
+}
+
+// ForgotPasswordParams defines the data needed to request a password reset email
+type ForgotPasswordParams struct{
+	Email string `json:"email"` // Address of the account
+}
+
+// ResetPasswordParams defines the data needed to set a new password with a reset token
+type ResetPasswordParams struct{
+	Token    string `json:"token"`    // Token from the reset email
+	Password string `json:"password"` // The new password
 }
 
 // CreateUserParams defines the data needed to create a new user
@@ -41,13 +73,16 @@ type User struct {
     LastName          string             `bson:"lastName"  json:"lastName"`          // User's last name
     Email             string             `bson:"email"     json:"email"`             // User's email address
     EncryptedPassword string             `bson:"EncryptedPassword" json:"-"`         // Password hash (not sent in JSON responses)
+    Role              Role               `bson:"role"      json:"role"`              // What the user is allowed to do
+    EmailVerified     bool               `bson:"emailVerified" json:"emailVerified"` // Set once the user followed the link in the verification email
+    HotelIDs          []primitive.ObjectID `bson:"hotelIDs,omitempty" json:"hotelIDs,omitempty"` // Hotels a staff member works for
 }
 
 // NewUserFromParams creates a new User object from the provided parameters
 // It handles password encryption and generates a new unique ID
 func NewUserFromParams(params CreateUserParams) (*User,error){
 	// Generate a secure hash of the password
-	encpw, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcryptCost)
+	encpw, err := EncryptPassword(params.Password)
 	if err != nil{
 		return nil,err
 	}
@@ -55,11 +90,58 @@ func NewUserFromParams(params CreateUserParams) (*User,error){
 	return &User{FirstName: params.FirstName,
 		LastName: params.LastName,
 		Email: params.Email,
-		EncryptedPassword: string(encpw),
+		EncryptedPassword: encpw,
+		Role: RoleGuest,
 		ID:  primitive.NewObjectID(),
 	},nil
 }
 
+// HasRole reports whether the user has one of the given roles
+// Users stored before roles existed have no role and are treated as guests
+func (u *User) HasRole(roles ...Role) bool{
+	role := u.Role
+	if role == ""{
+		role = RoleGuest
+	}
+	for _, r := range roles{
+		if r == role{
+			return true
+		}
+	}
+	return false
+}
+
+// IsValid reports whether the role is one of the known roles
+func (r Role) IsValid() bool{
+	switch r{
+	case RoleGuest, RoleStaff, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// IsAdmin reports whether the user is an administrator
+func (u *User) IsAdmin() bool{
+	return u.HasRole(RoleAdmin)
+}
+
+// CanManageHotel reports whether the user may act on behalf of the given hotel
+// Admins can manage every hotel, staff only the hotels they are assigned to
+func (u *User) CanManageHotel(hotelID primitive.ObjectID) bool{
+	if u.IsAdmin(){
+		return true
+	}
+	if !u.HasRole(RoleStaff){
+		return false
+	}
+	for _, id := range u.HotelIDs{
+		if id == hotelID{
+			return true
+		}
+	}
+	return false
+}
+
 // Validate checks if the CreateUserParams contains valid data
 // Returns a map of field names to error messages for any invalid fields
 func (params CreateUserParams) Validate() map[string]string{
@@ -87,6 +169,84 @@ func (params CreateUserParams) Validate() map[string]string{
 	return errors
 }
 
+// Validate checks if the UpdateUserParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params UpdateUserParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.FirstName != "" && len(params.FirstName)<miniFirstNameLen{
+		errors["firstName"] = fmt.Sprintf("firstName length should be at least %d characters",miniFirstNameLen)
+	}
+	if params.LastName != "" && len(params.LastName)<miniLastNameLen{
+		errors["lastName"] = fmt.Sprintf("lastName length should be at least %d characters",miniLastNameLen)
+	}
+	if params.Role != "" && !params.Role.IsValid(){
+		errors["role"] = fmt.Sprintf("unknown role %q",params.Role)
+	}
+	if len(errors) == 0 && len(params.ToBSON()) == 0{
+		errors["body"] = "nothing to update"
+	}
+	return errors
+}
+
+// IsAdminOnly reports whether the update changes fields only admins may change
+func (params UpdateUserParams) IsAdminOnly() bool{
+	return params.Role != "" || params.HotelIDs != nil
+}
+
+// ToBSON returns the fields to $set on the user document
+// Nothing but the whitelisted fields ever ends up in the update
+func (params UpdateUserParams) ToBSON() bson.M{
+	update := bson.M{}
+	if params.FirstName != ""{
+		update["firstName"] = params.FirstName
+	}
+	if params.LastName != ""{
+		update["lastName"] = params.LastName
+	}
+	if params.Role != ""{
+		update["role"] = params.Role
+	}
+	if params.HotelIDs != nil{
+		update["hotelIDs"] = params.HotelIDs
+	}
+	return update
+}
+
+// Validate checks if the ChangePasswordParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params ChangePasswordParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.CurrentPassword == ""{
+		errors["currentPassword"] = "currentPassword is required"
+	}
+	if len(params.NewPassword)<miniPasswordLen{
+		errors["newPassword"]=fmt.Sprintf("minimum password length should be at least %d characters",miniPasswordLen)
+	}
+	return errors
+}
+
+// Validate checks if the ResetPasswordParams contains valid data
+// Returns a map of field names to error messages for any invalid fields
+func (params ResetPasswordParams) Validate() map[string]string{
+	errors := map[string]string{}
+	if params.Token == ""{
+		errors["token"] = "token is required"
+	}
+	if len(params.Password)<miniPasswordLen{
+		errors["password"]=fmt.Sprintf("minimum password length should be at least %d characters",miniPasswordLen)
+	}
+	return errors
+}
+
+// EncryptPassword returns the bcrypt hash stored for a plain text password
+func EncryptPassword(pw string) (string,error){
+	encpw, err := bcrypt.GenerateFromPassword([]byte(pw), bcryptCost)
+	if err != nil{
+		return "",err
+	}
+	return string(encpw),nil
+}
+
 // IsEmailValid checks if the provided email string has a valid format
 // Uses regex pattern matching to validate email format
 func IsEmailValid(e string) bool{